@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/quiq/docker-registry-ui/registry"
+)
+
+// Statuses of the readiness report lines, colored green, yellow and red.
+const (
+	checkOK   = "\033[32m[ OK ]\033[0m"
+	checkWarn = "\033[33m[WARN]\033[0m"
+	checkFail = "\033[31m[FAIL]\033[0m"
+)
+
+// reportCheck print a line of the readiness report.
+func reportCheck(status, name, details string) {
+	fmt.Printf("%s %-14s %s\n", status, name, details)
+}
+
+// check validate the config against the registry without deleting anything and print the readiness report:
+// the purge configs compile, the credentials can list the catalog and delete manifests, and a dry-run
+// over the first sample repositories completes. It returns whether the purge is ready to run.
+func (a *apiClient) check(sample int) bool {
+	ready := true
+	fail := func(name string, err error) {
+		ready = false
+		reportCheck(checkFail, name, err.Error())
+	}
+	reportCheck(checkOK, "Registry", fmt.Sprintf("connected to %s", a.config.RegistryURL))
+
+	opts := a.purgeTagsOptions()
+	warnings, configErr := registry.CheckPurgeOptions(opts)
+	if configErr != nil {
+		fail("Purge config", configErr)
+	} else {
+		reportCheck(checkOK, "Purge config", fmt.Sprintf("%d purge configs compiled", len(opts.Configs)))
+	}
+	for _, w := range warnings {
+		reportCheck(checkWarn, "Purge config", w)
+	}
+
+	if err := a.client.CheckAuth(); err != nil {
+		fail("Auth", err)
+		return false
+	}
+	catalog := a.client.Repositories(false)
+	repos := []string{}
+	for _, namespace := range registry.SortedMapKeys(catalog) {
+		if len(opts.Namespaces) > 0 && !registry.ItemInSlice(namespace, opts.Namespaces) {
+			continue
+		}
+		for _, repo := range catalog[namespace] {
+			if namespace != "library" {
+				repo = fmt.Sprintf("%s/%s", namespace, repo)
+			}
+			repos = append(repos, repo)
+		}
+	}
+	reportCheck(checkOK, "Auth", fmt.Sprintf("listed %d repositories to purge", len(repos)))
+	if len(repos) == 0 {
+		reportCheck(checkWarn, "Delete", "no repositories to check the deletions and the purge on")
+		return ready
+	}
+	if len(repos) > sample {
+		repos = repos[:sample]
+	}
+
+	if err := a.client.CheckDelete(repos[0]); err != nil {
+		fail("Delete", err)
+	} else {
+		reportCheck(checkOK, "Delete", fmt.Sprintf("deletions allowed in %s", repos[0]))
+	}
+
+	if configErr != nil {
+		return false
+	}
+	// The sample dry-run is not a purging run to report.
+	opts.DryRun, opts.Repos, opts.PlanFile, opts.ReportSinks = true, repos, "", nil
+	summary := registry.PurgeOldTags(context.Background(), a.client, opts)
+	for _, e := range summary.Errors {
+		ready = false
+		reportCheck(checkFail, "Dry-run", e)
+	}
+	if len(summary.Errors) == 0 {
+		tags := 0
+		for _, r := range summary.Repos {
+			tags += r.TagsCount
+		}
+		reportCheck(checkOK, "Dry-run", fmt.Sprintf("%d tags of %d in %d sample repositories to purge", summary.TagsToPurge(), tags, len(summary.Repos)))
+	}
+	if n := summary.TagsUnprocessed(); n > 0 {
+		reportCheck(checkWarn, "Dry-run", fmt.Sprintf("%d tags could not be evaluated and are kept", n))
+	}
+	return ready
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/quiq/docker-registry-ui/registry"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+// recordingSink records the summaries published to it.
+type recordingSink struct {
+	summaries []*registry.PurgeSummary
+}
+
+func (s *recordingSink) Publish(summary *registry.PurgeSummary) error {
+	s.summaries = append(s.summaries, summary)
+	return nil
+}
+
+// newEmptyRegistry serve a registry with a single repo without tags.
+func newEmptyRegistry() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/":
+		case "/v2/_catalog":
+			json.NewEncoder(w).Encode(map[string]interface{}{"repositories": []string{"app"}})
+		case "/v2/app/tags/list":
+			json.NewEncoder(w).Encode(map[string]interface{}{"name": "app", "tags": []string{}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestCheck(t *testing.T) {
+	convey.Convey("Publish no report of the sample dry-run", t, func() {
+		server := newEmptyRegistry()
+		defer server.Close()
+		sink := &recordingSink{}
+		a := &apiClient{
+			client:      registry.NewClient(server.URL, false, "", ""),
+			config:      configData{RegistryURL: server.URL, PurgeTagsKeepDays: 7, PurgeTagsKeepCount: 1},
+			reportSinks: []registry.ReportSink{sink},
+		}
+		convey.So(a.check(1), convey.ShouldBeTrue)
+		convey.So(sink.summaries, convey.ShouldBeEmpty)
+	})
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/quiq/docker-registry-ui/registry"
+	"gopkg.in/yaml.v2"
+)
+
+// purgeConfigFile purge configs of a file of purge_configs_dir.
+type purgeConfigFile struct {
+	path    string
+	configs []registry.PurgeConfig
+}
+
+// loadPurgeConfigDir read the purge configs of the *.yml and *.yaml files of the dir in filename order,
+// e.g. one file per team, each having a purge_configs list like the config file.
+func loadPurgeConfigDir(dir string) ([]purgeConfigFile, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading purge configs dir: %s", err)
+	}
+	files := []purgeConfigFile{}
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") || (ext != ".yml" && ext != ".yaml") {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading purge config file: %s", err)
+		}
+		var fragment struct {
+			PurgeConfigs []registry.PurgeConfig `yaml:"purge_configs"`
+		}
+		if err := yaml.Unmarshal(data, &fragment); err != nil {
+			return nil, fmt.Errorf("Invalid purge config file %s: %s", path, err)
+		}
+		files = append(files, purgeConfigFile{path: path, configs: fragment.PurgeConfigs})
+	}
+	return files, nil
+}
+
+// mergePurgeConfigFiles append the purge configs of the files to the ones of the config file, in filename order.
+func mergePurgeConfigFiles(configs []registry.PurgeConfig, files []purgeConfigFile) []registry.PurgeConfig {
+	for _, f := range files {
+		configs = append(configs, f.configs...)
+	}
+	return configs
+}
+
+// checkPurgeConfigFiles validate the purge configs of every file on its own, so an invalid regex is reported
+// along with the file it comes from. The global options are validated first, not to blame a file for them.
+func (a *apiClient) checkPurgeConfigFiles(files []purgeConfigFile) error {
+	opts := a.purgeTagsOptions()
+	opts.Configs = nil
+	if _, err := registry.CheckPurgeOptions(opts); err != nil {
+		return fmt.Errorf("Invalid purge config: %s", err)
+	}
+	for _, f := range files {
+		opts.Configs = f.configs
+		if _, err := registry.CheckPurgeOptions(opts); err != nil {
+			return fmt.Errorf("Invalid purge config file %s: %s", f.path, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/quiq/docker-registry-ui/registry"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestPurgeConfigDir(t *testing.T) {
+	write := func(dir, name, data string) {
+		convey.So(ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644), convey.ShouldBeNil)
+	}
+	repoRegexes := func(configs []registry.PurgeConfig) []string {
+		regexes := []string{}
+		for _, c := range configs {
+			regexes = append(regexes, c.RepoRegex)
+		}
+		return regexes
+	}
+
+	convey.Convey("Merge the files after the config file in filename order", t, func() {
+		dir, _ := ioutil.TempDir("", "purge-configs")
+		defer os.RemoveAll(dir)
+		write(dir, "team-b.yml", "purge_configs:\n  - repo_regex: ^team-b/\n")
+		write(dir, "team-a.yaml", "purge_configs:\n  - repo_regex: ^team-a/\n  - repo_regex: ^shared/a-\n")
+		files, err := loadPurgeConfigDir(dir)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(files, convey.ShouldHaveLength, 2)
+		configs := mergePurgeConfigFiles([]registry.PurgeConfig{{RepoRegex: "^infra/"}}, files)
+		convey.So(repoRegexes(configs), convey.ShouldResemble, []string{"^infra/", "^team-a/", "^shared/a-", "^team-b/"})
+	})
+
+	convey.Convey("Ignore the other files, the hidden ones and the dirs", t, func() {
+		dir, _ := ioutil.TempDir("", "purge-configs")
+		defer os.RemoveAll(dir)
+		write(dir, "team-a.yml", "purge_configs:\n  - repo_regex: ^team-a/\n")
+		write(dir, "README.md", "# Purge configs\n")
+		write(dir, "team-b.yml.bak", "not: [yaml")
+		write(dir, ".team-c.yml", "not: [yaml")
+		convey.So(os.Mkdir(filepath.Join(dir, "archive.yml"), 0755), convey.ShouldBeNil)
+		files, err := loadPurgeConfigDir(dir)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(files, convey.ShouldHaveLength, 1)
+		convey.So(files[0].path, convey.ShouldEqual, filepath.Join(dir, "team-a.yml"))
+	})
+
+	convey.Convey("Load no configs from an empty dir and fail on a missing one", t, func() {
+		dir, _ := ioutil.TempDir("", "purge-configs")
+		defer os.RemoveAll(dir)
+		files, err := loadPurgeConfigDir(dir)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(files, convey.ShouldBeEmpty)
+		convey.So(mergePurgeConfigFiles(nil, files), convey.ShouldBeEmpty)
+
+		_, err = loadPurgeConfigDir(filepath.Join(dir, "missing"))
+		convey.So(err, convey.ShouldNotBeNil)
+	})
+
+	convey.Convey("Report the file of an invalid config", t, func() {
+		dir, _ := ioutil.TempDir("", "purge-configs")
+		defer os.RemoveAll(dir)
+		write(dir, "team-a.yml", "purge_configs:\n  - repo_regex: ^team-a/\n")
+		write(dir, "team-b.yml", "purge_configs: [repo_regex")
+		_, err := loadPurgeConfigDir(dir)
+		convey.So(err, convey.ShouldNotBeNil)
+		convey.So(err.Error(), convey.ShouldContainSubstring, "team-b.yml")
+
+		write(dir, "team-b.yml", "purge_configs:\n  - repo_regex: ^team-b/(\n")
+		files, err := loadPurgeConfigDir(dir)
+		convey.So(err, convey.ShouldBeNil)
+		a := &apiClient{config: configData{PurgeTagsKeepDays: 7, PurgeTagsKeepCount: 1}}
+		err = a.checkPurgeConfigFiles(files)
+		convey.So(err, convey.ShouldNotBeNil)
+		convey.So(err.Error(), convey.ShouldStartWith, "Invalid purge config file "+filepath.Join(dir, "team-b.yml")+":")
+
+		a.config.PurgeUnmatchedTagPolicy = "unknown"
+		err = a.checkPurgeConfigFiles(files[:1])
+		convey.So(err, convey.ShouldNotBeNil)
+		convey.So(err.Error(), convey.ShouldStartWith, "Invalid purge config: ")
+	})
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// envPrefix prefix of the environment variables overriding the config options.
+const envPrefix = "REGISTRY_UI_"
+
+// applyEnvConfig override the config options with the environment variables named after their keys,
+// e.g. REGISTRY_UI_PURGE_TAGS_KEEP_DAYS for purge_tags_keep_days. Strings are taken as is,
+// other values are parsed as YAML, so lists like purge_configs can be given as JSON too.
+func applyEnvConfig(config *configData) error {
+	v := reflect.ValueOf(config).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		key := strings.Split(v.Type().Field(i).Tag.Get("yaml"), ",")[0]
+		if key == "" || key == "-" {
+			continue
+		}
+		name := envPrefix + strings.ToUpper(key)
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		field := v.Field(i)
+		if field.Kind() == reflect.String {
+			field.SetString(value)
+			continue
+		}
+		// Reset the lists and maps so they are replaced rather than merged into, structs like purge_exit_codes
+		// are decoded over their defaults so a partial value keeps the other fields.
+		if field.Kind() == reflect.Slice || field.Kind() == reflect.Map {
+			field.Set(reflect.Zero(field.Type()))
+		}
+		if err := yaml.Unmarshal([]byte(value), field.Addr().Interface()); err != nil {
+			return fmt.Errorf("Invalid %s: %s", name, err)
+		}
+	}
+	return nil
+}
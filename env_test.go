@@ -0,0 +1,26 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestApplyEnvConfig(t *testing.T) {
+	convey.Convey("Override a part of the exit codes keeping the other defaults", t, func() {
+		os.Setenv("REGISTRY_UI_PURGE_EXIT_CODES", "{failed: 4}")
+		defer os.Unsetenv("REGISTRY_UI_PURGE_EXIT_CODES")
+		config := configData{PurgeExitCodes: defaultPurgeExitCodes}
+		convey.So(applyEnvConfig(&config), convey.ShouldBeNil)
+		convey.So(config.PurgeExitCodes, convey.ShouldResemble, purgeExitCodes{Failed: 4, InvalidConfig: 2, Cancelled: 3})
+	})
+
+	convey.Convey("Replace the lists rather than merging into them", t, func() {
+		os.Setenv("REGISTRY_UI_PURGE_NAMESPACES", "[team-b]")
+		defer os.Unsetenv("REGISTRY_UI_PURGE_NAMESPACES")
+		config := configData{PurgeNamespaces: []string{"team-a", "team-c"}}
+		convey.So(applyEnvConfig(&config), convey.ShouldBeNil)
+		convey.So(config.PurgeNamespaces, convey.ShouldResemble, []string{"team-b"})
+	})
+}
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/hhkbp2/go-logging"
 	"github.com/quiq/docker-registry-ui/registry"
@@ -31,6 +32,9 @@ const (
 `
 )
 
+// eventTimeFormat format of the created column of the events.
+const eventTimeFormat = "2006-01-02 15:04:05"
+
 // EventListener event listener
 type EventListener struct {
 	databaseDriver   string
@@ -66,21 +70,31 @@ func NewEventListener(databaseDriver, databaseLocation string, retention int, ev
 	}
 }
 
-// ProcessEvents parse and store registry events
-func (e *EventListener) ProcessEvents(request *http.Request) {
+// ProcessEvents parse and store registry events, and return the repositories pushed to.
+func (e *EventListener) ProcessEvents(request *http.Request) []string {
 	decoder := json.NewDecoder(request.Body)
 	var t eventData
 	if err := decoder.Decode(&t); err != nil {
 		e.logger.Errorf("Problem decoding event from request: %+v", request)
-		return
+		return nil
 	}
 	e.logger.Debugf("Received event: %+v", t)
 	j, _ := json.Marshal(t)
 
+	// Only the manifests pushed by tag add tags, unlike the blobs and the manifests of multi-arch images.
+	pushed := []string{}
+	for _, i := range gjson.GetBytes(j, "events").Array() {
+		repository := i.Get("target.repository").String()
+		if i.Get("action").String() == "push" && i.Get("target.tag").String() != "" &&
+			i.Get("request.useragent").String() != "docker-registry-ui" && !registry.ItemInSlice(repository, pushed) {
+			pushed = append(pushed, repository)
+		}
+	}
+
 	db, err := e.getDatabaseHandler()
 	if err != nil {
 		e.logger.Error(err)
-		return
+		return pushed
 	}
 	defer db.Close()
 
@@ -108,7 +122,7 @@ func (e *EventListener) ProcessEvents(request *http.Request) {
 		res, err := stmt.Exec(action, repository, tag, ip, user)
 		if err != nil {
 			e.logger.Error("Error inserting a row: ", err)
-			return
+			return pushed
 		}
 		id, _ := res.LastInsertId()
 		e.logger.Debug("New event added with id ", id)
@@ -116,7 +130,7 @@ func (e *EventListener) ProcessEvents(request *http.Request) {
 
 	// Purge old records.
 	if !e.eventDeletion {
-		return
+		return pushed
 	}
 	var res sql.Result
 	if e.databaseDriver == "mysql" {
@@ -128,6 +142,7 @@ func (e *EventListener) ProcessEvents(request *http.Request) {
 	}
 	count, _ := res.RowsAffected()
 	e.logger.Debug("Rows deleted: ", count)
+	return pushed
 }
 
 // GetEvents retrieve events from sqlite db
@@ -160,6 +175,43 @@ func (e *EventListener) GetEvents(repository string) []EventRow {
 	return events
 }
 
+// PushedRepositories list the repositories pushed to since the time, as a registry.ChangedReposProvider.
+// It cannot tell when no event was recorded before the time, e.g. they were deleted past the retention
+// or the listener was set up since then.
+func (e *EventListener) PushedRepositories(since time.Time) ([]string, bool, error) {
+	db, err := e.getDatabaseHandler()
+	if err != nil {
+		return nil, false, err
+	}
+	defer db.Close()
+
+	// SQLite DateTime('now') creates the events in UTC, so should the MySQL server timezone be for NOW().
+	// The dates are compared by the database, whatever format the driver scans them in.
+	after := since.UTC().Format(eventTimeFormat)
+	var before int
+	if err := db.QueryRow("SELECT COUNT(*) FROM events WHERE created <= ?", after).Scan(&before); err != nil {
+		return nil, false, fmt.Errorf("Error selecting from table: %s", err)
+	}
+	if before == 0 {
+		return nil, false, nil
+	}
+
+	rows, err := db.Query("SELECT DISTINCT repository FROM events WHERE action = 'push' AND created >= ?", after)
+	if err != nil {
+		return nil, false, fmt.Errorf("Error selecting from table: %s", err)
+	}
+	defer rows.Close()
+	repos := []string{}
+	for rows.Next() {
+		var repo string
+		if err := rows.Scan(&repo); err != nil {
+			return nil, false, fmt.Errorf("Error selecting from table: %s", err)
+		}
+		repos = append(repos, repo)
+	}
+	return repos, true, rows.Err()
+}
+
 func (e *EventListener) getDatabaseHandler() (*sql.DB, error) {
 	firstRun := false
 	schema := schemaSQLite
@@ -0,0 +1,66 @@
+package events
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestPushedRepositories(t *testing.T) {
+	since := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	newListener := func(events ...[]string) (*EventListener, func()) {
+		dir, _ := ioutil.TempDir("", "events")
+		e := NewEventListener("sqlite3", filepath.Join(dir, "registry_events.db"), 7, false)
+		db, err := e.getDatabaseHandler()
+		convey.So(err, convey.ShouldBeNil)
+		defer db.Close()
+		for _, event := range events {
+			_, err := db.Exec("INSERT INTO events(action, repository, created) values(?,?,?)", event[0], event[1], event[2])
+			convey.So(err, convey.ShouldBeNil)
+		}
+		return e, func() { os.RemoveAll(dir) }
+	}
+	at := func(d time.Duration) string {
+		return since.Add(d).Format(eventTimeFormat)
+	}
+
+	convey.Convey("Cannot tell without events", t, func() {
+		e, cleanup := newListener()
+		defer cleanup()
+		repos, known, err := e.PushedRepositories(since)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(known, convey.ShouldBeFalse)
+		convey.So(repos, convey.ShouldBeEmpty)
+	})
+
+	convey.Convey("Cannot tell when the oldest event is after the time", t, func() {
+		e, cleanup := newListener([]string{"push", "app", at(time.Minute)})
+		defer cleanup()
+		_, known, err := e.PushedRepositories(since)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(known, convey.ShouldBeFalse)
+	})
+
+	convey.Convey("List the repos pushed to since the time only", t, func() {
+		e, cleanup := newListener(
+			[]string{"push", "old", at(-time.Hour)},
+			[]string{"pull", "app", at(-time.Hour)},
+			[]string{"push", "app", at(time.Hour)},
+			[]string{"push", "app", at(2 * time.Hour)},
+			[]string{"pull", "pulled", at(time.Hour)},
+			[]string{"delete", "deleted", at(time.Hour)},
+			[]string{"push", "team-a/app", at(0)},
+		)
+		defer cleanup()
+		repos, known, err := e.PushedRepositories(since)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(known, convey.ShouldBeTrue)
+		convey.So(repos, convey.ShouldHaveLength, 2)
+		convey.So(repos, convey.ShouldContain, "app")
+		convey.So(repos, convey.ShouldContain, "team-a/app")
+	})
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+
+	"github.com/quiq/docker-registry-ui/registry"
+)
+
+// purgeExitCodes exit codes of the -purge-tags task by outcome, a successful run exits with 0 whether it
+// deleted tags or not.
+type purgeExitCodes struct {
+	// Failed is used when the purge had errors, e.g. failed deletions, or was aborted.
+	Failed int `yaml:"failed"`
+	// InvalidConfig is used when the purge options or the plan to apply are invalid, nothing is purged then.
+	InvalidConfig int `yaml:"invalid_config"`
+	// Cancelled is used when the purge was interrupted or stopped on exceeding the max duration.
+	Cancelled int `yaml:"cancelled"`
+}
+
+// defaultPurgeExitCodes leave 2 for invalid configs as Go panics exit with it, e.g. on config files failing to parse.
+var defaultPurgeExitCodes = purgeExitCodes{Failed: 1, InvalidConfig: 2, Cancelled: 3}
+
+// exitCode return the exit code of the purge summary, cancelled runs take precedence over failed ones
+// as their errors are often caused by the interruption.
+func (c purgeExitCodes) exitCode(ctx context.Context, summary *registry.PurgeSummary) int {
+	switch {
+	case ctx.Err() != nil || summary.TimedOut:
+		return c.Cancelled
+	case summary.Aborted || len(summary.Errors) > 0:
+		return c.Failed
+	}
+	return 0
+}
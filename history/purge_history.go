@@ -0,0 +1,160 @@
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hhkbp2/go-logging"
+	"github.com/quiq/docker-registry-ui/registry"
+)
+
+var runIDRegexp = regexp.MustCompile(`^[\w.-]+$`)
+
+// PurgeHistory store of purging runs summaries, one JSON file per run.
+type PurgeHistory struct {
+	dir    string
+	keep   int
+	logger logging.Logger
+}
+
+// NewPurgeHistory initialize PurgeHistory keeping the given number of the most recent runs.
+func NewPurgeHistory(dir string, keep int) *PurgeHistory {
+	return &PurgeHistory{
+		dir:    dir,
+		keep:   keep,
+		logger: registry.SetupLogging("history.purge_history"),
+	}
+}
+
+// Save store the run summary and prune the oldest runs beyond the retention.
+func (h *PurgeHistory) Save(summary *registry.PurgeSummary) error {
+	if err := os.MkdirAll(h.dir, 0755); err != nil {
+		return fmt.Errorf("Error creating purge history dir: %s", err)
+	}
+	data, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(h.dir, summary.ID+".json"), data, 0644); err != nil {
+		return fmt.Errorf("Error writing purge history: %s", err)
+	}
+
+	ids := h.ids()
+	for h.keep > 0 && len(ids) > h.keep {
+		if err := os.Remove(filepath.Join(h.dir, ids[len(ids)-1]+".json")); err != nil {
+			h.logger.Error(err)
+		}
+		ids = ids[:len(ids)-1]
+	}
+	return nil
+}
+
+// List return the summaries of the recent runs from newest to oldest.
+func (h *PurgeHistory) List(limit int) []*registry.PurgeSummary {
+	runs := []*registry.PurgeSummary{}
+	for _, id := range h.ids() {
+		if limit > 0 && len(runs) >= limit {
+			break
+		}
+		if s := h.Get(id); s != nil {
+			runs = append(runs, s)
+		}
+	}
+	return runs
+}
+
+// Get return the summary of the run or nil if not found.
+func (h *PurgeHistory) Get(id string) *registry.PurgeSummary {
+	if !runIDRegexp.MatchString(id) {
+		return nil
+	}
+	data, err := ioutil.ReadFile(filepath.Join(h.dir, id+".json"))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			h.logger.Error(err)
+		}
+		return nil
+	}
+	summary := &registry.PurgeSummary{}
+	if err := json.Unmarshal(data, summary); err != nil {
+		h.logger.Errorf("Error parsing purge history %s: %s", id, err)
+		return nil
+	}
+	return summary
+}
+
+// ids list stored run ids from newest to oldest.
+func (h *PurgeHistory) ids() []string {
+	files, err := ioutil.ReadDir(h.dir)
+	if err != nil {
+		return nil
+	}
+	ids := []string{}
+	for _, f := range files {
+		if strings.HasSuffix(f.Name(), ".json") {
+			ids = append(ids, strings.TrimSuffix(f.Name(), ".json"))
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(ids)))
+	return ids
+}
+
+// TagDecision would-delete decisions of a tag accumulated over the dry-runs.
+type TagDecision struct {
+	Repo  string
+	Tag   string
+	First time.Time
+	Last  time.Time
+	// Runs count the dry-runs which selected the tag, Current is set when the latest one still did.
+	Runs    int
+	Current bool
+}
+
+// WouldDelete accumulate the tags to purge of the dry-runs since the latest live run, e.g. to review what
+// the evaluate-only mode would have deleted over time before enabling deletions. The tags kept by a later
+// dry-run drop out, the selections before it no longer count. It returns the decisions sorted by repo and tag,
+// and the number of dry-runs.
+func (h *PurgeHistory) WouldDelete() ([]*TagDecision, int) {
+	decisions := map[string]*TagDecision{}
+	// kept are the tags kept by the dry-runs newer than the one accumulated.
+	kept := map[string]bool{}
+	runs := 0
+	for _, run := range h.List(0) {
+		if !run.DryRun {
+			break
+		}
+		for _, r := range run.Repos {
+			for _, tag := range r.Purge {
+				key := r.Repo + ":" + tag
+				if kept[key] {
+					continue
+				}
+				d, ok := decisions[key]
+				if !ok {
+					d = &TagDecision{Repo: r.Repo, Tag: tag, Last: run.Started, Current: runs == 0}
+					decisions[key] = d
+				}
+				d.First = run.Started
+				d.Runs++
+			}
+		}
+		for _, r := range run.Repos {
+			for _, tag := range r.Keep {
+				kept[r.Repo+":"+tag] = true
+			}
+		}
+		runs++
+	}
+	result := make([]*TagDecision, 0, len(decisions))
+	for _, key := range registry.SortedMapKeys(decisions) {
+		result = append(result, decisions[key])
+	}
+	return result, runs
+}
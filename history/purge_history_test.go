@@ -0,0 +1,168 @@
+package history
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/quiq/docker-registry-ui/registry"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestPurgeHistory(t *testing.T) {
+	started := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	run := func(days int) *registry.PurgeSummary {
+		now := started.AddDate(0, 0, days)
+		return &registry.PurgeSummary{ID: now.Format("20060102-150405"), Started: now, DryRun: true, TagsDeleted: days}
+	}
+	ids := func(runs []*registry.PurgeSummary) []string {
+		result := []string{}
+		for _, r := range runs {
+			result = append(result, r.ID)
+		}
+		return result
+	}
+
+	convey.Convey("Read back the saved runs", t, func() {
+		dir, _ := ioutil.TempDir("", "purge-history")
+		defer os.RemoveAll(dir)
+		h := NewPurgeHistory(filepath.Join(dir, "runs"), 10)
+		summary := run(0)
+		summary.Repos = []registry.RepoSummary{{Repo: "app", Purge: []string{"v1"}, Keep: []string{"v2"}}}
+		convey.So(h.Save(summary), convey.ShouldBeNil)
+
+		saved := h.Get(summary.ID)
+		convey.So(saved, convey.ShouldNotBeNil)
+		convey.So(saved.Started, convey.ShouldEqual, started)
+		convey.So(saved.DryRun, convey.ShouldBeTrue)
+		convey.So(saved.Repos, convey.ShouldHaveLength, 1)
+		convey.So(saved.Repos[0].Purge, convey.ShouldResemble, []string{"v1"})
+		convey.So(ids(h.List(0)), convey.ShouldResemble, []string{summary.ID})
+	})
+
+	convey.Convey("Prune the oldest runs beyond the count kept", t, func() {
+		dir, _ := ioutil.TempDir("", "purge-history")
+		defer os.RemoveAll(dir)
+		h := NewPurgeHistory(dir, 2)
+		for _, days := range []int{2, 0, 1} {
+			convey.So(h.Save(run(days)), convey.ShouldBeNil)
+		}
+		convey.So(ids(h.List(0)), convey.ShouldResemble, []string{run(2).ID, run(1).ID})
+		convey.So(h.Get(run(0).ID), convey.ShouldBeNil)
+		convey.So(ids(h.List(1)), convey.ShouldResemble, []string{run(2).ID})
+	})
+
+	convey.Convey("Keep all the runs without a count", t, func() {
+		dir, _ := ioutil.TempDir("", "purge-history")
+		defer os.RemoveAll(dir)
+		h := NewPurgeHistory(dir, 0)
+		for days := 0; days < 3; days++ {
+			convey.So(h.Save(run(days)), convey.ShouldBeNil)
+		}
+		convey.So(h.List(0), convey.ShouldHaveLength, 3)
+	})
+
+	convey.Convey("Return no runs from a missing dir", t, func() {
+		h := NewPurgeHistory(filepath.Join(os.TempDir(), "purge-history-missing"), 10)
+		convey.So(h.List(0), convey.ShouldBeEmpty)
+		convey.So(h.Get(run(0).ID), convey.ShouldBeNil)
+	})
+
+	convey.Convey("Skip the corrupted runs and the ids out of the dir", t, func() {
+		dir, _ := ioutil.TempDir("", "purge-history")
+		defer os.RemoveAll(dir)
+		h := NewPurgeHistory(dir, 10)
+		convey.So(h.Save(run(0)), convey.ShouldBeNil)
+		convey.So(ioutil.WriteFile(filepath.Join(dir, run(1).ID+".json"), []byte(`{"id": "`), 0644), convey.ShouldBeNil)
+		convey.So(h.Get(run(1).ID), convey.ShouldBeNil)
+		convey.So(ids(h.List(0)), convey.ShouldResemble, []string{run(0).ID})
+		convey.So(h.Get("../"+filepath.Base(dir)+"/"+run(0).ID), convey.ShouldBeNil)
+	})
+}
+
+func TestWouldDelete(t *testing.T) {
+	started := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	run := func(days int, dryRun bool, purge, keep []string) *registry.PurgeSummary {
+		now := started.AddDate(0, 0, days)
+		return &registry.PurgeSummary{ID: now.Format("20060102-150405"), Started: now, DryRun: dryRun,
+			Repos: []registry.RepoSummary{{Repo: "app", Purge: purge, Keep: keep}}}
+	}
+	tags := func(decisions []*TagDecision) []string {
+		result := []string{}
+		for _, d := range decisions {
+			result = append(result, d.Tag)
+		}
+		return result
+	}
+	newHistory := func(runs ...*registry.PurgeSummary) (*PurgeHistory, func()) {
+		dir, _ := ioutil.TempDir("", "purge-history")
+		h := NewPurgeHistory(dir, 0)
+		for _, r := range runs {
+			convey.So(h.Save(r), convey.ShouldBeNil)
+		}
+		return h, func() { os.RemoveAll(dir) }
+	}
+
+	convey.Convey("Accumulate all the dry-runs without a live run yet", t, func() {
+		h, cleanup := newHistory(
+			run(0, true, []string{"v1"}, []string{"v2", "v3"}),
+			run(1, true, []string{"v1", "v2"}, []string{"v3"}),
+		)
+		defer cleanup()
+		decisions, runs := h.WouldDelete()
+		convey.So(runs, convey.ShouldEqual, 2)
+		convey.So(tags(decisions), convey.ShouldResemble, []string{"v1", "v2"})
+		convey.So(decisions[0].Runs, convey.ShouldEqual, 2)
+		convey.So(decisions[0].First, convey.ShouldEqual, started)
+		convey.So(decisions[0].Last, convey.ShouldEqual, started.AddDate(0, 0, 1))
+		convey.So(decisions[0].Current, convey.ShouldBeTrue)
+		convey.So(decisions[1].Runs, convey.ShouldEqual, 1)
+	})
+
+	convey.Convey("Accumulate only the dry-runs after the latest live run", t, func() {
+		h, cleanup := newHistory(
+			run(0, true, []string{"v0"}, []string{"v1", "v2"}),
+			run(1, false, []string{"v0"}, []string{"v1", "v2"}),
+			run(2, true, []string{"v1"}, []string{"v2"}),
+		)
+		defer cleanup()
+		decisions, runs := h.WouldDelete()
+		convey.So(runs, convey.ShouldEqual, 1)
+		convey.So(tags(decisions), convey.ShouldResemble, []string{"v1"})
+		convey.So(decisions[0].First, convey.ShouldEqual, started.AddDate(0, 0, 2))
+
+		h, cleanup = newHistory(run(0, true, []string{"v0"}, nil), run(1, false, []string{"v0"}, nil))
+		defer cleanup()
+		decisions, runs = h.WouldDelete()
+		convey.So(runs, convey.ShouldEqual, 0)
+		convey.So(decisions, convey.ShouldBeEmpty)
+	})
+
+	convey.Convey("Drop the tags kept by a later dry-run", t, func() {
+		h, cleanup := newHistory(
+			run(0, true, []string{"v1", "v2"}, []string{"v3"}),
+			run(1, true, []string{"v2"}, []string{"v1", "v3"}),
+			run(2, true, []string{"v1", "v2"}, []string{"v3"}),
+			run(3, true, []string{"v2"}, []string{"v1", "v3"}),
+		)
+		defer cleanup()
+		decisions, runs := h.WouldDelete()
+		convey.So(runs, convey.ShouldEqual, 4)
+		convey.So(tags(decisions), convey.ShouldResemble, []string{"v2"})
+		convey.So(decisions[0].Runs, convey.ShouldEqual, 4)
+
+		// Selected again since it was last kept.
+		h, cleanup = newHistory(
+			run(0, true, []string{"v1"}, nil),
+			run(1, true, nil, []string{"v1"}),
+			run(2, true, []string{"v1"}, nil),
+		)
+		defer cleanup()
+		decisions, _ = h.WouldDelete()
+		convey.So(decisions, convey.ShouldHaveLength, 1)
+		convey.So(decisions[0].Runs, convey.ShouldEqual, 1)
+		convey.So(decisions[0].First, convey.ShouldEqual, started.AddDate(0, 0, 2))
+	})
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/quiq/docker-registry-ui/registry"
+)
+
+// isTerminal check whether the file is a terminal, e.g. the stdin of a manual run unlike the one of a cron job.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// confirmPurge print the tags to purge by repo and ask to type "yes" on the input before deleting them.
+// Anything else, the end of the input or the context canceled on interrupt declines.
+func confirmPurge(ctx context.Context, in io.Reader, purge map[string][]string) bool {
+	count := 0
+	fmt.Println("\nPurge plan:")
+	for _, repo := range registry.SortedMapKeys(purge) {
+		fmt.Printf("  %s (%d tags): %s\n", repo, len(purge[repo]), strings.Join(purge[repo], ", "))
+		count = count + len(purge[repo])
+	}
+	fmt.Printf("\nDelete %d tags of %d repositories? Type \"yes\" to confirm: ", count, len(purge))
+
+	answer := make(chan string, 1)
+	go func() {
+		line, _ := bufio.NewReader(in).ReadString('\n')
+		answer <- strings.TrimSpace(line)
+	}()
+	select {
+	case a := <-answer:
+		return a == "yes"
+	case <-ctx.Done():
+		fmt.Println()
+		return false
+	}
+}
@@ -1,20 +1,28 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/CloudyKit/jet"
+	"github.com/hhkbp2/go-logging"
 	"github.com/labstack/echo"
 	"github.com/labstack/echo/middleware"
 	"github.com/quiq/docker-registry-ui/events"
+	"github.com/quiq/docker-registry-ui/history"
 	"github.com/quiq/docker-registry-ui/registry"
-	"github.com/robfig/cron"
 	"github.com/tidwall/gjson"
 	"gopkg.in/yaml.v2"
 )
@@ -27,6 +35,8 @@ type configData struct {
 	Username              string   `yaml:"registry_username"`
 	Password              string   `yaml:"registry_password"`
 	PasswordFile          string   `yaml:"registry_password_file"`
+	NetrcFile             string   `yaml:"registry_netrc_file"`
+	PullSecret            string   `yaml:"registry_pull_secret"`
 	EventListenerToken    string   `yaml:"event_listener_token"`
 	EventRetentionDays    int      `yaml:"event_retention_days"`
 	EventDatabaseDriver   string   `yaml:"event_database_driver"`
@@ -38,17 +48,115 @@ type configData struct {
 	Debug                 bool     `yaml:"debug"`
 	PurgeTagsKeepDays     int      `yaml:"purge_tags_keep_days"`
 	PurgeTagsKeepCount    int      `yaml:"purge_tags_keep_count"`
+	PurgeTagsMaxAgeDays   int      `yaml:"purge_tags_max_age_days"`
+	PurgeZeroUnlimited    bool     `yaml:"purge_zero_means_unlimited"`
+	PurgeMinTags          int      `yaml:"purge_min_tags_before_purge"`
 	PurgeTagsSchedule     string   `yaml:"purge_tags_schedule"`
+	PurgeSchedulerToken   string   `yaml:"purge_scheduler_token"`
+	PurgeOnPush           bool     `yaml:"purge_on_push"`
+	PurgeOnPushDebounce   int      `yaml:"purge_on_push_debounce"`
+	PurgeTagsTimezone     string   `yaml:"purge_tags_timezone"`
+	MaxConcurrentRequests int      `yaml:"max_concurrent_requests"`
+	AnonymousPull         bool     `yaml:"anonymous_pull"`
+	DisableHeadRequests   bool     `yaml:"disable_head_requests"`
+	DisableSchema1        bool     `yaml:"disable_schema1"`
+	SizeAllPlatforms      bool     `yaml:"tag_size_all_platforms"`
+	HTTPMaxIdleConns      int      `yaml:"http_max_idle_conns"`
+	HTTPMaxConnsPerHost   int      `yaml:"http_max_conns_per_host"`
+	HTTPIdleConnTimeout   int      `yaml:"http_idle_conn_timeout"`
+	HTTPMaxResponseSize   int64    `yaml:"http_max_response_size"`
+	HTTPRetries           int      `yaml:"http_retries"`
+	HTTPRetryBudget       float64  `yaml:"http_retry_budget"`
+	StorageUsageURL       string   `yaml:"storage_usage_url"`
+	StorageUsageMetric    string   `yaml:"storage_usage_metric"`
+
+	PurgeConfigs            []registry.PurgeConfig  `yaml:"purge_configs"`
+	PurgeGitLabPolicies     []registry.GitLabPolicy `yaml:"purge_gitlab_policies"`
+	PurgeConfigsDir         string                  `yaml:"purge_configs_dir"`
+	PurgeConfigsArtifact    string                  `yaml:"purge_configs_artifact"`
+	PurgeUnmatchedTagPolicy string                  `yaml:"purge_unmatched_tag_policy"`
+	PurgeQuietSkips         bool                    `yaml:"purge_quiet_skips"`
+	PurgeLogTagsLimit       int                     `yaml:"purge_log_tags_limit"`
+	PurgeSharedManifests    string                  `yaml:"purge_shared_manifest_policy"`
+	PurgeGroupByManifest    bool                    `yaml:"purge_group_by_manifest"`
+	PurgeAgeSource          string                  `yaml:"purge_age_source"`
+	PurgeScanWorkers        int                     `yaml:"purge_scan_workers"`
+	PurgeInterRepoDelay     int                     `yaml:"purge_inter_repo_delay"`
+	PurgeTagWorkers         int                     `yaml:"purge_tag_workers"`
+	PurgeDeleteWorkers      int                     `yaml:"purge_delete_workers"`
+	PurgeMaxRepoDeletions   int                     `yaml:"purge_max_deletions_per_repo"`
+	PurgeDrainTimeout       int                     `yaml:"purge_drain_timeout"`
+	PurgePushGrace          int                     `yaml:"purge_recent_push_grace"`
+	PurgeVerifyDeletions    int                     `yaml:"purge_verify_deletions"`
+	PurgeMaxDuration        int                     `yaml:"purge_max_duration"`
+	PurgeRepoMaxDuration    int                     `yaml:"purge_repo_max_duration"`
+	PurgeCheckpointFile     string                  `yaml:"purge_checkpoint_file"`
+	PurgeTombstoneFile      string                  `yaml:"purge_tombstone_file"`
+	PurgeFirstSeenFile      string                  `yaml:"purge_first_seen_file"`
+	PurgeCreatedCacheTTL    int                     `yaml:"purge_created_cache_ttl"`
+	PurgeWatermarkFile      string                  `yaml:"purge_watermark_file"`
+	PurgeFailFast           bool                    `yaml:"purge_fail_fast"`
+	PurgeExcludeArtifacts   bool                    `yaml:"purge_exclude_artifacts"`
+	PurgeCrossRepo          bool                    `yaml:"purge_cross_repo_protection"`
+	PurgeIndexChildren      bool                    `yaml:"purge_index_child_protection"`
+	PurgeDeleteCompanions   bool                    `yaml:"purge_delete_companions"`
+	PurgeKeepAttested       bool                    `yaml:"purge_keep_attested"`
+	PurgeTagDetails         bool                    `yaml:"purge_tag_details"`
+	PurgeWarnTagCount       int                     `yaml:"purge_warn_tag_count"`
+	PurgeNamespaces         []string                `yaml:"purge_namespaces"`
+	PurgeVulnProvider       string                  `yaml:"purge_vuln_provider"`
+	PurgeVulnProviderURL    string                  `yaml:"purge_vuln_provider_url"`
+	PurgeInUseProvider      string                  `yaml:"purge_in_use_provider"`
+	PurgeInUseKubeContexts  []string                `yaml:"purge_in_use_kube_contexts"`
+	PurgeInUseFile          string                  `yaml:"purge_in_use_file"`
+	PurgeProtectedProvider  string                  `yaml:"purge_protected_tags_provider"`
+	PurgeProtectedSource    string                  `yaml:"purge_protected_tags_source"`
+	PurgeProtectedToken     string                  `yaml:"purge_protected_tags_token"`
+	PurgeHistoryDir         string                  `yaml:"purge_history_dir"`
+	PurgeHistoryKeep        int                     `yaml:"purge_history_keep"`
+	PurgeEvaluateOnly       bool                    `yaml:"purge_evaluate_only"`
+	PurgeAnchorMatch        bool                    `yaml:"purge_anchor_match"`
+	PurgePushgatewayURL     string                  `yaml:"purge_pushgateway_url"`
+	PurgePushgatewayJob     string                  `yaml:"purge_pushgateway_job"`
+	PurgePushgatewayInst    string                  `yaml:"purge_pushgateway_instance"`
+	PurgeMetricsFile        string                  `yaml:"purge_metrics_file"`
+	PurgeKeepLargerThan     int64                   `yaml:"purge_keep_larger_than_bytes"`
+	PurgeExitCodes          purgeExitCodes          `yaml:"purge_exit_codes"`
+	PurgeReportSinks        []reportSinkConfig      `yaml:"purge_report_sinks"`
 }
 
 type template struct {
 	View *jet.Set
 }
 
+// tagSizeWorkers is the number of tag sizes fetched at a time for the tag list, they are cached afterwards.
+const tagSizeWorkers = 8
+
 type apiClient struct {
 	client        *registry.Client
 	eventListener *events.EventListener
-	config        configData
+	purgeHistory  *history.PurgeHistory
+	purgeLocation *time.Location
+	vulnProvider  registry.VulnProvider
+	inUseProvider registry.InUseProvider
+	protectedTags registry.ProtectedTagsProvider
+	storageUsage  registry.StorageUsageProvider
+	reportSinks   []registry.ReportSink
+	createdCache  registry.CreatedCache
+	changedRepos  registry.ChangedReposProvider
+	pushPurges    *pushPurges
+	scheduler     *purgeScheduler
+	// samplePercent is set by the -sample-percent flag, see registry.PurgeTagsOptions.SamplePercent.
+	samplePercent float64
+	// reportFile is set by the -report-file flag, the bytes are measured for its report then.
+	reportFile string
+	// runID is set by the -run-id flag, see registry.PurgeTagsOptions.RunID.
+	runID        string
+	confirmPurge func(purge map[string][]string) bool
+	config       configData
+	logger       logging.Logger
+	// purging is set while a purge started on demand or by the schedule is running.
+	purging int32
 }
 
 func main() {
@@ -57,22 +165,68 @@ func main() {
 		configFile  string
 		purgeTags   bool
 		purgeDryRun bool
+		confirmAll  bool
+		planFile    string
+		applyPlan   string
+		deleteRepo  string
+		namespaces  string
+		recordDir   string
+		replayDir   string
+		check       bool
+		checkRepos  int
+		reportEmpty bool
+		interactive bool
 	)
 	flag.StringVar(&configFile, "config-file", "config.yml", "path to the config file")
 	flag.BoolVar(&purgeTags, "purge-tags", false, "purge old tags instead of running a web server")
 	flag.BoolVar(&purgeDryRun, "dry-run", false, "dry-run for purging task, does not delete anything")
+	flag.BoolVar(&confirmAll, "confirm-delete-all", false, "confirm deleting all tags of the repos matching a deleteAll purge config")
+	flag.StringVar(&namespaces, "namespaces", "", "comma-separated namespaces to purge instead of the configured ones")
+	flag.StringVar(&planFile, "plan-file", "", "write the tags to purge to the plan file on dry-run")
+	flag.StringVar(&deleteRepo, "delete-repo", "", "delete all the tags of the repo, requires -confirm-delete-all unless on -dry-run")
+	flag.StringVar(&a.reportFile, "report-file", "", "write a Markdown report of the purge to the file, - for stdout")
+	flag.BoolVar(&reportEmpty, "report-empty-repos", false, "list only the repos left without tags in the -report-file report")
+	flag.BoolVar(&interactive, "interactive", false, "print the purge plan and ask to type yes before deleting, ignored unless stdin is a terminal")
+	flag.StringVar(&applyPlan, "apply-plan", "", "delete the tags of the plan file written by a dry-run instead of purging old tags")
+	flag.StringVar(&recordDir, "record-fixtures", "", "record the registry responses into the directory")
+	flag.StringVar(&replayDir, "replay-fixtures", "", "serve the registry responses recorded into the directory instead of the registry, implies -dry-run")
+	flag.BoolVar(&check, "check", false, "check the config against the registry without deleting anything and print a readiness report")
+	flag.IntVar(&checkRepos, "check-repos", 5, "number of repositories the -check dry-run samples")
+	flag.Float64Var(&a.samplePercent, "sample-percent", 0, "analyze only that percentage of the repositories on -dry-run and extrapolate the totals")
+	flag.StringVar(&a.runID, "run-id", "", "ID tagging the logs, metrics and reports of the -purge-tags run, e.g. a CI build ID, random by default")
 	flag.Parse()
-
-	// Read config file.
+	a.logger = registry.SetupLogging("main")
+
+	// Read config file, it is optional unless given explicitly as the options can come from the environment.
+	configGiven := false
+	flag.Visit(func(f *flag.Flag) {
+		configGiven = configGiven || f.Name == "config-file"
+	})
+	a.config.PurgeExitCodes = defaultPurgeExitCodes
 	if _, err := os.Stat(configFile); os.IsNotExist(err) {
-		panic(err)
+		if configGiven {
+			panic(err)
+		}
+		a.logger.Warnf("Config file %s not found, reading the config from the environment only.", configFile)
+	} else {
+		bytes, err := ioutil.ReadFile(configFile)
+		if err != nil {
+			panic(err)
+		}
+		if err := yaml.Unmarshal(bytes, &a.config); err != nil {
+			panic(err)
+		}
 	}
-	bytes, err := ioutil.ReadFile(configFile)
-	if err != nil {
+	// Environment variables take precedence over the config file.
+	if err := applyEnvConfig(&a.config); err != nil {
 		panic(err)
 	}
-	if err := yaml.Unmarshal(bytes, &a.config); err != nil {
-		panic(err)
+	if dryRun, _ := strconv.ParseBool(os.Getenv(envPrefix + "DRY_RUN")); dryRun {
+		purgeDryRun = true
+	}
+	// Evaluate-only mode runs every purge as a dry-run until it is switched off.
+	if a.config.PurgeEvaluateOnly {
+		purgeDryRun = true
 	}
 	// Validate registry URL.
 	u, err := url.Parse(a.config.RegistryURL)
@@ -99,28 +253,242 @@ func main() {
 		}
 		a.config.Password = strings.TrimSuffix(string(passwordBytes[:]), "\n")
 	}
+	// Fall back to the credentials of the registry host in the pull secret, then in the netrc file.
+	if a.config.Username == "" && a.config.PullSecret != "" {
+		login, password, ok, err := registry.PullSecretCredentials(a.config.PullSecret, u.Host)
+		if err != nil {
+			panic(err)
+		}
+		if !ok {
+			panic(fmt.Errorf("No credentials of %s in pull secret %s", u.Host, a.config.PullSecret))
+		}
+		a.config.Username, a.config.Password = login, password
+	}
+	if a.config.Username == "" {
+		netrcFile := a.config.NetrcFile
+		if netrcFile == "" {
+			netrcFile = registry.DefaultNetrcFile()
+		}
+		if netrcFile != "" {
+			login, password, ok, err := registry.NetrcCredentials(netrcFile, u.Host)
+			if err != nil {
+				panic(err)
+			}
+			if ok {
+				a.config.Username, a.config.Password = login, password
+			}
+		}
+	}
+
+	if namespaces != "" {
+		a.config.PurgeNamespaces = strings.Split(namespaces, ",")
+	}
+	if a.config.PurgePushgatewayJob == "" {
+		a.config.PurgePushgatewayJob = "docker_registry_ui_purge"
+	}
+
+	// The files of the dir follow the purge_configs of the config file, still before the catch-all rule.
+	var configFiles []purgeConfigFile
+	if a.config.PurgeConfigsDir != "" {
+		if configFiles, err = loadPurgeConfigDir(a.config.PurgeConfigsDir); err != nil {
+			panic(err)
+		}
+		a.config.PurgeConfigs = mergePurgeConfigFiles(a.config.PurgeConfigs, configFiles)
+		a.logger.Infof("Loaded the purge configs of %d files from %s.", len(configFiles), a.config.PurgeConfigsDir)
+	}
+	gitLabConfigs, err := registry.GitLabPurgeConfigs(a.config.PurgeGitLabPolicies)
+	if err != nil {
+		panic(fmt.Errorf("Invalid purge_gitlab_policies: %s", err))
+	}
+	a.config.PurgeConfigs = append(a.config.PurgeConfigs, gitLabConfigs...)
+
+	if a.config.PurgeTagsTimezone != "" {
+		if a.purgeLocation, err = time.LoadLocation(a.config.PurgeTagsTimezone); err != nil {
+			panic(fmt.Errorf("Invalid purge_tags_timezone: %s", err))
+		}
+	}
+	switch a.config.PurgeVulnProvider {
+	case "":
+	case "trivy":
+		u, err := url.Parse(a.config.RegistryURL)
+		if err != nil {
+			panic(fmt.Errorf("Invalid registry_url: %s", err))
+		}
+		a.vulnProvider = registry.NewTrivyProvider(u.Host)
+	case "clair":
+		if a.config.PurgeVulnProviderURL == "" {
+			panic(fmt.Errorf("purge_vuln_provider_url is required for clair"))
+		}
+		a.vulnProvider = registry.NewClairProvider(a.config.PurgeVulnProviderURL)
+	default:
+		panic(fmt.Errorf("Invalid purge_vuln_provider: %s", a.config.PurgeVulnProvider))
+	}
+	switch a.config.PurgeInUseProvider {
+	case "":
+	case "kubernetes":
+		a.inUseProvider = registry.NewKubernetesProvider(a.config.PurgeInUseKubeContexts)
+	case "file":
+		a.inUseProvider = registry.NewFileProvider(a.config.PurgeInUseFile)
+	default:
+		panic(fmt.Errorf("Invalid purge_in_use_provider: %s", a.config.PurgeInUseProvider))
+	}
+	switch a.config.PurgeProtectedProvider {
+	case "":
+	case "file":
+		a.protectedTags = registry.NewTagsFileProvider(a.config.PurgeProtectedSource)
+	case "http":
+		a.protectedTags = registry.NewTagsHTTPProvider(a.config.PurgeProtectedSource, a.config.PurgeProtectedToken)
+	default:
+		panic(fmt.Errorf("Invalid purge_protected_tags_provider: %s", a.config.PurgeProtectedProvider))
+	}
+	if a.reportSinks, err = newReportSinks(a.config.PurgeReportSinks); err != nil {
+		panic(err)
+	}
+	if a.config.PurgeCreatedCacheTTL > 0 {
+		a.createdCache = registry.NewMemoryCreatedCache(time.Duration(a.config.PurgeCreatedCacheTTL) * time.Second)
+	}
+	if len(configFiles) > 0 {
+		if err := a.checkPurgeConfigFiles(configFiles); err != nil {
+			panic(err)
+		}
+	}
+	// The registry notifications recorded by the event listener tell which repos were pushed to.
+	if a.config.PurgeWatermarkFile != "" {
+		a.changedRepos = events.NewEventListener(
+			a.config.EventDatabaseDriver, a.config.EventDatabaseLocation, a.config.EventRetentionDays, a.config.EventDeletionEnabled,
+		).PushedRepositories
+	}
 
 	// Init registry API client.
-	a.client = registry.NewClient(a.config.RegistryURL, a.config.VerifyTLS, a.config.Username, a.config.Password)
+	if replayDir != "" {
+		a.logger.Warnf("Replaying registry responses from %s, forcing dry-run.", replayDir)
+		a.client = registry.NewReplayClient(replayDir)
+		purgeDryRun = true
+	} else {
+		a.client = registry.NewClient(a.config.RegistryURL, a.config.VerifyTLS, a.config.Username, a.config.Password)
+	}
 	if a.client == nil {
-		panic(fmt.Errorf("cannot initialize api client or unsupported auth method"))
+		err := fmt.Errorf("cannot initialize api client or unsupported auth method")
+		if check {
+			reportCheck(checkFail, "Registry", fmt.Sprintf("%s: %s", a.config.RegistryURL, err))
+			os.Exit(1)
+		}
+		panic(err)
+	}
+	if recordDir != "" {
+		if err := a.client.RecordFixtures(recordDir); err != nil {
+			panic(err)
+		}
+	}
+	a.client.SetConnectionPool(a.config.HTTPMaxIdleConns, a.config.HTTPMaxConnsPerHost, time.Duration(a.config.HTTPIdleConnTimeout)*time.Second)
+	a.client.SetMaxResponseSize(a.config.HTTPMaxResponseSize << 20)
+	a.client.SetMaxConcurrentRequests(a.config.MaxConcurrentRequests)
+	if a.config.HTTPRetries > 0 {
+		a.client.SetRetries(a.config.HTTPRetries, a.config.HTTPRetryBudget)
+	}
+	a.client.SetAnonymousPull(a.config.AnonymousPull)
+	a.client.SetDisableHead(a.config.DisableHeadRequests)
+	a.client.SetDisableSchema1(a.config.DisableSchema1)
+	a.client.SetTagSizeAllPlatforms(a.config.SizeAllPlatforms)
+	if a.config.StorageUsageURL != "" {
+		a.storageUsage = func() (int64, error) {
+			return a.client.StorageUsage(a.config.StorageUsageURL, a.config.StorageUsageMetric)
+		}
+	}
+
+	if a.config.PurgeHistoryDir != "" {
+		a.purgeHistory = history.NewPurgeHistory(a.config.PurgeHistoryDir, a.config.PurgeHistoryKeep)
 	}
 
 	// Execute CLI task and exit.
-	if purgeTags {
-		a.purgeOldTags(purgeDryRun)
+	if check {
+		if !a.check(checkRepos) {
+			fmt.Println("Not ready to purge, fix the failures above.")
+			os.Exit(1)
+		}
+		fmt.Println("Ready to purge.")
+		return
+	}
+	if deleteRepo != "" {
+		if !a.deleteRepository(deleteRepo, purgeDryRun, confirmAll) {
+			os.Exit(1)
+		}
 		return
 	}
+	if purgeTags {
+		// Stop purging on interrupt letting the started deletions complete.
+		ctx, cancel := context.WithCancel(context.Background())
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		go func() {
+			<-signals
+			cancel()
+		}()
+		if interactive && !purgeDryRun {
+			if isTerminal(os.Stdin) {
+				a.confirmPurge = func(purge map[string][]string) bool { return confirmPurge(ctx, os.Stdin, purge) }
+			} else {
+				a.logger.Warn("Ignoring -interactive as stdin is not a terminal.")
+			}
+		}
+		codes := a.config.PurgeExitCodes
+		if _, err := registry.CheckPurgeOptions(a.purgeTagsOptions()); err != nil {
+			a.logger.Errorf("Invalid purge config: %s", err)
+			os.Exit(codes.InvalidConfig)
+		}
+		if a.samplePercent > 0 && !purgeDryRun {
+			a.logger.Error("Sampling the repositories requires -dry-run.")
+			os.Exit(codes.InvalidConfig)
+		}
+		var summary *registry.PurgeSummary
+		if applyPlan != "" {
+			if a.config.PurgeEvaluateOnly {
+				a.logger.Error("Not applying the purge plan in evaluate-only mode, set purge_evaluate_only: false to delete.")
+				os.Exit(codes.InvalidConfig)
+			}
+			plan, err := registry.LoadPurgePlan(applyPlan)
+			if err != nil {
+				a.logger.Error(err)
+				os.Exit(codes.InvalidConfig)
+			}
+			summary = a.applyPurgePlan(ctx, plan)
+		} else {
+			summary = a.purgeOldTags(ctx, purgeDryRun, confirmAll, planFile)
+		}
+		if a.reportFile != "" {
+			if err := writeReport(a.reportFile, summary, reportEmpty); err != nil {
+				a.logger.Error(err)
+			}
+		}
+		os.Exit(codes.exitCode(ctx, summary))
+	}
+	if a.runID != "" {
+		// The runs of the web server each get their own random ID.
+		a.logger.Warn("Ignoring -run-id without -purge-tags.")
+		a.runID = ""
+	}
 	// Schedules to purge tags.
 	if a.config.PurgeTagsSchedule != "" {
-		c := cron.New()
 		task := func() {
-			a.purgeOldTags(purgeDryRun)
+			if !atomic.CompareAndSwapInt32(&a.purging, 0, 1) {
+				a.logger.Warn("Skipping scheduled purge as another one is running.")
+				return
+			}
+			defer atomic.StoreInt32(&a.purging, 0)
+			a.purgeOldTags(context.Background(), purgeDryRun, confirmAll, "")
 		}
-		if err := c.AddFunc(a.config.PurgeTagsSchedule, task); err != nil {
-			panic(fmt.Errorf("Invalid schedule format: %s", a.config.PurgeTagsSchedule))
+		if a.scheduler, err = newPurgeScheduler(a.config.PurgeTagsSchedule, task, a.logger); err != nil {
+			panic(err)
 		}
-		c.Start()
+		a.scheduler.cron.Start()
+	}
+
+	// Purge the repos pushed to as told by the registry notifications.
+	if a.config.PurgeOnPush {
+		debounce := time.Duration(a.config.PurgeOnPushDebounce) * time.Second
+		a.pushPurges = newPushPurges(debounce, func(repo string) bool {
+			return a.purgeRepo(repo, purgeDryRun)
+		})
 	}
 
 	// Count tags in background.
@@ -149,6 +517,11 @@ func main() {
 	e.GET(a.config.BasePath+"/:namespace/:repo/:tag", a.viewTagInfo)
 	e.GET(a.config.BasePath+"/:namespace/:repo/:tag/delete", a.deleteTag)
 	e.GET(a.config.BasePath+"/events", a.viewLog)
+	e.GET(a.config.BasePath+"/purge-history", a.viewPurgeHistory)
+	e.GET(a.config.BasePath+"/purge-history/would-delete", a.viewWouldDelete)
+	e.GET(a.config.BasePath+"/purge-history/:id", a.viewPurgeRun)
+	e.GET(a.config.BasePath+"/purge-history/run", a.streamPurge)
+	e.POST(a.config.BasePath+"/purge-history/run", a.streamPurge)
 
 	// Protected event listener.
 	p := e.Group(a.config.BasePath + "/api")
@@ -159,6 +532,19 @@ func main() {
 	}))
 	p.POST("/events", a.receiveEvents)
 
+	// Protected purge scheduler controls, when a token is set.
+	if a.config.PurgeSchedulerToken != "" {
+		s := e.Group(a.config.BasePath + "/scheduler")
+		s.Use(middleware.KeyAuthWithConfig(middleware.KeyAuthConfig{
+			Validator: middleware.KeyAuthValidator(func(token string, c echo.Context) (bool, error) {
+				return token == a.config.PurgeSchedulerToken, nil
+			}),
+		}))
+		s.POST("/pause", a.pauseScheduler)
+		s.POST("/resume", a.resumeScheduler)
+		s.GET("/status", a.viewSchedulerStatus)
+	}
+
 	e.Logger.Fatal(e.Start(a.config.ListenAddr))
 }
 
@@ -188,11 +574,20 @@ func (a *apiClient) viewTags(c echo.Context) error {
 
 	tags := a.client.Tags(repoPath)
 	deleteAllowed := a.checkDeletePermission(c.Request().Header.Get("X-WEBAUTH-USER"))
+	// Sizes missing for the tags failing to be fetched are shown empty.
+	sizes := map[string]int64{}
+	for _, tag := range tags {
+		sizes[tag] = -1
+	}
+	for tag, size := range a.client.TagSizes(repoPath, tags, tagSizeWorkers) {
+		sizes[tag] = size
+	}
 
 	data := jet.VarMap{}
 	data.Set("namespace", namespace)
 	data.Set("repo", repo)
 	data.Set("tags", tags)
+	data.Set("sizes", sizes)
 	data.Set("deleteAllowed", deleteAllowed)
 	repoPath, _ = url.PathUnescape(repoPath)
 	data.Set("events", a.eventListener.GetEvents(repoPath))
@@ -210,21 +605,19 @@ func (a *apiClient) viewTagInfo(c echo.Context) error {
 	}
 
 	sha256, infoV1, infoV2 := a.client.TagInfo(repoPath, tag, false)
-	if infoV1 == "" || infoV2 == "" {
+	if infoV2 == "" || (infoV1 == "" && !a.config.DisableSchema1) {
 		return c.Redirect(http.StatusSeeOther, fmt.Sprintf("%s/%s/%s", a.config.BasePath, namespace, repo))
 	}
-
-	var imageSize int64
-	if gjson.Get(infoV2, "layers").Exists() {
-		for _, s := range gjson.Get(infoV2, "layers.#.size").Array() {
-			imageSize = imageSize + s.Int()
-		}
-	} else {
-		for _, s := range gjson.Get(infoV2, "history.#.v1Compatibility").Array() {
-			imageSize = imageSize + gjson.Get(s.String(), "Size").Int()
+	// Without manifest v1, the creation date comes from the config blob.
+	created := gjson.Get(gjson.Get(infoV1, "history.0.v1Compatibility").String(), "created").String()
+	if infoV1 == "" {
+		if config, err := a.client.ConfigBlob(repoPath, tag); err == nil && !config.Created.IsZero() {
+			created = config.Created.UTC().Format(time.RFC3339)
 		}
 	}
 
+	imageSize := registry.ImageSize(infoV2)
+
 	var layersV2 []map[string]gjson.Result
 	for _, s := range gjson.Get(infoV2, "layers").Array() {
 		layersV2 = append(layersV2, s.Map())
@@ -248,9 +641,9 @@ func (a *apiClient) viewTagInfo(c echo.Context) error {
 	data.Set("repo", repo)
 	data.Set("sha256", sha256)
 	data.Set("imageSize", imageSize)
-	data.Set("tag", gjson.Get(infoV1, "tag").String())
-	data.Set("repoPath", gjson.Get(infoV1, "name").String())
-	data.Set("created", gjson.Get(gjson.Get(infoV1, "history.0.v1Compatibility").String(), "created").String())
+	data.Set("tag", tag)
+	data.Set("repoPath", repoPath)
+	data.Set("created", created)
 	data.Set("layersCount", layersCount)
 	data.Set("layersV2", layersV2)
 	data.Set("layersV1", layersV1)
@@ -296,13 +689,248 @@ func (a *apiClient) viewLog(c echo.Context) error {
 	return c.Render(http.StatusOK, "event_log.html", data)
 }
 
-// receiveEvents receive events.
+// receiveEvents receive events and schedule the purges of the repos pushed to if enabled.
 func (a *apiClient) receiveEvents(c echo.Context) error {
-	a.eventListener.ProcessEvents(c.Request())
+	pushed := a.eventListener.ProcessEvents(c.Request())
+	if a.pushPurges != nil {
+		for _, repo := range pushed {
+			a.pushPurges.pushed(repo)
+		}
+	}
 	return c.String(http.StatusOK, "OK")
 }
 
+// viewPurgeHistory view recent purging runs.
+func (a *apiClient) viewPurgeHistory(c echo.Context) error {
+	runs := []*registry.PurgeSummary{}
+	if a.purgeHistory != nil {
+		runs = a.purgeHistory.List(100)
+	}
+	data := jet.VarMap{}
+	data.Set("runs", runs)
+	data.Set("deleteAllowed", a.checkDeletePermission(c.Request().Header.Get("X-WEBAUTH-USER")))
+	data.Set("evaluateOnly", a.config.PurgeEvaluateOnly)
+
+	return c.Render(http.StatusOK, "purge_history.html", data)
+}
+
+// viewWouldDelete view the tags the dry-runs since the latest live run would have deleted.
+func (a *apiClient) viewWouldDelete(c echo.Context) error {
+	decisions, runs := []*history.TagDecision{}, 0
+	if a.purgeHistory != nil {
+		decisions, runs = a.purgeHistory.WouldDelete()
+	}
+	data := jet.VarMap{}
+	data.Set("decisions", decisions)
+	data.Set("runs", runs)
+	data.Set("evaluateOnly", a.config.PurgeEvaluateOnly)
+
+	return c.Render(http.StatusOK, "purge_would_delete.html", data)
+}
+
+// viewPurgeRun view the details of a purging run.
+func (a *apiClient) viewPurgeRun(c echo.Context) error {
+	var run *registry.PurgeSummary
+	if a.purgeHistory != nil {
+		run = a.purgeHistory.Get(c.Param("id"))
+	}
+	if run == nil {
+		return c.Redirect(http.StatusSeeOther, a.config.BasePath+"/purge-history")
+	}
+	data := jet.VarMap{}
+	data.Set("run", run)
+
+	return c.Render(http.StatusOK, "purge_run.html", data)
+}
+
+// streamPurge runs a purge on demand streaming its progress as server-sent events, then its summary.
+// It is a dry-run unless dry_run=false is posted by a user allowed to delete, always in evaluate-only mode.
+// A live run is refused over GET so a link or a prefetch cannot delete tags.
+func (a *apiClient) streamPurge(c echo.Context) error {
+	dryRun := a.config.PurgeEvaluateOnly || c.QueryParam("dry_run") != "false"
+	if !dryRun && c.Request().Method != http.MethodPost {
+		return c.String(http.StatusMethodNotAllowed, "Purging requires a POST request.")
+	}
+	if !dryRun && !a.checkDeletePermission(c.Request().Header.Get("X-WEBAUTH-USER")) {
+		return c.String(http.StatusForbidden, "Purging is not allowed.")
+	}
+	if !atomic.CompareAndSwapInt32(&a.purging, 0, 1) {
+		return c.String(http.StatusConflict, "Another purge is running.")
+	}
+	defer atomic.StoreInt32(&a.purging, 0)
+
+	resp := c.Response()
+	resp.Header().Set(echo.HeaderContentType, "text/event-stream")
+	resp.Header().Set("Cache-Control", "no-cache")
+	resp.WriteHeader(http.StatusOK)
+	send := func(event string, data interface{}) {
+		b, err := json.Marshal(data)
+		if err != nil {
+			a.logger.Error(err)
+			return
+		}
+		fmt.Fprintf(resp, "event: %s\ndata: %s\n\n", event, b)
+		resp.Flush()
+	}
+
+	opts := a.purgeTagsOptions()
+	opts.DryRun = dryRun
+	if dryRun {
+		// The dry-runs are looked at on the page, only the live runs are reported.
+		opts.ReportSinks = nil
+	}
+	opts.Progress = func(p registry.PurgeProgress) {
+		send(p.Event, p)
+	}
+	// The purge is cancelled through the drain of the in-flight deletions once the browser closes the stream.
+	summary := registry.PurgeOldTags(c.Request().Context(), a.client, opts)
+	a.recordPurge(summary)
+	send("summary", summary)
+	return nil
+}
+
+// deleteRepository deletes all the tags of the repo, only once confirmed.
+func (a *apiClient) deleteRepository(repo string, dryRun, confirm bool) bool {
+	tags := a.client.Tags(repo)
+	if dryRun || !confirm {
+		a.logger.Infof("[%s] %d tags to delete: %v", repo, len(tags), tags)
+		if !dryRun {
+			a.logger.Error("Not deleting the repository without -confirm-delete-all flag.")
+			return false
+		}
+		return true
+	}
+
+	deleted, errs := a.client.DeleteRepository(repo)
+	for _, err := range errs {
+		a.logger.Error(err)
+	}
+	a.logger.Infof("[%s] Deleted %d manifests of %d tags.", repo, deleted, len(tags))
+	return len(errs) == 0
+}
+
+// purgeTagsOptions build the purging options from the config.
+func (a *apiClient) purgeTagsOptions() registry.PurgeTagsOptions {
+	return registry.PurgeTagsOptions{
+		KeepDays:                  a.config.PurgeTagsKeepDays,
+		KeepCount:                 a.config.PurgeTagsKeepCount,
+		MaxAgeDays:                a.config.PurgeTagsMaxAgeDays,
+		ZeroMeansUnlimited:        a.config.PurgeZeroUnlimited,
+		MinTagsBeforePurge:        a.config.PurgeMinTags,
+		Configs:                   a.config.PurgeConfigs,
+		ConfigsArtifact:           a.config.PurgeConfigsArtifact,
+		UnmatchedTagPolicy:        a.config.PurgeUnmatchedTagPolicy,
+		QuietSkips:                a.config.PurgeQuietSkips,
+		LogTagsLimit:              a.config.PurgeLogTagsLimit,
+		SharedManifestPolicy:      a.config.PurgeSharedManifests,
+		GroupByManifest:           a.config.PurgeGroupByManifest,
+		AgeSource:                 a.config.PurgeAgeSource,
+		ScanWorkers:               a.config.PurgeScanWorkers,
+		InterRepoDelay:            time.Duration(a.config.PurgeInterRepoDelay) * time.Millisecond,
+		TagWorkers:                a.config.PurgeTagWorkers,
+		DeleteWorkers:             a.config.PurgeDeleteWorkers,
+		MaxDeletionsPerRepoPerRun: a.config.PurgeMaxRepoDeletions,
+		DrainTimeout:              time.Duration(a.config.PurgeDrainTimeout) * time.Second,
+		RecentPushGrace:           time.Duration(a.config.PurgePushGrace) * time.Second,
+		VerifyDeletions:           time.Duration(a.config.PurgeVerifyDeletions) * time.Second,
+		MaxDuration:               time.Duration(a.config.PurgeMaxDuration) * time.Second,
+		RepoMaxDuration:           time.Duration(a.config.PurgeRepoMaxDuration) * time.Second,
+		CheckpointFile:            a.config.PurgeCheckpointFile,
+		TombstoneFile:             a.config.PurgeTombstoneFile,
+		FirstSeenFile:             a.config.PurgeFirstSeenFile,
+		CreatedCache:              a.createdCache,
+		WatermarkFile:             a.config.PurgeWatermarkFile,
+		ChangedRepos:              a.changedRepos,
+		FailFast:                  a.config.PurgeFailFast,
+		ExcludeArtifacts:          a.config.PurgeExcludeArtifacts,
+		CrossRepoProtection:       a.config.PurgeCrossRepo,
+		IndexChildProtection:      a.config.PurgeIndexChildren,
+		DeleteCompanions:          a.config.PurgeDeleteCompanions,
+		KeepAttested:              a.config.PurgeKeepAttested,
+		TagDetails:                a.config.PurgeTagDetails,
+		WarnTagCount:              a.config.PurgeWarnTagCount,
+		Namespaces:                a.config.PurgeNamespaces,
+		SamplePercent:             a.samplePercent,
+		RunID:                     a.runID,
+		MeasureBytes:              a.config.PurgeMetricsFile != "" || a.config.PurgePushgatewayURL != "" || len(a.reportSinks) > 0 || a.reportFile != "",
+		TagsKeepIfLargerThanBytes: a.config.PurgeKeepLargerThan,
+		StorageUsage:              a.storageUsage,
+		VulnProvider:              a.vulnProvider,
+		InUseProvider:             a.inUseProvider,
+		ProtectedTagsProvider:     a.protectedTags,
+		ReportSinks:               a.reportSinks,
+		AnchorMatch:               a.config.PurgeAnchorMatch,
+		Location:                  a.purgeLocation,
+	}
+}
+
 // purgeOldTags purges old tags.
-func (a *apiClient) purgeOldTags(dryRun bool) {
-	registry.PurgeOldTags(a.client, dryRun, a.config.PurgeTagsKeepDays, a.config.PurgeTagsKeepCount)
+func (a *apiClient) purgeOldTags(ctx context.Context, dryRun, confirmDeleteAll bool, planFile string) *registry.PurgeSummary {
+	opts := a.purgeTagsOptions()
+	opts.DryRun, opts.ConfirmDeleteAll, opts.PlanFile = dryRun, confirmDeleteAll, planFile
+	opts.Confirm = a.confirmPurge
+	summary := registry.PurgeOldTags(ctx, a.client, opts)
+	a.recordPurge(summary)
+	return summary
+}
+
+// purgeRepo purges the repo pushed to, false if another purge is running. It leaves the watermark
+// and the checkpoint to the purges of all the repos.
+func (a *apiClient) purgeRepo(repo string, dryRun bool) bool {
+	if !atomic.CompareAndSwapInt32(&a.purging, 0, 1) {
+		return false
+	}
+	defer atomic.StoreInt32(&a.purging, 0)
+
+	a.logger.Infof("[%s] Purging the repository pushed to.", repo)
+	opts := a.purgeTagsOptions()
+	opts.DryRun, opts.Repos = dryRun, []string{repo}
+	// Every push would publish a report of a single repo otherwise.
+	opts.WatermarkFile, opts.CheckpointFile, opts.ReportSinks = "", "", nil
+	a.recordPurge(registry.PurgeOldTags(context.Background(), a.client, opts))
+	return true
+}
+
+// applyPurgePlan deletes the tags of the purge plan.
+func (a *apiClient) applyPurgePlan(ctx context.Context, plan *registry.PurgePlan) *registry.PurgeSummary {
+	summary := registry.ApplyPurgePlan(ctx, a.client, plan, a.purgeTagsOptions())
+	a.recordPurge(summary)
+	return summary
+}
+
+// writeReport writes the Markdown report of the purging run to the file or stdout for "-", only the repos
+// left without tags with emptied.
+func writeReport(path string, summary *registry.PurgeSummary, emptied bool) error {
+	report := summary.Markdown()
+	if emptied {
+		report = summary.EmptiedMarkdown()
+	}
+	if path == "-" {
+		_, err := fmt.Print(report)
+		return err
+	}
+	if err := ioutil.WriteFile(path, []byte(report), 0644); err != nil {
+		return fmt.Errorf("Error writing purge report: %s", err)
+	}
+	return nil
+}
+
+// recordPurge saves the purging run summary to the history, pushes its metrics and writes them to the metrics file.
+func (a *apiClient) recordPurge(summary *registry.PurgeSummary) {
+	if a.purgeHistory != nil {
+		if err := a.purgeHistory.Save(summary); err != nil {
+			a.logger.Error(err)
+		}
+	}
+	if a.config.PurgePushgatewayURL != "" {
+		err := registry.PushMetrics(a.config.PurgePushgatewayURL, a.config.PurgePushgatewayJob, a.config.PurgePushgatewayInst, summary)
+		if err != nil {
+			a.logger.Error(err)
+		}
+	}
+	if a.config.PurgeMetricsFile != "" {
+		if err := registry.WriteMetricsFile(a.config.PurgeMetricsFile, summary); err != nil {
+			a.logger.Error(err)
+		}
+	}
 }
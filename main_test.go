@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo"
+	"github.com/quiq/docker-registry-ui/registry"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestStreamPurge(t *testing.T) {
+	run := func(a *apiClient, method, target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, target, nil)
+		req.Header.Set("X-WEBAUTH-USER", "admin")
+		convey.So(a.streamPurge(echo.New().NewContext(req, rec)), convey.ShouldBeNil)
+		return rec
+	}
+
+	convey.Convey("Refuse to start a live purge over GET", t, func() {
+		a := &apiClient{config: configData{Admins: []string{"admin"}}}
+		rec := run(a, http.MethodGet, "/purge-history/run?dry_run=false")
+		convey.So(rec.Code, convey.ShouldEqual, http.StatusMethodNotAllowed)
+		convey.So(a.purging, convey.ShouldEqual, 0)
+	})
+
+	convey.Convey("Refuse a posted live purge to the users not allowed to delete", t, func() {
+		a := &apiClient{}
+		rec := run(a, http.MethodPost, "/purge-history/run?dry_run=false")
+		convey.So(rec.Code, convey.ShouldEqual, http.StatusForbidden)
+	})
+
+	convey.Convey("Accept a posted live purge and the dry-runs over GET", t, func() {
+		// The purge already running answers before any registry call.
+		a := &apiClient{config: configData{Admins: []string{"admin"}}, purging: 1}
+		rec := run(a, http.MethodPost, "/purge-history/run?dry_run=false")
+		convey.So(rec.Code, convey.ShouldEqual, http.StatusConflict)
+		rec = run(a, http.MethodGet, "/purge-history/run?dry_run=true")
+		convey.So(rec.Code, convey.ShouldEqual, http.StatusConflict)
+	})
+
+	convey.Convey("Cancel the purge once the stream is closed", t, func() {
+		server := newEmptyRegistry()
+		defer server.Close()
+		a := &apiClient{client: registry.NewClient(server.URL, false, "", ""), config: configData{PurgeTagsKeepDays: 7, PurgeTagsKeepCount: 1}}
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/purge-history/run?dry_run=true", nil).WithContext(ctx)
+		convey.So(a.streamPurge(echo.New().NewContext(req, rec)), convey.ShouldBeNil)
+		convey.So(rec.Body.String(), convey.ShouldContainSubstring, "purging cancelled: context canceled")
+		convey.So(a.purging, convey.ShouldEqual, 0)
+	})
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// pushPurges debounce the purges of the repos pushed to, so a burst of pushes, e.g. of several tags by a CI job,
+// triggers a single purge of the repo once no push came for the debounce duration.
+type pushPurges struct {
+	mux      sync.Mutex
+	timers   map[string]*time.Timer
+	debounce time.Duration
+	// purge purges the repo, false when it could not as another purge is running, so it is retried later.
+	purge func(repo string) bool
+}
+
+func newPushPurges(debounce time.Duration, purge func(repo string) bool) *pushPurges {
+	return &pushPurges{timers: map[string]*time.Timer{}, debounce: debounce, purge: purge}
+}
+
+// pushPurgeRetryDelay is the minimal delay before retrying a purge refused as another one is running, so a zero
+// debounce does not retry in a tight loop while a long purge runs.
+var pushPurgeRetryDelay = time.Second
+
+// pushed schedule the purge of the repo after the debounce duration, postponing the one already scheduled.
+func (p *pushPurges) pushed(repo string) {
+	p.schedule(repo, p.debounce)
+}
+
+// schedule the purge of the repo after the delay, replacing the one already scheduled, and retry it after
+// the debounce duration or pushPurgeRetryDelay, whichever is longer, while another purge is running.
+func (p *pushPurges) schedule(repo string, delay time.Duration) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	if t, ok := p.timers[repo]; ok {
+		t.Stop()
+	}
+	var t *time.Timer
+	t = time.AfterFunc(delay, func() {
+		p.mux.Lock()
+		// A push meanwhile replaced the timer which was firing.
+		current := p.timers[repo] == t
+		if current {
+			delete(p.timers, repo)
+		}
+		p.mux.Unlock()
+		if current && !p.purge(repo) {
+			retry := p.debounce
+			if retry < pushPurgeRetryDelay {
+				retry = pushPurgeRetryDelay
+			}
+			p.schedule(repo, retry)
+		}
+	})
+	p.timers[repo] = t
+}
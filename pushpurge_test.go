@@ -0,0 +1,53 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestPushPurges(t *testing.T) {
+	convey.Convey("Purge a repo once after a burst of pushes", t, func() {
+		mux := sync.Mutex{}
+		purged := []string{}
+		p := newPushPurges(20*time.Millisecond, func(repo string) bool {
+			mux.Lock()
+			defer mux.Unlock()
+			purged = append(purged, repo)
+			return true
+		})
+		for i := 0; i < 5; i++ {
+			p.pushed("app")
+			time.Sleep(5 * time.Millisecond)
+		}
+		p.pushed("tools/ci")
+		time.Sleep(60 * time.Millisecond)
+		mux.Lock()
+		defer mux.Unlock()
+		convey.So(purged, convey.ShouldHaveLength, 2)
+		convey.So(purged, convey.ShouldContain, "app")
+		convey.So(purged, convey.ShouldContain, "tools/ci")
+	})
+
+	convey.Convey("Retry the purge refused as another is running after the retry delay", t, func() {
+		defer func(delay time.Duration) { pushPurgeRetryDelay = delay }(pushPurgeRetryDelay)
+		pushPurgeRetryDelay = 30 * time.Millisecond
+		mux := sync.Mutex{}
+		attempts := []time.Time{}
+		p := newPushPurges(0, func(repo string) bool {
+			mux.Lock()
+			defer mux.Unlock()
+			attempts = append(attempts, time.Now())
+			return len(attempts) == 3
+		})
+		p.pushed("app")
+		time.Sleep(150 * time.Millisecond)
+		mux.Lock()
+		defer mux.Unlock()
+		// A zero debounce does not retry in a tight loop.
+		convey.So(attempts, convey.ShouldHaveLength, 3)
+		convey.So(attempts[2].Sub(attempts[0]), convey.ShouldBeGreaterThanOrEqualTo, 2*pushPurgeRetryDelay)
+	})
+}
@@ -0,0 +1,51 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// PurgeCheckpoint repos already purged by the runs stopped on MaxDuration, so the next run resumes after them.
+type PurgeCheckpoint struct {
+	Updated time.Time `json:"updated"`
+	Repos   []string  `json:"repos"`
+}
+
+// loadCheckpoint read the checkpoint from the file, an empty one if the file does not exist.
+func loadCheckpoint(path string) (*PurgeCheckpoint, error) {
+	checkpoint := &PurgeCheckpoint{Repos: []string{}}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return checkpoint, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error reading purge checkpoint: %s", err)
+	}
+	if err := json.Unmarshal(data, checkpoint); err != nil {
+		return nil, fmt.Errorf("Error parsing purge checkpoint %s: %s", path, err)
+	}
+	return checkpoint, nil
+}
+
+// save write the checkpoint to the file.
+func (c *PurgeCheckpoint) save(path string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("Error writing purge checkpoint: %s", err)
+	}
+	return nil
+}
+
+// removeCheckpoint remove the checkpoint file once a run completed all the repos.
+func removeCheckpoint(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Error removing purge checkpoint: %s", err)
+	}
+	return nil
+}
@@ -1,13 +1,21 @@
 package registry
 
 import (
+	"context"
 	"crypto"
+	"crypto/sha256"
 	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"net/url"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/hhkbp2/go-logging"
@@ -15,36 +23,144 @@ import (
 	"github.com/tidwall/gjson"
 )
 
+// manifestAcceptHeader accepted manifest media types for schema2, OCI image, index and artifact.
+const manifestAcceptHeader = MediaTypeManifestV2 + ", " + MediaTypeManifestList + ", " +
+	MediaTypeOCIManifest + ", " + MediaTypeOCIIndex + ", " + MediaTypeOCIArtifact
+
+// linkRegexp parse the next page URI from the pagination Link header.
+var linkRegexp = regexp.MustCompile("^<(.*?)>;.*$")
+
+// catalogPageSize the catalog page size requested, registries capping it server-side return smaller pages
+// and the walk follows their Link headers whatever the size of the pages.
+const catalogPageSize = 1000
+
+// nextLink return the target of the rel="next" link of the pagination Link header, or of its only link
+// if it has no rel, empty on the last page.
+func nextLink(header string) string {
+	if m := nextLinkRegexp.FindStringSubmatch(header); m != nil {
+		return m[1]
+	}
+	if link := linkRegexp.FindStringSubmatch(header); len(link) == 2 && !strings.Contains(header, "rel=") {
+		return link[1]
+	}
+	return ""
+}
+
 // Client main class.
 type Client struct {
 	url       string
 	verifyTLS bool
 	username  string
 	password  string
-	request   *gorequest.SuperAgent
+	basicAuth bool
 	logger    logging.Logger
 	mux       sync.Mutex
+	tokenMux  sync.Mutex
 	tokens    map[string]string
 	repos     map[string][]string
 	tagCounts map[string]int
 	authURL   string
+	sem       chan struct{}
+	// anonymous makes the tokens requested without credentials first, see SetAnonymousPull.
+	anonymous  bool
+	anonTokens map[string]string
+	denied     map[string]bool
+
+	configMux   sync.Mutex
+	configCache map[string]*ImageConfig
+	// sizeCache are the image sizes by manifest digest, see TagSize.
+	sizeCache map[string]int64
+	// allPlatforms sums the sizes of all the manifests of manifest lists, see SetTagSizeAllPlatforms.
+	allPlatforms bool
+	// fixtures record or replay the registry responses, see RecordFixtures.
+	fixtures *fixtures
+	// noHead makes manifests checked with GET instead of HEAD, see SetDisableHead.
+	noHead int32
+	// noSchema1 makes manifest v1 never requested, see SetDisableSchema1.
+	noSchema1 bool
+	// transport is shared by all the requests to reuse connections, see SetConnectionPool.
+	transport *http.Transport
+	// roundTripper is the transport wrapped by wrapTransport if any, the one the requests are sent with.
+	roundTripper  http.RoundTripper
+	wrapTransport func(http.RoundTripper) http.RoundTripper
+	// maxResponseSize bounds the response bodies read, see SetMaxResponseSize.
+	maxResponseSize int64
+	// tracer starts the spans of the operations if set, see SetTracer.
+	tracer Tracer
+	// retries are the retries per failing request within retryBudget, see SetRetries.
+	retries     int
+	retryBudget *retryBudget
+	retryDelay  time.Duration
+}
+
+// Connection pool defaults, higher than net/http ones keeping only 2 idle connections per host
+// as nearly all the requests go to the same registry host.
+const (
+	DefaultMaxIdleConns    = 100
+	DefaultIdleConnTimeout = 90 * time.Second
+)
+
+// resolveDigestsWorkers is the number of digests ResolveDigests resolves concurrently without
+// SetMaxConcurrentRequests bounding the requests.
+const resolveDigestsWorkers = 8
+
+// DefaultMaxResponseSize bounds the response bodies read from the registry, way above the size of manifests,
+// config blobs and catalog pages.
+const DefaultMaxResponseSize = 32 << 20
+
+func init() {
+	// Let the requests use the transport set by newRequest instead of replacing it with their own.
+	gorequest.DisableTransportSwap = true
+}
+
+// ImageConfig parsed image config blob.
+type ImageConfig struct {
+	Digest       string
+	Created      time.Time
+	Architecture string
+	OS           string
+	Author       string
+	Labels       map[string]string
+	// ArtifactType is empty for container images, see ArtifactType.
+	ArtifactType string
+	// CreatedSource is DateSourceConfig, or DateSourceAnnotation when Created comes from the manifest annotation.
+	CreatedSource string
 }
 
 // NewClient initialize Client.
 func NewClient(url string, verifyTLS bool, username, password string) *Client {
+	return NewClientWithTransport(url, verifyTLS, username, password, nil)
+}
+
+// NewClientWithTransport initialize Client sending all its requests, including the ones discovering the auth,
+// with the transport returned by wrap, e.g. to go through a corporate proxy with its auth, sign the requests
+// or instrument them, without the client supporting each explicitly. wrap is given the transport of
+// SetConnectionPool, which it should delegate to, and called again whenever it is replaced. The retries of
+// SetRetries and the bound of SetMaxConcurrentRequests apply above it, so every attempt goes through the
+// wrapped transport, and SetMaxResponseSize bounds the responses it returns. nil wrap is NewClient.
+func NewClientWithTransport(url string, verifyTLS bool, username, password string, wrap func(http.RoundTripper) http.RoundTripper) *Client {
 	c := &Client{
-		url:       strings.TrimRight(url, "/"),
+		url:       normalizeURL(url),
 		verifyTLS: verifyTLS,
 		username:  username,
 		password:  password,
 
-		request:   gorequest.New().TLSClientConfig(&tls.Config{InsecureSkipVerify: !verifyTLS}),
+		wrapTransport: wrap,
+
 		logger:    SetupLogging("registry.client"),
 		tokens:    map[string]string{},
 		repos:     map[string][]string{},
 		tagCounts: map[string]int{},
+
+		anonTokens: map[string]string{},
+		denied:     map[string]bool{},
+
+		configCache: map[string]*ImageConfig{},
+		sizeCache:   map[string]int64{},
 	}
-	resp, _, errs := c.request.Get(c.url+"/v2/").Set("User-Agent", "docker-registry-ui").End()
+	c.SetConnectionPool(0, 0, 0)
+	c.SetMaxResponseSize(0)
+	resp, _, errs := c.newRequest().Get(c.url+"/v2/").Set("User-Agent", "docker-registry-ui").End()
 	if len(errs) > 0 {
 		c.logger.Error(errs[0])
 		return nil
@@ -71,25 +187,271 @@ func NewClient(url string, verifyTLS bool, username, password string) *Client {
 			return nil
 		}
 	} else if strings.HasPrefix(strings.ToLower(authHeader), "basic") {
-		c.request = c.request.SetBasicAuth(c.username, c.password)
+		c.basicAuth = true
 		c.logger.Info("It was discovered the registry is configured with HTTP basic auth.")
 	}
 
 	return c
 }
 
+// normalizeURL strip the trailing slashes and API version path from the registry URL as all the request URIs start
+// with "/v2/", so "https://host", "https://host/" and "https://host/v2/" all work. A path prefix, e.g. of a reverse
+// proxy serving the registry under "https://host/registry", is kept.
+func normalizeURL(registryURL string) string {
+	registryURL = strings.TrimRight(strings.TrimSpace(registryURL), "/")
+	return strings.TrimRight(strings.TrimSuffix(registryURL, "/v2"), "/")
+}
+
+// linkURI return the request URI of a pagination Link header target, which registries give either as a path
+// or as an absolute URL, with or without the path prefix of the registry URL.
+func (c *Client) linkURI(link string) string {
+	if u, err := url.Parse(link); err == nil && u.IsAbs() {
+		link = u.RequestURI()
+	}
+	if u, err := url.Parse(c.url); err == nil && u.Path != "" && strings.HasPrefix(link, u.Path+"/") {
+		link = strings.TrimPrefix(link, u.Path)
+	}
+	if !strings.HasPrefix(link, "/") {
+		link = "/" + link
+	}
+	return link
+}
+
+// newRequest return a new request agent, those are not safe to share between goroutines.
+func (c *Client) newRequest() *gorequest.SuperAgent {
+	request := gorequest.New().RedirectPolicy(redirectPolicy)
+	request.Client.Transport = &limitedTransport{transport: c.roundTripper, max: c.maxResponseSize}
+	if c.basicAuth {
+		request = request.SetBasicAuth(c.username, c.password)
+	}
+	return request
+}
+
+// redirectPolicy follow up to 10 redirects like net/http does but never send the registry credentials to other hosts,
+// e.g. blob downloads redirected to the signed URLs of S3 or GCS, which reject requests having them.
+func redirectPolicy(req gorequest.Request, via []gorequest.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	if req.URL.Host != via[0].URL.Host {
+		req.Header.Del("Authorization")
+	}
+	return nil
+}
+
+// limitedTransport cut the response bodies after max bytes, so reading a huge or endless body,
+// e.g. from a misconfigured registry or a gzip bomb as it applies to the decompressed body, does not
+// exhaust the memory. send fails on the cut bodies.
+type limitedTransport struct {
+	transport http.RoundTripper
+	max       int64
+}
+
+func (t *limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.transport.RoundTrip(req)
+	if err != nil || t.max <= 0 {
+		return resp, err
+	}
+	if req.Method != http.MethodHead && resp.ContentLength > t.max {
+		resp.Body.Close()
+		return nil, responseTooLarge(req.URL.String(), t.max)
+	}
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(resp.Body, t.max+1), resp.Body}
+	return resp, nil
+}
+
+// tooLargeError error of the response exceeding the max response size.
+type tooLargeError struct {
+	url string
+	max int64
+}
+
+func (e *tooLargeError) Error() string {
+	return fmt.Sprintf("response of %s exceeds the max response size of %d bytes", e.url, e.max)
+}
+
+// responseTooLarge return the error of the response exceeding the max response size.
+func responseTooLarge(url string, max int64) error {
+	return &tooLargeError{url: url, max: max}
+}
+
+// SetMaxResponseSize bound the size of the response bodies read from the registry, larger responses fail
+// instead of being read into memory. 0 uses DefaultMaxResponseSize, a negative value means unlimited.
+// Call it before using the client.
+func (c *Client) SetMaxResponseSize(n int64) {
+	switch {
+	case n == 0:
+		c.maxResponseSize = DefaultMaxResponseSize
+	case n < 0:
+		c.maxResponseSize = 0
+	default:
+		c.maxResponseSize = n
+	}
+}
+
+// SetConnectionPool tune the connections kept open to the registry, call it before using the client.
+// The transport given to the wrap of NewClientWithTransport is replaced then.
+// maxIdleConns idle connections are kept open for idleConnTimeout to be reused, which saves the TLS handshakes
+// when scanning with many workers at the cost of open connections on the registry side, defaults are
+// DefaultMaxIdleConns and DefaultIdleConnTimeout. maxConnsPerHost bounds all the connections including
+// the active ones, requests wait for a free one then, 0 means unlimited, see also SetMaxConcurrentRequests.
+func (c *Client) SetConnectionPool(maxIdleConns, maxConnsPerHost int, idleConnTimeout time.Duration) {
+	if maxIdleConns <= 0 {
+		maxIdleConns = DefaultMaxIdleConns
+	}
+	if idleConnTimeout <= 0 {
+		idleConnTimeout = DefaultIdleConnTimeout
+	}
+	c.transport = &http.Transport{
+		TLSClientConfig:     &tls.Config{InsecureSkipVerify: !c.verifyTLS},
+		MaxIdleConns:        maxIdleConns,
+		MaxIdleConnsPerHost: maxIdleConns,
+		MaxConnsPerHost:     maxConnsPerHost,
+		IdleConnTimeout:     idleConnTimeout,
+	}
+	c.roundTripper = c.transport
+	if c.wrapTransport != nil {
+		c.roundTripper = c.wrapTransport(c.transport)
+	}
+}
+
+// SetMaxConcurrentRequests bound the number of concurrent requests to the registry, 0 means unlimited.
+// The bound is shared by everything using the client, e.g. scanning repos, fetching tags and deleting them.
+func (c *Client) SetMaxConcurrentRequests(n int) {
+	if n > 0 {
+		c.sem = make(chan struct{}, n)
+	} else {
+		c.sem = nil
+	}
+}
+
+// SetAnonymousPull make the client request anonymous tokens first, e.g. to browse public registries or namespaces,
+// falling back to the credentials for the scopes where the anonymous access is denied, e.g. on deletion.
+// Tokens are always requested anonymously when no username is configured.
+func (c *Client) SetAnonymousPull(anonymous bool) {
+	c.anonymous = anonymous
+}
+
+// SetDisableHead make the client check manifests with GET instead of HEAD requests, e.g. behind proxies blocking HEAD.
+// It is also disabled once HEAD is answered with 405 Method Not Allowed or 501 Not Implemented.
+func (c *Client) SetDisableHead(disable bool) {
+	var noHead int32
+	if disable {
+		noHead = 1
+	}
+	atomic.StoreInt32(&c.noHead, noHead)
+}
+
+// SetDisableSchema1 make the client never request manifest v1, e.g. for registries which turned schema1 off.
+// Tag creation dates come from the config blobs and manifest annotations only then.
+func (c *Client) SetDisableSchema1(disable bool) {
+	c.noSchema1 = disable
+}
+
+// methodBlocked check whether the status means the request method is not allowed by the registry or a proxy.
+func methodBlocked(status int) bool {
+	return status == 405 || status == 501
+}
+
+// end send the request retrying it with the credentials when denied with an anonymous token.
+func (c *Client) end(request *gorequest.SuperAgent) (gorequest.Response, string, []error) {
+	resp, data, errs := c.send(request)
+	if c.anonymous && len(errs) == 0 && resp.StatusCode == 401 {
+		if token := c.credentialsToken(request.Header["Authorization"]); token != "" {
+			request.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+			resp, data, errs = c.send(request)
+		}
+	}
+	return resp, data, errs
+}
+
+// send send the request waiting for a free slot when the number of concurrent requests is bounded,
+// recording the response or replaying a recorded one when fixtures are enabled.
+func (c *Client) send(request *gorequest.SuperAgent) (gorequest.Response, string, []error) {
+	if c.fixtures != nil && c.fixtures.replay {
+		return c.fixtures.load(c.fixtureURI(request), request)
+	}
+	resp, data, errs := c.roundTrip(request)
+	if c.retryBudget != nil {
+		c.retryBudget.deposit()
+		for attempt := 1; attempt <= c.retries && retryable(request, resp, errs); attempt++ {
+			ok, exhausted := c.retryBudget.withdraw()
+			if exhausted {
+				c.logger.Warn("Retry budget exhausted, failing requests without retrying them.")
+			}
+			if !ok {
+				break
+			}
+			time.Sleep(time.Duration(attempt) * c.retryDelay)
+			// gorequest keeps the errors of the previous attempt and would fail again with them.
+			request.Errors = nil
+			resp, data, errs = c.roundTrip(request)
+		}
+	}
+	if max := c.maxResponseSize; max > 0 && len(errs) == 0 && int64(len(data)) > max {
+		return resp, "", []error{responseTooLarge(request.Url, max)}
+	}
+	if c.fixtures != nil && len(errs) == 0 {
+		c.fixtures.save(c.fixtureURI(request), request, resp, data)
+	}
+	return resp, data, errs
+}
+
+// roundTrip send the request once waiting for a free slot when the number of concurrent requests is bounded.
+func (c *Client) roundTrip(request *gorequest.SuperAgent) (gorequest.Response, string, []error) {
+	if sem := c.sem; sem != nil {
+		sem <- struct{}{}
+		defer func() {
+			<-sem
+		}()
+	}
+	return request.End()
+}
+
 // getToken get existing or new auth token.
 func (c *Client) getToken(scope string) string {
+	c.tokenMux.Lock()
+	defer c.tokenMux.Unlock()
+
 	// Check if we have already a token and it's not expired.
 	if token, ok := c.tokens[scope]; ok {
-		resp, _, _ := c.request.Get(c.url+"/v2/").Set("Authorization", fmt.Sprintf("Bearer %s", token)).Set("User-Agent", "docker-registry-ui").End()
+		resp, _, _ := c.send(c.newRequest().Get(c.url+"/v2/").Set("Authorization", fmt.Sprintf("Bearer %s", token)).Set("User-Agent", "docker-registry-ui"))
 		if resp != nil && resp.StatusCode == 200 {
 			return token
 		}
 	}
 
+	anonymous := c.username == "" || (c.anonymous && !c.denied[scope])
+	token := c.requestToken(scope, anonymous)
+	if token == "" && anonymous && c.username != "" {
+		c.denied[scope] = true
+		anonymous = false
+		token = c.requestToken(scope, false)
+	}
+	if token == "" {
+		return ""
+	}
+
+	c.tokens[scope] = token
+	if anonymous && c.username != "" {
+		c.anonTokens[token] = scope
+	}
+	c.logger.Info("Received new token for scope ", scope)
+
+	return c.tokens[scope]
+}
+
+// requestToken request a new auth token from the token auth service, anonymously or with the credentials.
+func (c *Client) requestToken(scope string, anonymous bool) string {
 	request := gorequest.New().TLSClientConfig(&tls.Config{InsecureSkipVerify: !c.verifyTLS})
-	resp, data, errs := request.Get(fmt.Sprintf("%s&scope=%s", c.authURL, scope)).SetBasicAuth(c.username, c.password).Set("User-Agent", "docker-registry-ui").End()
+	request = request.Get(fmt.Sprintf("%s&scope=%s", c.authURL, scope)).Set("User-Agent", "docker-registry-ui")
+	if !anonymous {
+		request = request.SetBasicAuth(c.username, c.password)
+	}
+	resp, data, errs := request.End()
 	if len(errs) > 0 {
 		c.logger.Error(errs[0])
 		return ""
@@ -98,22 +460,44 @@ func (c *Client) getToken(scope string) string {
 		c.logger.Error("Failed to get token for scope ", scope, " from ", c.authURL)
 		return ""
 	}
+	return gjson.Get(data, "token").String()
+}
 
-	c.tokens[scope] = gjson.Get(data, "token").String()
-	c.logger.Info("Received new token for scope ", scope)
+// credentialsToken get the token with the credentials for the scope of the denied anonymous token
+// from the Authorization header, or empty string if not an anonymous one.
+func (c *Client) credentialsToken(authHeader string) string {
+	c.tokenMux.Lock()
+	token := strings.TrimPrefix(authHeader, "Bearer ")
+	scope, ok := c.anonTokens[token]
+	if ok {
+		c.logger.Info("Anonymous access denied for scope ", scope, ", using credentials.")
+		c.denied[scope] = true
+		delete(c.anonTokens, token)
+		if _, anon := c.anonTokens[c.tokens[scope]]; anon || c.tokens[scope] == token {
+			delete(c.anonTokens, c.tokens[scope])
+			delete(c.tokens, scope)
+		}
+	}
+	c.tokenMux.Unlock()
 
-	return c.tokens[scope]
+	if !ok {
+		return ""
+	}
+	return c.getToken(scope)
 }
 
 // callRegistry make an HTTP request to Docker registry.
-func (c *Client) callRegistry(uri, scope string, manifest uint, delete bool) (string, gorequest.Response) {
-	acceptHeader := fmt.Sprintf("application/vnd.docker.distribution.manifest.v%d+json", manifest)
+func (c *Client) callRegistry(uri, scope string, manifest uint) (string, gorequest.Response) {
+	acceptHeader := MediaTypeManifestV2
+	if manifest == 1 {
+		acceptHeader = MediaTypeManifestV1
+	}
 	authHeader := ""
 	if c.authURL != "" {
 		authHeader = fmt.Sprintf("Bearer %s", c.getToken(scope))
 	}
 
-	resp, data, errs := c.request.Get(c.url+uri).Set("Accept", acceptHeader).Set("Authorization", authHeader).Set("User-Agent", "docker-registry-ui").End()
+	resp, data, errs := c.end(c.newRequest().Get(c.url+uri).Set("Accept", acceptHeader).Set("Authorization", authHeader).Set("User-Agent", "docker-registry-ui"))
 	if len(errs) > 0 {
 		c.logger.Error(errs[0])
 		return "", resp
@@ -125,8 +509,7 @@ func (c *Client) callRegistry(uri, scope string, manifest uint, delete bool) (st
 		return "", resp
 	}
 
-	digest := resp.Header.Get("Docker-Content-Digest")
-	if digest == "" {
+	if resp.Header.Get("Docker-Content-Digest") == "" {
 		// Try to get digest from body instead, should be equal to what would be presented
 		// in Docker-Content-Digest
 		h := crypto.SHA256.New()
@@ -134,21 +517,329 @@ func (c *Client) callRegistry(uri, scope string, manifest uint, delete bool) (st
 		resp.Header.Set("Docker-Content-Digest", fmt.Sprintf("sha256:%x", h.Sum(nil)))
 	}
 
-	if delete {
-		// Delete by manifest digest reference.
-		parts := strings.Split(uri, "/manifests/")
-		uri = parts[0] + "/manifests/" + digest
-		resp, _, errs := c.request.Delete(c.url+uri).Set("Accept", acceptHeader).Set("Authorization", authHeader).Set("User-Agent", "docker-registry-ui").End()
-		if len(errs) > 0 {
-			c.logger.Error(errs[0])
-		} else {
-			// Returns 202 on success.
-			c.logger.Info("DELETE ", uri, " (", parts[1], ") ", resp.Status)
+	return data, resp
+}
+
+// ManifestExists check whether the manifest exists by tag or digest reference with a HEAD request
+// and return its digest.
+func (c *Client) ManifestExists(repo, reference string) (bool, string, error) {
+	resp, data, err := c.headManifest(repo, reference)
+	if err != nil {
+		return false, "", err
+	}
+	switch resp.StatusCode {
+	case 200:
+		if digest := resp.Header.Get("Docker-Content-Digest"); digest != "" {
+			return true, digest, nil
 		}
-		return "", resp
+		// Only GET responses have the body to compute the digest with.
+		if data != "" {
+			return true, fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(data))), nil
+		}
+		return true, "", nil
+	case 404:
+		return false, "", nil
+	}
+	return false, "", fmt.Errorf("unexpected status checking manifest %s:%s: %s", repo, reference, resp.Status)
+}
+
+// ResolveDigests resolve the manifest digests of the repo tags with concurrent HEAD requests, as many at a time
+// as SetMaxConcurrentRequests allows. The tags which manifest is missing or which digest fails to be resolved are
+// missing from the result.
+func (c *Client) ResolveDigests(repo string, tags []string) map[string]string {
+	workers := resolveDigestsWorkers
+	if sem := c.sem; sem != nil {
+		workers = cap(sem)
 	}
+	digests := map[string]string{}
+	var mux sync.Mutex
+	forEach(context.Background(), workers, tags, func(tag string) {
+		exists, digest, err := c.ManifestExists(repo, tag)
+		if err != nil || !exists || digest == "" {
+			return
+		}
+		mux.Lock()
+		digests[tag] = digest
+		mux.Unlock()
+	})
+	return digests
+}
 
-	return data, resp
+// ManifestUploaded return when the manifest was pushed according to its Last-Modified header,
+// zero time if the registry does not report it.
+func (c *Client) ManifestUploaded(repo, reference string) (time.Time, error) {
+	resp, _, err := c.headManifest(repo, reference)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if resp.StatusCode != 200 {
+		return time.Time{}, fmt.Errorf("failed to check manifest %s:%s: %s", repo, reference, resp.Status)
+	}
+	if lastModified := resp.Header.Get("Last-Modified"); lastModified != "" {
+		return http.ParseTime(lastModified)
+	}
+	return time.Time{}, nil
+}
+
+// headManifest request the manifest with HEAD, or GET if disabled, see SetDisableHead.
+func (c *Client) headManifest(repo, reference string) (gorequest.Response, string, error) {
+	scope := fmt.Sprintf("repository:%s:*", repo)
+	authHeader := ""
+	if c.authURL != "" {
+		authHeader = fmt.Sprintf("Bearer %s", c.getToken(scope))
+	}
+
+	uri := fmt.Sprintf("/v2/%s/manifests/%s", repo, reference)
+	method := "HEAD"
+	if atomic.LoadInt32(&c.noHead) == 1 {
+		method = "GET"
+	}
+	resp, data, errs := c.end(c.newRequest().CustomMethod(method, c.url+uri).Set("Accept", manifestAcceptHeader).Set("Authorization", authHeader).Set("User-Agent", "docker-registry-ui"))
+	if len(errs) == 0 && method == "HEAD" && methodBlocked(resp.StatusCode) {
+		c.logger.Warnf("HEAD %s %s, checking manifests with GET from now on.", uri, resp.Status)
+		atomic.StoreInt32(&c.noHead, 1)
+		method = "GET"
+		resp, data, errs = c.end(c.newRequest().Get(c.url+uri).Set("Accept", manifestAcceptHeader).Set("Authorization", authHeader).Set("User-Agent", "docker-registry-ui"))
+	}
+	if len(errs) > 0 {
+		c.logger.Error(errs[0])
+		return nil, "", errs[0]
+	}
+	c.logger.Info(method, " ", uri, " ", resp.Status)
+	return resp, data, nil
+}
+
+// getManifest get the schema2 or OCI manifest by tag or digest reference, for a manifest list or an image index
+// the manifest for linux/amd64 or the first one listed is returned instead.
+func (c *Client) getManifest(repo, reference string) (string, error) {
+	for i := 0; i < 2; i++ {
+		data, _, err := c.fetchManifest(repo, reference)
+		if err != nil {
+			return "", err
+		}
+
+		manifests := gjson.Get(data, "manifests").Array()
+		if !IsManifestIndex(ManifestMediaType(data)) || len(manifests) == 0 {
+			return data, nil
+		}
+		reference = manifests[0].Get("digest").String()
+		for _, m := range manifests {
+			if m.Get("platform.os").String() == "linux" && m.Get("platform.architecture").String() == "amd64" {
+				reference = m.Get("digest").String()
+				break
+			}
+		}
+	}
+	return "", fmt.Errorf("failed to get manifest %s:%s: nested manifest lists", repo, reference)
+}
+
+// fetchManifest get the manifest by tag or digest reference as is with its digest, with all the manifest media types
+// accepted. The digest is computed from the manifest for registries not returning it.
+func (c *Client) fetchManifest(repo, reference string) (string, string, error) {
+	scope := fmt.Sprintf("repository:%s:*", repo)
+	authHeader := ""
+	if c.authURL != "" {
+		authHeader = fmt.Sprintf("Bearer %s", c.getToken(scope))
+	}
+	uri := fmt.Sprintf("/v2/%s/manifests/%s", repo, reference)
+	resp, data, errs := c.end(c.newRequest().Get(c.url+uri).Set("Accept", manifestAcceptHeader).Set("Authorization", authHeader).Set("User-Agent", "docker-registry-ui"))
+	if len(errs) > 0 {
+		c.logger.Error(errs[0])
+		return "", "", errs[0]
+	}
+	c.logger.Info("GET ", uri, " ", resp.Status)
+	if resp.StatusCode != 200 {
+		return "", "", fmt.Errorf("failed to get manifest %s:%s: %s", repo, reference, resp.Status)
+	}
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		digest = fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(data)))
+	}
+	return data, digest, nil
+}
+
+// IndexChildren get the digests of the manifests referenced by the manifest list or image index of the repo tag,
+// none for the other manifests.
+func (c *Client) IndexChildren(repo, tag string) ([]string, error) {
+	manifest, _, err := c.fetchManifest(repo, tag)
+	if err != nil {
+		return nil, err
+	}
+	children := []string{}
+	if IsManifestIndex(ManifestMediaType(manifest)) {
+		for _, child := range gjson.Get(manifest, "manifests.#.digest").Array() {
+			children = append(children, child.String())
+		}
+	}
+	return children, nil
+}
+
+// SetTagSizeAllPlatforms make TagSize sum the sizes of all the manifests of manifest lists and image indexes,
+// e.g. to account the disk space of multi-arch images, instead of the size of the one getManifest picks.
+func (c *Client) SetTagSizeAllPlatforms(all bool) {
+	c.allPlatforms = all
+}
+
+// sizeCacheSize bounds the number of image sizes cached, the cache is dropped once full.
+var sizeCacheSize = 10000
+
+// TagSize get the image size of the repo tag as the sum of its layer and config blob sizes, not accounting
+// layers shared with other images. Sizes are cached by manifest digest, so a tag pushed again is measured again
+// and only its digest is requested for the ones already measured.
+func (c *Client) TagSize(repo, tag string) (int64, error) {
+	exists, digest, err := c.ManifestExists(repo, tag)
+	if err == nil && (!exists || digest == "") {
+		err = fmt.Errorf("manifest digest of %s:%s not found", repo, tag)
+	}
+	if err != nil {
+		return 0, err
+	}
+	c.configMux.Lock()
+	size, ok := c.sizeCache[digest]
+	c.configMux.Unlock()
+	if ok {
+		return size, nil
+	}
+
+	var manifests []string
+	if c.allPlatforms {
+		data, _, err := c.fetchManifest(repo, digest)
+		if err != nil {
+			return 0, err
+		}
+		manifests = []string{data}
+		if children := gjson.Get(data, "manifests.#.digest").Array(); IsManifestIndex(ManifestMediaType(data)) && len(children) > 0 {
+			manifests = nil
+			for _, digest := range children {
+				child, _, err := c.fetchManifest(repo, digest.String())
+				if err != nil {
+					return 0, err
+				}
+				manifests = append(manifests, child)
+			}
+		}
+	} else {
+		data, err := c.getManifest(repo, digest)
+		if err != nil {
+			return 0, err
+		}
+		manifests = []string{data}
+	}
+	for _, m := range manifests {
+		size = size + ImageSize(m) + gjson.Get(m, "config.size").Int()
+	}
+
+	c.configMux.Lock()
+	if len(c.sizeCache) >= sizeCacheSize {
+		c.sizeCache = map[string]int64{}
+	}
+	c.sizeCache[digest] = size
+	c.configMux.Unlock()
+	return size, nil
+}
+
+// TagSizes get the sizes of the repo tags, workers at a time, the ones failing to be fetched are missing.
+func (c *Client) TagSizes(repo string, tags []string, workers int) map[string]int64 {
+	sizes := map[string]int64{}
+	var mux sync.Mutex
+	forEach(context.Background(), workers, tags, func(tag string) {
+		size, err := c.TagSize(repo, tag)
+		if err != nil {
+			c.logger.Error(err)
+			return
+		}
+		mux.Lock()
+		sizes[tag] = size
+		mux.Unlock()
+	})
+	return sizes
+}
+
+// ConfigBlob get the parsed image config blob of the repo tag resolving manifest lists and image indexes.
+// Config blobs are immutable, so they are cached by digest. Artifacts often have an empty config,
+// their creation date is taken from the manifest annotation then, as it is for the manifests without a config blob
+// which only have the date and artifact type then.
+func (c *Client) ConfigBlob(repo, tag string) (*ImageConfig, error) {
+	manifest, err := c.getManifest(repo, tag)
+	if err != nil {
+		return nil, err
+	}
+	digest := gjson.Get(manifest, "config.digest").String()
+	if digest == "" {
+		if created := manifestCreated(manifest); !created.IsZero() {
+			return &ImageConfig{Created: created, ArtifactType: ArtifactType(manifest), Labels: map[string]string{}, CreatedSource: DateSourceAnnotation}, nil
+		}
+		return nil, fmt.Errorf("no config blob referenced by manifest %s:%s", repo, tag)
+	}
+	config, err := c.configBlob(repo, digest)
+	if err != nil {
+		return nil, err
+	}
+
+	// Copy as the cached config may be shared by unrelated manifests, e.g. the empty one of artifacts.
+	result := *config
+	result.ArtifactType = ArtifactType(manifest)
+	result.CreatedSource = DateSourceConfig
+	if result.Created.IsZero() {
+		result.Created, result.CreatedSource = manifestCreated(manifest), DateSourceAnnotation
+	}
+	return &result, nil
+}
+
+// manifestCreated return the creation date from the OCI manifest annotation or zero time if missing.
+func manifestCreated(manifest string) time.Time {
+	return gjson.Get(manifest, `annotations.org\.opencontainers\.image\.created`).Time()
+}
+
+// configBlob get the parsed config blob by digest.
+func (c *Client) configBlob(repo, digest string) (*ImageConfig, error) {
+	c.configMux.Lock()
+	config, ok := c.configCache[digest]
+	c.configMux.Unlock()
+	if ok {
+		return config, nil
+	}
+
+	scope := fmt.Sprintf("repository:%s:*", repo)
+	authHeader := ""
+	if c.authURL != "" {
+		authHeader = fmt.Sprintf("Bearer %s", c.getToken(scope))
+	}
+	uri := fmt.Sprintf("/v2/%s/blobs/%s", repo, digest)
+	resp, data, errs := c.end(c.newRequest().Get(c.url+uri).Set("Authorization", authHeader).Set("User-Agent", "docker-registry-ui"))
+	if len(errs) > 0 {
+		c.logger.Error(errs[0])
+		return nil, errs[0]
+	}
+	c.logger.Info("GET ", uri, " ", resp.Status)
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("failed to get config blob %s@%s: %s", repo, digest, resp.Status)
+	}
+
+	config = &ImageConfig{
+		Digest:       digest,
+		Created:      gjson.Get(data, "created").Time(),
+		Architecture: gjson.Get(data, "architecture").String(),
+		OS:           gjson.Get(data, "os").String(),
+		Author:       gjson.Get(data, "author").String(),
+		Labels:       map[string]string{},
+	}
+	for k, v := range gjson.Get(data, "config.Labels").Map() {
+		config.Labels[k] = v.String()
+	}
+	c.configMux.Lock()
+	c.configCache[digest] = config
+	c.configMux.Unlock()
+	return config, nil
+}
+
+// resetConfigCache drop the cached config blobs and tag sizes.
+func (c *Client) resetConfigCache() {
+	c.configMux.Lock()
+	defer c.configMux.Unlock()
+
+	c.configCache = map[string]*ImageConfig{}
+	c.sizeCache = map[string]int64{}
 }
 
 // Namespaces list repo namespaces.
@@ -174,71 +865,230 @@ func (c *Client) Repositories(useCache bool) map[string][]string {
 	c.mux.Lock()
 	defer c.mux.Unlock()
 
-	linkRegexp := regexp.MustCompile("^<(.*?)>;.*$")
-	scope := "registry:catalog:*"
-	uri := "/v2/_catalog"
 	c.repos = map[string][]string{}
+	c.WalkRepositories(func(repo string) error {
+		namespace := "library"
+		if strings.Contains(repo, "/") {
+			f := strings.SplitN(repo, "/", 2)
+			namespace = f[0]
+			repo = f[1]
+		}
+		c.repos[namespace] = append(c.repos[namespace], repo)
+		return nil
+	})
+	return c.repos
+}
+
+// host return the host of the registry with the port if any, as it is in the image references.
+func (c *Client) host() string {
+	u, err := url.Parse(c.url)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+// WalkRepositories call fn for every repo of the catalog as its pages are fetched, so processing starts before
+// the whole catalog is fetched and it is never held in memory. The pages follow the Link headers, as registries
+// may cap or ignore the page size requested. It stops on the first error returned by fn
+// or on failing to fetch a page, returning the error.
+func (c *Client) WalkRepositories(fn func(repo string) error) (err error) {
+	span := c.startSpan("Repositories")
+	count := 0
+	defer func() { endSpan(span, "repos", count, err) }()
+
+	scope := "registry:catalog:*"
+	uri := fmt.Sprintf("/v2/_catalog?n=%d", catalogPageSize)
+	visited := map[string]bool{}
 	for {
-		data, resp := c.callRegistry(uri, scope, 2, false)
-		if data == "" {
-			return c.repos
+		visited[uri] = true
+		data, resp := c.callRegistry(uri, scope, 2)
+		if resp == nil {
+			return fmt.Errorf("failed to list the catalog of %s", c.url)
+		}
+		span.SetAttribute("status", resp.Status)
+		if resp.StatusCode != 200 {
+			return fmt.Errorf("failed to list the catalog of %s: %s", c.url, resp.Status)
 		}
 
 		for _, r := range gjson.Get(data, "repositories").Array() {
-			namespace := "library"
-			repo := r.String()
-			if strings.Contains(repo, "/") {
-				f := strings.SplitN(repo, "/", 2)
-				namespace = f[0]
-				repo = f[1]
+			count++
+			if err := fn(r.String()); err != nil {
+				return err
 			}
-			c.repos[namespace] = append(c.repos[namespace], repo)
 		}
 
 		// pagination
-		linkHeader := resp.Header.Get("Link")
-		link := linkRegexp.FindStringSubmatch(linkHeader)
-		if len(link) == 2 {
-			// update uri and query next page
-			uri = link[1]
-		} else {
+		link := nextLink(resp.Header.Get("Link"))
+		if link == "" {
 			// no more pages
-			break
+			return nil
+		}
+		// update uri and query next page, guarding against registries linking back to a page
+		uri = c.linkURI(link)
+		if visited[uri] {
+			c.logger.Warnf("Catalog pagination of %s links back to %s, stopping there.", c.url, uri)
+			return nil
 		}
 	}
-	return c.repos
 }
 
-// Tags get tags for the repo.
+// Tags get tags for the repo following the pagination, without the duplicates of a tag list changing meanwhile.
+// Failing to fetch a page is logged only, returning the tags listed until then, see ListTags.
 func (c *Client) Tags(repo string) []string {
+	tags, err := c.ListTags(repo)
+	if err != nil {
+		c.logger.Error(err)
+	}
+	return tags
+}
+
+// ListTags get tags for the repo following the pagination like Tags, returning the error of a page failing
+// to be fetched along with the tags listed until then. A repo without tags has none.
+func (c *Client) ListTags(repo string) (tags []string, err error) {
+	span := c.startSpan("Tags", "repo", repo)
+	defer func() { endSpan(span, "tags", len(tags), err) }()
+	last := ""
+	// The tags listed again by the next pages of a tag list changing meanwhile are dropped.
+	seen := map[string]bool{}
+	duplicates := 0
+	// visited guards against registries linking back to a page.
+	visited := map[string]bool{"": true}
+	for {
+		page, next, err := c.tagsPage(repo, last, 0)
+		if err != nil {
+			return tags, err
+		}
+		for _, tag := range page {
+			if seen[tag] {
+				duplicates++
+				continue
+			}
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+		if next == "" {
+			if duplicates > 0 {
+				c.logger.Warnf("[%s] dropped %d duplicate tags listed across pages, the tag list changed while paginating, "+
+					"tags pushed meanwhile may be missing.", repo, duplicates)
+			}
+			return tags, nil
+		}
+		if visited[next] {
+			c.logger.Warnf("[%s] tags pagination links back to the page after %s, stopping there.", repo, next)
+			return tags, nil
+		}
+		visited[next] = true
+		last = next
+	}
+}
+
+// TagsPage get up to n tags for the repo sorted after the last given one, n of 0 leaves the page size
+// to the registry. It returns the last tag to query the next page with taken from the Link header,
+// or an empty string if there are no more pages.
+func (c *Client) TagsPage(repo, last string, n int) ([]string, string) {
+	tags, next, _ := c.tagsPage(repo, last, n)
+	return tags, next
+}
+
+// tagsPage get the page of tags like TagsPage, failing unless the registry returns it, except for a first page
+// not found as the registry returns 404 for repos without tags.
+func (c *Client) tagsPage(repo, last string, n int) ([]string, string, error) {
 	scope := fmt.Sprintf("repository:%s:*", repo)
-	data, _ := c.callRegistry(fmt.Sprintf("/v2/%s/tags/list", repo), scope, 2, false)
+	query := url.Values{}
+	if last != "" {
+		query.Set("last", last)
+	}
+	if n > 0 {
+		query.Set("n", strconv.Itoa(n))
+	}
+	uri := fmt.Sprintf("/v2/%s/tags/list", repo)
+	if len(query) > 0 {
+		uri = uri + "?" + query.Encode()
+	}
+
+	data, resp := c.callRegistry(uri, scope, 2)
+	if resp == nil {
+		return nil, "", fmt.Errorf("failed to list the tags of %s", repo)
+	}
+	if resp.StatusCode == 404 && last == "" {
+		return nil, "", nil
+	}
+	if resp.StatusCode != 200 || data == "" {
+		// An error reading the body, e.g. exceeding the max response size, leaves no data.
+		return nil, "", fmt.Errorf("failed to list the tags of %s after %q: %s", repo, last, resp.Status)
+	}
 	var tags []string
 	for _, t := range gjson.Get(data, "tags").Array() {
 		tags = append(tags, t.String())
 	}
-	return tags
+
+	next := ""
+	if link := nextLink(resp.Header.Get("Link")); link != "" {
+		if u, err := url.Parse(link); err == nil {
+			next = u.Query().Get("last")
+		}
+	}
+	// Guard against registries linking to the same page.
+	if next == last {
+		next = ""
+	}
+	return tags, next, nil
+}
+
+// RepoExists check whether the repo exists, e.g. to tell a repo deleted meanwhile from failing requests.
+func (c *Client) RepoExists(repo string) (bool, error) {
+	_, resp := c.callRegistry(fmt.Sprintf("/v2/%s/tags/list?n=1", repo), fmt.Sprintf("repository:%s:*", repo), 2)
+	if resp == nil {
+		return false, fmt.Errorf("failed to check whether repository %s exists", repo)
+	}
+	switch resp.StatusCode {
+	case 200:
+		return true, nil
+	case 404:
+		return false, nil
+	}
+	return false, fmt.Errorf("failed to check whether repository %s exists: %s", repo, resp.Status)
 }
 
-// TagInfo get image info for the repo tag.
+// TagInfo get image info for the repo tag. With SetDisableSchema1, the manifest v1 is always empty
+// and nothing is requested for v1only.
 func (c *Client) TagInfo(repo, tag string, v1only bool) (rsha256, rinfoV1, rinfoV2 string) {
-	scope := fmt.Sprintf("repository:%s:*", repo)
-	infoV1, _ := c.callRegistry(fmt.Sprintf("/v2/%s/manifests/%s", repo, tag), scope, 1, false)
-	if infoV1 == "" {
+	if c.noSchema1 && v1only {
 		return "", "", ""
 	}
+	span := c.startSpan("TagInfo", "repo", repo, "tag", tag)
+	var err error
+	defer func() { span.End(err) }()
+
+	scope := fmt.Sprintf("repository:%s:*", repo)
+	var infoV1 string
+	if !c.noSchema1 {
+		var resp gorequest.Response
+		infoV1, resp = c.callRegistry(fmt.Sprintf("/v2/%s/manifests/%s", repo, tag), scope, 1)
+		if resp != nil {
+			span.SetAttribute("status", resp.Status)
+		}
+		if infoV1 == "" {
+			err = fmt.Errorf("manifest of %s:%s not found", repo, tag)
+			return "", "", ""
+		}
+	}
 
 	if v1only {
 		return "", infoV1, ""
 	}
 
-	infoV2, resp := c.callRegistry(fmt.Sprintf("/v2/%s/manifests/%s", repo, tag), scope, 2, false)
-	digest := resp.Header.Get("Docker-Content-Digest")
-	if infoV2 == "" || digest == "" {
+	infoV2, resp := c.callRegistry(fmt.Sprintf("/v2/%s/manifests/%s", repo, tag), scope, 2)
+	if c.noSchema1 && resp != nil {
+		span.SetAttribute("status", resp.Status)
+	}
+	if infoV2 == "" || resp.Header.Get("Docker-Content-Digest") == "" {
+		err = fmt.Errorf("manifest digest of %s:%s not found", repo, tag)
 		return "", "", ""
 	}
 
-	sha256 := digest[7:]
+	sha256 := resp.Header.Get("Docker-Content-Digest")[7:]
 	return sha256, infoV1, infoV2
 }
 
@@ -266,8 +1116,114 @@ func (c *Client) CountTags(interval uint8) {
 	}
 }
 
-// DeleteTag delete image tag.
-func (c *Client) DeleteTag(repo, tag string) {
+// DeleteTag delete image tag by its manifest digest, which is resolved with all the manifest media types
+// accepted so OCI images and artifacts can be deleted too.
+func (c *Client) DeleteTag(repo, tag string) error {
+	_, err := c.deleteTag(repo, tag)
+	return err
+}
+
+// deleteTag delete image tag by its manifest digest and return the digest.
+func (c *Client) deleteTag(repo, tag string) (string, error) {
+	exists, digest, err := c.ManifestExists(repo, tag)
+	if err != nil {
+		return "", fmt.Errorf("failed to delete %s:%s: %s", repo, tag, err)
+	}
+	if !exists || digest == "" {
+		return "", fmt.Errorf("failed to delete %s:%s: manifest digest not found", repo, tag)
+	}
+	return digest, c.deleteManifest(repo, tag, digest)
+}
+
+// DeleteRepository delete all the manifests referenced by the tags of the repo and return how many were deleted.
+// Docker registry API has no repository deletion, so the repo is left without tags and its blobs
+// are reclaimed by the registry garbage collection.
+func (c *Client) DeleteRepository(repo string) (int, []error) {
+	if err := c.CheckDelete(repo); err != nil {
+		return 0, []error{err}
+	}
+	var errs []error
+	// Tags sharing a manifest are deleted at once by its digest.
+	digests := map[string]string{}
+	for _, tag := range c.Tags(repo) {
+		exists, digest, err := c.ManifestExists(repo, tag)
+		if err == nil && exists && digest == "" {
+			err = fmt.Errorf("failed to delete %s:%s: manifest digest not found", repo, tag)
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if _, ok := digests[digest]; exists && !ok {
+			digests[digest] = tag
+		}
+	}
+
+	deleted := 0
+	for _, digest := range SortedMapKeys(digests) {
+		if err := c.deleteManifest(repo, digests[digest], digest); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		deleted++
+	}
+	return deleted, errs
+}
+
+// CheckAuth check the credentials are granted listing the catalog, which the purge and the UI rely on.
+func (c *Client) CheckAuth() error {
+	_, resp := c.callRegistry("/v2/_catalog?n=1", "registry:catalog:*", 2)
+	if resp == nil {
+		return fmt.Errorf("failed to list the catalog of %s", c.url)
+	}
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("failed to list the catalog of %s: %s, check the credentials have access to it", c.url, resp.Status)
+	}
+	return nil
+}
+
+// CheckDelete check manifest deletions are allowed in the repo by deleting a manifest which does not exist,
+// so neither the registry nor a proxy in front of it rejects deletions only once a purge started.
+func (c *Client) CheckDelete(repo string) error {
 	scope := fmt.Sprintf("repository:%s:*", repo)
-	c.callRegistry(fmt.Sprintf("/v2/%s/manifests/%s", repo, tag), scope, 2, true)
+	authHeader := ""
+	if c.authURL != "" {
+		authHeader = fmt.Sprintf("Bearer %s", c.getToken(scope))
+	}
+	uri := fmt.Sprintf("/v2/%s/manifests/sha256:%s", repo, strings.Repeat("0", 64))
+	resp, _, errs := c.end(c.newRequest().Delete(c.url+uri).Set("Accept", manifestAcceptHeader).Set("Authorization", authHeader).Set("User-Agent", "docker-registry-ui"))
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to check deletions in %s: %s", repo, errs[0])
+	}
+	c.logger.Info("DELETE ", uri, " ", resp.Status)
+	if methodBlocked(resp.StatusCode) || resp.StatusCode == 401 || resp.StatusCode == 403 {
+		return fmt.Errorf("deletions are not allowed in %s: %s, check the registry has storage delete enabled "+
+			"and no proxy blocks DELETE requests", repo, resp.Status)
+	}
+	return nil
+}
+
+// deleteManifest delete the manifest by digest, which deletes all the tags referencing it.
+func (c *Client) deleteManifest(repo, tag, digest string) (err error) {
+	span := c.startSpan("DeleteTag", "repo", repo, "tag", tag, "digest", digest)
+	defer func() { span.End(err) }()
+
+	scope := fmt.Sprintf("repository:%s:*", repo)
+	authHeader := ""
+	if c.authURL != "" {
+		authHeader = fmt.Sprintf("Bearer %s", c.getToken(scope))
+	}
+	uri := fmt.Sprintf("/v2/%s/manifests/%s", repo, digest)
+	resp, _, errs := c.end(c.newRequest().Delete(c.url+uri).Set("Accept", manifestAcceptHeader).Set("Authorization", authHeader).Set("User-Agent", "docker-registry-ui"))
+	if len(errs) > 0 {
+		c.logger.Error(errs[0])
+		return fmt.Errorf("failed to delete %s:%s: %s", repo, tag, errs[0])
+	}
+	c.logger.Info("DELETE ", uri, " (", tag, ") ", resp.Status)
+	span.SetAttribute("status", resp.Status)
+	// Returns 202 on success.
+	if resp.StatusCode != 202 {
+		return fmt.Errorf("failed to delete %s:%s: %s", repo, tag, resp.Status)
+	}
+	return nil
 }
@@ -0,0 +1,1079 @@
+package registry
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/smartystreets/goconvey/convey"
+)
+
+// fakeRegistry in-memory Docker registry serving repos with tags and their creation dates.
+type fakeRegistry struct {
+	mux     sync.Mutex
+	repos   map[string]map[string]time.Time
+	deleted []string
+	// pageSize is the default size of the tags pages, 0 for no pagination.
+	pageSize int
+	// repeatLast lists the last tag of the previous page again like a tag list changing while paginating.
+	repeatLast bool
+	// tagsLinkBack makes the last page of tags link to the page after that tag, cycling across the pages.
+	tagsLinkBack string
+	// failTagsAfter fails the page of tags after that tag.
+	failTagsAfter string
+	// catalogPageSize is the size of the catalog pages, 0 for no pagination. It caps the n requested.
+	catalogPageSize int
+	// ignoreCatalogN serves catalogPageSize pages whatever the n requested, without n in the Link headers.
+	ignoreCatalogN bool
+	// catalogLoop makes the catalog Link header point to the first page.
+	catalogLoop bool
+	// catalogQueries are the queries of the catalog requests.
+	catalogQueries []string
+	// linkPrefix is prepended to the catalog Link paths like reverse proxies rewriting them to the public path do.
+	linkPrefix string
+	// noSchema1 makes manifest v1 unavailable like on registries which disabled it.
+	noSchema1 bool
+	// noConfigBlob makes config blobs unavailable, with noSchema1 tags cannot be evaluated.
+	noConfigBlob bool
+	// failDelete makes manifest deletions fail.
+	failDelete bool
+	// ignoreDelete accepts manifest deletions but keeps the manifests like registries failing them silently.
+	ignoreDelete bool
+	// onDelete is called on every manifest deletion before it is handled after deleteDelay, like a slow registry.
+	onDelete    func()
+	deleteDelay time.Duration
+	// failVerify fails the manifest HEAD requests by digest, so the deletions cannot be verified.
+	failVerify bool
+	// manifestDelay delays the manifest responses like a slow registry.
+	manifestDelay time.Duration
+	// artifacts are tags served as Helm chart OCI artifacts with an empty config.
+	artifacts map[string]bool
+	// annotated are tags served as OCI manifests without a config blob, dated by their annotation only.
+	annotated map[string]bool
+	// uploaded are the push times of the tags served as Last-Modified of their manifests.
+	uploaded map[string]time.Time
+	// vanish are the repos deleted once their tags are listed, like by another process while scanning.
+	vanish map[string]bool
+	// blockHead and blockDelete reject the requests of the method like restrictive proxies do.
+	blockHead, blockDelete bool
+	// indexes are tags served as OCI image indexes of the manifests of the other tags of the repo.
+	indexes map[string][]string
+}
+
+// emptyConfigDigest digest of the empty config "{}" of OCI artifacts.
+var emptyConfigDigest = fmt.Sprintf("sha256:%x", sha256.Sum256([]byte("{}")))
+
+func newFakeRegistry(repos map[string]map[string]time.Time) (*fakeRegistry, *httptest.Server) {
+	f := &fakeRegistry{repos: repos}
+	return f, httptest.NewServer(f)
+}
+
+// fakeDigest return the fake manifest digest of the tag, tags created at the same time share it.
+func fakeDigest(created time.Time) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(created.String())))
+}
+
+// fakeConfigDigest return the fake config blob digest of the tag.
+func fakeConfigDigest(created time.Time) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256([]byte("config "+created.String())))
+}
+
+func (f *fakeRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	if (f.blockHead && r.Method == http.MethodHead) || (f.blockDelete && r.Method == http.MethodDelete) {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/v2/")
+	switch {
+	case r.URL.Path == "/v2/":
+		w.WriteHeader(http.StatusOK)
+	case path == "_catalog":
+		repos := []string{}
+		for repo := range f.repos {
+			if repo > r.URL.Query().Get("last") {
+				repos = append(repos, repo)
+			}
+		}
+		sort.Strings(repos)
+		f.catalogQueries = append(f.catalogQueries, r.URL.RawQuery)
+		n := f.catalogPageSize
+		if requested, _ := strconv.Atoi(r.URL.Query().Get("n")); requested > 0 && (n == 0 || requested < n) && !f.ignoreCatalogN {
+			n = requested
+		}
+		if n > 0 && len(repos) > n {
+			repos = repos[:n]
+			next := fmt.Sprintf("last=%s&n=%d", repos[n-1], n)
+			if f.ignoreCatalogN {
+				next = "last=" + repos[n-1]
+			}
+			if f.catalogLoop {
+				next = ""
+			}
+			w.Header().Set("Link", fmt.Sprintf(`<%s/v2/_catalog?%s>; rel="next", <%s/v2/_catalog>; rel="first"`, f.linkPrefix, next, f.linkPrefix))
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"repositories": repos})
+	case strings.HasSuffix(path, "/tags/list"):
+		repo := strings.TrimSuffix(path, "/tags/list")
+		tags, ok := f.repos[repo]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if last := r.URL.Query().Get("last"); f.failTagsAfter != "" && last == f.failTagsAfter {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		names := []string{}
+		for t := range tags {
+			if last := r.URL.Query().Get("last"); t > last || (f.repeatLast && t == last) {
+				names = append(names, t)
+			}
+		}
+		sort.Strings(names)
+		if n, _ := strconv.Atoi(r.URL.Query().Get("n")); n == 0 && f.pageSize > 0 {
+			r.URL.RawQuery = fmt.Sprintf("n=%d", f.pageSize)
+		}
+		if n, _ := strconv.Atoi(r.URL.Query().Get("n")); n > 0 && len(names) > n {
+			names = names[:n]
+			w.Header().Set("Link", fmt.Sprintf(`</v2/%s/tags/list?last=%s&n=%d>; rel="next"`, repo, names[n-1], n))
+		} else if f.tagsLinkBack != "" {
+			w.Header().Set("Link", fmt.Sprintf(`</v2/%s/tags/list?last=%s&n=%d>; rel="next"`, repo, f.tagsLinkBack, n))
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"name": repo, "tags": names})
+		if f.vanish[repo] {
+			delete(f.repos, repo)
+		}
+	case strings.Contains(path, "/manifests/"):
+		parts := strings.SplitN(path, "/manifests/", 2)
+		f.serveManifest(w, r, parts[0], parts[1])
+	case strings.Contains(path, "/blobs/"):
+		parts := strings.SplitN(path, "/blobs/", 2)
+		if f.noConfigBlob {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if parts[1] == emptyConfigDigest {
+			w.Write([]byte("{}"))
+			return
+		}
+		for _, c := range f.repos[parts[0]] {
+			if fakeConfigDigest(c) == parts[1] {
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"created": c.Format(time.RFC3339Nano), "architecture": "amd64", "os": "linux",
+					"config": map[string]interface{}{"Labels": map[string]string{"maintainer": "qa"}},
+				})
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (f *fakeRegistry) serveManifest(w http.ResponseWriter, r *http.Request, repo, ref string) {
+	time.Sleep(f.manifestDelay)
+	if f.failVerify && r.Method == http.MethodHead && strings.HasPrefix(ref, "sha256:") {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	tag, created := "", time.Time{}
+	for t, c := range f.repos[repo] {
+		if t == ref || fakeDigest(c) == ref {
+			tag, created = t, c
+			break
+		}
+	}
+	if tag == "" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	digest := fakeDigest(created)
+	if r.Method == http.MethodDelete {
+		if f.onDelete != nil {
+			f.onDelete()
+		}
+		time.Sleep(f.deleteDelay)
+		if f.failDelete {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		for t, c := range f.repos[repo] {
+			if fakeDigest(c) == digest && !f.ignoreDelete {
+				delete(f.repos[repo], t)
+				f.deleted = append(f.deleted, repo+":"+t)
+			}
+		}
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	w.Header().Set("Docker-Content-Digest", digest)
+	if u, ok := f.uploaded[tag]; ok {
+		w.Header().Set("Last-Modified", u.Format(http.TimeFormat))
+	}
+	if r.Method == http.MethodHead {
+		return
+	}
+	if f.artifacts[tag] {
+		if !strings.Contains(r.Header.Get("Accept"), "application/vnd.oci.image.manifest.v1+json") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"schemaVersion": 2,
+			"mediaType":     "application/vnd.oci.image.manifest.v1+json",
+			"config":        map[string]interface{}{"mediaType": "application/vnd.cncf.helm.config.v1+json", "digest": emptyConfigDigest, "size": 2},
+			"layers":        []map[string]interface{}{{"digest": digest, "size": 500}},
+			"annotations":   map[string]string{"org.opencontainers.image.created": created.Format(time.RFC3339Nano)},
+		})
+		return
+	}
+	if f.annotated[tag] {
+		if !strings.Contains(r.Header.Get("Accept"), "application/vnd.oci.image.manifest.v1+json") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"schemaVersion": 2,
+			"mediaType":     "application/vnd.oci.image.manifest.v1+json",
+			"layers":        []map[string]interface{}{{"digest": digest, "size": 1000}},
+			"annotations":   map[string]string{"org.opencontainers.image.created": created.Format(time.RFC3339Nano)},
+		})
+		return
+	}
+	if strings.Contains(r.Header.Get("Accept"), "distribution.manifest.v1") {
+		if f.noSchema1 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		v1, _ := json.Marshal(map[string]string{"created": created.Format(time.RFC3339Nano)})
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"name": repo, "tag": tag, "history": []map[string]string{{"v1Compatibility": string(v1)}},
+		})
+		return
+	}
+	if children, ok := f.indexes[tag]; ok {
+		manifests := []map[string]interface{}{}
+		for _, child := range children {
+			manifests = append(manifests, map[string]interface{}{"digest": fakeDigest(f.repos[repo][child]), "platform": map[string]string{"os": "linux", "architecture": child}})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"schemaVersion": 2,
+			"mediaType":     "application/vnd.oci.image.index.v1+json",
+			"manifests":     manifests,
+		})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     "application/vnd.docker.distribution.manifest.v2+json",
+		"config":        map[string]interface{}{"digest": fakeConfigDigest(created), "size": 100},
+		"layers":        []map[string]interface{}{{"digest": digest, "size": 1000}},
+	})
+}
+
+func TestManifestExists(t *testing.T) {
+	created := time.Date(2019, 7, 1, 0, 0, 0, 0, time.UTC)
+	_, server := newFakeRegistry(map[string]map[string]time.Time{"app": {"v1": created}})
+	defer server.Close()
+	client := NewClient(server.URL, false, "", "")
+
+	convey.Convey("Check manifest existence", t, func() {
+		convey.So(client, convey.ShouldNotBeNil)
+		exists, digest, err := client.ManifestExists("app", "v1")
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(exists, convey.ShouldBeTrue)
+		convey.So(digest, convey.ShouldEqual, fakeDigest(created))
+
+		exists, _, err = client.ManifestExists("app", digest)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(exists, convey.ShouldBeTrue)
+
+		exists, digest, err = client.ManifestExists("app", "v2")
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(exists, convey.ShouldBeFalse)
+		convey.So(digest, convey.ShouldBeEmpty)
+	})
+}
+
+func TestManifestUploaded(t *testing.T) {
+	created := time.Date(2019, 7, 1, 12, 0, 0, 0, time.UTC)
+	uploaded := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	f, server := newFakeRegistry(map[string]map[string]time.Time{"app": {"v1": created, "v2": created}})
+	defer server.Close()
+	f.uploaded = map[string]time.Time{"v1": uploaded}
+	client := NewClient(server.URL, false, "", "")
+
+	convey.Convey("Get the push time from Last-Modified", t, func() {
+		u, err := client.ManifestUploaded("app", "v1")
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(u, convey.ShouldEqual, uploaded)
+
+		u, err = client.ManifestUploaded("app", "v2")
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(u.IsZero(), convey.ShouldBeTrue)
+
+		_, err = client.ManifestUploaded("app", "v3")
+		convey.So(err, convey.ShouldNotBeNil)
+	})
+
+	convey.Convey("Age tags by push time falling back to the creation date", t, func() {
+		p := &purger{client: client, opts: PurgeTagsOptions{AgeSource: AgeUploaded}, logger: SetupLogging("registry.tasks_test"), summary: &PurgeSummary{}}
+		scan := p.scanRepo(context.Background(), "app")
+		sort.Sort(scan.tags)
+		convey.So(scan.tags, convey.ShouldResemble, timeSlice{{name: "v1", created: uploaded, source: DateSourceUploaded}, {name: "v2", created: created, index: 1, source: DateSourceV1}})
+	})
+}
+
+func TestBlockedMethods(t *testing.T) {
+	created := time.Date(2019, 7, 1, 12, 0, 0, 0, time.UTC)
+	f, server := newFakeRegistry(map[string]map[string]time.Time{"app": {"v1": created}})
+	defer server.Close()
+	f.blockHead, f.blockDelete = true, true
+	client := NewClient(server.URL, false, "", "")
+
+	convey.Convey("Fall back to GET when HEAD is not allowed", t, func() {
+		exists, digest, err := client.ManifestExists("app", "v1")
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(exists, convey.ShouldBeTrue)
+		convey.So(digest, convey.ShouldEqual, fakeDigest(created))
+		convey.So(client.noHead, convey.ShouldEqual, 1)
+	})
+
+	convey.Convey("Fail before deleting when DELETE is not allowed", t, func() {
+		convey.So(client.CheckDelete("app"), convey.ShouldNotBeNil)
+		summary := PurgeOldTags(context.Background(), client, PurgeTagsOptions{KeepDays: 1})
+		convey.So(summary.TagsToPurge(), convey.ShouldEqual, 1)
+		convey.So(summary.Errors, convey.ShouldHaveLength, 1)
+		convey.So(summary.Errors[0], convey.ShouldContainSubstring, "deletions are not allowed")
+
+		f.blockDelete = false
+		convey.So(client.CheckDelete("app"), convey.ShouldBeNil)
+	})
+}
+
+func TestCheckAuth(t *testing.T) {
+	_, server := newFakeRegistry(map[string]map[string]time.Time{"app": {"v1": time.Now()}})
+	defer server.Close()
+	denied := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/" {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer denied.Close()
+
+	convey.Convey("Check the catalog can be listed", t, func() {
+		convey.So(NewClient(server.URL, false, "", "").CheckAuth(), convey.ShouldBeNil)
+		err := NewClient(denied.URL, false, "", "").CheckAuth()
+		convey.So(err, convey.ShouldNotBeNil)
+		convey.So(err.Error(), convey.ShouldContainSubstring, "403")
+	})
+}
+
+func TestConnectionPool(t *testing.T) {
+	f := &fakeRegistry{repos: map[string]map[string]time.Time{"app": {"v1": time.Now()}}}
+	server := httptest.NewUnstartedServer(f)
+	var conns int32
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	convey.Convey("Reuse connections across requests", t, func() {
+		client := NewClient(server.URL, false, "", "")
+		for i := 0; i < 10; i++ {
+			client.ManifestExists("app", "v1")
+		}
+		convey.So(atomic.LoadInt32(&conns), convey.ShouldEqual, 1)
+	})
+}
+
+// roundTripFunc RoundTripper of a func.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestClientTransport(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Signature") != "signed" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		paths = append(paths, r.URL.Path)
+		if r.URL.Path == "/v2/app/manifests/flaky" && len(paths) == 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+	wrap := func(transport http.RoundTripper) http.RoundTripper {
+		return roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			req.Header.Set("X-Signature", "signed")
+			return transport.RoundTrip(req)
+		})
+	}
+
+	convey.Convey("Send all the requests and their retries with the wrapped transport", t, func() {
+		client := NewClientWithTransport(server.URL, false, "", "", wrap)
+		convey.So(client, convey.ShouldNotBeNil)
+		client.SetConnectionPool(4, 0, 0)
+		client.SetRetries(1, 1)
+		client.retryDelay = 0
+		_, err := client.getManifest("app", "flaky")
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(paths, convey.ShouldResemble, []string{"/v2/", "/v2/app/manifests/flaky", "/v2/app/manifests/flaky"})
+		convey.So(NewClient(server.URL, false, "", ""), convey.ShouldBeNil)
+	})
+}
+
+func TestMaxResponseSize(t *testing.T) {
+	big := `{"schemaVersion": 2, "layers": [` + strings.Repeat(`{"size": 1},`, 1000) + `{}]}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/app/manifests/small":
+			w.Write([]byte(`{"schemaVersion": 2}`))
+		case "/v2/app/manifests/sized":
+			w.Header().Set("Content-Length", strconv.Itoa(len(big)))
+			w.Write([]byte(big))
+		case "/v2/app/manifests/chunked":
+			w.Write([]byte(big[:100]))
+			w.(http.Flusher).Flush()
+			w.Write([]byte(big[100:]))
+		}
+	}))
+	defer server.Close()
+	client := NewClient(server.URL, false, "", "")
+	client.SetMaxResponseSize(1024)
+
+	convey.Convey("Fail on responses larger than the max response size", t, func() {
+		_, err := client.getManifest("app", "small")
+		convey.So(err, convey.ShouldBeNil)
+		for _, tag := range []string{"sized", "chunked"} {
+			_, err := client.getManifest("app", tag)
+			convey.So(err, convey.ShouldNotBeNil)
+			convey.So(err.Error(), convey.ShouldContainSubstring, "exceeds the max response size of 1024 bytes")
+		}
+	})
+
+	convey.Convey("Read responses of any size when unlimited", t, func() {
+		client.SetMaxResponseSize(-1)
+		_, err := client.getManifest("app", "chunked")
+		convey.So(err, convey.ShouldBeNil)
+	})
+}
+
+func TestRetries(t *testing.T) {
+	var requests, flaky int
+	mux := sync.Mutex{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.Lock()
+		defer mux.Unlock()
+		switch r.URL.Path {
+		case "/v2/app/manifests/flaky":
+			flaky++
+			if flaky == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.Write([]byte(`{"schemaVersion": 2}`))
+		case "/v2/app/manifests/broken":
+			requests++
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+	client := NewClient(server.URL, false, "", "")
+	client.SetRetries(3, 0.1)
+	client.retryDelay = 0
+
+	convey.Convey("Retry reads failing with 5xx", t, func() {
+		_, err := client.getManifest("app", "flaky")
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(flaky, convey.ShouldEqual, 2)
+	})
+
+	convey.Convey("Cap the retries at the budget fraction of the requests", t, func() {
+		for i := 0; i < 100; i++ {
+			client.getManifest("app", "broken")
+		}
+		// 100 requests, up to the burst of 10 retries plus 10% of the requests.
+		convey.So(requests, convey.ShouldBeBetweenOrEqual, 100, 120)
+	})
+
+	convey.Convey("Never retry deletions", t, func() {
+		mux.Lock()
+		requests = 0
+		mux.Unlock()
+		client.SetRetries(3, 0.1)
+		client.retryDelay = 0
+		client.send(client.newRequest().Delete(server.URL + "/v2/app/manifests/broken"))
+		convey.So(requests, convey.ShouldEqual, 1)
+	})
+}
+
+func TestMaxConcurrentRequests(t *testing.T) {
+	var current, max int
+	mux := sync.Mutex{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.Lock()
+		current++
+		if current > max {
+			max = current
+		}
+		mux.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mux.Lock()
+		current--
+		mux.Unlock()
+		w.Write([]byte(`{"tags": []}`))
+	}))
+	defer server.Close()
+	client := NewClient(server.URL, false, "", "")
+	client.SetMaxConcurrentRequests(2)
+
+	convey.Convey("Bound concurrent requests", t, func() {
+		wg := sync.WaitGroup{}
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				client.Tags("app")
+			}()
+		}
+		wg.Wait()
+		convey.So(max, convey.ShouldEqual, 2)
+	})
+}
+
+func TestTagsPagination(t *testing.T) {
+	created := time.Date(2019, 7, 1, 0, 0, 0, 0, time.UTC)
+	f, server := newFakeRegistry(map[string]map[string]time.Time{
+		"app": {"a": created, "b": created, "c": created, "d": created, "e": created},
+	})
+	defer server.Close()
+	client := NewClient(server.URL, false, "", "")
+
+	convey.Convey("Get a page of tags after the given one", t, func() {
+		tags, next := client.TagsPage("app", "a", 2)
+		convey.So(tags, convey.ShouldResemble, []string{"b", "c"})
+		convey.So(next, convey.ShouldEqual, "c")
+
+		tags, next = client.TagsPage("app", next, 2)
+		convey.So(tags, convey.ShouldResemble, []string{"d", "e"})
+		convey.So(next, convey.ShouldBeEmpty)
+	})
+
+	convey.Convey("Follow the Link header across all pages", t, func() {
+		f.pageSize = 2
+		convey.So(client.Tags("app"), convey.ShouldResemble, []string{"a", "b", "c", "d", "e"})
+	})
+
+	convey.Convey("Drop the duplicates of a tag list changing across pages", t, func() {
+		f.pageSize, f.repeatLast = 3, true
+		defer func() { f.repeatLast = false }()
+		convey.So(client.Tags("app"), convey.ShouldResemble, []string{"a", "b", "c", "d", "e"})
+	})
+
+	convey.Convey("Stop on a Link header cycling across pages", t, func() {
+		f.pageSize, f.tagsLinkBack = 2, "b"
+		defer func() { f.tagsLinkBack = "" }()
+		tags, err := client.ListTags("app")
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(tags, convey.ShouldResemble, []string{"a", "b", "c", "d", "e"})
+	})
+
+	convey.Convey("Fail on a page failing to be fetched", t, func() {
+		f.pageSize, f.failTagsAfter = 2, "b"
+		defer func() { f.failTagsAfter = "" }()
+		tags, err := client.ListTags("app")
+		convey.So(err, convey.ShouldNotBeNil)
+		convey.So(tags, convey.ShouldResemble, []string{"a", "b"})
+
+		tags, err = client.ListTags("missing")
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(tags, convey.ShouldBeEmpty)
+	})
+}
+
+func TestWalkRepositories(t *testing.T) {
+	now := time.Now()
+	f, server := newFakeRegistry(map[string]map[string]time.Time{"app": {"v1": now}, "team/api": {"v1": now}, "team/web": {"v1": now}})
+	defer server.Close()
+	f.catalogPageSize = 2
+	client := NewClient(server.URL, false, "", "")
+
+	convey.Convey("Walk the repos of all the catalog pages", t, func() {
+		repos := []string{}
+		err := client.WalkRepositories(func(repo string) error {
+			repos = append(repos, repo)
+			return nil
+		})
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(repos, convey.ShouldResemble, []string{"app", "team/api", "team/web"})
+		convey.So(client.Repositories(false), convey.ShouldResemble, map[string][]string{"library": {"app"}, "team": {"api", "web"}})
+	})
+
+	convey.Convey("Follow the server page boundaries when it ignores the page size requested", t, func() {
+		f.catalogPageSize = 1
+		f.ignoreCatalogN = true
+		f.catalogQueries = nil
+		defer func() { f.catalogPageSize, f.ignoreCatalogN = 2, false }()
+		repos := []string{}
+		err := client.WalkRepositories(func(repo string) error {
+			repos = append(repos, repo)
+			return nil
+		})
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(repos, convey.ShouldResemble, []string{"app", "team/api", "team/web"})
+		convey.So(f.catalogQueries, convey.ShouldResemble, []string{"n=1000", "last=app", "last=team%2Fapi"})
+	})
+
+	convey.Convey("Stop when the Link header points back to a page", t, func() {
+		f.catalogLoop = true
+		defer func() { f.catalogLoop = false }()
+		repos := []string{}
+		err := client.WalkRepositories(func(repo string) error {
+			repos = append(repos, repo)
+			return nil
+		})
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(repos, convey.ShouldResemble, []string{"app", "team/api", "app", "team/api"})
+	})
+
+	convey.Convey("Stop on the first error", t, func() {
+		repos := []string{}
+		err := client.WalkRepositories(func(repo string) error {
+			repos = append(repos, repo)
+			return fmt.Errorf("stop")
+		})
+		convey.So(err, convey.ShouldNotBeNil)
+		convey.So(repos, convey.ShouldResemble, []string{"app"})
+	})
+}
+
+func TestConfigBlob(t *testing.T) {
+	created := time.Date(2019, 7, 1, 12, 0, 0, 0, time.UTC)
+	f, server := newFakeRegistry(map[string]map[string]time.Time{"app": {"v1": created}})
+	defer server.Close()
+	client := NewClient(server.URL, false, "", "")
+
+	convey.Convey("Get parsed config blob", t, func() {
+		config, err := client.ConfigBlob("app", "v1")
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(config.Digest, convey.ShouldEqual, fakeConfigDigest(created))
+		convey.So(config.Created, convey.ShouldEqual, created)
+		convey.So(config.Architecture, convey.ShouldEqual, "amd64")
+		convey.So(config.OS, convey.ShouldEqual, "linux")
+		convey.So(config.Labels, convey.ShouldResemble, map[string]string{"maintainer": "qa"})
+
+		_, err = client.ConfigBlob("app", "v2")
+		convey.So(err, convey.ShouldNotBeNil)
+	})
+
+	convey.Convey("Fall back to config blob created date without manifest v1", t, func() {
+		f.noSchema1 = true
+		p := &purger{client: client, logger: SetupLogging("registry.tasks_test"), summary: &PurgeSummary{}}
+		scan := p.scanRepo(context.Background(), "app")
+		convey.So(scan.tags, convey.ShouldResemble, timeSlice{{name: "v1", created: created, source: DateSourceConfig}})
+		convey.So(scan.unprocessed, convey.ShouldBeEmpty)
+	})
+
+	convey.Convey("Keep tags without manifest v1 and config blob as unprocessed", t, func() {
+		f.noSchema1, f.noConfigBlob = true, true
+		client.resetConfigCache()
+		p := &purger{client: client, logger: SetupLogging("registry.tasks_test"), summary: &PurgeSummary{}}
+		scan := p.scanRepo(context.Background(), "app")
+		convey.So(scan.tags, convey.ShouldBeEmpty)
+		convey.So(scan.unprocessed, convey.ShouldResemble, []string{"v1"})
+
+		summary := PurgeOldTags(context.Background(), client, PurgeTagsOptions{KeepDays: 1, KeepCount: 0})
+		convey.So(summary.TagsToPurge(), convey.ShouldEqual, 0)
+		convey.So(summary.TagsUnprocessed(), convey.ShouldEqual, 1)
+		convey.So(summary.Repos[0].Keep, convey.ShouldResemble, []string{"v1"})
+		convey.So(summary.Metrics(), convey.ShouldContainSubstring, "registry_ui_purge_tags_unprocessed 1\n")
+	})
+}
+
+func TestDisableSchema1(t *testing.T) {
+	created := time.Date(2019, 7, 1, 12, 0, 0, 0, time.UTC)
+	f := &fakeRegistry{repos: map[string]map[string]time.Time{"app": {"v1": created}}}
+	var schema1Requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.Header.Get("Accept"), "distribution.manifest.v1") {
+			atomic.AddInt32(&schema1Requests, 1)
+		}
+		f.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	client := NewClient(server.URL, false, "", "")
+	client.SetDisableSchema1(true)
+
+	convey.Convey("Get the image info without manifest v1", t, func() {
+		_, infoV1, _ := client.TagInfo("app", "v1", true)
+		convey.So(infoV1, convey.ShouldBeEmpty)
+		sha256, infoV1, infoV2 := client.TagInfo("app", "v1", false)
+		convey.So("sha256:"+sha256, convey.ShouldEqual, fakeDigest(created))
+		convey.So(infoV1, convey.ShouldBeEmpty)
+		convey.So(infoV2, convey.ShouldNotBeEmpty)
+	})
+
+	convey.Convey("Date the tags by their config blob without requesting manifest v1", t, func() {
+		p := &purger{client: client, logger: SetupLogging("registry.tasks_test"), summary: &PurgeSummary{}}
+		scan := p.scanRepo(context.Background(), "app")
+		convey.So(scan.tags, convey.ShouldResemble, timeSlice{{name: "v1", created: created, source: DateSourceConfig}})
+		convey.So(atomic.LoadInt32(&schema1Requests), convey.ShouldEqual, 0)
+	})
+}
+
+func TestConfigBlobRedirect(t *testing.T) {
+	var storageAuth []string
+	storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Signed URLs are rejected along with other credentials like S3 does.
+		storageAuth = append(storageAuth, r.Header.Get("Authorization"))
+		if r.Header.Get("Authorization") != "" || r.URL.Query().Get("signature") == "" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(`{"created": "2019-07-01T12:00:00Z", "architecture": "amd64", "os": "linux"}`))
+	}))
+	defer storage.Close()
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, _, ok := r.BasicAuth(); !ok || user != "qa" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if strings.Contains(r.URL.Path, "/blobs/") {
+			http.Redirect(w, r, storage.URL+"/blob?signature=abc", http.StatusTemporaryRedirect)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer registry.Close()
+	client := NewClient(registry.URL, false, "qa", "secret")
+
+	convey.Convey("Follow blob redirects to storage without the registry credentials", t, func() {
+		config, err := client.configBlob("app", "sha256:abc")
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(config.Created, convey.ShouldEqual, time.Date(2019, 7, 1, 12, 0, 0, 0, time.UTC))
+		convey.So(storageAuth, convey.ShouldResemble, []string{""})
+	})
+}
+
+func TestArtifacts(t *testing.T) {
+	now := time.Now().UTC()
+	newRepos := func() map[string]map[string]time.Time {
+		return map[string]map[string]time.Time{
+			"charts": {"1.0.0": now.Add(-30 * 24 * time.Hour), "1.1.0": now},
+			"app":    {"v1": now.Add(-30 * 24 * time.Hour), "v2": now},
+		}
+	}
+	artifacts := map[string]bool{"1.0.0": true, "1.1.0": true}
+	opts := PurgeTagsOptions{KeepDays: 7, KeepCount: 1}
+
+	convey.Convey("Get artifact creation date from manifest annotation", t, func() {
+		f, server := newFakeRegistry(newRepos())
+		defer server.Close()
+		f.artifacts = artifacts
+		client := NewClient(server.URL, false, "", "")
+		config, err := client.ConfigBlob("charts", "1.0.0")
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(config.ArtifactType, convey.ShouldEqual, "application/vnd.cncf.helm.config.v1+json")
+		convey.So(config.Created, convey.ShouldEqual, now.Add(-30*24*time.Hour))
+
+		config, err = client.ConfigBlob("charts", "1.1.0")
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(config.Created, convey.ShouldEqual, now)
+	})
+
+	convey.Convey("Purge artifacts like images", t, func() {
+		f, server := newFakeRegistry(newRepos())
+		defer server.Close()
+		f.artifacts = artifacts
+		PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), opts)
+		sort.Strings(f.deleted)
+		convey.So(f.deleted, convey.ShouldResemble, []string{"app:v1", "charts:1.0.0"})
+	})
+
+	convey.Convey("Keep artifacts when excluded", t, func() {
+		f, server := newFakeRegistry(newRepos())
+		defer server.Close()
+		f.artifacts = artifacts
+		excluded := opts
+		excluded.ExcludeArtifacts = true
+		summary := PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), excluded)
+		convey.So(f.deleted, convey.ShouldResemble, []string{"app:v1"})
+		convey.So(summary.repo("charts").Keep, convey.ShouldResemble, []string{"1.0.0", "1.1.0"})
+	})
+
+	convey.Convey("Purge tags dated by the manifest annotation only", t, func() {
+		f, server := newFakeRegistry(newRepos())
+		defer server.Close()
+		f.annotated = map[string]bool{"v1": true, "v2": true}
+		client := NewClient(server.URL, false, "", "")
+		config, err := client.ConfigBlob("app", "v1")
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(config.Digest, convey.ShouldBeEmpty)
+		convey.So(config.Created, convey.ShouldEqual, now.Add(-30*24*time.Hour))
+
+		summary := PurgeOldTags(context.Background(), client, opts)
+		sort.Strings(f.deleted)
+		convey.So(f.deleted, convey.ShouldResemble, []string{"app:v1", "charts:1.0.0"})
+		convey.So(summary.TagsUnprocessed(), convey.ShouldEqual, 0)
+	})
+}
+
+func TestBaseURL(t *testing.T) {
+	created := time.Date(2019, 7, 1, 0, 0, 0, 0, time.UTC)
+	newRepos := func() map[string]map[string]time.Time {
+		return map[string]map[string]time.Time{"app": {"v1": created, "v2": created}, "team/api": {"v1": created}}
+	}
+
+	convey.Convey("Build the request URLs whatever the form of the registry URL", t, func() {
+		f, server := newFakeRegistry(newRepos())
+		defer server.Close()
+		f.catalogPageSize, f.pageSize = 1, 1
+		for _, base := range []string{server.URL, server.URL + "/", server.URL + "//", server.URL + "/v2", server.URL + "/v2/", " " + server.URL + "/ "} {
+			client := NewClient(base, false, "", "")
+			convey.So(client, convey.ShouldNotBeNil)
+			convey.So(client.Repositories(false), convey.ShouldResemble, map[string][]string{"library": {"app"}, "team": {"api"}})
+			convey.So(client.Tags("app"), convey.ShouldResemble, []string{"v1", "v2"})
+		}
+	})
+
+	convey.Convey("Keep the path prefix of registries behind reverse proxies", t, func() {
+		// Some proxies rewrite the Link headers to the public path, some do not.
+		for _, linkPrefix := range []string{"", "/registry"} {
+			f := &fakeRegistry{repos: newRepos(), catalogPageSize: 1, linkPrefix: linkPrefix}
+			server := httptest.NewServer(http.StripPrefix("/registry", f))
+			for _, base := range []string{server.URL + "/registry", server.URL + "/registry/v2/"} {
+				client := NewClient(base, false, "", "")
+				convey.So(client, convey.ShouldNotBeNil)
+				convey.So(client.Repositories(false), convey.ShouldResemble, map[string][]string{"library": {"app"}, "team": {"api"}})
+			}
+			server.Close()
+		}
+	})
+
+	convey.Convey("Follow absolute pagination links", t, func() {
+		client := &Client{url: "https://registry.local/registry"}
+		convey.So(client.linkURI("https://registry.local/registry/v2/_catalog?last=a&n=1"), convey.ShouldEqual, "/v2/_catalog?last=a&n=1")
+		convey.So(client.linkURI("/v2/_catalog?last=a"), convey.ShouldEqual, "/v2/_catalog?last=a")
+		convey.So(client.linkURI("v2/_catalog?last=a"), convey.ShouldEqual, "/v2/_catalog?last=a")
+	})
+}
+
+func TestTagSize(t *testing.T) {
+	convey.Convey("Sum the layers and config blob of the tag", t, func() {
+		created := time.Date(2019, 7, 1, 0, 0, 0, 0, time.UTC)
+		_, server := newFakeRegistry(map[string]map[string]time.Time{"app": {"v1": created}})
+		defer server.Close()
+		client := NewClient(server.URL, false, "", "")
+		size, err := client.TagSize("app", "v1")
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(size, convey.ShouldEqual, 1100)
+
+		_, err = client.TagSize("app", "missing")
+		convey.So(err, convey.ShouldNotBeNil)
+		convey.So(client.TagSizes("app", []string{"v1", "missing"}, 2), convey.ShouldResemble, map[string]int64{"v1": 1100})
+	})
+
+	convey.Convey("Cache the sizes by manifest digest up to the cache size", t, func() {
+		created := time.Date(2019, 7, 1, 0, 0, 0, 0, time.UTC)
+		f, server := newFakeRegistry(map[string]map[string]time.Time{"app": {"v1": created, "v2": created.Add(time.Hour)}})
+		defer server.Close()
+		client := NewClient(server.URL, false, "", "")
+		_, err := client.TagSize("app", "v1")
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(client.sizeCache, convey.ShouldContainKey, fakeDigest(created))
+
+		// The tag pushed again is measured by its new digest.
+		f.repos["app"]["v1"] = created.Add(2 * time.Hour)
+		_, err = client.TagSize("app", "v1")
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(client.sizeCache, convey.ShouldContainKey, fakeDigest(created.Add(2*time.Hour)))
+
+		defer func(size int) { sizeCacheSize = size }(sizeCacheSize)
+		sizeCacheSize = 2
+		_, err = client.TagSize("app", "v2")
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(client.sizeCache, convey.ShouldResemble, map[string]int64{fakeDigest(created.Add(time.Hour)): 1100})
+	})
+
+	convey.Convey("Size manifest lists by their linux/amd64 manifest or all of them", t, func() {
+		manifests := map[string]string{
+			"multi":      `{"mediaType": "application/vnd.oci.image.index.v1+json", "manifests": [{"digest": "sha256:arm", "platform": {"os": "linux", "architecture": "arm64"}}, {"digest": "sha256:amd", "platform": {"os": "linux", "architecture": "amd64"}}]}`,
+			"sha256:arm": `{"mediaType": "application/vnd.oci.image.manifest.v1+json", "config": {"size": 10}, "layers": [{"size": 1000}]}`,
+			"sha256:amd": `{"mediaType": "application/vnd.oci.image.manifest.v1+json", "config": {"size": 20}, "layers": [{"size": 1500}, {"size": 500}]}`,
+		}
+		manifests["sha256:multi"] = manifests["multi"]
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ref := strings.TrimPrefix(r.URL.Path, "/v2/app/manifests/")
+			m, ok := manifests[ref]
+			if r.URL.Path != "/v2/" && !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Docker-Content-Digest", "sha256:"+strings.TrimPrefix(ref, "sha256:"))
+			w.Write([]byte(m))
+		}))
+		defer server.Close()
+		client := NewClient(server.URL, false, "", "")
+		size, err := client.TagSize("app", "multi")
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(size, convey.ShouldEqual, 2020)
+
+		client.SetTagSizeAllPlatforms(true)
+		client.resetConfigCache()
+		size, err = client.TagSize("app", "multi")
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(size, convey.ShouldEqual, 3030)
+	})
+}
+
+func TestResolveDigests(t *testing.T) {
+	convey.Convey("Resolve the digests of the tags found", t, func() {
+		created := time.Date(2019, 7, 1, 0, 0, 0, 0, time.UTC)
+		_, server := newFakeRegistry(map[string]map[string]time.Time{"app": {"v1": created, "v2": created.Add(time.Hour)}})
+		defer server.Close()
+		digests := NewClient(server.URL, false, "", "").ResolveDigests("app", []string{"v1", "v2", "missing"})
+		convey.So(digests, convey.ShouldResemble, map[string]string{"v1": fakeDigest(created), "v2": fakeDigest(created.Add(time.Hour))})
+	})
+
+	convey.Convey("Resolve them as many at a time as the concurrent requests bound", t, func() {
+		var current, highest int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			n := atomic.AddInt32(&current, 1)
+			defer atomic.AddInt32(&current, -1)
+			for {
+				h := atomic.LoadInt32(&highest)
+				if n <= h || atomic.CompareAndSwapInt32(&highest, h, n) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			w.Header().Set("Docker-Content-Digest", "sha256:"+strings.TrimPrefix(r.URL.Path, "/v2/app/manifests/"))
+		}))
+		defer server.Close()
+		client := NewClient(server.URL, false, "", "")
+		client.SetMaxConcurrentRequests(2)
+		atomic.StoreInt32(&highest, 0)
+		digests := client.ResolveDigests("app", []string{"a", "b", "c", "d", "e", "f"})
+		convey.So(digests, convey.ShouldHaveLength, 6)
+		convey.So(digests["c"], convey.ShouldEqual, "sha256:c")
+		convey.So(atomic.LoadInt32(&highest), convey.ShouldEqual, 2)
+	})
+}
+
+func TestDeleteRepository(t *testing.T) {
+	created := time.Date(2019, 7, 1, 0, 0, 0, 0, time.UTC)
+	f, server := newFakeRegistry(map[string]map[string]time.Time{
+		"app":   {"v1": created, "latest": created, "v2": created.Add(time.Hour)},
+		"other": {"v1": created},
+	})
+	defer server.Close()
+	client := NewClient(server.URL, false, "", "")
+
+	convey.Convey("Delete every manifest of the repo once", t, func() {
+		deleted, errs := client.DeleteRepository("app")
+		convey.So(errs, convey.ShouldBeEmpty)
+		convey.So(deleted, convey.ShouldEqual, 2)
+		convey.So(f.repos["app"], convey.ShouldBeEmpty)
+		convey.So(f.repos["other"], convey.ShouldHaveLength, 1)
+	})
+
+	convey.Convey("Report deletion errors", t, func() {
+		f.failDelete = true
+		deleted, errs := client.DeleteRepository("other")
+		convey.So(deleted, convey.ShouldEqual, 0)
+		convey.So(errs, convey.ShouldHaveLength, 1)
+	})
+}
+
+func TestAnonymousPull(t *testing.T) {
+	var tokenRequests []string
+	mux := sync.Mutex{}
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.Lock()
+		defer mux.Unlock()
+
+		if r.URL.Path == "/token" {
+			kind := "anonymous"
+			if user, _, ok := r.BasicAuth(); ok {
+				kind = "user-" + user
+			}
+			tokenRequests = append(tokenRequests, kind)
+			json.NewEncoder(w).Encode(map[string]string{"token": fmt.Sprintf("%s-%d", kind, len(tokenRequests))})
+			return
+		}
+		auth := r.Header.Get("Authorization")
+		// Public repo is readable anonymously, private one and deletions require credentials.
+		allowed := strings.HasPrefix(auth, "Bearer user-qa-") ||
+			(strings.HasPrefix(auth, "Bearer anonymous-") && r.Method != http.MethodDelete && !strings.HasPrefix(r.URL.Path, "/v2/private/"))
+		if !allowed {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registry"`, server.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.URL.Path == "/v2/":
+		case strings.HasSuffix(r.URL.Path, "/tags/list"):
+			w.Write([]byte(`{"tags": ["v1"]}`))
+		case r.Method == http.MethodHead:
+			w.Header().Set("Docker-Content-Digest", "sha256:abc")
+		case r.Method == http.MethodDelete:
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	convey.Convey("Request tokens anonymously without username", t, func() {
+		tokenRequests = nil
+		client := NewClient(server.URL, false, "", "")
+		convey.So(client, convey.ShouldNotBeNil)
+		convey.So(client.Tags("public"), convey.ShouldResemble, []string{"v1"})
+		convey.So(tokenRequests, convey.ShouldResemble, []string{"anonymous"})
+	})
+
+	convey.Convey("Use credentials by default", t, func() {
+		tokenRequests = nil
+		client := NewClient(server.URL, false, "qa", "secret")
+		convey.So(client.Tags("public"), convey.ShouldResemble, []string{"v1"})
+		convey.So(tokenRequests, convey.ShouldResemble, []string{"user-qa"})
+	})
+
+	convey.Convey("Try anonymous first and fall back to credentials where denied", t, func() {
+		tokenRequests = nil
+		client := NewClient(server.URL, false, "qa", "secret")
+		client.SetAnonymousPull(true)
+		convey.So(client.Tags("public"), convey.ShouldResemble, []string{"v1"})
+		convey.So(client.Tags("private"), convey.ShouldResemble, []string{"v1"})
+		convey.So(client.Tags("private"), convey.ShouldResemble, []string{"v1"})
+		convey.So(client.DeleteTag("public", "v1"), convey.ShouldBeNil)
+		convey.So(tokenRequests, convey.ShouldResemble, []string{"anonymous", "anonymous", "user-qa", "user-qa"})
+	})
+}
@@ -1,11 +1,14 @@
 package registry
 
 import (
+	"crypto/rand"
 	"fmt"
 	"reflect"
+	"regexp"
 	"sort"
 
 	"github.com/hhkbp2/go-logging"
+	"github.com/tidwall/gjson"
 )
 
 // SetupLogging configure logging.
@@ -21,6 +24,34 @@ func SetupLogging(name string) logging.Logger {
 	return logger
 }
 
+// SetupRunLogging configure the logging of a run, every line tagged with the run ID. The returned func removes
+// the handler of the run once it is done.
+func SetupRunLogging(name, runID string) (logging.Logger, func()) {
+	logger := logging.GetLogger(name)
+	handler := logging.NewStdoutHandler()
+	format := "%(asctime)s - %(name)s - %(levelname)s - run " + runID + " - %(message)s"
+	dateFormat := "%Y-%m-%d %H:%M:%S"
+	handler.SetFormatter(logging.NewStandardFormatter(format, dateFormat))
+	logger.SetLevel(logging.LevelInfo)
+	logger.AddHandler(handler)
+	return logger, func() { logger.RemoveHandler(handler) }
+}
+
+// runIDRegexp matches the characters not allowed in run IDs.
+var runIDRegexp = regexp.MustCompile(`[^\w.:@+-]`)
+
+// newRunID return the run ID given, with the characters not allowed replaced by "_", or a random one if empty.
+func newRunID(id string) string {
+	if id != "" {
+		return runIDRegexp.ReplaceAllString(id, "_")
+	}
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return fmt.Sprintf("%x", b)
+}
+
 // SortedMapKeys sort keys of the map where values can be of any type.
 func SortedMapKeys(m interface{}) []string {
 	v := reflect.ValueOf(m)
@@ -43,6 +74,43 @@ func PrettySize(size float64) string {
 	return fmt.Sprintf("%.*f %s", 0, size, units[i])
 }
 
+// imageConfigMediaTypes config media types of container images, manifests with other ones are artifacts.
+var imageConfigMediaTypes = map[string]bool{
+	MediaTypeImageConfig:    true,
+	MediaTypeOCIImageConfig: true,
+}
+
+// ArtifactType return the artifact type of the manifest, e.g. Helm chart or SBOM, or empty string for container images.
+func ArtifactType(manifest string) string {
+	if t := gjson.Get(manifest, "artifactType").String(); t != "" {
+		return t
+	}
+	if t := gjson.Get(manifest, "config.mediaType").String(); t != "" && !imageConfigMediaTypes[t] {
+		return t
+	}
+	return ""
+}
+
+// ImageSize sum the layer sizes of the image manifest, or blob sizes of the artifact manifest,
+// falling back to v1 history when no layers listed.
+func ImageSize(manifest string) int64 {
+	var size int64
+	if gjson.Get(manifest, "layers").Exists() {
+		for _, s := range gjson.Get(manifest, "layers.#.size").Array() {
+			size = size + s.Int()
+		}
+	} else if gjson.Get(manifest, "blobs").Exists() {
+		for _, s := range gjson.Get(manifest, "blobs.#.size").Array() {
+			size = size + s.Int()
+		}
+	} else {
+		for _, s := range gjson.Get(manifest, "history.#.v1Compatibility").Array() {
+			size = size + gjson.Get(s.String(), "Size").Int()
+		}
+	}
+	return size
+}
+
 // ItemInSlice check if item is an element of slice
 func ItemInSlice(item string, slice []string) bool {
 	for _, i := range slice {
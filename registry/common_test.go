@@ -55,3 +55,32 @@ func TestItemInSlice(t *testing.T) {
 		convey.So(ItemInSlice("gh", a), convey.ShouldBeFalse)
 	})
 }
+
+func TestArtifactType(t *testing.T) {
+	convey.Convey("Detect artifact manifests", t, func() {
+		convey.So(ArtifactType(`{"config": {"mediaType": "application/vnd.docker.container.image.v1+json"}}`), convey.ShouldBeEmpty)
+		convey.So(ArtifactType(`{"config": {"mediaType": "application/vnd.oci.image.config.v1+json"}}`), convey.ShouldBeEmpty)
+		convey.So(ArtifactType(`{"config": {"mediaType": "application/vnd.cncf.helm.config.v1+json"}}`),
+			convey.ShouldEqual, "application/vnd.cncf.helm.config.v1+json")
+		convey.So(ArtifactType(`{"artifactType": "application/spdx+json", "config": {"mediaType": "application/vnd.oci.empty.v1+json"}}`),
+			convey.ShouldEqual, "application/spdx+json")
+	})
+}
+
+func TestImageSize(t *testing.T) {
+	convey.Convey("Sum layers or artifact blobs", t, func() {
+		convey.So(ImageSize(`{"layers": [{"size": 100}, {"size": 20}]}`), convey.ShouldEqual, 120)
+		convey.So(ImageSize(`{"blobs": [{"size": 7}]}`), convey.ShouldEqual, 7)
+		convey.So(ImageSize(`{"history": [{"v1Compatibility": "{\"Size\": 5}"}]}`), convey.ShouldEqual, 5)
+	})
+}
+
+func TestNewRunID(t *testing.T) {
+	convey.Convey("Generate random run IDs or sanitize the given one", t, func() {
+		id := newRunID("")
+		convey.So(id, convey.ShouldHaveLength, 16)
+		convey.So(newRunID(""), convey.ShouldNotEqual, id)
+		convey.So(newRunID("ci-1234"), convey.ShouldEqual, "ci-1234")
+		convey.So(newRunID("build 42/%d"), convey.ShouldEqual, "build_42__d")
+	})
+}
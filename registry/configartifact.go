@@ -0,0 +1,76 @@
+package registry
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hhkbp2/go-logging"
+	"github.com/tidwall/gjson"
+	"gopkg.in/yaml.v2"
+)
+
+// ArtifactContent get the content of the first layer of the artifact tagged in the repo, e.g. a file pushed with
+// "oras push", false if the tag does not exist.
+func (c *Client) ArtifactContent(repo, tag string) ([]byte, bool, error) {
+	exists, _, err := c.ManifestExists(repo, tag)
+	if err != nil || !exists {
+		return nil, false, err
+	}
+	manifest, _, err := c.fetchManifest(repo, tag)
+	if err != nil {
+		return nil, false, err
+	}
+	digest := gjson.Get(manifest, "layers.0.digest").String()
+	if digest == "" {
+		return nil, false, fmt.Errorf("no layer in artifact %s:%s", repo, tag)
+	}
+
+	scope := fmt.Sprintf("repository:%s:*", repo)
+	authHeader := ""
+	if c.authURL != "" {
+		authHeader = fmt.Sprintf("Bearer %s", c.getToken(scope))
+	}
+	uri := fmt.Sprintf("/v2/%s/blobs/%s", repo, digest)
+	resp, data, errs := c.end(c.newRequest().Get(c.url+uri).Set("Authorization", authHeader).Set("User-Agent", "docker-registry-ui"))
+	if len(errs) > 0 {
+		c.logger.Error(errs[0])
+		return nil, false, errs[0]
+	}
+	c.logger.Info("GET ", uri, " ", resp.Status)
+	if resp.StatusCode != 200 {
+		return nil, false, fmt.Errorf("failed to get artifact blob %s@%s: %s", repo, digest, resp.Status)
+	}
+	return []byte(data), true, nil
+}
+
+// artifactConfigs return the purge configs of the ConfigsArtifact validated like the ones of the config file,
+// opts.Configs if the artifact does not exist or is not set.
+func artifactConfigs(client *Client, logger logging.Logger, opts PurgeTagsOptions) ([]PurgeConfig, error) {
+	if opts.ConfigsArtifact == "" {
+		return opts.Configs, nil
+	}
+	repo, tag := opts.ConfigsArtifact, "latest"
+	if i := strings.LastIndex(repo, ":"); i > strings.LastIndex(repo, "/") {
+		repo, tag = repo[:i], repo[i+1:]
+	}
+	data, ok, err := client.ArtifactContent(repo, tag)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get purge configs artifact %s:%s: %s", repo, tag, err)
+	}
+	if !ok {
+		logger.Infof("No purge configs artifact %s:%s, using the local purge configs.", repo, tag)
+		return opts.Configs, nil
+	}
+	var fragment struct {
+		PurgeConfigs []PurgeConfig `yaml:"purge_configs"`
+	}
+	if err := yaml.Unmarshal(data, &fragment); err != nil {
+		return nil, fmt.Errorf("invalid purge configs artifact %s:%s: %s", repo, tag, err)
+	}
+	opts.Configs = fragment.PurgeConfigs
+	if _, err := CheckPurgeOptions(opts); err != nil {
+		return nil, fmt.Errorf("invalid purge configs artifact %s:%s: %s", repo, tag, err)
+	}
+	logger.Infof("Using the %d purge configs of artifact %s:%s in place of the local ones.", len(fragment.PurgeConfigs), repo, tag)
+	return fragment.PurgeConfigs, nil
+}
@@ -0,0 +1,66 @@
+package registry
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestConfigsArtifact(t *testing.T) {
+	now := time.Now().UTC()
+	opts := PurgeTagsOptions{KeepDays: 7, KeepCount: 1, DeleteWorkers: 2, DrainTimeout: time.Second, ConfigsArtifact: "_config/retention"}
+	// serve the fake registry along with the config artifact of the content, none if empty.
+	serve := func(content string) (*fakeRegistry, *httptest.Server) {
+		f := &fakeRegistry{repos: map[string]map[string]time.Time{
+			"app": {"v1": now.Add(-30 * 24 * time.Hour), "v2": now.Add(-20 * 24 * time.Hour), "v3": now},
+		}}
+		digest := fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(content)))
+		return f, httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case content != "" && r.URL.Path == "/v2/_config/retention/manifests/latest":
+				w.Header().Set("Content-Type", MediaTypeOCIManifest)
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"schemaVersion": 2, "mediaType": MediaTypeOCIManifest,
+					"config": map[string]interface{}{"mediaType": "application/vnd.oci.empty.v1+json", "digest": emptyConfigDigest, "size": 2},
+					"layers": []map[string]interface{}{{"mediaType": "application/yaml", "digest": digest, "size": len(content)}},
+				})
+			case content != "" && r.URL.Path == "/v2/_config/retention/blobs/"+digest:
+				w.Write([]byte(content))
+			default:
+				f.ServeHTTP(w, r)
+			}
+		}))
+	}
+
+	convey.Convey("Purge by the configs of the artifact", t, func() {
+		f, server := serve("purge_configs:\n  - repo_regex: ^app$\n    tags:\n      - tags_regex: ^.*$\n        keep_days: 25\n        keep_count: 1\n")
+		defer server.Close()
+		summary := PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), opts)
+		convey.So(summary.Errors, convey.ShouldBeEmpty)
+		convey.So(f.deleted, convey.ShouldResemble, []string{"app:v1"})
+	})
+
+	convey.Convey("Fall back to the local configs without the artifact", t, func() {
+		f, server := serve("")
+		defer server.Close()
+		summary := PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), opts)
+		convey.So(summary.Errors, convey.ShouldBeEmpty)
+		convey.So(f.deleted, convey.ShouldHaveLength, 2)
+	})
+
+	convey.Convey("Purge nothing with an invalid artifact", t, func() {
+		f, server := serve("purge_configs:\n  - repo_regex: ^app($\n")
+		defer server.Close()
+		summary := PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), opts)
+		convey.So(summary.Errors, convey.ShouldHaveLength, 1)
+		convey.So(summary.Errors[0], convey.ShouldContainSubstring, "invalid purge configs artifact _config/retention:latest")
+		convey.So(f.deleted, convey.ShouldBeEmpty)
+	})
+}
@@ -0,0 +1,121 @@
+package registry
+
+import (
+	"context"
+	"regexp"
+	"strings"
+)
+
+// companionRegexp match the tags of the artifacts attached to a subject manifest by cosign, i.e. its signature,
+// attestations and SBOM, and the referrers index tag of the OCI referrers tag schema, named after the subject digest.
+var companionRegexp = regexp.MustCompile(`^sha256-([0-9a-f]{64})(\.(sig|att|sbom))?$`)
+
+// companionSubject return the digest of the subject of the companion tag and its kind, e.g. "att", false for other tags.
+func companionSubject(tag string) (string, string, bool) {
+	m := companionRegexp.FindStringSubmatch(tag)
+	if m == nil {
+		return "", "", false
+	}
+	return "sha256:" + m[1], m[3], true
+}
+
+// splitCompanions split the companion tags from the others with DeleteCompanions, so they follow their subject
+// rather than the tags rules and do not count towards KeepCount.
+func (p *purger) splitCompanions(tags timeSlice) ([]string, timeSlice) {
+	if !p.opts.DeleteCompanions {
+		return nil, tags
+	}
+	companions := []string{}
+	rest := timeSlice{}
+	for _, t := range tags {
+		if _, _, ok := companionSubject(t.name); ok {
+			companions = append(companions, t.name)
+		} else {
+			rest = append(rest, t)
+		}
+	}
+	return companions, rest
+}
+
+// followSubjects apply KeepAttested and DeleteCompanions to the tags of the repo: the tags to purge which manifest
+// has attestations are kept with the first, the companion tags are purged along with their subject with the second
+// and kept otherwise. Tags which digest cannot be resolved are kept, as are their companions.
+func (p *purger) followSubjects(ctx context.Context, repo string, keep, purge []string) ([]string, []string) {
+	if !p.opts.DeleteCompanions && !p.opts.KeepAttested {
+		return keep, purge
+	}
+	companions := map[string][]string{}
+	attested := map[string]bool{}
+	subjects := []string{}
+	for _, tag := range append(append([]string{}, keep...), purge...) {
+		if digest, kind, ok := companionSubject(tag); ok {
+			companions[digest] = append(companions[digest], tag)
+			attested[digest] = attested[digest] || kind == "att"
+		} else {
+			subjects = append(subjects, tag)
+		}
+	}
+	if len(companions) == 0 || ctx.Err() != nil {
+		return keep, purge
+	}
+	p.resolveDigests(repo, subjects)
+	digest := func(tag string) string {
+		d, err := p.resolveDigest(repo, tag)
+		if err != nil {
+			p.logger.Errorf("[%s] keeping tag %s failed to check its companion tags: %s", repo, tag, err)
+		}
+		return d
+	}
+
+	// Digests of the subjects staying, which companions stay too, and of the ones purged.
+	staying := map[string]bool{}
+	purged := map[string]bool{}
+	newKeep := []string{}
+	newPurge := []string{}
+	for _, tag := range keep {
+		if _, _, ok := companionSubject(tag); ok {
+			if !p.opts.DeleteCompanions {
+				newKeep = append(newKeep, tag)
+			}
+			continue
+		}
+		newKeep = append(newKeep, tag)
+		if p.opts.DeleteCompanions {
+			if d := digest(tag); d != "" {
+				staying[d] = true
+			}
+		}
+	}
+	for _, tag := range purge {
+		if _, _, ok := companionSubject(tag); ok {
+			if !p.opts.DeleteCompanions {
+				newPurge = append(newPurge, tag)
+			}
+			continue
+		}
+		d := digest(tag)
+		switch {
+		case d == "":
+			newKeep = append(newKeep, tag)
+		case p.opts.KeepAttested && attested[d]:
+			p.logger.Infof("[%s] keeping tag %s which manifest %s has attestations.", repo, tag, d)
+			newKeep = append(newKeep, tag)
+			staying[d] = true
+		default:
+			newPurge = append(newPurge, tag)
+			purged[d] = true
+		}
+	}
+	if !p.opts.DeleteCompanions {
+		return newKeep, newPurge
+	}
+	for _, d := range SortedMapKeys(companions) {
+		if purged[d] && !staying[d] {
+			p.logger.Infof("[%s] purging %s along with their subject %s.", repo, strings.Join(companions[d], ", "), d)
+			newPurge = append(newPurge, companions[d]...)
+		} else {
+			newKeep = append(newKeep, companions[d]...)
+		}
+	}
+	return newKeep, newPurge
+}
@@ -0,0 +1,71 @@
+package registry
+
+import (
+	"sync"
+	"time"
+)
+
+// CreatedCache cache of the creation dates of the manifests by digest shared across runs, e.g. the scheduled ones
+// of the process, as the creation date of a digest never changes. Implementations must be safe for concurrent use.
+type CreatedCache interface {
+	// Get return the creation date of the manifest digest with its date source, false if not cached.
+	Get(digest string) (time.Time, string, bool)
+	Set(digest string, created time.Time, source string)
+}
+
+// memoryCreatedCache in-memory CreatedCache which entries expire after the TTL.
+type memoryCreatedCache struct {
+	mux     sync.Mutex
+	ttl     time.Duration
+	entries map[string]cachedCreated
+	// pruned is when the expired entries were last dropped.
+	pruned time.Time
+}
+
+// cachedCreated creation date of a manifest cached until it expires.
+type cachedCreated struct {
+	created time.Time
+	source  string
+	expires time.Time
+}
+
+// NewMemoryCreatedCache create an in-memory CreatedCache which entries expire after the TTL, never with 0.
+func NewMemoryCreatedCache(ttl time.Duration) CreatedCache {
+	return &memoryCreatedCache{ttl: ttl, entries: map[string]cachedCreated{}}
+}
+
+// Get return the cached creation date of the digest, dropping it once expired.
+func (c *memoryCreatedCache) Get(digest string) (time.Time, string, bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	entry, ok := c.entries[digest]
+	if !ok {
+		return time.Time{}, "", false
+	}
+	if !entry.expires.IsZero() && !time.Now().Before(entry.expires) {
+		delete(c.entries, digest)
+		return time.Time{}, "", false
+	}
+	return entry.created, entry.source, true
+}
+
+// Set cache the creation date of the digest, dropping the expired entries once per TTL so the digests of the
+// deleted manifests do not pile up.
+func (c *memoryCreatedCache) Set(digest string, created time.Time, source string) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	now := time.Now()
+	entry := cachedCreated{created: created, source: source}
+	if c.ttl > 0 {
+		entry.expires = now.Add(c.ttl)
+		if now.Sub(c.pruned) >= c.ttl {
+			for d, e := range c.entries {
+				if !now.Before(e.expires) {
+					delete(c.entries, d)
+				}
+			}
+			c.pruned = now
+		}
+	}
+	c.entries[digest] = entry
+}
@@ -0,0 +1,45 @@
+package registry
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestMemoryCreatedCache(t *testing.T) {
+	now := time.Now().UTC()
+
+	convey.Convey("Cache the creation dates until they expire", t, func() {
+		cache := NewMemoryCreatedCache(20 * time.Millisecond)
+		cache.Set("sha256:0a", now, DateSourceConfig)
+		created, source, ok := cache.Get("sha256:0a")
+		convey.So(ok, convey.ShouldBeTrue)
+		convey.So(created, convey.ShouldEqual, now)
+		convey.So(source, convey.ShouldEqual, DateSourceConfig)
+		_, _, ok = cache.Get("sha256:0b")
+		convey.So(ok, convey.ShouldBeFalse)
+
+		time.Sleep(30 * time.Millisecond)
+		_, _, ok = cache.Get("sha256:0a")
+		convey.So(ok, convey.ShouldBeFalse)
+	})
+
+	convey.Convey("Evaluate the tags of the next runs from the cache", t, func() {
+		f, server := newFakeRegistry(map[string]map[string]time.Time{
+			"app": {"v1": now.Add(-30 * 24 * time.Hour), "v2": now.Add(-20 * 24 * time.Hour), "v3": now},
+		})
+		defer server.Close()
+		opts := PurgeTagsOptions{DryRun: true, KeepDays: 7, KeepCount: 1, CreatedCache: NewMemoryCreatedCache(0)}
+		client := NewClient(server.URL, false, "", "")
+		summary := PurgeOldTags(context.Background(), client, opts)
+		convey.So(summary.Repos[0].Purge, convey.ShouldResemble, []string{"v2", "v1"})
+
+		// The tags could not be evaluated without the cache.
+		f.noSchema1, f.noConfigBlob = true, true
+		summary = PurgeOldTags(context.Background(), client, opts)
+		convey.So(summary.Repos[0].Unprocessed, convey.ShouldBeEmpty)
+		convey.So(summary.Repos[0].Purge, convey.ShouldResemble, []string{"v2", "v1"})
+	})
+}
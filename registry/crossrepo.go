@@ -0,0 +1,151 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/tidwall/gjson"
+)
+
+// manifestReferences resolve the manifests referenced by the tags staying in the repos, directly or as children
+// of their manifest lists and image indexes, as the "repo:tag" referencing them by digest. Any manifest failing
+// to be fetched fails it, as what it references is unknown then.
+func (p *purger) manifestReferences(ctx context.Context, staying map[string][]string) (map[string][]string, error) {
+	references := map[string][]string{}
+	mux := sync.Mutex{}
+	var failed error
+	for _, repo := range SortedMapKeys(staying) {
+		forEach(ctx, p.opts.TagWorkers, staying[repo], func(tag string) {
+			manifest, digest, err := p.client.fetchManifest(repo, tag)
+			mux.Lock()
+			defer mux.Unlock()
+			if err != nil {
+				if failed == nil {
+					failed = err
+				}
+				return
+			}
+			ref := repo + ":" + tag
+			references[digest] = append(references[digest], ref)
+			if IsManifestIndex(ManifestMediaType(manifest)) {
+				for _, child := range gjson.Get(manifest, "manifests.#.digest").Array() {
+					references[child.String()] = append(references[child.String()], ref)
+				}
+			}
+		})
+	}
+	if failed == nil && ctx.Err() != nil {
+		failed = ctx.Err()
+	}
+	if failed != nil {
+		return nil, fmt.Errorf("failed to resolve the manifests referenced across repos: %s", failed)
+	}
+	return references, nil
+}
+
+// keepCrossRepoReferences move the tags to purge which manifest is still referenced by a tag of another repo
+// staying, or by a manifest list of it, to the ones to keep. It returns the repos which tags to purge changed.
+func (p *purger) keepCrossRepoReferences(ctx context.Context, keepTags, purgeTags map[string][]string) ([]string, error) {
+	staying := map[string][]string{}
+	for repo, keep := range keepTags {
+		staying[repo] = append([]string{}, keep...)
+		// The tags not deleted by the run stay too, e.g. the ones of DryRunOnly repos.
+		if r := p.summary.repo(repo); r != nil {
+			for _, tag := range r.Purge {
+				if !ItemInSlice(tag, purgeTags[repo]) {
+					staying[repo] = append(staying[repo], tag)
+				}
+			}
+		}
+	}
+	references, err := p.manifestReferences(ctx, staying)
+	if err != nil {
+		return nil, err
+	}
+
+	changed := []string{}
+	for _, repo := range SortedMapKeys(purgeTags) {
+		p.resolveDigests(repo, purgeTags[repo])
+		remaining := []string{}
+		for _, tag := range purgeTags[repo] {
+			digest, err := p.resolveDigest(repo, tag)
+			if err != nil {
+				p.logger.Errorf("[%s] keeping tag %s failed to check whether other repos reference it: %s", repo, tag, err)
+				keepTags[repo] = append(keepTags[repo], tag)
+				continue
+			}
+			others := []string{}
+			for _, ref := range references[digest] {
+				if !strings.HasPrefix(ref, repo+":") {
+					others = append(others, ref)
+				}
+			}
+			if len(others) > 0 {
+				sort.Strings(others)
+				p.logger.Warnf("[%s] keeping tag %s which manifest %s is referenced by %v.", repo, tag, digest, others)
+				keepTags[repo] = append(keepTags[repo], tag)
+				continue
+			}
+			remaining = append(remaining, tag)
+		}
+		if len(remaining) < len(purgeTags[repo]) {
+			changed = append(changed, repo)
+		}
+		purgeTags[repo] = remaining
+	}
+	return changed, nil
+}
+
+// keepIndexChildren move the tags to purge which manifest is a child of the manifest list or image index of a kept tag
+// of the repo to the ones to keep. All of them are kept if a kept tag cannot be fetched, as what it references is unknown then.
+func (p *purger) keepIndexChildren(ctx context.Context, repo string, keep, purge []string) ([]string, []string) {
+	if !p.opts.IndexChildProtection || len(purge) == 0 || len(keep) == 0 {
+		return keep, purge
+	}
+	parents := map[string]string{}
+	mux := sync.Mutex{}
+	var failed error
+	forEach(ctx, p.opts.TagWorkers, keep, func(tag string) {
+		children, err := p.client.IndexChildren(repo, tag)
+		mux.Lock()
+		defer mux.Unlock()
+		if err != nil {
+			failed = err
+			return
+		}
+		for _, child := range children {
+			parents[child] = tag
+		}
+	})
+	if failed == nil && ctx.Err() != nil {
+		failed = ctx.Err()
+	}
+	if failed != nil {
+		p.logger.Errorf("[%s] keeping all %d tags to purge as the image indexes of the kept tags could not be fetched: %s", repo, len(purge), failed)
+		return append(keep, purge...), nil
+	}
+	if len(parents) == 0 {
+		return keep, purge
+	}
+
+	p.resolveDigests(repo, purge)
+	remaining := []string{}
+	for _, tag := range purge {
+		digest, err := p.resolveDigest(repo, tag)
+		if err != nil {
+			p.logger.Errorf("[%s] keeping tag %s failed to check whether a kept image index references it: %s", repo, tag, err)
+			keep = append(keep, tag)
+			continue
+		}
+		if parent, ok := parents[digest]; ok {
+			p.logger.Warnf("[%s] keeping tag %s which manifest %s is referenced by the image index of kept tag %s.", repo, tag, digest, parent)
+			keep = append(keep, tag)
+			continue
+		}
+		remaining = append(remaining, tag)
+	}
+	return keep, remaining
+}
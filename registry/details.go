@@ -0,0 +1,167 @@
+package registry
+
+import "time"
+
+// Sources of the tag creation dates of TagDetail.
+const (
+	// DateSourceV1 is the history of the manifest v1.
+	DateSourceV1 = "v1"
+	// DateSourceConfig is the created field of the image config blob.
+	DateSourceConfig = "config"
+	// DateSourceAnnotation is the org.opencontainers.image.created annotation of the manifest.
+	DateSourceAnnotation = "annotation"
+	// DateSourceUploaded is the Last-Modified header of the manifest with AgeUploaded.
+	DateSourceUploaded = "uploaded"
+	// DateSourceFirstSeen is when a run first saw the tag with AgeFirstSeen.
+	DateSourceFirstSeen = "first_seen"
+)
+
+// Decisions of TagDetail.
+const (
+	DecisionKeep  = "keep"
+	DecisionPurge = "purge"
+)
+
+// Reasons of the decisions of TagDetail, the last step of the run which kept or purged the tag.
+const (
+	// ReasonRetention is the tags rule matching the tag, by age, count or any retention strategy.
+	ReasonRetention = "retention"
+	// ReasonKeepRegex is the keep_regex of the repo rule.
+	ReasonKeepRegex = "keep_regex"
+	// ReasonUnmatched is the tag matching no tags rule, kept when there is no catch-all one.
+	ReasonUnmatched = "unmatched"
+	// ReasonDeleteAll is a delete-all rule, which keeps the tags when not confirmed.
+	ReasonDeleteAll = "delete_all"
+	// ReasonMinTags is the repo having fewer tags than MinTagsBeforePurge.
+	ReasonMinTags = "min_tags"
+	// ReasonUnprocessed is the tag which could not be evaluated.
+	ReasonUnprocessed = "unprocessed"
+	// ReasonIncomplete is the repo which scan exceeded RepoMaxDuration or failed to list all its tags, left untouched.
+	ReasonIncomplete = "incomplete"
+	// ReasonArtifact is the artifact excluded by ExcludeArtifacts.
+	ReasonArtifact = "artifact"
+	// ReasonProtected is the tag protected by ProtectedTagsProvider.
+	ReasonProtected = "protected"
+	// ReasonInUse is the image in use returned by InUseProvider.
+	ReasonInUse = "in_use"
+	// ReasonLarge is the image larger than TagsKeepIfLargerThanBytes.
+	ReasonLarge = "large"
+	// ReasonIndexChild is the child of the image index of a kept tag, see IndexChildProtection.
+	ReasonIndexChild = "index_child"
+	// ReasonDeferred is the tag to purge deferred to the next runs by MaxDeletionsPerRepoPerRun.
+	ReasonDeferred = "deferred"
+	// ReasonAttested is the image having attestations, see KeepAttested.
+	ReasonAttested = "attested"
+	// ReasonCompanion is the companion tag following its subject, see DeleteCompanions.
+	ReasonCompanion = "companion"
+	// ReasonSharedManifest is the manifest shared with a kept tag or which could not be resolved.
+	ReasonSharedManifest = "shared_manifest"
+	// ReasonCrossRepo is the manifest referenced by another repo, see CrossRepoProtection.
+	ReasonCrossRepo = "cross_repo"
+)
+
+// TagDetail decision on a tag with its creation date and where it was taken from, see PurgeTagsOptions.TagDetails.
+// Created is zero and DateSource empty for the tags which were not dated, e.g. the unprocessed or unscanned ones.
+type TagDetail struct {
+	Tag        string    `json:"tag"`
+	Created    time.Time `json:"created"`
+	DateSource string    `json:"date_source"`
+	Decision   string    `json:"decision"`
+	Reason     string    `json:"reason"`
+}
+
+// recordMoved record the reason of the tags which left the ones to purge, or joined them, at a step of the analysis.
+func recordMoved(reasons map[string]string, before, after []string, reason func(tag string) string) {
+	if reasons == nil {
+		return
+	}
+	was := map[string]bool{}
+	for _, tag := range before {
+		was[tag] = true
+	}
+	for _, tag := range after {
+		if !was[tag] {
+			reasons[tag] = reason(tag)
+		}
+		delete(was, tag)
+	}
+	for tag := range was {
+		reasons[tag] = reason(tag)
+	}
+}
+
+// constReason return the reason of every tag moved by a step of the analysis.
+func constReason(reason string) func(tag string) string {
+	return func(string) string { return reason }
+}
+
+// subjectReason return the reason of the tags moved by followSubjects.
+func subjectReason(tag string) string {
+	if _, _, ok := companionSubject(tag); ok {
+		return ReasonCompanion
+	}
+	return ReasonAttested
+}
+
+// analysisReasons return the reasons of the decisions of analyzeRepo on the tags.
+func (p *purger) analysisReasons(repo string, keep, purge []string) map[string]string {
+	rule := matchRepoRule(p.rules, repo)
+	reasons := map[string]string{}
+	for _, tag := range append(append([]string{}, keep...), purge...) {
+		_, _, companion := companionSubject(tag)
+		switch {
+		case p.protectedTags[tag] || p.headOf(tag) != "":
+			reasons[tag] = ReasonProtected
+		case companion && p.opts.DeleteCompanions:
+			reasons[tag] = ReasonCompanion
+		case rule.keep != nil && rule.keep.FindStringIndex(tag) != nil:
+			reasons[tag] = ReasonKeepRegex
+		case rule.deleteAll:
+			reasons[tag] = ReasonDeleteAll
+		case rule.matchTag(tag) < 0 && p.unmatched == nil:
+			reasons[tag] = ReasonUnmatched
+		default:
+			reasons[tag] = ReasonRetention
+		}
+	}
+	return reasons
+}
+
+// tagDetails return the details of the tags of the scan decided to keep or purge for the reasons.
+func tagDetails(scan *repoScan, purge []string, reasons map[string]string) []TagDetail {
+	purged := map[string]bool{}
+	for _, tag := range purge {
+		purged[tag] = true
+	}
+	detail := func(tag string, created time.Time, source string) TagDetail {
+		d := TagDetail{Tag: tag, Created: created, DateSource: source, Decision: DecisionKeep, Reason: reasons[tag]}
+		if purged[tag] {
+			d.Decision = DecisionPurge
+		}
+		return d
+	}
+	details := make([]TagDetail, 0, len(scan.tags)+len(scan.unprocessed)+len(scan.artifacts))
+	for _, t := range scan.tags {
+		details = append(details, detail(t.name, t.created, t.source))
+	}
+	for _, tag := range scan.unprocessed {
+		details = append(details, detail(tag, time.Time{}, ""))
+	}
+	for _, tag := range append(append([]string{}, scan.artifacts...), scan.unscanned...) {
+		details = append(details, detail(tag, time.Time{}, ""))
+	}
+	return details
+}
+
+// keptAcrossRepos update the details of the tags of the repo kept as referenced by other repos.
+func (r *RepoSummary) keptAcrossRepos(keep []string) {
+	kept := map[string]bool{}
+	for _, tag := range keep {
+		kept[tag] = true
+	}
+	for i := range r.Tags {
+		if d := &r.Tags[i]; d.Decision == DecisionPurge && kept[d.Tag] {
+			d.Decision, d.Reason = DecisionKeep, ReasonCrossRepo
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// PurgeFirstSeen when the runs first saw the tags, by "repo:tag", for AgeFirstSeen.
+type PurgeFirstSeen struct {
+	Updated time.Time            `json:"updated"`
+	Tags    map[string]FirstSeen `json:"tags"`
+}
+
+// FirstSeen when a run first saw the tag referencing the manifest digest.
+type FirstSeen struct {
+	Digest string    `json:"digest"`
+	First  time.Time `json:"first"`
+}
+
+// loadFirstSeen read the first seen times from the file, none if the file does not exist.
+func loadFirstSeen(path string) (*PurgeFirstSeen, error) {
+	firstSeen := &PurgeFirstSeen{Tags: map[string]FirstSeen{}}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return firstSeen, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error reading purge first seen file: %s", err)
+	}
+	if err := json.Unmarshal(data, firstSeen); err != nil {
+		return nil, fmt.Errorf("Error parsing purge first seen file %s: %s", path, err)
+	}
+	if firstSeen.Tags == nil {
+		firstSeen.Tags = map[string]FirstSeen{}
+	}
+	return firstSeen, nil
+}
+
+// save write the first seen times to the file.
+func (f *PurgeFirstSeen) save(path string) error {
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("Error writing purge first seen file: %s", err)
+	}
+	return nil
+}
+
+// firstSeenAt return when the tag was first seen referencing the digest, now if it was never seen or referenced
+// another digest then, e.g. re-pushed, recording it as seen by this run.
+func (p *purger) firstSeenAt(repo, tag, digest string) time.Time {
+	key := repo + ":" + tag
+	p.seenMux.Lock()
+	defer p.seenMux.Unlock()
+	seen, ok := p.firstSeen.Tags[key]
+	if !ok || seen.Digest != digest {
+		seen = FirstSeen{Digest: digest, First: p.clock.now}
+	}
+	p.seen[key] = seen
+	return seen.First
+}
+
+// saveFirstSeen replace the first seen times of the repos fully scanned by the run with the tags it saw, so the
+// tags which no longer exist are pruned, keeping the ones of the other repos, e.g. out of the namespaces.
+func (p *purger) saveFirstSeen() {
+	p.seenMux.Lock()
+	defer p.seenMux.Unlock()
+	for key, seen := range p.firstSeen.Tags {
+		if _, ok := p.seen[key]; !ok && !p.seenRepos[strings.SplitN(key, ":", 2)[0]] {
+			p.seen[key] = seen
+		}
+	}
+	firstSeen := &PurgeFirstSeen{Updated: p.clock.now, Tags: p.seen}
+	if err := firstSeen.save(p.opts.FirstSeenFile); err != nil {
+		p.logger.Error(err)
+		p.summary.addError(err)
+	}
+}
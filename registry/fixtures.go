@@ -0,0 +1,119 @@
+package registry
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hhkbp2/go-logging"
+	"github.com/parnurzeal/gorequest"
+)
+
+// fixtureHeaders response headers kept in the fixtures, credentials and tokens are never recorded.
+var fixtureHeaders = []string{"Content-Type", "Docker-Content-Digest", "Link"}
+
+// fixture recorded registry response to a request.
+type fixture struct {
+	Method string            `json:"method"`
+	URI    string            `json:"uri"`
+	Accept string            `json:"accept"`
+	Status int               `json:"status"`
+	Header map[string]string `json:"header"`
+	Body   string            `json:"body"`
+}
+
+// fixtures directory of the recorded registry responses, one JSON file per distinct request.
+type fixtures struct {
+	dir    string
+	replay bool
+	logger logging.Logger
+}
+
+// path return the fixture file path of the request on the uri relative to the registry URL.
+func (f *fixtures) path(method, uri, accept string) string {
+	return filepath.Join(f.dir, fmt.Sprintf("%x.json", sha256.Sum256([]byte(method+" "+uri+" "+accept))))
+}
+
+// save record the response to the request.
+func (f *fixtures) save(uri string, request *gorequest.SuperAgent, resp gorequest.Response, data string) {
+	fx := fixture{
+		Method: request.Method, URI: uri, Accept: request.Header["Accept"],
+		Status: resp.StatusCode, Header: map[string]string{}, Body: data,
+	}
+	for _, h := range fixtureHeaders {
+		if v := resp.Header.Get(h); v != "" {
+			fx.Header[h] = v
+		}
+	}
+	content, err := json.MarshalIndent(fx, "", "  ")
+	if err == nil {
+		err = ioutil.WriteFile(f.path(fx.Method, uri, fx.Accept), content, 0644)
+	}
+	if err != nil {
+		f.logger.Errorf("Error recording fixture of %s %s: %s", fx.Method, uri, err)
+	}
+}
+
+// load return the recorded response to the request, 404 if it was not recorded.
+func (f *fixtures) load(uri string, request *gorequest.SuperAgent) (gorequest.Response, string, []error) {
+	fx := fixture{Status: http.StatusNotFound}
+	content, err := ioutil.ReadFile(f.path(request.Method, uri, request.Header["Accept"]))
+	if err == nil {
+		err = json.Unmarshal(content, &fx)
+	}
+	if err != nil {
+		f.logger.Warnf("No fixture for %s %s: %s", request.Method, uri, err)
+	}
+
+	resp := &http.Response{
+		Status:     fmt.Sprintf("%d %s", fx.Status, http.StatusText(fx.Status)),
+		StatusCode: fx.Status,
+		Header:     http.Header{},
+		Body:       ioutil.NopCloser(bytes.NewBufferString(fx.Body)),
+		Request:    &http.Request{Method: request.Method},
+	}
+	for h, v := range fx.Header {
+		resp.Header.Set(h, v)
+	}
+	return resp, fx.Body, nil
+}
+
+// RecordFixtures record the registry responses into the directory to replay them later with NewReplayClient,
+// e.g. to iterate on the retention rules offline on dry-run.
+func (c *Client) RecordFixtures(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("Error creating fixtures dir: %s", err)
+	}
+	c.fixtures = &fixtures{dir: dir, logger: c.logger}
+	return nil
+}
+
+// NewReplayClient initialize Client serving the registry responses recorded with RecordFixtures
+// without any network access, requests not recorded get 404.
+func NewReplayClient(dir string) *Client {
+	c := &Client{
+		url:       "http://replay",
+		logger:    SetupLogging("registry.client"),
+		tokens:    map[string]string{},
+		repos:     map[string][]string{},
+		tagCounts: map[string]int{},
+
+		anonTokens: map[string]string{},
+		denied:     map[string]bool{},
+
+		configCache: map[string]*ImageConfig{},
+	}
+	c.fixtures = &fixtures{dir: dir, replay: true, logger: c.logger}
+	return c
+}
+
+// fixtureURI return the request URI relative to the registry URL.
+func (c *Client) fixtureURI(request *gorequest.SuperAgent) string {
+	return strings.TrimPrefix(request.Url, c.url)
+}
@@ -0,0 +1,47 @@
+package registry
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestFixtures(t *testing.T) {
+	now := time.Now().UTC()
+	f, server := newFakeRegistry(map[string]map[string]time.Time{
+		"app":    {"v1": now.Add(-30 * 24 * time.Hour), "v2": now.Add(-20 * 24 * time.Hour), "v3": now},
+		"team/x": {"v1": now.Add(-40 * 24 * time.Hour), "v2": now},
+	})
+	f.pageSize = 1
+	dir, err := ioutil.TempDir("", "fixtures")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	opts := PurgeTagsOptions{DryRun: true, KeepDays: 7, KeepCount: 1}
+
+	var recorded *PurgeSummary
+	convey.Convey("Record registry responses", t, func() {
+		client := NewClient(server.URL, false, "", "")
+		convey.So(client.RecordFixtures(dir), convey.ShouldBeNil)
+		recorded = PurgeOldTags(context.Background(), client, opts)
+		convey.So(recorded.TagsToPurge(), convey.ShouldEqual, 3)
+		files, _ := ioutil.ReadDir(dir)
+		convey.So(files, convey.ShouldNotBeEmpty)
+	})
+	server.Close()
+
+	convey.Convey("Replay recorded responses without the registry", t, func() {
+		client := NewReplayClient(dir)
+		replayed := PurgeOldTags(context.Background(), client, opts)
+		convey.So(replayed.Errors, convey.ShouldBeEmpty)
+		convey.So(replayed.Repos, convey.ShouldResemble, recorded.Repos)
+
+		convey.So(client.Tags("missing"), convey.ShouldBeEmpty)
+		convey.So(client.DeleteTag("app", "v1"), convey.ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,46 @@
+//go:build go1.18
+// +build go1.18
+
+package registry
+
+import (
+	"testing"
+	"time"
+)
+
+// Fuzz targets need Go 1.18, run them with e.g. go test -fuzz FuzzSelectTags ./registry
+
+func FuzzManifestV1Created(f *testing.F) {
+	f.Add(`{"history": [{"v1Compatibility": "{\"created\": \"2019-07-01T12:00:00.123Z\"}"}]}`)
+	f.Add(`{"history": [{"v1Compatibility": "{\"created\": \"yesterday\"}"}]}`)
+	f.Add(`{"history": [{"v1Compatibility": 42}]}`)
+	f.Add(`{"history": []}`)
+	f.Add(`{"history": [`)
+	f.Fuzz(func(t *testing.T, manifest string) {
+		manifestV1Created(manifest)
+	})
+}
+
+func FuzzSelectTags(f *testing.F) {
+	f.Add("^library/", "^release-", "^keep-", "library/app", "release-1", false, false)
+	f.Add("prod", "(?i)LATEST", "", "non-prod-app", "latest", true, true)
+	f.Add("[", "*", "(", "", "", false, false)
+	f.Add(".*", "^$", "a{1000}", "\x00", "\xff\xfe", true, false)
+	now := time.Now().UTC()
+	f.Fuzz(func(t *testing.T, repoRegex, tagsRegex, keepRegex, repo, tag string, anchor, caseInsensitive bool) {
+		configs := []PurgeConfig{{
+			RepoRegex: repoRegex, KeepRegex: keepRegex, CaseInsensitive: caseInsensitive,
+			Tags: []TagConfig{{TagsRegex: tagsRegex, KeepDays: 1, CaseInsensitive: caseInsensitive}},
+		}}
+		rules, err := compileRules(PurgeTagsOptions{Configs: configs, KeepDays: 7, AnchorMatch: anchor})
+		if err != nil {
+			return
+		}
+		unmatched := &rules[len(rules)-1].tags[0]
+		// Skipped tags are reported in addition to being kept or purged.
+		keep, purge, _ := matchRepoRule(rules, repo).selectTags(timeSlice{daysAgo(now, tag, 3)}, clock{now: now}, unmatched)
+		if len(keep)+len(purge) != 1 {
+			t.Fatalf("tag %q selected %d times: keep %v, purge %v", tag, len(keep)+len(purge), keep, purge)
+		}
+	})
+}
@@ -0,0 +1,96 @@
+package registry
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// GitLabPolicy cleanup policy in the shape of the GitLab Container Registry ones, translated into a PurgeConfig
+// so existing policies can be pasted as is. Unlike PurgeConfig regexes, the name regexes match the whole tag.
+type GitLabPolicy struct {
+	// RepoRegex selects the repos the policy applies to, as GitLab ones apply to the repos of a project.
+	RepoRegex string `yaml:"repo_regex"`
+	// KeepN keeps the newest tags matching NameRegexDelete and not NameRegexKeep, 0 keeps none of them.
+	KeepN int `yaml:"keep_n"`
+	// OlderThan purges only the tags older than that, e.g. "7d", "14 days", "2w" or "1 month" of 30 days.
+	// Tags of any age are purged when empty.
+	OlderThan       string `yaml:"older_than"`
+	NameRegexDelete string `yaml:"name_regex_delete"`
+	// NameRegex is the deprecated name of NameRegexDelete.
+	NameRegex     string `yaml:"name_regex"`
+	NameRegexKeep string `yaml:"name_regex_keep"`
+}
+
+var olderThanRegexp = regexp.MustCompile(`^(\d+)\s*(d|days?|w|weeks?|months?)$`)
+
+// olderThanDays parse the GitLab older_than duration into days.
+func olderThanDays(olderThan string) (int, error) {
+	m := olderThanRegexp.FindStringSubmatch(olderThan)
+	if m == nil {
+		return 0, fmt.Errorf("invalid older_than %q", olderThan)
+	}
+	n, _ := strconv.Atoi(m[1])
+	switch m[2][0] {
+	case 'w':
+		n = n * 7
+	case 'm':
+		n = n * 30
+	}
+	return n, nil
+}
+
+// PurgeConfig translate the policy into the equivalent purge config. The tag "latest" is always kept like
+// GitLab does and the tags not matching NameRegexDelete follow PurgeTagsOptions.UnmatchedTagPolicy.
+func (p GitLabPolicy) PurgeConfig() (PurgeConfig, error) {
+	deleteRegex := p.NameRegexDelete
+	if deleteRegex == "" {
+		deleteRegex = p.NameRegex
+	}
+	if deleteRegex == "" {
+		return PurgeConfig{}, fmt.Errorf("name_regex_delete is required, GitLab deletes nothing without it")
+	}
+	if p.KeepN < 0 {
+		return PurgeConfig{}, fmt.Errorf("negative keep_n %d", p.KeepN)
+	}
+	// Negative days purge tags of any age, as filterTags purges the ones older than that.
+	keepDays := -1
+	if p.OlderThan != "" {
+		days, err := olderThanDays(p.OlderThan)
+		if err != nil {
+			return PurgeConfig{}, err
+		}
+		keepDays = days
+	}
+	for _, r := range []string{deleteRegex, p.NameRegexKeep} {
+		if _, err := regexp.Compile(r); err != nil {
+			return PurgeConfig{}, fmt.Errorf("invalid name regex %q: %s", r, err)
+		}
+	}
+	keepRegex := "^latest$"
+	if p.NameRegexKeep != "" {
+		keepRegex = keepRegex + "|^(?:" + p.NameRegexKeep + ")$"
+	}
+	repoRegex := p.RepoRegex
+	if repoRegex == "" {
+		repoRegex = ".*"
+	}
+	return PurgeConfig{
+		RepoRegex: repoRegex,
+		KeepRegex: keepRegex,
+		Tags:      []TagConfig{{TagsRegex: "^(?:" + deleteRegex + ")$", KeepDays: keepDays, KeepCount: p.KeepN}},
+	}, nil
+}
+
+// GitLabPurgeConfigs translate the GitLab policies into purge configs in the same order.
+func GitLabPurgeConfigs(policies []GitLabPolicy) ([]PurgeConfig, error) {
+	configs := []PurgeConfig{}
+	for i, p := range policies {
+		c, err := p.PurgeConfig()
+		if err != nil {
+			return nil, fmt.Errorf("GitLab policy %d: %s", i+1, err)
+		}
+		configs = append(configs, c)
+	}
+	return configs, nil
+}
@@ -0,0 +1,60 @@
+package registry
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestGitLabPolicy(t *testing.T) {
+	convey.Convey("Translate the policy into a purge config", t, func() {
+		c, err := GitLabPolicy{KeepN: 5, OlderThan: "14d", NameRegexDelete: ".*", NameRegexKeep: "v.+"}.PurgeConfig()
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(c, convey.ShouldResemble, PurgeConfig{
+			RepoRegex: ".*",
+			KeepRegex: "^latest$|^(?:v.+)$",
+			Tags:      []TagConfig{{TagsRegex: "^(?:.*)$", KeepDays: 14, KeepCount: 5}},
+		})
+	})
+
+	convey.Convey("Parse the older_than durations", t, func() {
+		for olderThan, days := range map[string]int{"7d": 7, "14 days": 14, "1 day": 1, "2w": 14, "1 month": 30, "3months": 90} {
+			d, err := olderThanDays(olderThan)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(d, convey.ShouldEqual, days)
+		}
+		_, err := olderThanDays("1y")
+		convey.So(err, convey.ShouldNotBeNil)
+	})
+
+	convey.Convey("Reject policies GitLab would not delete anything with", t, func() {
+		_, err := GitLabPurgeConfigs([]GitLabPolicy{{NameRegex: ".*"}, {KeepN: 1}})
+		convey.So(err.Error(), convey.ShouldStartWith, "GitLab policy 2: name_regex_delete is required")
+		_, err = GitLabPolicy{NameRegexDelete: "("}.PurgeConfig()
+		convey.So(err, convey.ShouldNotBeNil)
+	})
+
+	convey.Convey("Purge like GitLab does", t, func() {
+		now := time.Now()
+		f, server := newFakeRegistry(map[string]map[string]time.Time{"app": {
+			"latest":   now.Add(-40 * 24 * time.Hour),
+			"v1":       now.Add(-39 * 24 * time.Hour),
+			"dev-1":    now.Add(-38 * 24 * time.Hour),
+			"dev-2":    now.Add(-37 * 24 * time.Hour),
+			"dev-3":    now.Add(-36 * 24 * time.Hour),
+			"dev-4":    now.Add(-1 * time.Hour),
+			"feature1": now.Add(-35 * 24 * time.Hour),
+		}})
+		defer server.Close()
+		configs, err := GitLabPurgeConfigs([]GitLabPolicy{{KeepN: 1, OlderThan: "30d", NameRegexDelete: "dev-.*|feature", NameRegexKeep: "v1"}})
+		convey.So(err, convey.ShouldBeNil)
+		opts := PurgeTagsOptions{Configs: configs, DeleteWorkers: 1, DrainTimeout: time.Second}
+		PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), opts)
+		sort.Strings(f.deleted)
+		// dev-4 is the newest one kept, feature1 only matches partially so it follows the unmatched tag policy.
+		convey.So(f.deleted, convey.ShouldResemble, []string{"app:dev-1", "app:dev-2", "app:dev-3"})
+	})
+}
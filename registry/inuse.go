@@ -0,0 +1,195 @@
+package registry
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// InUseProvider return the references of the images in use, e.g. by running containers, as host/repo:tag,
+// host/repo@digest or host/repo:tag@digest, or bare digests protecting the manifest in any repo.
+// The images of other registries than the purged one are ignored.
+type InUseProvider func() ([]string, error)
+
+var digestRegexp = regexp.MustCompile(`^[a-z0-9]+(?:[.+_-][a-z0-9]+)*:[a-zA-Z0-9=_-]{32,}$`)
+
+// NewFileProvider sample InUseProvider reading the references, one per line, from the file or from stdin
+// for "-", e.g. the digests in use computed by an inventory system. Empty lines and the ones starting with #
+// are skipped. Stdin is read by the first call only.
+func NewFileProvider(path string) InUseProvider {
+	var stdin []string
+	return func() ([]string, error) {
+		if path != "-" {
+			f, err := os.Open(path)
+			if err != nil {
+				return nil, fmt.Errorf("Error reading images in use: %s", err)
+			}
+			defer f.Close()
+			return readRefs(f)
+		}
+		if stdin == nil {
+			refs, err := readRefs(os.Stdin)
+			if err != nil {
+				return nil, err
+			}
+			stdin = refs
+		}
+		return stdin, nil
+	}
+}
+
+// readRefs read the references of the lines, skipping empty ones and comments.
+func readRefs(r io.Reader) ([]string, error) {
+	refs := []string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			refs = append(refs, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Error reading images in use: %s", err)
+	}
+	return refs, nil
+}
+
+// NewKubernetesProvider sample InUseProvider listing the images of the pods of the Kubernetes clusters
+// of the kubeconfig contexts, or of the current context if none given, with kubectl CLI which has to be installed
+// and allowed to list pods in all namespaces. Both the images of the pod specs and the digests they run are returned.
+func NewKubernetesProvider(contexts []string) InUseProvider {
+	if len(contexts) == 0 {
+		contexts = []string{""}
+	}
+	return func() ([]string, error) {
+		refs := []string{}
+		for _, kubeContext := range contexts {
+			args := []string{"get", "pods", "--all-namespaces", "--output", "json"}
+			if kubeContext != "" {
+				args = append([]string{"--context", kubeContext}, args...)
+			}
+			out, err := exec.Command("kubectl", args...).Output()
+			if err != nil {
+				return nil, fmt.Errorf("Error listing pods of kubernetes context %q with kubectl: %s", kubeContext, err)
+			}
+			refs = append(refs, podImages(out)...)
+		}
+		return refs, nil
+	}
+}
+
+// podImages return the images of the containers of the pod list and the digests they run as reported
+// by the container statuses, e.g. "docker-pullable://host/repo@sha256:...".
+func podImages(pods []byte) []string {
+	refs := []string{}
+	for _, path := range []string{
+		"items.#.spec.containers.#.image", "items.#.spec.initContainers.#.image", "items.#.spec.ephemeralContainers.#.image",
+		"items.#.status.containerStatuses.#.imageID", "items.#.status.initContainerStatuses.#.imageID",
+	} {
+		for _, pod := range gjson.GetBytes(pods, path).Array() {
+			for _, image := range pod.Array() {
+				ref := image.String()
+				if i := strings.Index(ref, "://"); i >= 0 {
+					ref = ref[i+3:]
+				}
+				if ref != "" {
+					refs = append(refs, ref)
+				}
+			}
+		}
+	}
+	return refs
+}
+
+// parseImageRef split the image reference into its repo, tag and digest if it belongs to the registry host,
+// either of tag and digest may be empty.
+func parseImageRef(ref, registryHost string) (repo, tag, digest string, ok bool) {
+	if !strings.HasPrefix(ref, registryHost+"/") {
+		return "", "", "", false
+	}
+	repo = strings.TrimPrefix(ref, registryHost+"/")
+	if i := strings.Index(repo, "@"); i >= 0 {
+		repo, digest = repo[:i], repo[i+1:]
+	}
+	// The tag follows the last colon of the last path component, the host port is already stripped.
+	if i := strings.LastIndex(repo, ":"); i > strings.LastIndex(repo, "/") {
+		repo, tag = repo[:i], repo[i+1:]
+	}
+	return repo, tag, digest, repo != ""
+}
+
+// inUse images in use of the purged registry by "repo:tag" and "repo@digest", bare digests by "@digest".
+type inUse map[string]bool
+
+// newInUse collect the images of the references belonging to the registry host and the bare digests.
+func newInUse(refs []string, registryHost string) inUse {
+	images := inUse{}
+	for _, ref := range refs {
+		if digestRegexp.MatchString(ref) {
+			images["@"+ref] = true
+			continue
+		}
+		repo, tag, digest, ok := parseImageRef(ref, registryHost)
+		if !ok {
+			continue
+		}
+		if tag != "" {
+			images[repo+":"+tag] = true
+		}
+		if digest != "" {
+			images[repo+"@"+digest] = true
+		}
+	}
+	return images
+}
+
+// hasDigests check whether any image of the repo is in use by digest.
+func (u inUse) hasDigests(repo string) bool {
+	for ref := range u {
+		if strings.HasPrefix(ref, repo+"@") || strings.HasPrefix(ref, "@") {
+			return true
+		}
+	}
+	return false
+}
+
+// keepInUse move the tags to purge of the images in use by tag or digest to the ones to keep.
+func (p *purger) keepInUse(repo string, keep, purge []string) ([]string, []string) {
+	if len(p.inUse) == 0 || len(purge) == 0 {
+		return keep, purge
+	}
+	byDigest := p.inUse.hasDigests(repo)
+	if byDigest {
+		p.resolveDigests(repo, purge)
+	}
+	remaining := []string{}
+	protected := []string{}
+	for _, tag := range purge {
+		used := p.inUse[repo+":"+tag]
+		if !used && byDigest {
+			digest, err := p.resolveDigest(repo, tag)
+			if err != nil {
+				p.logger.Errorf("[%s] keeping tag %s failed to check whether it is in use: %s", repo, tag, err)
+				keep = append(keep, tag)
+				continue
+			}
+			used = p.inUse[repo+"@"+digest] || p.inUse["@"+digest]
+		}
+		if used {
+			protected = append(protected, tag)
+		} else {
+			remaining = append(remaining, tag)
+		}
+	}
+	if len(protected) > 0 {
+		p.logger.Infof("[%s] keeping %d tags in use: %v", repo, len(protected), protected)
+		p.inUseKept = p.inUseKept + len(protected)
+	}
+	return append(keep, protected...), remaining
+}
@@ -0,0 +1,59 @@
+package registry
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseImageRef(t *testing.T) {
+	convey.Convey("Split references of the registry", t, func() {
+		for ref, expected := range map[string][]string{
+			"registry.local:5000/app:v1":                     {"app", "v1", ""},
+			"registry.local:5000/team/app@sha256:abc":        {"team/app", "", "sha256:abc"},
+			"registry.local:5000/team/app:v1@sha256:abc":     {"team/app", "v1", "sha256:abc"},
+			"registry.local:5000/team/app":                   {"team/app", "", ""},
+			"registry.local:5000/team.v2/app:latest@sha256:": {"team.v2/app", "latest", "sha256:"},
+		} {
+			repo, tag, digest, ok := parseImageRef(ref, "registry.local:5000")
+			convey.So(ok, convey.ShouldBeTrue)
+			convey.So([]string{repo, tag, digest}, convey.ShouldResemble, expected)
+		}
+	})
+
+	convey.Convey("Ignore references of other registries", t, func() {
+		for _, ref := range []string{"nginx:1.17", "registry.local/app:v1", "registry.local:5000.evil/app:v1"} {
+			_, _, _, ok := parseImageRef(ref, "registry.local:5000")
+			convey.So(ok, convey.ShouldBeFalse)
+		}
+	})
+}
+
+func TestPodImages(t *testing.T) {
+	pods := `{"items": [
+		{"spec": {"containers": [{"image": "registry.local/app:v1"}, {"image": "nginx"}], "initContainers": [{"image": "registry.local/init:v2"}]},
+		 "status": {"containerStatuses": [{"imageID": "docker-pullable://registry.local/app@sha256:abc"}, {"imageID": ""}]}},
+		{"spec": {"containers": [{"image": "registry.local/api:v3"}]}}
+	]}`
+
+	convey.Convey("List the images of the pods and the digests they run", t, func() {
+		convey.So(podImages([]byte(pods)), convey.ShouldResemble, []string{
+			"registry.local/app:v1", "nginx", "registry.local/api:v3", "registry.local/init:v2", "registry.local/app@sha256:abc",
+		})
+	})
+}
+
+func TestReadRefs(t *testing.T) {
+	convey.Convey("Read the references skipping empty lines and comments", t, func() {
+		refs, err := readRefs(strings.NewReader("# in use\nsha256:0123456789abcdef0123456789abcdef\n\n  registry.local/app:v1  \n"))
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(refs, convey.ShouldResemble, []string{"sha256:0123456789abcdef0123456789abcdef", "registry.local/app:v1"})
+	})
+
+	convey.Convey("Keep bare digests for any repo", t, func() {
+		used := newInUse([]string{"sha256:0123456789abcdef0123456789abcdef", "registry.local/app:v1"}, "registry.local")
+		convey.So(used, convey.ShouldResemble, inUse{"@sha256:0123456789abcdef0123456789abcdef": true, "app:v1": true})
+		convey.So(used.hasDigests("api"), convey.ShouldBeTrue)
+	})
+}
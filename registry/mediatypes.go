@@ -0,0 +1,42 @@
+package registry
+
+import "github.com/tidwall/gjson"
+
+// Media types of manifests and image configs.
+const (
+	MediaTypeManifestV1       = "application/vnd.docker.distribution.manifest.v1+json"
+	MediaTypeManifestV1Signed = "application/vnd.docker.distribution.manifest.v1+prettyjws"
+	MediaTypeManifestV2       = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeManifestList     = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeOCIManifest      = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeOCIIndex         = "application/vnd.oci.image.index.v1+json"
+	MediaTypeOCIArtifact      = "application/vnd.oci.artifact.manifest.v1+json"
+	MediaTypeImageConfig      = "application/vnd.docker.container.image.v1+json"
+	MediaTypeOCIImageConfig   = "application/vnd.oci.image.config.v1+json"
+)
+
+// ManifestMediaType return the media type of the manifest, inferred from its content when not set
+// as OCI does not require it, or empty string if unknown.
+func ManifestMediaType(manifest string) string {
+	if t := gjson.Get(manifest, "mediaType").String(); t != "" {
+		return t
+	}
+	switch {
+	case gjson.Get(manifest, "schemaVersion").Int() == 1 && gjson.Get(manifest, "signatures").Exists():
+		return MediaTypeManifestV1Signed
+	case gjson.Get(manifest, "schemaVersion").Int() == 1:
+		return MediaTypeManifestV1
+	case gjson.Get(manifest, "manifests").Exists():
+		return MediaTypeOCIIndex
+	case gjson.Get(manifest, "layers").Exists():
+		return MediaTypeOCIManifest
+	case gjson.Get(manifest, "blobs").Exists():
+		return MediaTypeOCIArtifact
+	}
+	return ""
+}
+
+// IsManifestIndex check whether the media type is of a manifest list or an image index, e.g. of multi-arch images.
+func IsManifestIndex(mediaType string) bool {
+	return mediaType == MediaTypeManifestList || mediaType == MediaTypeOCIIndex
+}
@@ -0,0 +1,31 @@
+package registry
+
+import (
+	"testing"
+
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestManifestMediaType(t *testing.T) {
+	convey.Convey("Return the declared media type", t, func() {
+		convey.So(ManifestMediaType(`{"schemaVersion": 2, "mediaType": "`+MediaTypeManifestList+`", "manifests": []}`),
+			convey.ShouldEqual, MediaTypeManifestList)
+		convey.So(ManifestMediaType(`{"schemaVersion": 2, "mediaType": "`+MediaTypeManifestV2+`", "layers": []}`),
+			convey.ShouldEqual, MediaTypeManifestV2)
+	})
+
+	convey.Convey("Infer the media type from the content", t, func() {
+		convey.So(ManifestMediaType(`{"schemaVersion": 1, "history": []}`), convey.ShouldEqual, MediaTypeManifestV1)
+		convey.So(ManifestMediaType(`{"schemaVersion": 1, "signatures": []}`), convey.ShouldEqual, MediaTypeManifestV1Signed)
+		convey.So(ManifestMediaType(`{"schemaVersion": 2, "manifests": []}`), convey.ShouldEqual, MediaTypeOCIIndex)
+		convey.So(ManifestMediaType(`{"schemaVersion": 2, "layers": []}`), convey.ShouldEqual, MediaTypeOCIManifest)
+		convey.So(ManifestMediaType(`{"blobs": []}`), convey.ShouldEqual, MediaTypeOCIArtifact)
+		convey.So(ManifestMediaType(`{}`), convey.ShouldBeEmpty)
+	})
+
+	convey.Convey("Classify manifest lists and image indexes", t, func() {
+		convey.So(IsManifestIndex(MediaTypeManifestList), convey.ShouldBeTrue)
+		convey.So(IsManifestIndex(MediaTypeOCIIndex), convey.ShouldBeTrue)
+		convey.So(IsManifestIndex(MediaTypeOCIManifest), convey.ShouldBeFalse)
+	})
+}
@@ -0,0 +1,134 @@
+package registry
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// metricsPrefix prefix of the purging run metric names.
+const metricsPrefix = "registry_ui_purge_"
+
+// writeMetric write the metric in Prometheus text exposition format.
+func writeMetric(b *bytes.Buffer, name, typ, help string, value float64) {
+	fmt.Fprintf(b, "# HELP %s%s %s\n", metricsPrefix, name, help)
+	fmt.Fprintf(b, "# TYPE %s%s %s\n", metricsPrefix, name, typ)
+	fmt.Fprintf(b, "%s%s %s\n", metricsPrefix, name, strconv.FormatFloat(value, 'f', -1, 64))
+}
+
+// Metrics render the run summary as metrics in Prometheus text exposition format.
+func (s *PurgeSummary) Metrics() string {
+	dryRun := 0.0
+	if s.DryRun {
+		dryRun = 1
+	}
+	aborted := 0.0
+	if s.Aborted {
+		aborted = 1
+	}
+	timedOut := 0.0
+	if s.TimedOut {
+		timedOut = 1
+	}
+	b := &bytes.Buffer{}
+	writeMetric(b, "last_run_timestamp_seconds", "gauge", "Time the purging run finished.", float64(s.Finished.Unix()))
+	writeMetric(b, "duration_seconds", "gauge", "Duration of the purging run.", s.Finished.Sub(s.Started).Seconds())
+	writeMetric(b, "dry_run", "gauge", "Whether the purging run was a dry-run.", dryRun)
+	writeMetric(b, "repos_scanned", "gauge", "Repositories scanned by the purging run.", float64(len(s.Repos)))
+	writeMetric(b, "repos_over_tag_count", "gauge", "Repositories having more tags than the warning threshold.", float64(s.ReposOverTagCount()))
+	writeMetric(b, "tags_to_purge", "gauge", "Tags selected for purging.", float64(s.TagsToPurge()))
+	writeMetric(b, "tags_unprocessed", "gauge", "Tags kept as they could not be evaluated.", float64(s.TagsUnprocessed()))
+	writeMetric(b, "tags_deleted", "gauge", "Tags deleted by the purging run.", float64(s.TagsDeleted))
+	writeMetric(b, "bytes_reclaimed", "gauge", "Bytes of layers referenced by the deleted tags.", float64(s.BytesReclaimed))
+	writeMetric(b, "aborted", "gauge", "Whether the purging run was aborted on a deletion error.", aborted)
+	writeMetric(b, "timed_out", "gauge", "Whether the purging run was stopped on exceeding its max duration.", timedOut)
+	writeMetric(b, "errors", "gauge", "Errors occurred during the purging run.", float64(len(s.Errors)))
+	fmt.Fprintf(b, "# HELP %srun_info Run ID of the purging run to correlate its metrics with its logs.\n", metricsPrefix)
+	fmt.Fprintf(b, "# TYPE %srun_info gauge\n", metricsPrefix)
+	fmt.Fprintf(b, "%srun_info{run_id=%q} 1\n", metricsPrefix, s.RunID)
+	fmt.Fprintf(b, "# HELP %sskipped Repositories or tags left out of the purging run by reason.\n", metricsPrefix)
+	fmt.Fprintf(b, "# TYPE %sskipped gauge\n", metricsPrefix)
+	for _, reason := range SortedMapKeys(s.Skipped) {
+		fmt.Fprintf(b, "%sskipped{reason=%q} %d\n", metricsPrefix, reason, s.Skipped[SkipReason(reason)])
+	}
+	return b.String()
+}
+
+// writeRepoMetric write the metric of every repo labeled by its name in Prometheus text exposition format.
+func writeRepoMetric(b *bytes.Buffer, name, help string, repos []RepoSummary, value func(RepoSummary) float64) {
+	fmt.Fprintf(b, "# HELP %s%s %s\n", metricsPrefix, name, help)
+	fmt.Fprintf(b, "# TYPE %s%s gauge\n", metricsPrefix, name)
+	for _, r := range repos {
+		fmt.Fprintf(b, "%s%s{repo=%q} %s\n", metricsPrefix, name, r.Repo, strconv.FormatFloat(value(r), 'f', -1, 64))
+	}
+}
+
+// RepoMetrics render the retention decisions of the run per repo as metrics in Prometheus text exposition format.
+func (s *PurgeSummary) RepoMetrics() string {
+	b := &bytes.Buffer{}
+	writeRepoMetric(b, "repo_tags_total", "Tags of the repository.", s.Repos, func(r RepoSummary) float64 {
+		return float64(r.TagsCount)
+	})
+	writeRepoMetric(b, "repo_tags_to_purge", "Tags of the repository selected for purging.", s.Repos, func(r RepoSummary) float64 {
+		return float64(len(r.Purge))
+	})
+	writeRepoMetric(b, "repo_bytes_reclaimable", "Bytes of layers referenced by the tags of the repository selected for purging.", s.Repos, func(r RepoSummary) float64 {
+		return float64(r.BytesToPurge)
+	})
+	return b.String()
+}
+
+// WriteMetricsFile write the run and per-repo metrics to the file for node-exporter textfile collector.
+// The file is replaced atomically by renaming a temporary one, so the collector never reads a half-written file.
+func WriteMetricsFile(path string, s *PurgeSummary) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return fmt.Errorf("Error writing metrics file: %s", err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.WriteString(s.Metrics() + s.RepoMetrics())
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		return fmt.Errorf("Error writing metrics file: %s", err)
+	}
+	return nil
+}
+
+// PushMetrics push the run metrics to Prometheus Pushgateway replacing the ones of the same job and instance,
+// so one-shot purging jobs feed the dashboards without being scraped.
+func PushMetrics(gatewayURL, job, instance string, s *PurgeSummary) error {
+	uri := fmt.Sprintf("%s/metrics/job/%s", strings.TrimRight(gatewayURL, "/"), url.PathEscape(job))
+	if instance != "" {
+		uri = fmt.Sprintf("%s/instance/%s", uri, url.PathEscape(instance))
+	}
+	req, err := http.NewRequest(http.MethodPut, uri, strings.NewReader(s.Metrics()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Error pushing metrics to %s: %s", uri, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("Error pushing metrics to %s: %s", uri, resp.Status)
+	}
+	return nil
+}
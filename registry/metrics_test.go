@@ -0,0 +1,79 @@
+package registry
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestPushMetrics(t *testing.T) {
+	var method, path, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, path = r.Method, r.URL.EscapedPath()
+		data, _ := ioutil.ReadAll(r.Body)
+		body = string(data)
+		if r.URL.Path == "/metrics/job/fail" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	now := time.Now().UTC()
+	summary := &PurgeSummary{
+		RunID: "ci-1234", Started: now.Add(-time.Minute), Finished: now, TagsDeleted: 3, BytesReclaimed: 2048,
+		Repos: []RepoSummary{{Repo: "app", Purge: []string{"a", "b", "c"}}},
+	}
+
+	convey.Convey("Push run metrics to pushgateway", t, func() {
+		err := PushMetrics(server.URL+"/", "registry purge", "ci-1", summary)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(method, convey.ShouldEqual, http.MethodPut)
+		convey.So(path, convey.ShouldEqual, "/metrics/job/registry%20purge/instance/ci-1")
+		convey.So(body, convey.ShouldContainSubstring, "registry_ui_purge_tags_deleted 3\n")
+		convey.So(body, convey.ShouldContainSubstring, "registry_ui_purge_tags_to_purge 3\n")
+		convey.So(body, convey.ShouldContainSubstring, "registry_ui_purge_bytes_reclaimed 2048\n")
+		convey.So(body, convey.ShouldContainSubstring, "registry_ui_purge_run_info{run_id=\"ci-1234\"} 1\n")
+		convey.So(body, convey.ShouldContainSubstring, "registry_ui_purge_duration_seconds 60\n")
+	})
+
+	convey.Convey("Fail on pushgateway error", t, func() {
+		err := PushMetrics(server.URL, "fail", "", summary)
+		convey.So(err, convey.ShouldNotBeNil)
+	})
+}
+
+func TestWriteMetricsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	summary := &PurgeSummary{
+		Finished: time.Now().UTC(),
+		Repos:    []RepoSummary{{Repo: "team/app", TagsCount: 5, Purge: []string{"a", "b"}, BytesToPurge: 2048}},
+	}
+
+	convey.Convey("Write run and per repo metrics replacing the file", t, func() {
+		path := filepath.Join(dir, "registry_ui_purge.prom")
+		convey.So(ioutil.WriteFile(path, []byte("old"), 0644), convey.ShouldBeNil)
+		convey.So(WriteMetricsFile(path, summary), convey.ShouldBeNil)
+		data, err := ioutil.ReadFile(path)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(string(data), convey.ShouldContainSubstring, "registry_ui_purge_tags_to_purge 2\n")
+		convey.So(string(data), convey.ShouldContainSubstring, `registry_ui_purge_repo_tags_total{repo="team/app"} 5`+"\n")
+		convey.So(string(data), convey.ShouldContainSubstring, `registry_ui_purge_repo_tags_to_purge{repo="team/app"} 2`+"\n")
+		convey.So(string(data), convey.ShouldContainSubstring, `registry_ui_purge_repo_bytes_reclaimable{repo="team/app"} 2048`+"\n")
+		files, _ := ioutil.ReadDir(dir)
+		convey.So(files, convey.ShouldHaveLength, 1)
+	})
+
+	convey.Convey("Fail on missing directory", t, func() {
+		convey.So(WriteMetricsFile(filepath.Join(dir, "missing", "registry_ui_purge.prom"), summary), convey.ShouldNotBeNil)
+	})
+}
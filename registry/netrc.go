@@ -0,0 +1,105 @@
+package registry
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultNetrcFile return the netrc file of $NETRC or ~/.netrc as curl and git do, empty if none can be determined.
+func DefaultNetrcFile() string {
+	if path := os.Getenv("NETRC"); path != "" {
+		return path
+	}
+	home := os.Getenv("HOME")
+	if home == "" {
+		return ""
+	}
+	return filepath.Join(home, ".netrc")
+}
+
+// NetrcCredentials look up the login and password of the host in the netrc file, with the "default" entry
+// as a fallback. The host is matched with its port first, then without it. A missing file has no credentials.
+func NetrcCredentials(path, host string) (login, password string, ok bool, err error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", "", false, nil
+	}
+	if err != nil {
+		return "", "", false, fmt.Errorf("Error reading netrc file: %s", err)
+	}
+	entries := parseNetrc(string(data))
+	candidates := []string{host}
+	if i := strings.LastIndex(host, ":"); i > 0 && !strings.HasSuffix(host, "]") {
+		candidates = append(candidates, host[:i])
+	}
+	candidates = append(candidates, "")
+	for _, machine := range candidates {
+		for _, e := range entries {
+			if e.machine == machine {
+				return e.login, e.password, true, nil
+			}
+		}
+	}
+	return "", "", false, nil
+}
+
+// netrcEntry credentials of a machine, the "default" entry has an empty machine.
+type netrcEntry struct {
+	machine  string
+	login    string
+	password string
+}
+
+// parseNetrc parse the entries of the netrc content, skipping macro definitions up to the next empty line.
+func parseNetrc(content string) []netrcEntry {
+	entries := []netrcEntry{}
+	current := -1
+	inMacro := false
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if inMacro {
+			inMacro = strings.TrimSpace(line) != ""
+			continue
+		}
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		tokens := strings.Fields(line)
+		for i := 0; i < len(tokens); i++ {
+			next := ""
+			if i+1 < len(tokens) {
+				next = tokens[i+1]
+			}
+			switch tokens[i] {
+			case "machine":
+				entries = append(entries, netrcEntry{machine: next})
+				current = len(entries) - 1
+				i++
+			case "default":
+				entries = append(entries, netrcEntry{})
+				current = len(entries) - 1
+			case "login":
+				if current >= 0 {
+					entries[current].login = next
+				}
+				i++
+			case "password":
+				if current >= 0 {
+					entries[current].password = next
+				}
+				i++
+			case "account":
+				i++
+			case "macdef":
+				inMacro = true
+				i = len(tokens)
+			}
+		}
+	}
+	return entries
+}
@@ -0,0 +1,63 @@
+package registry
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestNetrcCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netrc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, ".netrc")
+	content := `# registries
+machine registry.local:5000 login ci password secret1
+machine registry.local
+    login admin
+    password secret2
+
+macdef init
+machine evil.local login evil password evil
+
+machine mirror.local login mirror account ops password secret3
+default login anonymous password guest
+`
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	convey.Convey("Look up the credentials by host", t, func() {
+		for host, expected := range map[string][]string{
+			"registry.local:5000": {"ci", "secret1"},
+			"registry.local:443":  {"admin", "secret2"},
+			"registry.local":      {"admin", "secret2"},
+			"mirror.local":        {"mirror", "secret3"},
+			"evil.local":          {"anonymous", "guest"},
+			"other.local":         {"anonymous", "guest"},
+		} {
+			login, password, ok, err := NetrcCredentials(path, host)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(ok, convey.ShouldBeTrue)
+			convey.So([]string{login, password}, convey.ShouldResemble, expected)
+		}
+	})
+
+	convey.Convey("No credentials without a matching entry or file", t, func() {
+		if err := ioutil.WriteFile(path, []byte("machine registry.local login admin password secret\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		_, _, ok, err := NetrcCredentials(path, "other.local")
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(ok, convey.ShouldBeFalse)
+
+		_, _, ok, err = NetrcCredentials(filepath.Join(dir, "missing"), "registry.local")
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(ok, convey.ShouldBeFalse)
+	})
+}
@@ -0,0 +1,138 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/hhkbp2/go-logging"
+)
+
+// PurgePlan exact deletions selected by a dry-run to be reviewed and applied later.
+type PurgePlan struct {
+	Created   time.Time         `json:"created"`
+	Deletions []PlannedDeletion `json:"deletions"`
+}
+
+// PlannedDeletion tag to delete along with the manifest digest it referenced when planned.
+type PlannedDeletion struct {
+	Repo   string `json:"repo"`
+	Tag    string `json:"tag"`
+	Digest string `json:"digest"`
+}
+
+// Save write the plan to the file.
+func (p *PurgePlan) Save(path string) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("Error writing purge plan: %s", err)
+	}
+	return nil
+}
+
+// LoadPurgePlan read the plan from the file.
+func LoadPurgePlan(path string) (*PurgePlan, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading purge plan: %s", err)
+	}
+	plan := &PurgePlan{}
+	if err := json.Unmarshal(data, plan); err != nil {
+		return nil, fmt.Errorf("Error parsing purge plan %s: %s", path, err)
+	}
+	return plan, nil
+}
+
+// writePlan resolve the digests of the tags to purge and write them as the plan file.
+func (p *purger) writePlan(ctx context.Context, purgeTags map[string][]string) {
+	plan := &PurgePlan{Created: p.clock.now, Deletions: []PlannedDeletion{}}
+	for _, repo := range SortedMapKeys(purgeTags) {
+		p.resolveDigests(repo, purgeTags[repo])
+		for _, tag := range purgeTags[repo] {
+			if ctx.Err() != nil {
+				p.summary.addError(fmt.Errorf("planning cancelled: %s", ctx.Err()))
+				return
+			}
+			digest, err := p.resolveDigest(repo, tag)
+			if err != nil {
+				p.logger.Errorf("[%s] not planning tag %s: %s", repo, tag, err)
+				p.summary.addError(err)
+				continue
+			}
+			plan.Deletions = append(plan.Deletions, PlannedDeletion{Repo: repo, Tag: tag, Digest: digest})
+		}
+	}
+	if err := plan.Save(p.opts.PlanFile); err != nil {
+		p.logger.Error(err)
+		p.summary.addError(err)
+		return
+	}
+	p.logger.Infof("Purge plan of %d deletions written to %s.", len(plan.Deletions), p.opts.PlanFile)
+}
+
+// ApplyPurgePlan delete the tags of the plan which still reference the planned digest and return the summary of the run.
+// Tags re-pushed or deleted since the plan was written are kept. Retention rules are not evaluated, only DeleteWorkers,
+// DrainTimeout, FailFast, ReportSinks and RunID options apply.
+func ApplyPurgePlan(ctx context.Context, client *Client, plan *PurgePlan, opts PurgeTagsOptions) *PurgeSummary {
+	runID := newRunID(opts.RunID)
+	logger, closeLog := SetupRunLogging("registry.plan.ApplyPurgePlan", runID)
+	defer closeLog()
+	// Reduce client logging.
+	client.logger.SetLevel(logging.LevelError)
+
+	now := time.Now().UTC()
+	summary := &PurgeSummary{ID: now.Format("20060102-150405"), RunID: runID, Started: now, FailFast: opts.FailFast}
+	defer func() {
+		summary.Finished = time.Now().UTC()
+		publishReport(logger, opts.ReportSinks, summary)
+	}()
+	p := &purger{client: client, opts: opts, logger: logger, clock: clock{now: now}, summary: summary, digests: map[string]string{}}
+
+	keepTags := map[string][]string{}
+	purgeTags := map[string][]string{}
+	for _, d := range plan.Deletions {
+		if ctx.Err() != nil {
+			summary.addError(fmt.Errorf("purging cancelled: %s", ctx.Err()))
+			return summary
+		}
+		exists, digest, err := client.ManifestExists(d.Repo, d.Tag)
+		switch {
+		case err != nil:
+			logger.Errorf("[%s] %s", d.Repo, err)
+			summary.addError(err)
+			keepTags[d.Repo] = append(keepTags[d.Repo], d.Tag)
+		case !exists:
+			p.skip(SkipTagGone, 1, logger.Warnf, "[%s] tag %s no longer exists, skipping it.", d.Repo, d.Tag)
+		case digest != d.Digest:
+			p.skip(SkipDigestChanged, 1, logger.Warnf, "[%s] tag %s now references %s instead of planned %s, keeping it.", d.Repo, d.Tag, digest, d.Digest)
+			keepTags[d.Repo] = append(keepTags[d.Repo], d.Tag)
+		default:
+			purgeTags[d.Repo] = append(purgeTags[d.Repo], d.Tag)
+			p.digests[d.Repo+":"+d.Tag] = d.Digest
+		}
+	}
+
+	for _, repo := range SortedMapKeys(keepTags) {
+		if _, ok := purgeTags[repo]; !ok {
+			summary.Repos = append(summary.Repos, RepoSummary{Repo: repo, TagsCount: len(keepTags[repo]), Keep: keepTags[repo]})
+		}
+	}
+	for _, repo := range SortedMapKeys(purgeTags) {
+		summary.Repos = append(summary.Repos, RepoSummary{
+			Repo: repo, TagsCount: len(keepTags[repo]) + len(purgeTags[repo]), Keep: keepTags[repo], Purge: purgeTags[repo],
+		})
+	}
+	logger.Infof("Applying purge plan of %s: %d of %d tags to purge.",
+		plan.Created.Format("2006-01-02 15:04:05"), len(p.digests), len(plan.Deletions))
+	p.deleteTags(ctx, purgeTags)
+	if len(summary.Skipped) > 0 {
+		logger.Infof("Skipped by reason: %s", skippedText(summary.Skipped))
+	}
+	logger.Info("Done.")
+	return summary
+}
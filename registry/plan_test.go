@@ -0,0 +1,56 @@
+package registry
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestPurgePlan(t *testing.T) {
+	now := time.Now().UTC()
+	f, server := newFakeRegistry(map[string]map[string]time.Time{
+		"app": {"v1": now.Add(-30 * 24 * time.Hour), "v2": now.Add(-20 * 24 * time.Hour), "v3": now},
+	})
+	defer server.Close()
+	client := NewClient(server.URL, false, "", "")
+	dir, err := ioutil.TempDir("", "purge-plan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	planFile := filepath.Join(dir, "plan.json")
+
+	convey.Convey("Write the plan on dry-run", t, func() {
+		PurgeOldTags(context.Background(), client, PurgeTagsOptions{DryRun: true, KeepDays: 7, KeepCount: 1, PlanFile: planFile})
+		convey.So(f.deleted, convey.ShouldBeEmpty)
+		plan, err := LoadPurgePlan(planFile)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(plan.Deletions, convey.ShouldResemble, []PlannedDeletion{
+			{Repo: "app", Tag: "v2", Digest: fakeDigest(now.Add(-20 * 24 * time.Hour))},
+			{Repo: "app", Tag: "v1", Digest: fakeDigest(now.Add(-30 * 24 * time.Hour))},
+		})
+	})
+
+	convey.Convey("Apply only the deletions still matching the plan", t, func() {
+		plan, err := LoadPurgePlan(planFile)
+		convey.So(err, convey.ShouldBeNil)
+		// Re-push v2 after planning.
+		f.repos["app"]["v2"] = now.Add(-time.Hour)
+		summary := ApplyPurgePlan(context.Background(), client, plan, PurgeTagsOptions{})
+		convey.So(f.deleted, convey.ShouldResemble, []string{"app:v1"})
+		convey.So(summary.Repos, convey.ShouldHaveLength, 1)
+		convey.So(summary.Repos[0].Keep, convey.ShouldResemble, []string{"v2"})
+		convey.So(summary.TagsDeleted, convey.ShouldEqual, 1)
+		convey.So(summary.Errors, convey.ShouldBeEmpty)
+	})
+
+	convey.Convey("Fail on missing plan", t, func() {
+		_, err := LoadPurgePlan(filepath.Join(dir, "missing.json"))
+		convey.So(err, convey.ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,57 @@
+package registry
+
+// Events of PurgeProgress.
+const (
+	// ProgressRepoStart is reported when the scan of a repo starts.
+	ProgressRepoStart = "repo-start"
+	// ProgressRepoFinish is reported when a repo is analyzed and its tags to purge are deleted.
+	ProgressRepoFinish = "repo-finish"
+	// ProgressTagDelete is reported as the deletion of every tag completes, with its error if it failed.
+	ProgressTagDelete = "tag-delete"
+)
+
+// PurgeProgress progress of a purging run reported to PurgeTagsOptions.Progress, e.g. to stream it to the UI.
+type PurgeProgress struct {
+	Event string `json:"event"`
+	Repo  string `json:"repo"`
+	// Done and Total count the repos finished and all the repos of the run, Total grows as the catalog is fetched.
+	Done  int `json:"done"`
+	Total int `json:"total"`
+	// Summary of the repo on ProgressRepoFinish, nil for repos without tags.
+	Summary *RepoSummary `json:"summary,omitempty"`
+	// Tag deleted on ProgressTagDelete and the error if the deletion failed.
+	Tag   string `json:"tag,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// progress report the event of the repo to PurgeTagsOptions.Progress if any, safe for concurrent use.
+func (p *purger) progress(event, repo string) {
+	if p.opts.Progress == nil {
+		return
+	}
+	p.progressMux.Lock()
+	defer p.progressMux.Unlock()
+
+	e := PurgeProgress{Event: event, Repo: repo, Total: p.total}
+	if event == ProgressRepoFinish {
+		p.finished++
+		e.Summary = p.summary.repoCopy(repo)
+	}
+	e.Done = p.finished
+	p.opts.Progress(e)
+}
+
+// progressTag report the deletion of the tag to PurgeTagsOptions.Progress if any, safe for concurrent use.
+func (p *purger) progressTag(repo, tag string, err error) {
+	if p.opts.Progress == nil {
+		return
+	}
+	p.progressMux.Lock()
+	defer p.progressMux.Unlock()
+
+	e := PurgeProgress{Event: ProgressTagDelete, Repo: repo, Tag: tag, Done: p.finished, Total: p.total}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	p.opts.Progress(e)
+}
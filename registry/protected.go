@@ -0,0 +1,208 @@
+package registry
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// ProtectedTagsProvider return the refs protecting the tags named after them from purging, e.g. the live
+// git branches. It is called once per run, so the protected tags follow the branches as they come and go.
+type ProtectedTagsProvider func() ([]ProtectedRef, error)
+
+// ProtectedRef name protecting the tags named after it, with the commit SHA of the branch head if known,
+// which protects the tags of that commit, full or abbreviated, while the older commit tags age out.
+type ProtectedRef struct {
+	Name string
+	SHA  string
+}
+
+// minSHATag the shortest abbreviated commit SHA matched, as git abbreviates them.
+const minSHATag = 7
+
+var shaRegexp = regexp.MustCompile(`^([0-9a-f]{40}|[0-9a-f]{64})$`)
+
+// maxProtectedPages bounds the pages NewTagsHTTPProvider follows, e.g. on a Link header pointing to itself.
+const maxProtectedPages = 100
+
+var nextLinkRegexp = regexp.MustCompile(`<([^>]+)>\s*;\s*rel="next"`)
+
+// NewTagsFileProvider sample ProtectedTagsProvider reading the names from the file, one per line,
+// optionally followed by the commit SHA, or as output by git ls-remote --heads.
+// Empty lines and the ones starting with # are skipped.
+func NewTagsFileProvider(path string) ProtectedTagsProvider {
+	return func() ([]ProtectedRef, error) {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading protected tags: %s", err)
+		}
+		defer f.Close()
+		lines, err := readRefs(f)
+		if err != nil {
+			return nil, err
+		}
+		return parseProtectedLines(lines), nil
+	}
+}
+
+// NewTagsHTTPProvider sample ProtectedTagsProvider getting the names from the URL, e.g. the branches API
+// of GitHub or GitLab. The response is a JSON array of names or of objects with a name and the commit of
+// the GitHub or GitLab APIs, or lines like the ones of NewTagsFileProvider. Pages are followed by the Link
+// header, the token is sent as Bearer token if set.
+func NewTagsHTTPProvider(url, token string) ProtectedTagsProvider {
+	client := &http.Client{Timeout: 30 * time.Second}
+	return func() ([]ProtectedRef, error) {
+		refs := []ProtectedRef{}
+		next := url
+		for page := 0; next != "" && page < maxProtectedPages; page++ {
+			req, err := http.NewRequest("GET", next, nil)
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("User-Agent", "docker-registry-ui")
+			if token != "" {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get protected tags: %s", err)
+			}
+			data, err := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				return nil, fmt.Errorf("failed to get protected tags: %s", err)
+			}
+			if resp.StatusCode != 200 {
+				return nil, fmt.Errorf("failed to get protected tags from %s: %s", next, resp.Status)
+			}
+			pageRefs, err := parseProtectedTags(data)
+			if err != nil {
+				return nil, err
+			}
+			refs = append(refs, pageRefs...)
+			next = ""
+			if m := nextLinkRegexp.FindStringSubmatch(resp.Header.Get("Link")); m != nil {
+				next = m[1]
+			}
+		}
+		return refs, nil
+	}
+}
+
+// parseProtectedTags parse the refs of a JSON array of names or of objects with a name, the commit SHA
+// being commit.sha on GitHub and commit.id on GitLab, or of the lines.
+func parseProtectedTags(data []byte) ([]ProtectedRef, error) {
+	result := gjson.ParseBytes(data)
+	if !result.IsArray() {
+		lines, err := readRefs(strings.NewReader(string(data)))
+		if err != nil {
+			return nil, err
+		}
+		return parseProtectedLines(lines), nil
+	}
+	refs := []ProtectedRef{}
+	for _, item := range result.Array() {
+		if item.Type == gjson.String {
+			refs = append(refs, ProtectedRef{Name: item.String()})
+		} else if name := item.Get("name").String(); name != "" {
+			sha := item.Get("commit.sha").String()
+			if sha == "" {
+				sha = item.Get("commit.id").String()
+			}
+			refs = append(refs, ProtectedRef{Name: name, SHA: strings.ToLower(sha)})
+		}
+	}
+	return refs, nil
+}
+
+// parseProtectedLines parse the lines of names optionally followed by the commit SHA, or of SHAs followed by
+// the refs like git ls-remote --heads outputs them, e.g. "<sha>\trefs/heads/main".
+func parseProtectedLines(lines []string) []ProtectedRef {
+	refs := []ProtectedRef{}
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 2 && shaRegexp.MatchString(strings.ToLower(fields[0])):
+			refs = append(refs, ProtectedRef{Name: strings.TrimPrefix(fields[1], "refs/heads/"), SHA: strings.ToLower(fields[0])})
+		case len(fields) == 2 && shaRegexp.MatchString(strings.ToLower(fields[1])):
+			refs = append(refs, ProtectedRef{Name: fields[0], SHA: strings.ToLower(fields[1])})
+		default:
+			refs = append(refs, ProtectedRef{Name: line})
+		}
+	}
+	return refs
+}
+
+var (
+	invalidTagChars  = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+	invalidSlugChars = regexp.MustCompile(`[^a-z0-9]`)
+)
+
+// protectedTagNames return the tag names protected by the names of the refs as is, with the characters invalid
+// in tags replaced by "-", e.g. feature/login as feature-login, and as GitLab CI_COMMIT_REF_SLUG.
+func protectedTagNames(refs []ProtectedRef) map[string]bool {
+	tags := map[string]bool{}
+	for _, ref := range refs {
+		name := ref.Name
+		tags[name] = true
+		tags[invalidTagChars.ReplaceAllString(name, "-")] = true
+		slug := invalidSlugChars.ReplaceAllString(strings.ToLower(name), "-")
+		if len(slug) > 63 {
+			slug = slug[:63]
+		}
+		tags[strings.Trim(slug, "-")] = true
+	}
+	return tags
+}
+
+// headSHAs return the branches by the commit SHAs of their heads.
+func headSHAs(refs []ProtectedRef) map[string]string {
+	shas := map[string]string{}
+	for _, ref := range refs {
+		if ref.SHA != "" {
+			shas[ref.SHA] = ref.Name
+		}
+	}
+	return shas
+}
+
+// headOf return the branch which head commit the tag is named after, by its full or abbreviated SHA
+// optionally prefixed by "sha-" like docker/metadata-action does, empty for other tags.
+func (p *purger) headOf(tag string) string {
+	tag = strings.TrimPrefix(strings.ToLower(tag), "sha-")
+	if len(p.headSHAs) == 0 || len(tag) < minSHATag || strings.Trim(tag, "0123456789abcdef") != "" {
+		return ""
+	}
+	for sha, branch := range p.headSHAs {
+		if strings.HasPrefix(sha, tag) {
+			return branch
+		}
+	}
+	return ""
+}
+
+// splitProtected split the tags named after the protected names or the head commits of the branches from the others.
+func (p *purger) splitProtected(repo string, tags timeSlice) ([]string, timeSlice) {
+	if len(p.protectedTags) == 0 && len(p.headSHAs) == 0 {
+		return nil, tags
+	}
+	protected := []string{}
+	rest := timeSlice{}
+	for _, t := range tags {
+		if p.protectedTags[t.name] || p.headOf(t.name) != "" {
+			protected = append(protected, t.name)
+		} else {
+			rest = append(rest, t)
+		}
+	}
+	if len(protected) > 0 {
+		p.logger.Infof("[%s] keeping %d protected tags: %v", repo, len(protected), protected)
+	}
+	return protected, rest
+}
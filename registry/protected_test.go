@@ -0,0 +1,81 @@
+package registry
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestProtectedTags(t *testing.T) {
+	convey.Convey("Parse the names of JSON arrays and of lines", t, func() {
+		for data, expected := range map[string][]ProtectedRef{
+			`["main", "release/1.0"]`:                     {{Name: "main"}, {Name: "release/1.0"}},
+			`[{"name": "main", "commit": {}}, {"id": 1}]`: {{Name: "main"}},
+			"# branches\nmain\n\ndevelop\n":               {{Name: "main"}, {Name: "develop"}},
+		} {
+			refs, err := parseProtectedTags([]byte(data))
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(refs, convey.ShouldResemble, expected)
+		}
+	})
+
+	convey.Convey("Parse the commit SHAs of the branch heads", t, func() {
+		sha := strings.Repeat("a1", 20)
+		for data, expected := range map[string][]ProtectedRef{
+			`[{"name": "main", "commit": {"sha": "` + strings.ToUpper(sha) + `"}}]`: {{Name: "main", SHA: sha}},
+			`[{"name": "main", "commit": {"id": "` + sha + `"}}]`:                   {{Name: "main", SHA: sha}},
+			sha + "\trefs/heads/feature/login\nmain " + sha + "\n":                  {{Name: "feature/login", SHA: sha}, {Name: "main", SHA: sha}},
+		} {
+			refs, err := parseProtectedTags([]byte(data))
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(refs, convey.ShouldResemble, expected)
+		}
+	})
+
+	convey.Convey("Protect the tag-safe forms of the names", t, func() {
+		tags := protectedTagNames([]ProtectedRef{{Name: "Feature/Login_2"}})
+		for _, tag := range []string{"Feature/Login_2", "Feature-Login_2", "feature-login-2"} {
+			convey.So(tags[tag], convey.ShouldBeTrue)
+		}
+	})
+
+	convey.Convey("Match the tags of the head commits, full or abbreviated", t, func() {
+		sha := "0123456789abcdef0123456789abcdef01234567"
+		p := &purger{headSHAs: headSHAs([]ProtectedRef{{Name: "main", SHA: sha}, {Name: "develop"}})}
+		for _, tag := range []string{sha, "0123456", "sha-0123456", "0123456789AB"} {
+			convey.So(p.headOf(tag), convey.ShouldEqual, "main")
+		}
+		for _, tag := range []string{"012345", "1234567", "0123456-dirty", "main"} {
+			convey.So(p.headOf(tag), convey.ShouldBeEmpty)
+		}
+	})
+
+	convey.Convey("Follow the pages of the branches API", t, func() {
+		var server *httptest.Server
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if r.URL.Query().Get("page") == "" {
+				w.Header().Set("Link", fmt.Sprintf(`<%s/branches?page=2>; rel="next", <%s/branches?page=2>; rel="last"`, server.URL, server.URL))
+				fmt.Fprint(w, `[{"name": "main"}]`)
+				return
+			}
+			fmt.Fprint(w, `[{"name": "develop"}]`)
+		}))
+		defer server.Close()
+		provider := NewTagsHTTPProvider(server.URL+"/branches", "secret")
+		for run := 0; run < 2; run++ {
+			refs, err := provider()
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(refs, convey.ShouldResemble, []ProtectedRef{{Name: "main"}, {Name: "develop"}})
+		}
+		_, err := NewTagsHTTPProvider(server.URL+"/branches", "")()
+		convey.So(err, convey.ShouldNotBeNil)
+	})
+}
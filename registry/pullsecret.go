@@ -0,0 +1,158 @@
+package registry
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// serviceAccountDir where Kubernetes mounts the token, CA and namespace of the service account of the pod.
+var serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// kubeAPI Kubernetes API reached with the service account of the pod.
+type kubeAPI struct {
+	url       string
+	token     string
+	namespace string
+	client    *http.Client
+}
+
+// inClusterAPI return the Kubernetes API of the cluster the pod runs in.
+func inClusterAPI() (*kubeAPI, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running in a Kubernetes cluster, KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT are not set")
+	}
+	token, err := ioutil.ReadFile(filepath.Join(serviceAccountDir, "token"))
+	if err != nil {
+		return nil, fmt.Errorf("Error reading service account token: %s", err)
+	}
+	namespace, err := ioutil.ReadFile(filepath.Join(serviceAccountDir, "namespace"))
+	if err != nil {
+		return nil, fmt.Errorf("Error reading service account namespace: %s", err)
+	}
+	ca, err := ioutil.ReadFile(filepath.Join(serviceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, fmt.Errorf("Error reading service account CA: %s", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("invalid service account CA")
+	}
+	return &kubeAPI{
+		url:       "https://" + net.JoinHostPort(host, port),
+		token:     strings.TrimSpace(string(token)),
+		namespace: strings.TrimSpace(string(namespace)),
+		client: &http.Client{
+			Timeout:   30 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		},
+	}, nil
+}
+
+// PullSecretCredentials look up the credentials of the host in the Kubernetes Secret of type
+// kubernetes.io/dockerconfigjson, i.e. an imagePullSecret, referenced as namespace/name or as name in the
+// namespace of the pod. It is read with the service account of the pod, which has to be allowed to get it.
+// A secret without an entry for the host has no credentials.
+func PullSecretCredentials(ref, host string) (username, password string, ok bool, err error) {
+	api, err := inClusterAPI()
+	if err != nil {
+		return "", "", false, err
+	}
+	return api.pullSecretCredentials(ref, host)
+}
+
+// pullSecretCredentials get the secret and look up the credentials of the host in it.
+func (k *kubeAPI) pullSecretCredentials(ref, host string) (string, string, bool, error) {
+	namespace, name := k.namespace, ref
+	if i := strings.Index(ref, "/"); i >= 0 {
+		namespace, name = ref[:i], ref[i+1:]
+	}
+	uri := fmt.Sprintf("%s/api/v1/namespaces/%s/secrets/%s", k.url, namespace, name)
+	req, err := http.NewRequest(http.MethodGet, uri, nil)
+	if err != nil {
+		return "", "", false, err
+	}
+	req.Header.Set("Authorization", "Bearer "+k.token)
+	req.Header.Set("Accept", "application/json")
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return "", "", false, fmt.Errorf("Error getting pull secret %s/%s: %s", namespace, name, err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", "", false, fmt.Errorf("Error getting pull secret %s/%s: %s", namespace, name, err)
+	}
+	if resp.StatusCode != 200 {
+		return "", "", false, fmt.Errorf("Error getting pull secret %s/%s: %s", namespace, name, resp.Status)
+	}
+	secret := gjson.ParseBytes(data)
+	if typ := secret.Get("type").String(); typ != "kubernetes.io/dockerconfigjson" {
+		return "", "", false, fmt.Errorf("pull secret %s/%s is of type %q, not kubernetes.io/dockerconfigjson", namespace, name, typ)
+	}
+	config, err := base64.StdEncoding.DecodeString(secret.Get(`data.\.dockerconfigjson`).String())
+	if err != nil {
+		return "", "", false, fmt.Errorf("invalid pull secret %s/%s: %s", namespace, name, err)
+	}
+	return DockerConfigCredentials(config, host)
+}
+
+// DockerConfigCredentials look up the credentials of the host in the auths of a Docker config.json, keyed by
+// the registry host with or without scheme and path, e.g. "https://host/v1/". The host is matched with its port
+// first, then without it. The credentials are either username and password or their base64 encoded auth.
+func DockerConfigCredentials(config []byte, host string) (username, password string, ok bool, err error) {
+	var parsed struct {
+		Auths map[string]struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+			Auth     string `json:"auth"`
+		} `json:"auths"`
+	}
+	if err := json.Unmarshal(config, &parsed); err != nil {
+		return "", "", false, fmt.Errorf("invalid Docker config: %s", err)
+	}
+	candidates := []string{host}
+	if i := strings.LastIndex(host, ":"); i > 0 && !strings.HasSuffix(host, "]") {
+		candidates = append(candidates, host[:i])
+	}
+	for _, candidate := range candidates {
+		for key, entry := range parsed.Auths {
+			if dockerConfigHost(key) != candidate {
+				continue
+			}
+			if entry.Username != "" {
+				return entry.Username, entry.Password, true, nil
+			}
+			auth, err := base64.StdEncoding.DecodeString(entry.Auth)
+			if err != nil || !strings.Contains(string(auth), ":") {
+				return "", "", false, fmt.Errorf("invalid auth of %s in Docker config", key)
+			}
+			parts := strings.SplitN(string(auth), ":", 2)
+			return parts[0], parts[1], true, nil
+		}
+	}
+	return "", "", false, nil
+}
+
+// dockerConfigHost return the host of the auths key of a Docker config.json.
+func dockerConfigHost(key string) string {
+	if i := strings.Index(key, "://"); i >= 0 {
+		key = key[i+3:]
+	}
+	if i := strings.Index(key, "/"); i >= 0 {
+		key = key[:i]
+	}
+	return key
+}
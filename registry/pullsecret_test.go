@@ -0,0 +1,67 @@
+package registry
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestPullSecret(t *testing.T) {
+	config := []byte(`{"auths": {
+		"https://registry.local:5000/v1/": {"username": "ci", "password": "secret"},
+		"mirror.local": {"auth": "` + base64.StdEncoding.EncodeToString([]byte("robot:p:w")) + `"}
+	}}`)
+
+	convey.Convey("Look up the credentials of the host in the Docker config", t, func() {
+		username, password, ok, err := DockerConfigCredentials(config, "registry.local:5000")
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(ok, convey.ShouldBeTrue)
+		convey.So(username+" "+password, convey.ShouldEqual, "ci secret")
+
+		username, password, ok, err = DockerConfigCredentials(config, "mirror.local:443")
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(ok, convey.ShouldBeTrue)
+		convey.So(username+" "+password, convey.ShouldEqual, "robot p:w")
+
+		_, _, ok, err = DockerConfigCredentials(config, "other.local")
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(ok, convey.ShouldBeFalse)
+	})
+
+	convey.Convey("Read the pull secret with the service account", t, func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer sa-token" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			typ := "kubernetes.io/dockerconfigjson"
+			if r.URL.Path == "/api/v1/namespaces/ops/secrets/opaque" {
+				typ = "Opaque"
+			} else if r.URL.Path != "/api/v1/namespaces/ci/secrets/registry" && r.URL.Path != "/api/v1/namespaces/ops/secrets/registry" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"type": typ,
+				"data": map[string]string{".dockerconfigjson": base64.StdEncoding.EncodeToString(config)},
+			})
+		}))
+		defer server.Close()
+		api := &kubeAPI{url: server.URL, token: "sa-token", namespace: "ci", client: server.Client()}
+
+		for _, ref := range []string{"registry", "ops/registry"} {
+			username, password, ok, err := api.pullSecretCredentials(ref, "registry.local:5000")
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(ok, convey.ShouldBeTrue)
+			convey.So(username+" "+password, convey.ShouldEqual, "ci secret")
+		}
+		_, _, _, err := api.pullSecretCredentials("ops/opaque", "registry.local:5000")
+		convey.So(err, convey.ShouldNotBeNil)
+		_, _, _, err = api.pullSecretCredentials("missing", "registry.local:5000")
+		convey.So(err, convey.ShouldNotBeNil)
+	})
+}
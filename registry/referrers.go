@@ -0,0 +1,63 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Descriptor OCI descriptor of a manifest, e.g. of a referrer.
+type Descriptor struct {
+	MediaType    string            `json:"mediaType"`
+	Digest       string            `json:"digest"`
+	Size         int64             `json:"size"`
+	ArtifactType string            `json:"artifactType,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+}
+
+// Referrers list the manifests referring to the manifest digest of the repo by their subject, e.g. signatures,
+// attestations and SBOMs, with the OCI referrers API. Registries lacking the API are queried by the referrers
+// tag schema instead, i.e. the image index tagged by the digest with "-" in place of ":". For a subject without
+// referrers the list is empty.
+func (c *Client) Referrers(repo, digest string) ([]Descriptor, error) {
+	status, data, err := c.getIndex(repo, fmt.Sprintf("/v2/%s/referrers/%s", repo, digest))
+	if err == nil && status == 404 {
+		// The referrers API is not supported, fall back to the tag schema.
+		status, data, err = c.getIndex(repo, fmt.Sprintf("/v2/%s/manifests/%s", repo, strings.Replace(digest, ":", "-", 1)))
+		if err == nil && status == 404 {
+			return []Descriptor{}, nil
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list referrers of %s@%s: %s", repo, digest, err)
+	}
+	if status != 200 {
+		return nil, fmt.Errorf("failed to list referrers of %s@%s: status %d", repo, digest, status)
+	}
+	var index struct {
+		Manifests []Descriptor `json:"manifests"`
+	}
+	if err := json.Unmarshal([]byte(data), &index); err != nil {
+		return nil, fmt.Errorf("failed to list referrers of %s@%s: invalid image index: %s", repo, digest, err)
+	}
+	if index.Manifests == nil {
+		index.Manifests = []Descriptor{}
+	}
+	return index.Manifests, nil
+}
+
+// getIndex get the image index at the URI and return the response status with it.
+func (c *Client) getIndex(repo, uri string) (int, string, error) {
+	scope := fmt.Sprintf("repository:%s:*", repo)
+	authHeader := ""
+	if c.authURL != "" {
+		authHeader = fmt.Sprintf("Bearer %s", c.getToken(scope))
+	}
+	resp, data, errs := c.end(c.newRequest().Get(c.url+uri).Set("Accept", MediaTypeOCIIndex).Set("Authorization", authHeader).Set("User-Agent", "docker-registry-ui"))
+	if len(errs) > 0 {
+		c.logger.Error(errs[0])
+		return 0, "", errs[0]
+	}
+	c.logger.Info("GET ", uri, " ", resp.Status)
+	return resp.StatusCode, data, nil
+}
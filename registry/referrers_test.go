@@ -0,0 +1,58 @@
+package registry
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestReferrers(t *testing.T) {
+	subject := "sha256:ab0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcd"
+	sbom := Descriptor{
+		MediaType: MediaTypeOCIManifest, Digest: "sha256:0c", Size: 512,
+		ArtifactType: "application/spdx+json", Annotations: map[string]string{"org.opencontainers.image.created": "2023-01-01T00:00:00Z"},
+	}
+	serve := func(api bool) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/v2/":
+				return
+			case "/v2/app/referrers/" + subject:
+				if !api {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+			case "/v2/app/manifests/sha256-" + subject[7:]:
+				if api {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+			default:
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", MediaTypeOCIIndex)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"schemaVersion": 2, "mediaType": MediaTypeOCIIndex, "manifests": []Descriptor{sbom},
+			})
+		}))
+	}
+
+	convey.Convey("List the referrers with the referrers API or the tag schema", t, func() {
+		for _, api := range []bool{true, false} {
+			server := serve(api)
+			defer server.Close()
+			c := NewClient(server.URL, false, "", "")
+			referrers, err := c.Referrers("app", subject)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(referrers, convey.ShouldResemble, []Descriptor{sbom})
+
+			referrers, err = c.Referrers("app", "sha256:ff")
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(referrers, convey.ShouldBeEmpty)
+		}
+	})
+}
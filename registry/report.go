@@ -0,0 +1,150 @@
+package registry
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Markdown render the run summary as a Markdown report with the totals and a table of the tags per repo,
+// e.g. to paste the dry-run into an issue for approval.
+func (s *PurgeSummary) Markdown() string {
+	b := &bytes.Buffer{}
+	kind := "Purge"
+	if s.DryRun {
+		kind = "Purge dry-run"
+	}
+	fmt.Fprintf(b, "# %s %s\n\n", kind, s.ID)
+	fmt.Fprintf(b, "Started %s, took %s.", s.Started.Format("2006-01-02 15:04:05 MST"), s.Duration())
+	if s.RunID != "" {
+		fmt.Fprintf(b, " Run ID `%s`.", s.RunID)
+	}
+	if s.Aborted {
+		b.WriteString(" Aborted, see the errors.")
+	}
+	if s.TimedOut {
+		b.WriteString(" Stopped on exceeding the max duration.")
+	}
+	b.WriteString("\n\n## Totals\n\n")
+
+	var tags, keep int
+	var bytesToPurge int64
+	for _, r := range s.Repos {
+		tags = tags + r.TagsCount
+		keep = keep + len(r.Keep)
+		bytesToPurge = bytesToPurge + r.BytesToPurge
+	}
+	b.WriteString("| Repositories | Tags | Keep | Purge | Unprocessed | Size to purge | Deleted | Reclaimed | Errors |\n")
+	b.WriteString("|---:|---:|---:|---:|---:|---:|---:|---:|---:|\n")
+	fmt.Fprintf(b, "| %d | %d | %d | %d | %d | %s | %d | %s | %d |\n", len(s.Repos), tags, keep, s.TagsToPurge(),
+		s.TagsUnprocessed(), PrettySize(float64(bytesToPurge)), s.TagsDeleted, PrettySize(float64(s.BytesReclaimed)), len(s.Errors))
+	if sample := s.Sample; sample != nil {
+		fmt.Fprintf(b, "\nSampled %d of %d repositories (%g%%), the totals above are the sampled ones. Estimated across all of them:\n\n",
+			sample.ReposSampled, sample.ReposTotal, sample.Percent)
+		b.WriteString("| Tags | Purge | Size to purge |\n")
+		b.WriteString("|---:|---:|---:|\n")
+		fmt.Fprintf(b, "| %d | %d | %s |\n", sample.EstimatedTags, sample.EstimatedTagsToPurge, PrettySize(float64(sample.EstimatedBytesToPurge)))
+	}
+
+	if len(s.Errors) > 0 {
+		b.WriteString("\n## Errors\n\n")
+		for _, e := range s.Errors {
+			fmt.Fprintf(b, "* %s\n", e)
+		}
+	}
+	if len(s.Skipped) > 0 {
+		b.WriteString("\n## Skipped\n\n")
+		for _, reason := range SortedMapKeys(s.Skipped) {
+			fmt.Fprintf(b, "* %s: %d\n", reason, s.Skipped[SkipReason(reason)])
+		}
+	}
+
+	b.WriteString("\n## Repositories\n")
+	for _, r := range s.Repos {
+		fmt.Fprintf(b, "\n### %s\n\n", r.Repo)
+		notes := []string{fmt.Sprintf("%d tags, %d to keep, %d to purge", r.TagsCount, len(r.Keep), len(r.Purge))}
+		if r.BytesToPurge > 0 {
+			notes = append(notes, PrettySize(float64(r.BytesToPurge))+" to purge")
+		}
+		if !s.DryRun {
+			notes = append(notes, fmt.Sprintf("%d deleted", r.Deleted))
+		}
+		if len(r.RecentlyPushed) > 0 {
+			notes = append(notes, fmt.Sprintf("%d not deleted as pushed recently", len(r.RecentlyPushed)))
+		}
+		if len(r.Deferred) > 0 {
+			notes = append(notes, fmt.Sprintf("%d deferred to the next runs", len(r.Deferred)))
+		}
+		if len(r.NotDeleted) > 0 {
+			notes = append(notes, fmt.Sprintf("%d still resolvable after deletion", len(r.NotDeleted)))
+		}
+		if len(r.Unverified) > 0 {
+			notes = append(notes, fmt.Sprintf("%d deleted but not verified", len(r.Unverified)))
+		}
+		if r.DryRunOnly {
+			notes = append(notes, "dry-run only, nothing deleted")
+		}
+		if r.OverTagCount {
+			notes = append(notes, "over the tag count warning threshold")
+		}
+		if r.Incomplete {
+			notes = append(notes, "incomplete as its scan exceeded the repo max duration or failed to list all its tags, left untouched")
+		}
+		fmt.Fprintf(b, "%s.\n\n", strings.Join(notes, ", "))
+		if len(r.Keep)+len(r.Purge) == 0 {
+			continue
+		}
+		b.WriteString("| Tag | Decision |\n|---|---|\n")
+		for _, t := range r.Purge {
+			decision := "purge"
+			if ItemInSlice(t, r.RecentlyPushed) {
+				decision = "purge, not deleted as pushed recently"
+			} else if ItemInSlice(t, r.NotDeleted) {
+				decision = "purge, still resolvable after deletion"
+			} else if ItemInSlice(t, r.Unverified) {
+				decision = "purge, deletion not verified"
+			}
+			fmt.Fprintf(b, "| `%s` | %s |\n", t, decision)
+		}
+		unprocessed := map[string]bool{}
+		for _, t := range r.Unprocessed {
+			unprocessed[t] = true
+		}
+		for _, t := range r.Keep {
+			decision := "keep"
+			if unprocessed[t] {
+				decision = "keep, unprocessed"
+			} else if ItemInSlice(t, r.Deferred) {
+				decision = "keep, deferred to the next runs"
+			}
+			fmt.Fprintf(b, "| `%s` | %s |\n", t, decision)
+		}
+	}
+	return b.String()
+}
+
+// EmptiedMarkdown render the repos the run leaves without tags as a Markdown report, the riskiest decisions
+// to review first before applying the dry-run.
+func (s *PurgeSummary) EmptiedMarkdown() string {
+	b := &bytes.Buffer{}
+	kind := "Purge"
+	if s.DryRun {
+		kind = "Purge dry-run"
+	}
+	fmt.Fprintf(b, "# %s %s: repositories left empty\n\n", kind, s.ID)
+	repos := s.ReposEmptied()
+	if len(repos) == 0 {
+		fmt.Fprintf(b, "None of the %d repositories is left without tags.\n", len(s.Repos))
+		return b.String()
+	}
+	fmt.Fprintf(b, "%d of the %d repositories are left without tags.\n\n", len(repos), len(s.Repos))
+	b.WriteString("| Repository | Tags to purge | Size to purge | Tags |\n|---|---:|---:|---|\n")
+	for _, r := range repos {
+		tags := make([]string, 0, len(r.Purge))
+		for _, t := range r.Purge {
+			tags = append(tags, "`"+t+"`")
+		}
+		fmt.Fprintf(b, "| %s | %d | %s | %s |\n", r.Repo, len(r.Purge), PrettySize(float64(r.BytesToPurge)), strings.Join(tags, ", "))
+	}
+	return b.String()
+}
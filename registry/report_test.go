@@ -0,0 +1,51 @@
+package registry
+
+import (
+	"testing"
+	"time"
+
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestMarkdown(t *testing.T) {
+	started := time.Date(2019, 7, 1, 3, 10, 0, 0, time.UTC)
+	summary := &PurgeSummary{
+		ID: "20190701-031000", Started: started, Finished: started.Add(time.Minute), DryRun: true,
+		Repos: []RepoSummary{
+			{Repo: "app", TagsCount: 3, Keep: []string{"v3", "broken"}, Purge: []string{"v1"}, Unprocessed: []string{"broken"}, BytesToPurge: 2048},
+			{Repo: "base", TagsCount: 1, Keep: []string{"v1"}, DryRunOnly: true},
+		},
+		Errors: []string{"failed to delete app:v0"},
+	}
+
+	convey.Convey("Render the totals and the tags per repo", t, func() {
+		report := summary.Markdown()
+		convey.So(report, convey.ShouldStartWith, "# Purge dry-run 20190701-031000\n\nStarted 2019-07-01 03:10:00 UTC, took 1m0s.\n")
+		convey.So(report, convey.ShouldContainSubstring, "| 2 | 4 | 3 | 1 | 1 | 2 KB | 0 | 0 B | 1 |\n")
+		convey.So(report, convey.ShouldContainSubstring, "* failed to delete app:v0\n")
+		convey.So(report, convey.ShouldContainSubstring, "### app\n\n3 tags, 2 to keep, 1 to purge, 2 KB to purge.\n")
+		convey.So(report, convey.ShouldContainSubstring, "| `v1` | purge |\n| `v3` | keep |\n| `broken` | keep, unprocessed |\n")
+		convey.So(report, convey.ShouldContainSubstring, "### base\n\n1 tags, 1 to keep, 0 to purge, dry-run only, nothing deleted.\n")
+	})
+}
+
+func TestEmptiedMarkdown(t *testing.T) {
+	summary := &PurgeSummary{
+		ID: "20190701-031000", DryRun: true,
+		Repos: []RepoSummary{
+			{Repo: "app", TagsCount: 3, Keep: []string{"v3"}, Purge: []string{"v1", "v2"}},
+			{Repo: "legacy", TagsCount: 2, Purge: []string{"v1", "v2"}, BytesToPurge: 2048},
+			{Repo: "base", TagsCount: 1, Keep: []string{"v1"}},
+		},
+	}
+
+	convey.Convey("Render only the repos left without tags", t, func() {
+		report := summary.EmptiedMarkdown()
+		convey.So(report, convey.ShouldStartWith, "# Purge dry-run 20190701-031000: repositories left empty\n\n1 of the 3 repositories")
+		convey.So(report, convey.ShouldContainSubstring, "| legacy | 2 | 2 KB | `v1`, `v2` |\n")
+		convey.So(report, convey.ShouldNotContainSubstring, "| app |")
+
+		summary.Repos = summary.Repos[:1]
+		convey.So(summary.EmptiedMarkdown(), convey.ShouldEndWith, "None of the 1 repositories is left without tags.\n")
+	})
+}
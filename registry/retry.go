@@ -0,0 +1,85 @@
+package registry
+
+import (
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/parnurzeal/gorequest"
+)
+
+// Retry budget defaults, retries are capped at 10% of the requests on top of a burst of 10 retries,
+// which lets the first requests retry before the budget builds up.
+const (
+	DefaultRetryBudget = 0.1
+	retryBudgetBurst   = 10
+	retryDelay         = 200 * time.Millisecond
+)
+
+// retryBudget token bucket of the retries shared by all the requests of the client, every request deposits
+// ratio of a token and every retry withdraws one, so the retries never exceed that fraction of the requests
+// however many fail, e.g. on a registry answering everything with 500.
+type retryBudget struct {
+	mux       sync.Mutex
+	ratio     float64
+	tokens    float64
+	exhausted bool
+}
+
+func newRetryBudget(ratio float64) *retryBudget {
+	if ratio <= 0 {
+		ratio = DefaultRetryBudget
+	}
+	return &retryBudget{ratio: ratio, tokens: retryBudgetBurst}
+}
+
+// deposit account a request.
+func (b *retryBudget) deposit() {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.tokens = b.tokens + b.ratio
+	if b.tokens > retryBudgetBurst {
+		b.tokens = retryBudgetBurst
+	}
+}
+
+// withdraw take a token for a retry, false when the budget is exhausted and the request should fail fast.
+// The second value tells whether the budget just got exhausted, to log it once rather than for every request.
+func (b *retryBudget) withdraw() (bool, bool) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	if b.tokens < 1 {
+		first := !b.exhausted
+		b.exhausted = true
+		return false, first
+	}
+	b.tokens--
+	b.exhausted = false
+	return true, false
+}
+
+// SetRetries make the client retry the GET and HEAD requests failing with network errors, 429 or 5xx up to
+// retries times each, 0 disables retries. Across the client, retries are capped at the budget fraction
+// of the requests, DefaultRetryBudget if 0, past which failing requests fail fast. Call it before using the client.
+func (c *Client) SetRetries(retries int, budget float64) {
+	c.retries = retries
+	c.retryBudget = newRetryBudget(budget)
+	c.retryDelay = retryDelay
+}
+
+// retryable check whether the failed request is worth retrying, only idempotent reads are.
+func retryable(request *gorequest.SuperAgent, resp gorequest.Response, errs []error) bool {
+	if request.Method != gorequest.GET && request.Method != gorequest.HEAD {
+		return false
+	}
+	if len(errs) > 0 {
+		// Responses too large fail the same way again.
+		err := errs[0]
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		_, tooLarge := err.(*tooLargeError)
+		return !tooLarge
+	}
+	return resp != nil && (resp.StatusCode == 429 || resp.StatusCode >= 500)
+}
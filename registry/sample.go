@@ -0,0 +1,45 @@
+package registry
+
+import (
+	"hash/fnv"
+	"math"
+)
+
+// PurgeSample totals of a sampled dry-run, see PurgeTagsOptions.SamplePercent. The summary totals are the exact
+// ones of the sampled repos, the estimates extrapolate them to all the repos by the ratio of the repo counts.
+type PurgeSample struct {
+	Percent      float64 `json:"percent"`
+	ReposSampled int     `json:"repos_sampled"`
+	ReposTotal   int     `json:"repos_total"`
+	// EstimatedBytesToPurge is only set with PurgeTagsOptions.MeasureBytes.
+	EstimatedTags         int   `json:"estimated_tags"`
+	EstimatedTagsToPurge  int   `json:"estimated_tags_to_purge"`
+	EstimatedBytesToPurge int64 `json:"estimated_bytes_to_purge"`
+}
+
+// sampled check whether the repo is part of the sample of that percentage of the repos. Repos are chosen by
+// hashing their names, so every run samples the same ones as long as the percentage stays the same.
+func sampled(repo string, percent float64) bool {
+	h := fnv.New32a()
+	h.Write([]byte(repo))
+	return float64(h.Sum32()%10000) < percent*100
+}
+
+// newPurgeSample extrapolate the totals of the sampled repos of the summary to the total count of repos.
+func newPurgeSample(s *PurgeSummary, percent float64, total int) *PurgeSample {
+	sample := &PurgeSample{Percent: percent, ReposSampled: len(s.Repos), ReposTotal: total}
+	if sample.ReposSampled == 0 {
+		return sample
+	}
+	var tags int
+	var bytesToPurge int64
+	for _, r := range s.Repos {
+		tags = tags + r.TagsCount
+		bytesToPurge = bytesToPurge + r.BytesToPurge
+	}
+	ratio := float64(total) / float64(sample.ReposSampled)
+	sample.EstimatedTags = int(math.Round(float64(tags) * ratio))
+	sample.EstimatedTagsToPurge = int(math.Round(float64(s.TagsToPurge()) * ratio))
+	sample.EstimatedBytesToPurge = int64(math.Round(float64(bytesToPurge) * ratio))
+	return sample
+}
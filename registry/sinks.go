@@ -0,0 +1,160 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hhkbp2/go-logging"
+)
+
+// ReportSink publish the summary of every purging run, e.g. to a chat channel. PurgeOldTags and ApplyPurgePlan
+// fan the final summary out to all the sinks of PurgeTagsOptions.ReportSinks, failing ones are logged only.
+type ReportSink interface {
+	Publish(summary *PurgeSummary) error
+}
+
+// writerSink writes the Markdown report to the writer.
+type writerSink struct {
+	w io.Writer
+}
+
+// NewStdoutSink sample ReportSink printing the Markdown report to stdout.
+func NewStdoutSink() ReportSink {
+	return &writerSink{w: os.Stdout}
+}
+
+func (s *writerSink) Publish(summary *PurgeSummary) error {
+	_, err := io.WriteString(s.w, summary.Markdown())
+	return err
+}
+
+// fileSink writes the report to the file.
+type fileSink struct {
+	path string
+}
+
+// NewFileSink sample ReportSink writing the report to the file, the JSON summary if it ends with .json,
+// the Markdown report otherwise. The file is replaced by every run.
+func NewFileSink(path string) ReportSink {
+	return &fileSink{path: path}
+}
+
+func (s *fileSink) Publish(summary *PurgeSummary) error {
+	data := []byte(summary.Markdown())
+	if filepath.Ext(s.path) == ".json" {
+		var err error
+		if data, err = json.MarshalIndent(summary, "", "  "); err != nil {
+			return err
+		}
+	}
+	if err := ioutil.WriteFile(s.path, data, 0644); err != nil {
+		return fmt.Errorf("Error writing purge report: %s", err)
+	}
+	return nil
+}
+
+// webhookSink posts the report to the URL.
+type webhookSink struct {
+	url    string
+	slack  bool
+	client *http.Client
+}
+
+// NewWebhookSink sample ReportSink posting the JSON summary to the URL.
+func NewWebhookSink(url string) ReportSink {
+	return &webhookSink{url: url, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// NewSlackSink sample ReportSink posting the headline of the run to the Slack incoming webhook URL.
+func NewSlackSink(url string) ReportSink {
+	return &webhookSink{url: url, slack: true, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+func (s *webhookSink) Publish(summary *PurgeSummary) error {
+	var body []byte
+	var err error
+	if s.slack {
+		body, err = json.Marshal(map[string]string{"text": reportHeadline(summary)})
+	} else {
+		body, err = json.Marshal(summary)
+	}
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("Error publishing purge report: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("Error publishing purge report to %s: %s", s.url, resp.Status)
+	}
+	return nil
+}
+
+// emailSink mails the report.
+type emailSink struct {
+	server, from, username, password string
+	to                               []string
+}
+
+// NewEmailSink sample ReportSink mailing the Markdown report through the SMTP server host:port,
+// authenticating with the username and password if set.
+func NewEmailSink(server, from string, to []string, username, password string) ReportSink {
+	return &emailSink{server: server, from: from, to: to, username: username, password: password}
+}
+
+func (s *emailSink) Publish(summary *PurgeSummary) error {
+	var auth smtp.Auth
+	if s.username != "" {
+		host, _, _ := net.SplitHostPort(s.server)
+		auth = smtp.PlainAuth("", s.username, s.password, host)
+	}
+	msg := &bytes.Buffer{}
+	fmt.Fprintf(msg, "From: %s\r\nTo: %s\r\nSubject: %s\r\n", s.from, strings.Join(s.to, ", "), reportHeadline(summary))
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.Replace(summary.Markdown(), "\n", "\r\n", -1))
+	if err := smtp.SendMail(s.server, auth, s.from, s.to, msg.Bytes()); err != nil {
+		return fmt.Errorf("Error mailing purge report: %s", err)
+	}
+	return nil
+}
+
+// reportHeadline one line summary of the run for chat messages and mail subjects.
+func reportHeadline(s *PurgeSummary) string {
+	kind := "Purge"
+	if s.DryRun {
+		kind = "Purge dry-run"
+	}
+	id := s.ID
+	if s.RunID != "" {
+		id = fmt.Sprintf("%s (run %s)", s.ID, s.RunID)
+	}
+	line := fmt.Sprintf("%s %s: %d tags to purge in %d repositories", kind, id, s.TagsToPurge(), len(s.Repos))
+	if !s.DryRun {
+		line = line + fmt.Sprintf(", %d deleted, %s reclaimed", s.TagsDeleted, PrettySize(float64(s.BytesReclaimed)))
+	}
+	if len(s.Errors) > 0 {
+		line = line + fmt.Sprintf(", %d errors", len(s.Errors))
+	}
+	return line
+}
+
+// publishReport publish the summary to every sink, logging the failing ones.
+func publishReport(logger logging.Logger, sinks []ReportSink, summary *PurgeSummary) {
+	for _, sink := range sinks {
+		if err := sink.Publish(summary); err != nil {
+			logger.Errorf("Failed to publish the purge report: %s", err)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/smartystreets/goconvey/convey"
+)
+
+// recordingSink records the summaries published to it.
+type recordingSink struct {
+	summaries []*PurgeSummary
+	err       error
+}
+
+func (s *recordingSink) Publish(summary *PurgeSummary) error {
+	s.summaries = append(s.summaries, summary)
+	return s.err
+}
+
+func TestReportSinks(t *testing.T) {
+	summary := &PurgeSummary{ID: "20190701-120000", Repos: []RepoSummary{{Repo: "app", TagsCount: 3, Keep: []string{"v3"}, Purge: []string{"v1", "v2"}}}}
+
+	convey.Convey("Write the Markdown report", t, func() {
+		b := &bytes.Buffer{}
+		convey.So((&writerSink{w: b}).Publish(summary), convey.ShouldBeNil)
+		convey.So(b.String(), convey.ShouldEqual, summary.Markdown())
+	})
+
+	convey.Convey("Write the JSON summary to .json files and the Markdown report otherwise", t, func() {
+		dir, err := ioutil.TempDir("", "sinks")
+		convey.So(err, convey.ShouldBeNil)
+		defer os.RemoveAll(dir)
+		convey.So(NewFileSink(filepath.Join(dir, "report.json")).Publish(summary), convey.ShouldBeNil)
+		data, _ := ioutil.ReadFile(filepath.Join(dir, "report.json"))
+		var decoded PurgeSummary
+		convey.So(json.Unmarshal(data, &decoded), convey.ShouldBeNil)
+		convey.So(decoded.Repos[0].Purge, convey.ShouldResemble, []string{"v1", "v2"})
+
+		convey.So(NewFileSink(filepath.Join(dir, "report.md")).Publish(summary), convey.ShouldBeNil)
+		data, _ = ioutil.ReadFile(filepath.Join(dir, "report.md"))
+		convey.So(string(data), convey.ShouldEqual, summary.Markdown())
+	})
+
+	convey.Convey("Post the JSON summary and the Slack headline", t, func() {
+		bodies := map[string]string{}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			data, _ := ioutil.ReadAll(r.Body)
+			bodies[r.URL.Path] = string(data)
+			if r.URL.Path == "/fail" {
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+		}))
+		defer server.Close()
+		convey.So(NewWebhookSink(server.URL+"/hook").Publish(summary), convey.ShouldBeNil)
+		convey.So(bodies["/hook"], convey.ShouldContainSubstring, `"purge":["v1","v2"]`)
+		convey.So(NewSlackSink(server.URL+"/slack").Publish(summary), convey.ShouldBeNil)
+		convey.So(bodies["/slack"], convey.ShouldEqual, fmt.Sprintf(`{"text":"%s"}`, reportHeadline(summary)))
+		convey.So(reportHeadline(summary), convey.ShouldStartWith, "Purge 20190701-120000: 2 tags to purge in 1 repositories, 0 deleted")
+		convey.So(NewWebhookSink(server.URL+"/fail").Publish(summary), convey.ShouldNotBeNil)
+	})
+
+	convey.Convey("Fan the final summary out to all the sinks", t, func() {
+		now := time.Now()
+		_, server := newFakeRegistry(map[string]map[string]time.Time{"app": {"v1": now.Add(-30 * 24 * time.Hour), "v2": now}})
+		defer server.Close()
+		failing, recording := &recordingSink{err: fmt.Errorf("unreachable")}, &recordingSink{}
+		opts := PurgeTagsOptions{KeepDays: 7, KeepCount: 1, DeleteWorkers: 2, DrainTimeout: time.Second, ReportSinks: []ReportSink{failing, recording}}
+		summary := PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), opts)
+		convey.So(failing.summaries, convey.ShouldHaveLength, 1)
+		convey.So(recording.summaries, convey.ShouldResemble, []*PurgeSummary{summary})
+		convey.So(summary.Finished.IsZero(), convey.ShouldBeFalse)
+		convey.So(strings.Join(summary.Errors, ""), convey.ShouldBeEmpty)
+	})
+}
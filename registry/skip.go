@@ -0,0 +1,76 @@
+package registry
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SkipReason why repos or tags were left out of the purge, counted in PurgeSummary.Skipped and logged
+// as "skip_reason=<reason>" so the skips can be aggregated.
+type SkipReason string
+
+// Reasons of the repos skipped, counting repos.
+const (
+	// SkipFiltered is the repo out of Namespaces.
+	SkipFiltered SkipReason = "filtered"
+	// SkipUnchanged is the repo not pushed to since the last run with WatermarkFile.
+	SkipUnchanged SkipReason = "unchanged"
+	// SkipCheckpoint is the repo already done by the run resumed from CheckpointFile.
+	SkipCheckpoint SkipReason = "checkpoint"
+	// SkipNotSampled is the repo left out of the sample with SamplePercent.
+	SkipNotSampled SkipReason = "not_sampled"
+	// SkipMaxDuration is the repo not started as MaxDuration was exceeded.
+	SkipMaxDuration SkipReason = "max_duration"
+	// SkipRepoGone is the repo deleted while scanning it.
+	SkipRepoGone SkipReason = "repo_gone"
+	// SkipRepoEmpty is the repo without tags, there is nothing to purge in it.
+	SkipRepoEmpty SkipReason = "empty"
+	// SkipRepoIncomplete is the repo which scan exceeded RepoMaxDuration or failed to list all its tags.
+	SkipRepoIncomplete SkipReason = "repo_incomplete"
+	// SkipMinTags is the repo having fewer tags than MinTagsBeforePurge.
+	SkipMinTags SkipReason = "min_tags"
+	// SkipDryRunOnly is the repo of a PurgeConfig with DryRunOnly having tags to purge.
+	SkipDryRunOnly SkipReason = "dry_run_only"
+)
+
+// Reasons of the tags skipped, counting tags.
+const (
+	// SkipNoTagRule is the tag matching no tags rule, kept without UnmatchedTagPurgePerGlobal.
+	SkipNoTagRule SkipReason = "no_tag_rule"
+	// SkipMissingManifest is the tag which manifest v1 and config blob could not be fetched, kept unprocessed.
+	SkipMissingManifest SkipReason = "missing_manifest"
+	// SkipArtifact is the artifact excluded by ExcludeArtifacts.
+	SkipArtifact SkipReason = "artifact"
+	// SkipRecentlyPushed is the tag to purge pushed within RecentPushGrace, not deleted.
+	SkipRecentlyPushed SkipReason = "recently_pushed"
+	// SkipTagGone is the tag of the plan which no longer exists.
+	SkipTagGone SkipReason = "tag_gone"
+	// SkipDigestChanged is the tag of the plan which references another manifest than the planned one.
+	SkipDigestChanged SkipReason = "digest_changed"
+)
+
+// addSkipped count n repos or tags skipped for the reason, safe for concurrent use.
+func (s *PurgeSummary) addSkipped(reason SkipReason, n int) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.Skipped == nil {
+		s.Skipped = map[SkipReason]int{}
+	}
+	s.Skipped[reason] = s.Skipped[reason] + n
+}
+
+// skip log the message with the reason by logf and count n repos or tags skipped for it.
+func (p *purger) skip(reason SkipReason, n int, logf func(format string, args ...interface{}), format string, args ...interface{}) {
+	logf(format+" skip_reason=%s", append(args, reason)...)
+	p.summary.addSkipped(reason, n)
+}
+
+// skippedText format the counts of the skips by reason as they are logged, e.g. "filtered=2 no_tag_rule=5".
+func skippedText(skipped map[SkipReason]int) string {
+	reasons := make([]string, 0, len(skipped))
+	for _, reason := range SortedMapKeys(skipped) {
+		reasons = append(reasons, fmt.Sprintf("%s=%d", reason, skipped[SkipReason(reason)]))
+	}
+	return strings.Join(reasons, " ")
+}
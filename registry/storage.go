@@ -0,0 +1,81 @@
+package registry
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/parnurzeal/gorequest"
+	"github.com/tidwall/gjson"
+)
+
+// StorageUsageProvider return the bytes used by the registry storage, see Client.StorageUsage.
+type StorageUsageProvider func() (int64, error)
+
+// StorageUsage get the bytes used by the registry storage from the URL, e.g. the metrics of a sidecar
+// measuring the storage volume or bucket, as the registry API does not expose it. A path starting with /
+// is relative to the registry and requested with its credentials, other URLs without them.
+// The response is either a plain number, a JSON document where metric is the gjson path of the number,
+// or Prometheus metrics where the samples of the metric are summed, e.g. over the filesystems.
+func (c *Client) StorageUsage(url, metric string) (int64, error) {
+	var request *gorequest.SuperAgent
+	if strings.HasPrefix(url, "/") {
+		url = c.url + url
+		request = c.newRequest()
+	} else {
+		request = gorequest.New()
+		request.Client.Transport = &limitedTransport{transport: c.roundTripper, max: c.maxResponseSize}
+	}
+	resp, data, errs := c.send(request.Get(url).Set("User-Agent", "docker-registry-ui"))
+	if len(errs) > 0 {
+		return 0, fmt.Errorf("failed to get storage usage: %s", errs[0])
+	}
+	if resp.StatusCode != 200 {
+		return 0, fmt.Errorf("failed to get storage usage from %s: %s", url, resp.Status)
+	}
+	return parseStorageUsage(data, metric)
+}
+
+// parseStorageUsage parse the bytes used out of a plain number, a JSON document or Prometheus metrics.
+func parseStorageUsage(data, metric string) (int64, error) {
+	data = strings.TrimSpace(data)
+	if n, err := strconv.ParseFloat(data, 64); err == nil {
+		return int64(n), nil
+	}
+	if strings.HasPrefix(data, "{") || strings.HasPrefix(data, "[") {
+		value := gjson.Get(data, metric)
+		if value.Type != gjson.Number {
+			return 0, fmt.Errorf("no storage usage number at %q", metric)
+		}
+		return value.Int(), nil
+	}
+	var total float64
+	found := false
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, metric) || len(line) == len(metric) || (line[len(metric)] != ' ' && line[len(metric)] != '{') {
+			continue
+		}
+		// The value follows the labels, an optional timestamp follows it.
+		sample := line[len(metric):]
+		if sample[0] == '{' {
+			sample = sample[strings.LastIndex(sample, "}")+1:]
+		}
+		fields := strings.Fields(sample)
+		if len(fields) == 0 {
+			continue
+		}
+		n, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid storage usage sample %q", line)
+		}
+		total = total + n
+		found = true
+	}
+	if !found {
+		return 0, fmt.Errorf("no storage usage metric %q", metric)
+	}
+	return int64(total), nil
+}
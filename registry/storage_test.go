@@ -0,0 +1,47 @@
+package registry
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestStorageUsage(t *testing.T) {
+	convey.Convey("Parse the storage usage of numbers, JSON and Prometheus metrics", t, func() {
+		metrics := "# HELP used_bytes Bytes used.\n# TYPE used_bytes gauge\n" +
+			"used_bytes{volume=\"a\"} 1000\nused_bytes{volume=\"b\"} 2e3 1590000000000\nused_bytes_total 5\n"
+		for data, metric := range map[string]string{
+			"3000\n":                      "",
+			`{"storage": {"used": 3000}}`: "storage.used",
+			metrics:                       "used_bytes",
+			"used_bytes 3000\nother 1\n":  "used_bytes",
+		} {
+			used, err := parseStorageUsage(data, metric)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(used, convey.ShouldEqual, 3000)
+		}
+		_, err := parseStorageUsage(metrics, "unknown")
+		convey.So(err, convey.ShouldNotBeNil)
+		_, err = parseStorageUsage(`{"used": "a lot"}`, "used")
+		convey.So(err, convey.ShouldNotBeNil)
+	})
+
+	convey.Convey("Get the storage usage from the registry or a sidecar", t, func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/metrics" {
+				w.Write([]byte("used_bytes 42\n"))
+			}
+		}))
+		defer server.Close()
+		client := NewClient(server.URL, false, "", "")
+		for _, url := range []string{"/metrics", server.URL + "/metrics"} {
+			used, err := client.StorageUsage(url, "used_bytes")
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(used, convey.ShouldEqual, 42)
+		}
+		_, err := client.StorageUsage("/missing", "used_bytes")
+		convey.So(err, convey.ShouldNotBeNil)
+	})
+}
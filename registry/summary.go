@@ -0,0 +1,192 @@
+package registry
+
+import (
+	"sync"
+	"time"
+)
+
+// PurgeSummary structured summary of a purging run.
+type PurgeSummary struct {
+	ID string `json:"id"`
+	// RunID correlates the logs, spans, metrics and reports of the run, see PurgeTagsOptions.RunID.
+	RunID    string        `json:"run_id"`
+	Started  time.Time     `json:"started"`
+	Finished time.Time     `json:"finished"`
+	DryRun   bool          `json:"dry_run"`
+	FailFast bool          `json:"fail_fast"`
+	Repos    []RepoSummary `json:"repos"`
+	// TagsDeleted and BytesReclaimed are zero on dry-run, bytes do not account layers shared between images and
+	// are only measured with PurgeTagsOptions.MeasureBytes.
+	TagsDeleted    int      `json:"tags_deleted"`
+	BytesReclaimed int64    `json:"bytes_reclaimed"`
+	Errors         []string `json:"errors"`
+	// Aborted is set when FailFast stopped the run on a deletion error or Confirm declined the deletions.
+	Aborted bool `json:"aborted"`
+	// TimedOut is set when MaxDuration stopped the run before all the repos were done.
+	TimedOut bool `json:"timed_out"`
+	// Sample is set on the dry-runs sampling the repos, see PurgeTagsOptions.SamplePercent.
+	Sample *PurgeSample `json:"sample,omitempty"`
+	// Skipped counts the repos or tags left out of the purge by reason, see SkipReason.
+	Skipped map[SkipReason]int `json:"skipped,omitempty"`
+
+	mux sync.Mutex
+}
+
+// RepoSummary structured summary of a repository within a purging run.
+type RepoSummary struct {
+	Repo      string   `json:"repo"`
+	TagsCount int      `json:"tags_count"`
+	Keep      []string `json:"keep"`
+	Purge     []string `json:"purge"`
+	Deleted   int      `json:"deleted"`
+	// Unprocessed tags could not be evaluated, e.g. on manifest fetch errors, so they are kept.
+	Unprocessed []string `json:"unprocessed"`
+	// OverTagCount is set when the repo has more tags than PurgeTagsOptions.WarnTagCount.
+	OverTagCount bool `json:"over_tag_count"`
+	// DryRunOnly is set when the repo matches a PurgeConfig with DryRunOnly, so Purge lists the tags it would purge.
+	DryRunOnly bool `json:"dry_run_only"`
+	// RecentlyPushed tags were selected for purging but not deleted as pushed within PurgeTagsOptions.RecentPushGrace.
+	RecentlyPushed []string `json:"recently_pushed"`
+	// Deferred tags were selected for purging but deferred to the next runs by PurgeTagsOptions.MaxDeletionsPerRepoPerRun.
+	Deferred []string `json:"deferred"`
+	// NotDeleted tags were deleted but their manifest was still resolvable with PurgeTagsOptions.VerifyDeletions.
+	NotDeleted []string `json:"not_deleted"`
+	// Unverified tags were deleted but the checks of PurgeTagsOptions.VerifyDeletions failed, they count as deleted.
+	Unverified []string `json:"unverified"`
+	// BytesToPurge is the size of the tags to purge with PurgeTagsOptions.MeasureBytes, not accounting layers
+	// shared between images.
+	BytesToPurge int64 `json:"bytes_to_purge"`
+	// Incomplete is set when scanning the repo exceeded PurgeTagsOptions.RepoMaxDuration or failed to list all its
+	// tags, it is left untouched then.
+	Incomplete bool `json:"incomplete"`
+	// Tags are the decisions on the tags with PurgeTagsOptions.TagDetails.
+	Tags []TagDetail `json:"tags,omitempty"`
+}
+
+// Duration return how long the run took rounded to seconds.
+func (s *PurgeSummary) Duration() time.Duration {
+	return s.Finished.Sub(s.Started).Round(time.Second)
+}
+
+// TagsToPurge count tags selected for purging across all repos.
+func (s *PurgeSummary) TagsToPurge() int {
+	count := 0
+	for _, r := range s.Repos {
+		count = count + len(r.Purge)
+	}
+	return count
+}
+
+// TagsUnprocessed count tags which could not be evaluated across all repos.
+func (s *PurgeSummary) TagsUnprocessed() int {
+	count := 0
+	for _, r := range s.Repos {
+		count = count + len(r.Unprocessed)
+	}
+	return count
+}
+
+// ReposEmptied return the repos which all tags are to purge, left empty once purged.
+func (s *PurgeSummary) ReposEmptied() []RepoSummary {
+	repos := []RepoSummary{}
+	for _, r := range s.Repos {
+		if len(r.Purge) > 0 && len(r.Keep) == 0 {
+			repos = append(repos, r)
+		}
+	}
+	return repos
+}
+
+// ReposIncomplete return the repos which scan was incomplete, see RepoSummary.Incomplete.
+func (s *PurgeSummary) ReposIncomplete() []string {
+	repos := []string{}
+	for _, r := range s.Repos {
+		if r.Incomplete {
+			repos = append(repos, r.Repo)
+		}
+	}
+	return repos
+}
+
+// ReposOverTagCount count repos having more tags than the warning threshold.
+func (s *PurgeSummary) ReposOverTagCount() int {
+	count := 0
+	for _, r := range s.Repos {
+		if r.OverTagCount {
+			count++
+		}
+	}
+	return count
+}
+
+// repo return the summary of the repo.
+func (s *PurgeSummary) repo(repo string) *RepoSummary {
+	for i := range s.Repos {
+		if s.Repos[i].Repo == repo {
+			return &s.Repos[i]
+		}
+	}
+	return nil
+}
+
+// repoCopy return a copy of the summary of the repo, safe for concurrent use.
+func (s *PurgeSummary) repoCopy(repo string) *RepoSummary {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if r := s.repo(repo); r != nil {
+		c := *r
+		return &c
+	}
+	return nil
+}
+
+// addDeleted record a tag deletion, safe for concurrent use.
+func (s *PurgeSummary) addDeleted(repo string, size int64) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.TagsDeleted++
+	s.BytesReclaimed = s.BytesReclaimed + size
+	if r := s.repo(repo); r != nil {
+		r.Deleted++
+	}
+}
+
+// addRecentlyPushed record a tag not deleted as pushed recently, safe for concurrent use.
+func (s *PurgeSummary) addRecentlyPushed(repo, tag string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if r := s.repo(repo); r != nil {
+		r.RecentlyPushed = append(r.RecentlyPushed, tag)
+	}
+}
+
+// addNotDeleted record a tag which manifest persisted after its deletion, safe for concurrent use.
+func (s *PurgeSummary) addNotDeleted(repo, tag string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if r := s.repo(repo); r != nil {
+		r.NotDeleted = append(r.NotDeleted, tag)
+	}
+}
+
+// addUnverified record a deleted tag which deletion could not be verified, safe for concurrent use.
+func (s *PurgeSummary) addUnverified(repo, tag string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if r := s.repo(repo); r != nil {
+		r.Unverified = append(r.Unverified, tag)
+	}
+}
+
+// addError record a run error, safe for concurrent use.
+func (s *PurgeSummary) addError(err error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.Errors = append(s.Errors, err.Error())
+}
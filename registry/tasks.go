@@ -1,17 +1,299 @@
 package registry
 
 import (
+	"context"
 	"fmt"
+	"math"
+	"regexp"
 	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/hhkbp2/go-logging"
 	"github.com/tidwall/gjson"
 )
 
+// Policies for the tags of a repo which do not match any TagConfig of its PurgeConfig.
+const (
+	// UnmatchedTagKeep keeps such tags untouched (default).
+	UnmatchedTagKeep = "keep"
+	// UnmatchedTagPurgePerGlobal applies the global keep days/count to such tags.
+	UnmatchedTagPurgePerGlobal = "purge-per-global"
+)
+
+// Policies for the tags to purge which manifest is also referenced by a kept tag of the repo.
+const (
+	// SharedManifestKeep keeps such tags as deleting the manifest would delete the kept tags too (default).
+	SharedManifestKeep = "keep"
+	// SharedManifestDelete deletes such tags along with the kept ones sharing the manifest, logging a warning.
+	SharedManifestDelete = "delete"
+)
+
+// Sources of the tag ages.
+const (
+	// AgeCreated takes the age from the image creation date, i.e. when it was built (default).
+	AgeCreated = "created"
+	// AgeUploaded takes the age from when the manifest was pushed, as reported by its Last-Modified header,
+	// falling back to the creation date where the registry does not report it.
+	AgeUploaded = "uploaded"
+	// AgeFirstSeen takes the age from when a run first saw the tag referencing its manifest digest, as recorded
+	// in PurgeTagsOptions.FirstSeenFile, so it is the age in this registry whatever the build date. The tags
+	// seen for the first time, or re-pushed, are aged from the start of the run.
+	AgeFirstSeen = "first-seen"
+)
+
+// PurgeModeDeleteAll purges every tag of the matched repos regardless of age and count,
+// except for the ones protected by KeepRegex. It requires an explicit confirmation unless on dry-run.
+const PurgeModeDeleteAll = "deleteAll"
+
+// TagConfig retention rule for the tags matching TagsRegex or any of TagsRegexes.
+type TagConfig struct {
+	TagsRegex string `yaml:"tags_regex"`
+	KeepDays  int    `yaml:"keep_days"`
+	KeepCount int    `yaml:"keep_count"`
+	// TagsRegexes are combined with TagsRegex, so several patterns share the retention.
+	TagsRegexes []string `yaml:"tags_regexes"`
+	// CaseInsensitive compiles TagsRegex and TagsRegexes with the "i" flag.
+	CaseInsensitive bool `yaml:"case_insensitive"`
+	// KeepPerDay switches to keeping the newest KeepPerDay tags of every calendar day created within
+	// the last KeepPerDayWindow days, the older ones are purged. KeepDays is ignored then.
+	KeepPerDay       int `yaml:"keep_per_day"`
+	KeepPerDayWindow int `yaml:"keep_per_day_window"`
+	// Tiers switch to the tiered retention, see RetentionTier. KeepDays and KeepPerDay are ignored then.
+	Tiers []RetentionTier `yaml:"tiers"`
+	// KeepByListOrder protects the last N tags of the tag list, assuming the registry lists tags in push order,
+	// regardless of their creation dates, or the first N ones when ListNewestFirst. Note, Docker registry
+	// lists tags sorted by name, so this only fits registries returning them in push order.
+	KeepByListOrder int  `yaml:"keep_by_list_order"`
+	ListNewestFirst bool `yaml:"list_newest_first"`
+	// KeepByNameOrder protects the N lexically highest tag names regardless of their creation dates, e.g. date-stamped
+	// tags like 20240101, or the N lowest ones when NameOrderAscending. Numbers must have the same width to sort right.
+	KeepByNameOrder    int  `yaml:"keep_by_name_order"`
+	NameOrderAscending bool `yaml:"name_order_ascending"`
+	// PurgeSeverity additionally requires the tags selected for purging to have a vulnerability of that
+	// severity or higher found by PurgeTagsOptions.VulnProvider, the other ones are kept.
+	PurgeSeverity string `yaml:"purge_severity"`
+	// MaxAgeDays purges the tags older than that even when the strategy keeps them, e.g. by KeepCount,
+	// so nothing older survives. 0 disables the cap.
+	MaxAgeDays int `yaml:"max_age_days"`
+	// DeleteAfterDays keeps the tags selected for purging until every run selected them for that many days,
+	// as recorded in PurgeTagsOptions.TombstoneFile, giving a grace period to accidental selections.
+	DeleteAfterDays int `yaml:"delete_after_days"`
+	// DeletionPriority is which of the tags to purge are deleted first when MaxDeletionsPerRepoPerRun caps them,
+	// DeletionOldestFirst by default.
+	DeletionPriority string `yaml:"deletion_priority"`
+}
+
+// Deletion priorities of the tags to purge under MaxDeletionsPerRepoPerRun.
+const (
+	DeletionOldestFirst = "oldest"
+	// DeletionLargestFirst reclaims the most space first.
+	DeletionLargestFirst = "largest"
+	// DeletionMostTagsFirst deletes the manifests referenced by the most tags of the repo first, as deleting
+	// a manifest deletes all its tags.
+	DeletionMostTagsFirst = "most-tags"
+)
+
+// Periods a RetentionTier keeps tags per.
+const (
+	TierPerDay   = "day"
+	TierPerWeek  = "week"
+	TierPerMonth = "month"
+)
+
+// RetentionTier keeps the tags created within the last WithinDays days not covered by the previous tier:
+// all of them when Per is empty, otherwise the newest Keep tags (1 by default) of every calendar period,
+// weeks being ISO ones. Tiers are ordered by WithinDays and the tags older than the last one are purged.
+type RetentionTier struct {
+	WithinDays int    `yaml:"within_days"`
+	Keep       int    `yaml:"keep"`
+	Per        string `yaml:"per"`
+}
+
+// PurgeConfig retention rules for the repositories matching RepoRegex.
+type PurgeConfig struct {
+	RepoRegex string      `yaml:"repo_regex"`
+	Tags      []TagConfig `yaml:"tags"`
+	// CaseInsensitive compiles RepoRegex with the "i" flag.
+	CaseInsensitive bool `yaml:"case_insensitive"`
+	// KeepRegex protects the matching tags from purging no matter the other rules.
+	KeepRegex string `yaml:"keep_regex"`
+	// Mode is either empty for the regular retention or PurgeModeDeleteAll.
+	Mode string `yaml:"mode"`
+	// DryRunOnly makes the matching repos analyzed and reported but never purged, even on a live run.
+	DryRunOnly bool `yaml:"dry_run_only"`
+}
+
+// PurgeTagsOptions options of the purging task.
+type PurgeTagsOptions struct {
+	DryRun bool
+	// KeepDays, KeepCount and MaxAgeDays form the catch-all rule applied to repos matching no PurgeConfig.
+	KeepDays   int
+	KeepCount  int
+	MaxAgeDays int
+	// ZeroMeansUnlimited makes a KeepDays or KeepCount of 0 keep all the tags of the catch-all rule, so leaving
+	// either unset never purges everything. -1 then explicitly keeps no tags by days or by count.
+	ZeroMeansUnlimited bool
+	Configs            []PurgeConfig
+	// ConfigsArtifact replaces Configs by the purge_configs of the YAML file pushed as an OCI artifact to the
+	// "repo:tag" of the registry, "latest" by default, fetched at the start of every run so the retention config
+	// is versioned along the images. Configs apply while the artifact does not exist.
+	ConfigsArtifact string
+	// CreatedCache caches the creation dates of the manifests by digest across runs, e.g. NewMemoryCreatedCache
+	// shared by the scheduled runs, costing a HEAD request per tag instead of fetching its manifest and config blob.
+	// It does not apply to AgeUploaded and AgeFirstSeen.
+	CreatedCache CreatedCache
+	// MinTagsBeforePurge leaves the repos having fewer tags untouched, e.g. so a repo of 3 tags is not trimmed to 1.
+	// It does not apply to PurgeModeDeleteAll configs, 0 disables it.
+	MinTagsBeforePurge int
+	// UnmatchedTagPolicy is either UnmatchedTagKeep or UnmatchedTagPurgePerGlobal.
+	UnmatchedTagPolicy string
+	// LogTagsLimit truncates the lists of all the tags, the ones to keep and the ones to purge logged per repo
+	// to that many tags, e.g. so repos of thousands of tags do not log huge lines. 0 logs them all.
+	LogTagsLimit int
+	// QuietSkips logs the count of the tags matching no tags rule once per repo instead of every such tag.
+	QuietSkips bool
+	// SharedManifestPolicy is either SharedManifestKeep or SharedManifestDelete.
+	SharedManifestPolicy string
+	// AgeSource is either AgeCreated, AgeUploaded or AgeFirstSeen.
+	AgeSource string
+	// GroupByManifest counts the tags referencing the same manifest once for retention, e.g. 1.2.3, 1.2 and 1,
+	// so they are kept or purged together. It costs an extra manifest request per tag.
+	GroupByManifest bool
+	// ScanWorkers is the number of repos scanned concurrently, 1 by default.
+	ScanWorkers int
+	// InterRepoDelay is how long to wait between starting to scan repos, e.g. to gently pace the scan of a
+	// sensitive registry, whatever ScanWorkers is. 0 for no delay.
+	InterRepoDelay time.Duration
+	// TagWorkers is the number of tags of a repo fetched concurrently, 1 by default.
+	// Bound the total number of concurrent requests with Client.SetMaxConcurrentRequests.
+	TagWorkers int
+	// DeleteWorkers is the number of concurrent deletions, 1 by default.
+	DeleteWorkers int
+	// MaxDeletionsPerRepoPerRun purges only that many tags of a repo per run, the oldest ones unless ordered by
+	// TagConfig.DeletionPriority, the other ones selected for purging are deferred to the next runs, so repos with
+	// large backlogs are cleaned up gradually. The tags known to share a manifest are deleted at once and count once.
+	// Companions following their subjects with DeleteCompanions are not counted. 0 for no limit.
+	MaxDeletionsPerRepoPerRun int
+	// DrainTimeout is how long in-flight deletions may complete once the purge is cancelled, 0 waits for them
+	// without limit.
+	DrainTimeout time.Duration
+	// RecentPushGrace skips the deletion of the tags which manifest was modified within that duration according
+	// to its Last-Modified header right before deleting it, as they may be pushed concurrently, e.g. by CI jobs.
+	// The manifests without Last-Modified are deleted. 0 disables the check.
+	RecentPushGrace time.Duration
+	// VerifyDeletions checks after each deletion that the manifest digest is no longer resolvable, retrying
+	// for up to that duration as some registries accept the deletion but keep serving the manifest for a while
+	// or silently fail it. The tags still resolvable are reported as not deleted, the ones which checks keep
+	// failing count as deleted and are reported as not verified. 0 disables the check.
+	VerifyDeletions time.Duration
+	// MaxDuration stops the run from starting new repos once it takes longer, in-flight deletions still
+	// complete. 0 for no limit.
+	MaxDuration time.Duration
+	// RepoMaxDuration stops scanning a repo once it takes longer, e.g. one with tens of thousands of tags, so it
+	// cannot starve the run. The repo is left untouched, as its retention cannot be decided from a part of its tags,
+	// and marked RepoSummary.Incomplete. 0 for no limit.
+	RepoMaxDuration time.Duration
+	// CheckpointFile keeps the repos purged by a run stopped on MaxDuration for the next run to skip them,
+	// it is removed once a run completes. It is not used on dry-run.
+	CheckpointFile string
+	// WatermarkFile keeps when the last run started, so with ChangedRepos the next runs only scan the repos
+	// pushed to since then, all of them when ChangedRepos cannot tell. It is written once a run completes
+	// without errors, not on dry-run. Note, tags aging past KeepDays in repos not pushed to are only purged
+	// by the runs scanning all the repos.
+	WatermarkFile string
+	ChangedRepos  ChangedReposProvider
+	// TombstoneFile keeps when the tags of the TagConfigs with DeleteAfterDays were first selected for purging
+	// across runs. It is read but not written on dry-run.
+	TombstoneFile string
+	// FirstSeenFile keeps when the runs first saw the tags across runs for AgeFirstSeen. The tags which no longer
+	// exist are pruned from it. It is written on dry-run too, as seeing the tags deletes nothing.
+	FirstSeenFile string
+	// TagDetails adds RepoSummary.Tags, the decision on every tag with its reason, creation date and where
+	// it was taken from, to audit the retention.
+	TagDetails bool
+	// ReportSinks are published the final summary of the run, see ReportSink.
+	ReportSinks []ReportSink
+	// RunID tags every log line, span, metric and report of the run, e.g. a CI build ID to correlate them with
+	// external systems. A random one is generated when empty.
+	RunID string
+	// Progress is called as repos start and finish and as their tags are deleted, calls are serialized
+	// but it should not block for long as it holds up the purge.
+	Progress func(PurgeProgress)
+	// ExcludeArtifacts keeps OCI artifacts such as Helm charts and SBOMs so repos of artifacts are left untouched.
+	// It costs an extra manifest request per tag.
+	ExcludeArtifacts bool
+	// Namespaces limits the purge to the repos of these top-level namespaces, "library" being the one of
+	// the repos without namespace. Empty for all. PurgeConfig rules still apply within them.
+	Namespaces []string
+	// MeasureBytes sums the image sizes of the tags to purge per repo into RepoSummary.BytesToPurge, also on dry-run,
+	// and the ones of the deleted tags into PurgeSummary.BytesReclaimed. It costs an extra manifest request per tag
+	// to purge.
+	MeasureBytes bool
+	// TagsKeepIfLargerThanBytes keeps the tags to purge which image size exceeds it, e.g. big base images among
+	// small CI ones, as well as the ones which size cannot be fetched. It costs an extra manifest request per tag
+	// to purge, 0 disables it.
+	TagsKeepIfLargerThanBytes int64
+	// StorageUsage is logged before and after the deletion of a live purge, to correlate the deletions with
+	// the disk usage, which only drops once the garbage collection ran. Failing to get it is logged only.
+	StorageUsage StorageUsageProvider
+	// SamplePercent analyzes only that percentage of the repos, chosen by hashing their names so every run samples
+	// the same ones, and extrapolates the totals into PurgeSummary.Sample for a fast impact preview. It requires DryRun.
+	SamplePercent float64
+	// Repos limits the purge to these repos, e.g. a sample of them to check the config on, scanned without listing
+	// the catalog. Empty for all.
+	Repos []string
+	// CrossRepoProtection keeps the tags to purge which manifest is still referenced by a tag of another repo
+	// of the run, or by a manifest list of it. It costs a manifest request per tag kept across the run.
+	CrossRepoProtection bool
+	// IndexChildProtection keeps the tags to purge which manifest is a child of the manifest list or image index
+	// of a kept tag of the same repo, as deleting it would leave the index dangling. It costs a manifest request
+	// per kept tag of the repos having tags to purge.
+	IndexChildProtection bool
+	// DeleteCompanions purges the cosign signature, attestations and SBOM tags, named sha256-<digest>.sig, .att and
+	// .sbom after the manifest they are attached to, and the OCI referrers index tags along with that subject,
+	// so they are not left orphaned. They are kept with their subject otherwise, whatever the tags rules.
+	DeleteCompanions bool
+	// KeepAttested keeps the tags to purge which manifest has cosign attestations, i.e. a sha256-<digest>.att tag.
+	KeepAttested bool
+	// InUseProvider protects the tags of the images it returns in use from purging, referenced either by tag
+	// or by digest. It is called once at the start of the run, which fails if it does.
+	InUseProvider InUseProvider
+	// ProtectedTagsProvider protects the tags named after the names it returns, e.g. the live git branches,
+	// and after the commit SHAs of the branch heads when it returns them, before the tags rules apply so they
+	// do not count towards KeepCount. It is called once at the start of the run, which fails if it does.
+	ProtectedTagsProvider ProtectedTagsProvider
+	// VulnProvider is required by the TagConfigs with PurgeSeverity.
+	VulnProvider VulnProvider
+	// WarnTagCount logs a warning for the repos having more tags, e.g. to catch runaway CI, 0 disables it.
+	WarnTagCount int
+	// PlanFile is where a dry-run writes the tags to purge with their digests, see ApplyPurgePlan.
+	PlanFile string
+	// FailFast aborts the run on the first deletion error, otherwise errors are collected and the run completes.
+	FailFast bool
+	// ConfirmDeleteAll confirms deleting all tags of the repos matching a PurgeModeDeleteAll config.
+	ConfirmDeleteAll bool
+	// Confirm is called with the tags to purge by repo once all are analyzed, e.g. to ask an operator,
+	// nothing is deleted unless it returns true. It is not called on dry-run or when there is nothing to purge.
+	Confirm func(purge map[string][]string) bool
+	// Location makes keep days count calendar days in the timezone so they start at its midnight,
+	// otherwise whole 24h periods elapsed since the tag creation are counted.
+	Location *time.Location
+	// AnchorMatch makes repo and tags regexes match the whole name as if wrapped into ^...$,
+	// otherwise they match anywhere in the name, e.g. "prod" matches "non-prod-app".
+	AnchorMatch bool
+}
+
 type tagData struct {
 	name    string
 	created time.Time
+	// index is the position of the tag in the tag list returned by the registry.
+	index int
+	// source is where created was taken from, one of the DateSource constants.
+	source string
 }
 
 func (t tagData) String() string {
@@ -32,46 +314,1641 @@ func (p timeSlice) Swap(i, j int) {
 	p[i], p[j] = p[j], p[i]
 }
 
-// PurgeOldTags purge old tags.
-func PurgeOldTags(client *Client, purgeDryRun bool, purgeTagsKeepDays, purgeTagsKeepCount int) {
-	logger := SetupLogging("registry.tasks.PurgeOldTags")
+// strings return the tags with their creation dates as they are logged.
+func (p timeSlice) strings() []string {
+	items := make([]string, len(p))
+	for i, t := range p {
+		items[i] = t.String()
+	}
+	return items
+}
+
+// logList format the items for the log, only the first limit ones followed by the count of the others
+// unless limit is 0.
+func logList(items []string, limit int) string {
+	if limit > 0 && len(items) > limit {
+		return fmt.Sprintf("%v and %d more", items[:limit], len(items)-limit)
+	}
+	return fmt.Sprintf("%v", items)
+}
+
+// patterns return TagsRegex along with TagsRegexes, just TagsRegex even if empty when there are no TagsRegexes.
+func (t TagConfig) patterns() []string {
+	if t.TagsRegex == "" && len(t.TagsRegexes) > 0 {
+		return t.TagsRegexes
+	}
+	return append([]string{t.TagsRegex}, t.TagsRegexes...)
+}
+
+// tagsRegex return the patterns combined as alternatives.
+func (t TagConfig) tagsRegex() string {
+	patterns := t.patterns()
+	if len(patterns) == 1 {
+		return patterns[0]
+	}
+	alternatives := make([]string, len(patterns))
+	for i, p := range patterns {
+		alternatives[i] = "(?:" + p + ")"
+	}
+	return strings.Join(alternatives, "|")
+}
+
+type tagRule struct {
+	regex  *regexp.Regexp
+	config TagConfig
+}
+
+type repoRule struct {
+	regex      *regexp.Regexp
+	tags       []tagRule
+	keep       *regexp.Regexp
+	deleteAll  bool
+	dryRunOnly bool
+}
+
+// compileRegex compile the pattern optionally matching the whole string and case-insensitively.
+func compileRegex(pattern string, anchor, caseInsensitive bool) (*regexp.Regexp, error) {
+	if anchor {
+		pattern = "^(?:" + pattern + ")$"
+	}
+	if caseInsensitive {
+		pattern = "(?i)" + pattern
+	}
+	return regexp.Compile(pattern)
+}
+
+// unanchoredPatterns list the configured regexes lacking either ^ or $ which therefore match substrings.
+func unanchoredPatterns(configs []PurgeConfig) []string {
+	patterns := []string{}
+	unanchored := func(p string) bool {
+		return !strings.HasPrefix(p, "^") || !strings.HasSuffix(p, "$")
+	}
+	for _, c := range configs {
+		if unanchored(c.RepoRegex) {
+			patterns = append(patterns, c.RepoRegex)
+		}
+		if c.KeepRegex != "" && unanchored(c.KeepRegex) {
+			patterns = append(patterns, c.KeepRegex)
+		}
+		for _, t := range c.Tags {
+			for _, p := range t.patterns() {
+				if unanchored(p) {
+					patterns = append(patterns, p)
+				}
+			}
+		}
+	}
+	return patterns
+}
+
+// shadowedPatterns list the tags regexes which an earlier tags rule of the same config already has,
+// so they never apply as every tag follows the first tags rule matching it.
+func shadowedPatterns(configs []PurgeConfig) []string {
+	shadowed := []string{}
+	for _, c := range configs {
+		seen := map[string]bool{}
+		for _, t := range c.Tags {
+			for _, p := range t.patterns() {
+				if seen[p] {
+					shadowed = append(shadowed, fmt.Sprintf("tags regex %q of repo regex %q", p, c.RepoRegex))
+				}
+			}
+			for _, p := range t.patterns() {
+				seen[p] = true
+			}
+		}
+	}
+	return shadowed
+}
+
+// catchAllRetention return the keep days and count of the catch-all rule, see ZeroMeansUnlimited.
+func catchAllRetention(opts PurgeTagsOptions) (keepDays, keepCount int) {
+	keepDays, keepCount = opts.KeepDays, opts.KeepCount
+	if opts.ZeroMeansUnlimited {
+		if keepDays == 0 {
+			keepDays = math.MaxInt32
+		}
+		if keepCount == 0 {
+			keepCount = math.MaxInt32
+		}
+	}
+	return keepDays, keepCount
+}
+
+// compileRules compile purge configs into rules and append the global catch-all one.
+func compileRules(opts PurgeTagsOptions) ([]*repoRule, error) {
+	keepDays, keepCount := catchAllRetention(opts)
+	catchAll := PurgeConfig{RepoRegex: ".*", Tags: []TagConfig{{TagsRegex: ".*", KeepDays: keepDays, KeepCount: keepCount, MaxAgeDays: opts.MaxAgeDays}}}
+	rules := []*repoRule{}
+	for _, c := range append(opts.Configs, catchAll) {
+		r, err := compileRegex(c.RepoRegex, opts.AnchorMatch, c.CaseInsensitive)
+		if err != nil {
+			return nil, fmt.Errorf("invalid repo regex %q: %s", c.RepoRegex, err)
+		}
+		rule := &repoRule{regex: r, dryRunOnly: c.DryRunOnly}
+		switch c.Mode {
+		case "":
+		case PurgeModeDeleteAll:
+			rule.deleteAll = true
+		default:
+			return nil, fmt.Errorf("invalid mode %q of repo regex %q", c.Mode, c.RepoRegex)
+		}
+		if c.KeepRegex != "" {
+			if rule.keep, err = compileRegex(c.KeepRegex, opts.AnchorMatch, c.CaseInsensitive); err != nil {
+				return nil, fmt.Errorf("invalid keep regex %q of repo regex %q: %s", c.KeepRegex, c.RepoRegex, err)
+			}
+		}
+		for _, t := range c.Tags {
+			tagsRegex := t.tagsRegex()
+			if err := validateTagsRegexes(t.TagsRegexes); err != nil {
+				return nil, fmt.Errorf("invalid tags regexes of repo regex %q: %s", c.RepoRegex, err)
+			}
+			r, err := compileRegex(tagsRegex, opts.AnchorMatch, t.CaseInsensitive)
+			if err != nil {
+				return nil, fmt.Errorf("invalid tags regex %q of repo regex %q: %s", tagsRegex, c.RepoRegex, err)
+			}
+			if err := validateTiers(t.Tiers); err != nil {
+				return nil, fmt.Errorf("invalid tiers of tags regex %q of repo regex %q: %s", tagsRegex, c.RepoRegex, err)
+			}
+			if t.PurgeSeverity != "" && severityRank(t.PurgeSeverity) < 0 {
+				return nil, fmt.Errorf("invalid purge severity %q of tags regex %q of repo regex %q", t.PurgeSeverity, tagsRegex, c.RepoRegex)
+			}
+			if t.PurgeSeverity != "" && opts.VulnProvider == nil {
+				return nil, fmt.Errorf("purge severity of tags regex %q of repo regex %q requires a vulnerability provider", tagsRegex, c.RepoRegex)
+			}
+			switch t.DeletionPriority {
+			case "", DeletionOldestFirst, DeletionLargestFirst, DeletionMostTagsFirst:
+			default:
+				return nil, fmt.Errorf("invalid deletion priority %q of tags regex %q of repo regex %q", t.DeletionPriority, tagsRegex, c.RepoRegex)
+			}
+			if t.DeleteAfterDays > 0 && opts.TombstoneFile == "" {
+				return nil, fmt.Errorf("delete after days of tags regex %q of repo regex %q requires a tombstone file", tagsRegex, c.RepoRegex)
+			}
+			rule.tags = append(rule.tags, tagRule{regex: r, config: t})
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// validateTagsRegexes check the regexes are neither empty nor duplicated, each one has to be compiled on its own
+// as a pattern combined with the others may compile even when it does not.
+func validateTagsRegexes(regexes []string) error {
+	seen := map[string]bool{}
+	for _, r := range regexes {
+		if r == "" {
+			return fmt.Errorf("empty regex")
+		}
+		if seen[r] {
+			return fmt.Errorf("duplicate regex %q", r)
+		}
+		seen[r] = true
+		if _, err := regexp.Compile(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateTiers check the tiers are ordered by age and keep per a known period.
+func validateTiers(tiers []RetentionTier) error {
+	for i, t := range tiers {
+		switch t.Per {
+		case "", TierPerDay, TierPerWeek, TierPerMonth:
+		default:
+			return fmt.Errorf("unknown period %q", t.Per)
+		}
+		if t.Keep < 0 {
+			return fmt.Errorf("negative keep %d", t.Keep)
+		}
+		if i > 0 && t.WithinDays <= tiers[i-1].WithinDays {
+			return fmt.Errorf("within_days %d is not greater than the previous tier's %d", t.WithinDays, tiers[i-1].WithinDays)
+		}
+	}
+	return nil
+}
+
+// matchRepoRule return the first rule matching the repo, the catch-all one matches any.
+func matchRepoRule(rules []*repoRule, repo string) *repoRule {
+	for _, r := range rules {
+		if r.regex.FindStringIndex(repo) != nil {
+			return r
+		}
+	}
+	return rules[len(rules)-1]
+}
+
+// matchTag return the index of the first tag rule matching the tag or -1.
+func (r *repoRule) matchTag(tag string) int {
+	for i, t := range r.tags {
+		if t.regex.FindStringIndex(tag) != nil {
+			return i
+		}
+	}
+	return -1
+}
+
+// clock current time of the purging run to compute tag ages with.
+type clock struct {
+	now time.Time
+	// loc makes ages count calendar days in the location instead of elapsed 24h periods.
+	loc *time.Location
+}
+
+// ageDays return the age in days of the tag created at the given time.
+func (c clock) ageDays(created time.Time) int {
+	if c.loc == nil {
+		return int(c.now.Sub(created).Hours() / 24)
+	}
+	// Compare dates at UTC midnight so DST transitions do not make days shorter or longer.
+	y, m, d := c.now.In(c.loc).Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	y, m, d = created.In(c.loc).Date()
+	day := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	return int(today.Sub(day).Hours() / 24)
+}
+
+// filterTags split tags sorted from newest to oldest into the ones to keep and purge.
+func filterTags(tags timeSlice, clk clock, keepDays, keepCount int) (keep, purge []string) {
+	// Filter out tags by retention days.
+	for _, tag := range tags {
+		delta := clk.ageDays(tag.created)
+		if delta > keepDays {
+			purge = append(purge, tag.name)
+		} else {
+			keep = append(keep, tag.name)
+		}
+	}
+
+	return keepMinCount(keep, purge, keepCount)
+}
+
+// keepMinCount keep minimal count of tags no matter how old they are, purge ones are sorted from newest to oldest.
+func keepMinCount(keep, purge []string, keepCount int) ([]string, []string) {
+	if len(keep) < keepCount {
+		if len(purge) > keepCount {
+			keep = append(keep, purge[:keepCount]...)
+			purge = purge[keepCount:]
+		} else {
+			keep = append(keep, purge...)
+			purge = nil
+		}
+	}
+	return keep, purge
+}
+
+// filterTagsPerDay split tags sorted from newest to oldest keeping the newest keepPerDay tags of every
+// calendar day within the window of days and purging all the older ones.
+func filterTagsPerDay(tags timeSlice, clk clock, keepPerDay, window, keepCount int) (keep, purge []string) {
+	loc := clk.loc
+	if loc == nil {
+		loc = time.UTC
+	}
+	perDay := map[string]int{}
+	for _, tag := range tags {
+		day := tag.created.In(loc).Format("2006-01-02")
+		if clk.ageDays(tag.created) <= window && perDay[day] < keepPerDay {
+			perDay[day]++
+			keep = append(keep, tag.name)
+		} else {
+			purge = append(purge, tag.name)
+		}
+	}
+	return keepMinCount(keep, purge, keepCount)
+}
+
+// period return the calendar period of the time in the location, e.g. "2019-W27" for a week.
+func period(t time.Time, per string, loc *time.Location) string {
+	t = t.In(loc)
+	switch per {
+	case TierPerWeek:
+		y, w := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", y, w)
+	case TierPerMonth:
+		return t.Format("2006-01")
+	}
+	return t.Format("2006-01-02")
+}
+
+// filterTagsTiered split tags sorted from newest to oldest assigning each tag to the first tier covering its age
+// to keep all the tags of the tier or the newest ones of every period, tags older than the last tier are purged.
+func filterTagsTiered(tags timeSlice, clk clock, tiers []RetentionTier, keepCount int) (keep, purge []string) {
+	loc := clk.loc
+	if loc == nil {
+		loc = time.UTC
+	}
+	// Count kept tags per tier and period, a period spanning two tiers is counted in each separately.
+	kept := map[string]int{}
+	for _, tag := range tags {
+		age := clk.ageDays(tag.created)
+		tier := -1
+		for i, t := range tiers {
+			if age <= t.WithinDays {
+				tier = i
+				break
+			}
+		}
+		if tier < 0 {
+			purge = append(purge, tag.name)
+			continue
+		}
+		t := tiers[tier]
+		if t.Per == "" {
+			keep = append(keep, tag.name)
+			continue
+		}
+		limit := t.Keep
+		if limit == 0 {
+			limit = 1
+		}
+		key := fmt.Sprintf("%d/%s", tier, period(tag.created, t.Per, loc))
+		if kept[key] < limit {
+			kept[key]++
+			keep = append(keep, tag.name)
+		} else {
+			purge = append(purge, tag.name)
+		}
+	}
+	return keepMinCount(keep, purge, keepCount)
+}
+
+// protectByListOrder split tags into the last n ones of the tag list, or the first n ones when it lists
+// newest first, and the rest keeping their order.
+func protectByListOrder(tags timeSlice, n int, newestFirst bool) (protected []string, rest timeSlice) {
+	if n >= len(tags) {
+		for _, t := range tags {
+			protected = append(protected, t.name)
+		}
+		return protected, nil
+	}
+	indexes := make([]int, len(tags))
+	for i, t := range tags {
+		indexes[i] = t.index
+	}
+	sort.Ints(indexes)
+	for _, t := range tags {
+		if (newestFirst && t.index <= indexes[n-1]) || (!newestFirst && t.index >= indexes[len(indexes)-n]) {
+			protected = append(protected, t.name)
+		} else {
+			rest = append(rest, t)
+		}
+	}
+	return protected, rest
+}
+
+// protectByNameOrder split tags into the n lexically highest ones, or the lowest ones when ascending,
+// and the rest keeping their order.
+func protectByNameOrder(tags timeSlice, n int, ascending bool) (protected []string, rest timeSlice) {
+	names := make([]string, len(tags))
+	for i, t := range tags {
+		names[i] = t.name
+	}
+	if ascending {
+		sort.Strings(names)
+	} else {
+		sort.Sort(sort.Reverse(sort.StringSlice(names)))
+	}
+	if n > len(names) {
+		n = len(names)
+	}
+	top := map[string]bool{}
+	for _, name := range names[:n] {
+		top[name] = true
+	}
+	for _, t := range tags {
+		if top[t.name] {
+			protected = append(protected, t.name)
+		} else {
+			rest = append(rest, t)
+		}
+	}
+	return protected, rest
+}
+
+// filter split tags sorted from newest to oldest into the ones to keep and purge by the retention strategy.
+func (c TagConfig) filter(tags timeSlice, clk clock) (keep, purge []string) {
+	if c.MaxAgeDays > 0 {
+		maxAgeDays := c.MaxAgeDays
+		c.MaxAgeDays = 0
+		keep, purge = c.filter(tags, clk)
+		return capMaxAge(tags, clk, maxAgeDays, keep, purge)
+	}
+	if c.KeepByListOrder > 0 {
+		protected, rest := protectByListOrder(tags, c.KeepByListOrder, c.ListNewestFirst)
+		c.KeepByListOrder = 0
+		keep, purge = c.filter(rest, clk)
+		return append(protected, keep...), purge
+	}
+	if c.KeepByNameOrder > 0 {
+		protected, rest := protectByNameOrder(tags, c.KeepByNameOrder, c.NameOrderAscending)
+		c.KeepByNameOrder = 0
+		keep, purge = c.filter(rest, clk)
+		return append(protected, keep...), purge
+	}
+	if len(c.Tiers) > 0 {
+		return filterTagsTiered(tags, clk, c.Tiers, c.KeepCount)
+	}
+	if c.KeepPerDay > 0 {
+		return filterTagsPerDay(tags, clk, c.KeepPerDay, c.KeepPerDayWindow, c.KeepCount)
+	}
+	return filterTags(tags, clk, c.KeepDays, c.KeepCount)
+}
+
+// capMaxAge move the tags to keep older than maxAgeDays to the ones to purge.
+func capMaxAge(tags timeSlice, clk clock, maxAgeDays int, keep, purge []string) ([]string, []string) {
+	created := map[string]time.Time{}
+	for _, t := range tags {
+		created[t.name] = t.created
+	}
+	capped := []string{}
+	for _, tag := range keep {
+		if clk.ageDays(created[tag]) > maxAgeDays {
+			purge = append(purge, tag)
+		} else {
+			capped = append(capped, tag)
+		}
+	}
+	return capped, purge
+}
+
+// selectTags split tags sorted from newest to oldest into the ones to keep, purge and the unmatched ones.
+// Tags matching no tag rule are kept unless the unmatched rule is given.
+func (r *repoRule) selectTags(tags timeSlice, clk clock, unmatched *tagRule) (keep, purge, skipped []string) {
+	return r.selectTagsBy(tags, clk, unmatched, nil)
+}
+
+// selectTagsBy select tags like selectTags keeping the ones selected for purging which confirm rejects.
+func (r *repoRule) selectTagsBy(tags timeSlice, clk clock, unmatched *tagRule, confirm func(c TagConfig, tag string) bool) (keep, purge, skipped []string) {
+	groups := make([]timeSlice, len(r.tags))
+	var rest timeSlice
+	for _, t := range tags {
+		if r.keep != nil && r.keep.FindStringIndex(t.name) != nil {
+			keep = append(keep, t.name)
+		} else if r.deleteAll {
+			purge = append(purge, t.name)
+		} else if i := r.matchTag(t.name); i >= 0 {
+			groups[i] = append(groups[i], t)
+		} else {
+			rest = append(rest, t)
+			skipped = append(skipped, t.name)
+		}
+	}
+	if unmatched != nil && len(rest) > 0 {
+		groups = append(groups, rest)
+	} else {
+		for _, t := range rest {
+			keep = append(keep, t.name)
+		}
+	}
+
+	for i, g := range groups {
+		var c TagConfig
+		if i < len(r.tags) {
+			c = r.tags[i].config
+		} else {
+			c = unmatched.config
+		}
+		k, p := c.filter(g, clk)
+		keep = append(keep, k...)
+		for _, t := range p {
+			if confirm == nil || confirm(c, t) {
+				purge = append(purge, t)
+			} else {
+				keep = append(keep, t)
+			}
+		}
+	}
+	return keep, purge, skipped
+}
+
+// purger state of the purging task.
+type purger struct {
+	client    *Client
+	opts      PurgeTagsOptions
+	logger    logging.Logger
+	rules     []*repoRule
+	unmatched *tagRule
+	clock     clock
+	summary   *PurgeSummary
+	// digests are the manifest digests to delete the tags by, resolved on analysis or verified when applying a plan,
+	// so re-pushed tags do not get their new manifest deleted.
+	digests map[string]string
+	// deadline is when MaxDuration is exceeded, zero for no limit.
+	deadline time.Time
+	// remaining are the repos not started as MaxDuration was exceeded.
+	remaining []string
+	// resolved are the manifest digests of the tags resolved within the run.
+	resolvedMux sync.Mutex
+	resolved    map[string]string
+	// total and finished count repos for the progress.
+	progressMux     sync.Mutex
+	total, finished int
+	// tombstones are loaded from TombstoneFile, selected are the tags of the TagConfigs with DeleteAfterDays
+	// selected for purging by this run along with when they were first selected.
+	tombstones *PurgeTombstones
+	selected   map[string]time.Time
+	// firstSeen are loaded from FirstSeenFile, seen are the tags seen by this run with when they were first seen
+	// and seenRepos the repos it scanned fully.
+	seenMux   sync.Mutex
+	firstSeen *PurgeFirstSeen
+	seen      map[string]FirstSeen
+	seenRepos map[string]bool
+	// inUse are the images returned by InUseProvider, inUseKept counts the tags to purge kept as in use.
+	inUse     inUse
+	inUseKept int
+	// protectedTags are the tag names protected by ProtectedTagsProvider.
+	protectedTags map[string]bool
+	// headSHAs are the branches by the commit SHAs of their heads given by ProtectedTagsProvider.
+	headSHAs map[string]string
+	// lastRepo is when the last repo started being scanned, for InterRepoDelay.
+	paceMux  sync.Mutex
+	lastRepo time.Time
+}
+
+// paceRepo wait until InterRepoDelay passed since the last repo started being scanned, false when cancelled meanwhile.
+func (p *purger) paceRepo(ctx context.Context) bool {
+	if p.opts.InterRepoDelay <= 0 {
+		return true
+	}
+	p.paceMux.Lock()
+	defer p.paceMux.Unlock()
+	if wait := time.Until(p.lastRepo.Add(p.opts.InterRepoDelay)); !p.lastRepo.IsZero() && wait > 0 {
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return false
+		}
+	}
+	p.lastRepo = time.Now()
+	return true
+}
+
+// measureBytes sum the image sizes of the tags, which the client caches for the deletion to not fetch them again.
+func (p *purger) measureBytes(ctx context.Context, repo string, tags []string) int64 {
+	var total int64
+	for _, size := range p.client.TagSizes(repo, tags, p.opts.TagWorkers) {
+		total = total + size
+	}
+	return total
+}
+
+// deferDeletions move the tags to purge beyond MaxDeletionsPerRepoPerRun to the ones to keep, deferring them to
+// the next runs, and return them. The tags are deleted in the order of the tags rules they follow, each one sorting
+// its tags by its DeletionPriority, the oldest first on ties.
+func (p *purger) deferDeletions(repo string, scan *repoScan, keep, purge []string) ([]string, []string, []string) {
+	max := p.opts.MaxDeletionsPerRepoPerRun
+	if max <= 0 || len(purge) <= max {
+		return keep, purge, nil
+	}
+	created := make(map[string]time.Time, len(scan.tags))
+	for _, t := range scan.tags {
+		created[t.name] = t.created
+	}
+	rule := matchRepoRule(p.rules, repo)
+	groups := map[int][]string{}
+	for _, tag := range purge {
+		i := rule.matchTag(tag)
+		if i < 0 {
+			// Purged by the global rule or in PurgeModeDeleteAll, last.
+			i = len(rule.tags)
+		}
+		groups[i] = append(groups[i], tag)
+	}
+	ordered := make([]string, 0, len(purge))
+	for g := 0; g <= len(rule.tags); g++ {
+		tags := groups[g]
+		sort.SliceStable(tags, func(i, j int) bool {
+			if !created[tags[i]].Equal(created[tags[j]]) {
+				return created[tags[i]].Before(created[tags[j]])
+			}
+			return tags[i] < tags[j]
+		})
+		if g < len(rule.tags) && len(tags) > 1 {
+			p.sortByPriority(repo, scan, tags, rule.tags[g].config.DeletionPriority)
+		}
+		ordered = append(ordered, tags...)
+	}
+	// Deleting a manifest deletes all its tags, so the tags sharing it count once and are not deferred apart.
+	manifests := map[string]bool{}
+	purge, deferred := []string{}, []string{}
+	p.resolvedMux.Lock()
+	for _, tag := range ordered {
+		manifest, ok := p.resolved[repo+":"+tag]
+		if !ok {
+			manifest = tag
+		}
+		if !manifests[manifest] && len(manifests) >= max {
+			deferred = append(deferred, tag)
+			continue
+		}
+		manifests[manifest] = true
+		purge = append(purge, tag)
+	}
+	p.resolvedMux.Unlock()
+	if len(deferred) == 0 {
+		return keep, purge, nil
+	}
+	p.logger.Infof("[%s] deferring %d of the %d tags to purge to the next runs, over the max of %d deletions per run: %s",
+		repo, len(deferred), len(ordered), max, logList(deferred, p.opts.LogTagsLimit))
+	return append(keep, deferred...), purge, deferred
+}
+
+// sortByPriority stable sort the tags to purge by the deletion priority, the ones to delete first first. The tags
+// which size or digest cannot be fetched come last.
+func (p *purger) sortByPriority(repo string, scan *repoScan, tags []string, priority string) {
+	var weight map[string]int64
+	switch priority {
+	case DeletionLargestFirst:
+		weight = p.client.TagSizes(repo, tags, p.opts.TagWorkers)
+	case DeletionMostTagsFirst:
+		names := make([]string, 0, len(scan.tags))
+		for _, t := range scan.tags {
+			names = append(names, t.name)
+		}
+		p.resolveDigests(repo, names)
+		counts := map[string]int64{}
+		for _, name := range names {
+			if digest, err := p.resolveDigest(repo, name); err == nil {
+				counts[digest]++
+			}
+		}
+		weight = map[string]int64{}
+		for _, tag := range tags {
+			if digest, err := p.resolveDigest(repo, tag); err == nil {
+				weight[tag] = counts[digest]
+			}
+		}
+	default:
+		return
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return weight[tags[i]] > weight[tags[j]] })
+}
+
+// keepLarge move the tags to purge larger than TagsKeepIfLargerThanBytes to the ones to keep.
+func (p *purger) keepLarge(repo string, keep, purge []string) ([]string, []string) {
+	if p.opts.TagsKeepIfLargerThanBytes <= 0 || len(purge) == 0 {
+		return keep, purge
+	}
+	sizes := p.client.TagSizes(repo, purge, p.opts.TagWorkers)
+	remaining := []string{}
+	large := []string{}
+	for _, tag := range purge {
+		size, ok := sizes[tag]
+		if !ok {
+			p.logger.Errorf("[%s] keeping tag %s failed to get its size", repo, tag)
+			keep = append(keep, tag)
+		} else if size > p.opts.TagsKeepIfLargerThanBytes {
+			large = append(large, tag)
+		} else {
+			remaining = append(remaining, tag)
+		}
+	}
+	if len(large) > 0 {
+		p.logger.Infof("[%s] keeping %d tags larger than %d bytes: %v", repo, len(large), p.opts.TagsKeepIfLargerThanBytes, large)
+	}
+	return append(keep, large...), remaining
+}
+
+// logStorageUsage log the bytes used by the registry storage before or after the deletion.
+func (p *purger) logStorageUsage(when string) {
+	used, err := p.opts.StorageUsage()
+	if err != nil {
+		p.logger.Warnf("Failed to get the registry storage usage %s the purge: %s", when, err)
+		return
+	}
+	p.logger.Infof("Registry storage used %s the purge: %s (%d bytes).", when, PrettySize(float64(used)), used)
+}
+
+// overBudget check whether MaxDuration of the run is exceeded.
+func (p *purger) overBudget() bool {
+	return !p.deadline.IsZero() && !time.Now().Before(p.deadline)
+}
+
+// forEach call fn for every item by the given number of workers until the context is cancelled.
+func forEach(ctx context.Context, workers int, items []string, fn func(string)) {
+	if workers < 1 {
+		workers = 1
+	}
+	queue := make(chan string)
+	wg := sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range queue {
+				fn(item)
+			}
+		}()
+	}
+	for _, item := range items {
+		if ctx.Err() != nil {
+			break
+		}
+		queue <- item
+	}
+	close(queue)
+	wg.Wait()
+}
+
+// forEachOf call fn for every item of the queue by the given number of workers until it is closed,
+// the items received once the context is cancelled are skipped.
+func forEachOf(ctx context.Context, workers int, queue <-chan string, fn func(string)) {
+	if workers < 1 {
+		workers = 1
+	}
+	wg := sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range queue {
+				if ctx.Err() == nil {
+					fn(item)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// scanRepos fetch the tags with their creation dates for all the repos of the queue until it is closed,
+// ScanWorkers repos at a time.
+func (p *purger) scanRepos(ctx context.Context, queue <-chan string) map[string]*repoScan {
+	repos := map[string]*repoScan{}
+	mux := sync.Mutex{}
+	forEachOf(ctx, p.opts.ScanWorkers, queue, func(repo string) {
+		if p.overBudget() {
+			mux.Lock()
+			p.remaining = append(p.remaining, repo)
+			mux.Unlock()
+			return
+		}
+		if !p.paceRepo(ctx) {
+			return
+		}
+		p.progress(ProgressRepoStart, repo)
+		tags := p.scanRepo(ctx, repo)
+		if p.opts.AgeSource == AgeFirstSeen && ctx.Err() == nil && len(tags.unscanned) == 0 {
+			p.seenMux.Lock()
+			p.seenRepos[repo] = true
+			p.seenMux.Unlock()
+		}
+		if len(tags.tags) == 0 && len(tags.unprocessed) == 0 && len(tags.artifacts) == 0 && len(tags.unscanned) == 0 {
+			p.progress(ProgressRepoFinish, repo)
+			return
+		}
+		mux.Lock()
+		repos[repo] = tags
+		mux.Unlock()
+	})
+	return repos
+}
+
+// manifestV1Created return the creation date from the manifest v1 history or zero time if missing.
+func manifestV1Created(manifest string) time.Time {
+	return gjson.Get(gjson.Get(manifest, "history.0.v1Compatibility").String(), "created").Time()
+}
+
+// repoScan tags of a repo with their creation dates and the ones which could not be evaluated.
+type repoScan struct {
+	tags        timeSlice
+	unprocessed []string
+	// artifacts are kept when excluded from purging.
+	artifacts []string
+	// unscanned are the tags not scanned as RepoMaxDuration was exceeded or the tag list failed, the repo is
+	// incomplete then.
+	unscanned []string
+}
+
+// count return the number of tags of the repo.
+func (s *repoScan) count() int {
+	return len(s.tags) + len(s.unprocessed) + len(s.artifacts) + len(s.unscanned)
+}
+
+// scanRepo fetch the tags of the repo with their creation dates, TagWorkers tags at a time.
+func (p *purger) scanRepo(ctx context.Context, repo string) *repoScan {
+	tags, err := p.client.ListTags(repo)
+	result := &repoScan{}
+	if err != nil {
+		// Retention cannot be decided from a part of the tags, they are left unscanned so the repo is untouched.
+		p.logger.Error(err)
+		p.summary.addError(err)
+		p.skip(SkipRepoIncomplete, 1, p.logger.Warnf, "[%s] failed to list all its tags, leaving it untouched.", repo)
+		result.unscanned = tags
+		return result
+	}
+	if len(tags) == 0 {
+		p.skip(SkipRepoEmpty, 1, p.logger.Infof, "[%s] no tags, skipping it.", repo)
+		return result
+	}
+	p.logger.Infof("[%s] scanning %d tags...", repo, len(tags))
+
+	indexes := make(map[string]int, len(tags))
+	for i, tag := range tags {
+		indexes[tag] = i
+	}
+	var deadline time.Time
+	if p.opts.RepoMaxDuration > 0 {
+		deadline = time.Now().Add(p.opts.RepoMaxDuration)
+	}
+	scanned := map[string]bool{}
+	mux := sync.Mutex{}
+	// gone is set once a tag failed to be evaluated as the repo was deleted meanwhile, e.g. by another process.
+	var gone, checked bool
+	isGone := func() bool {
+		mux.Lock()
+		defer mux.Unlock()
+		if !checked {
+			checked = true
+			exists, err := p.client.RepoExists(repo)
+			gone = err == nil && !exists
+		}
+		return gone
+	}
+	forEach(ctx, p.opts.TagWorkers, tags, func(tag string) {
+		mux.Lock()
+		skip := gone || (!deadline.IsZero() && !time.Now().Before(deadline))
+		if !skip {
+			scanned[tag] = true
+		}
+		mux.Unlock()
+		if skip {
+			return
+		}
+		if p.opts.ExcludeArtifacts {
+			if manifest, err := p.client.getManifest(repo, tag); err == nil && ArtifactType(manifest) != "" {
+				mux.Lock()
+				result.artifacts = append(result.artifacts, tag)
+				mux.Unlock()
+				return
+			}
+		}
+
+		var created time.Time
+		var source string
+		switch p.opts.AgeSource {
+		case AgeUploaded:
+			uploaded, err := p.client.ManifestUploaded(repo, tag)
+			if err != nil {
+				p.logger.Warnf("[%s] failed to get upload time of tag %s, using its creation date: %s", repo, tag, err)
+			}
+			created, source = uploaded, DateSourceUploaded
+		case AgeFirstSeen:
+			digest, err := p.resolveDigest(repo, tag)
+			if err != nil {
+				p.logger.Warnf("[%s] failed to resolve the digest of tag %s, using its creation date: %s", repo, tag, err)
+			} else {
+				created, source = p.firstSeenAt(repo, tag, digest), DateSourceFirstSeen
+			}
+		}
+		var digest string
+		if created.IsZero() && p.opts.CreatedCache != nil {
+			// The creation date never changes for a digest, so resolving it saves fetching the manifest and blob.
+			if digest, _ = p.resolveDigest(repo, tag); digest != "" {
+				created, source, _ = p.opts.CreatedCache.Get(digest)
+			}
+		}
+		if created.IsZero() {
+			_, infoV1, _ := p.client.TagInfo(repo, tag, true)
+			if infoV1 != "" {
+				created, source = manifestV1Created(infoV1), DateSourceV1
+			} else {
+				// Fall back to the config blob for registries not serving manifest v1,
+				// then to the manifest annotation, see ConfigBlob.
+				config, err := p.client.ConfigBlob(repo, tag)
+				if err == nil && config.Created.IsZero() {
+					err = fmt.Errorf("no creation date in config blob %s", config.Digest)
+				}
+				if err != nil && isGone() {
+					return
+				}
+				if err != nil {
+					p.skip(SkipMissingManifest, 1, p.logger.Errorf, "[%s] missing manifest v1 and config blob for tag %s, keeping it: %s", repo, tag, err)
+					mux.Lock()
+					result.unprocessed = append(result.unprocessed, tag)
+					mux.Unlock()
+					return
+				}
+				created, source = config.Created, config.CreatedSource
+			}
+			if digest != "" && !created.IsZero() {
+				p.opts.CreatedCache.Set(digest, created, source)
+			}
+		}
+		mux.Lock()
+		result.tags = append(result.tags, tagData{name: tag, created: created, index: indexes[tag], source: source})
+		mux.Unlock()
+	})
+	if gone {
+		p.skip(SkipRepoGone, 1, p.logger.Infof, "[%s] repository is gone, skipping it.", repo)
+		return &repoScan{}
+	}
+	if len(scanned) < len(tags) && ctx.Err() == nil {
+		for _, tag := range tags {
+			if !scanned[tag] {
+				result.unscanned = append(result.unscanned, tag)
+			}
+		}
+		p.skip(SkipRepoIncomplete, 1, p.logger.Warnf, "[%s] exceeded the repo max duration of %s with %d of %d tags scanned, leaving it untouched.",
+			repo, p.opts.RepoMaxDuration, len(scanned), len(tags))
+	}
+	sort.Strings(result.unprocessed)
+	sort.Strings(result.artifacts)
+	return result
+}
+
+// analyzeRepo decide which tags of the repo to keep and purge.
+func (p *purger) analyzeRepo(repo string, tags timeSlice) (keep, purge []string) {
+	// Sort tags by "created" from newest to oldest.
+	sort.Sort(tags)
+	protected, tags := p.splitProtected(repo, tags)
+	companions, tags := p.splitCompanions(tags)
+	defer func() { keep = append(append(keep, protected...), companions...) }()
+
+	rule := matchRepoRule(p.rules, repo)
+	if rule.deleteAll {
+		if !p.opts.DryRun && !rule.dryRunOnly && !p.opts.ConfirmDeleteAll {
+			p.logger.Errorf("[%s] matches a %s rule but it is not confirmed, keeping all %d tags.", repo, PurgeModeDeleteAll, len(tags))
+			keep = make([]string, 0, len(tags))
+			for _, t := range tags {
+				keep = append(keep, t.name)
+			}
+			return keep, nil
+		}
+		p.logger.Warnf("[%s] !!! %s mode: purging ALL %d tags except the protected ones !!!", repo, PurgeModeDeleteAll, len(tags))
+	}
+
+	keep, purge, skipped := rule.selectTagsBy(tags, p.clock, p.unmatched, func(c TagConfig, tag string) bool {
+		return p.vulnerable(repo, tag, c.PurgeSeverity) && p.deleteDue(repo, tag, c.DeleteAfterDays)
+	})
+	if p.opts.QuietSkips {
+		if len(skipped) > 0 && p.unmatched != nil {
+			p.logger.Infof("[%s] applied the global rule to %d tags not matching any rule", repo, len(skipped))
+		} else if len(skipped) > 0 {
+			p.skip(SkipNoTagRule, len(skipped), p.logger.Infof, "[%s] skipped %d tags not matching any rule", repo, len(skipped))
+		}
+		return keep, purge
+	}
+	for _, t := range skipped {
+		if p.unmatched != nil {
+			p.logger.Infof("[%s] tag %s matches no tags rule, applying the global one", repo, t)
+		} else {
+			p.skip(SkipNoTagRule, 1, p.logger.Infof, "[%s] skipping tag %s matching no tags rule", repo, t)
+		}
+	}
+	return keep, purge
+}
+
+// resolveDigest return the manifest digest of the tag, resolved once per run.
+func (p *purger) resolveDigest(repo, tag string) (string, error) {
+	key := repo + ":" + tag
+	p.resolvedMux.Lock()
+	digest, ok := p.resolved[key]
+	p.resolvedMux.Unlock()
+	if ok {
+		return digest, nil
+	}
+	exists, digest, err := p.client.ManifestExists(repo, tag)
+	if err == nil && (!exists || digest == "") {
+		err = fmt.Errorf("manifest digest of %s:%s not found", repo, tag)
+	}
+	if err != nil {
+		return "", err
+	}
+	p.resolvedMux.Lock()
+	p.resolved[key] = digest
+	p.resolvedMux.Unlock()
+	return digest, nil
+}
+
+// resolveDigests resolve the manifest digests of the tags not resolved yet within the run at once, so resolveDigest
+// then finds them resolved and only retries the ones failing.
+func (p *purger) resolveDigests(repo string, tags []string) {
+	missing := []string{}
+	p.resolvedMux.Lock()
+	for _, tag := range tags {
+		if _, ok := p.resolved[repo+":"+tag]; !ok {
+			missing = append(missing, tag)
+		}
+	}
+	p.resolvedMux.Unlock()
+	if len(missing) < 2 {
+		return
+	}
+	digests := p.client.ResolveDigests(repo, missing)
+	p.resolvedMux.Lock()
+	for tag, digest := range digests {
+		p.resolved[repo+":"+tag] = digest
+	}
+	p.resolvedMux.Unlock()
+}
+
+// groupByManifest collapse the tags referencing the same manifest and following the same tags rule into the first
+// one listed, so the group counts once for retention, and return the other tags of the groups by the first one.
+// Tags which digest cannot be resolved are not grouped.
+func (p *purger) groupByManifest(ctx context.Context, repo string, tags timeSlice) (timeSlice, map[string][]string) {
+	digests := map[string]string{}
+	mux := sync.Mutex{}
+	names := make([]string, len(tags))
+	for i, t := range tags {
+		names[i] = t.name
+	}
+	forEach(ctx, p.opts.TagWorkers, names, func(tag string) {
+		if digest, err := p.resolveDigest(repo, tag); err == nil {
+			mux.Lock()
+			digests[tag] = digest
+			mux.Unlock()
+		}
+	})
+
+	rule := matchRepoRule(p.rules, repo)
+	firsts := map[string]string{}
+	aliases := map[string][]string{}
+	grouped := timeSlice{}
+	for _, t := range tags {
+		digest, ok := digests[t.name]
+		if !ok {
+			grouped = append(grouped, t)
+			continue
+		}
+		key := fmt.Sprintf("%s %d %t", digest, rule.matchTag(t.name), rule.keep != nil && rule.keep.FindStringIndex(t.name) != nil)
+		if first, ok := firsts[key]; ok {
+			aliases[first] = append(aliases[first], t.name)
+			continue
+		}
+		firsts[key] = t.name
+		grouped = append(grouped, t)
+	}
+	return grouped, aliases
+}
+
+// expandAliases add the other tags of the groups after their first ones.
+func expandAliases(tags []string, aliases map[string][]string) []string {
+	if len(aliases) == 0 {
+		return tags
+	}
+	expanded := []string{}
+	for _, t := range tags {
+		expanded = append(expanded, t)
+		expanded = append(expanded, aliases[t]...)
+	}
+	return expanded
+}
+
+// pinManifests resolve the manifest digests of the tags to delete them by and keep the tags to purge which manifest
+// is also referenced by a kept tag, as deleting it would delete the kept tag too, unless on SharedManifestDelete.
+// Tags to purge which digest cannot be resolved are kept, as well as all of them if a kept tag cannot be resolved.
+func (p *purger) pinManifests(ctx context.Context, repo string, keep, purge []string) ([]string, []string) {
+	digests := map[string]string{}
+	mux := sync.Mutex{}
+	failed := false
+	tags := append(append([]string{}, keep...), purge...)
+	forEach(ctx, p.opts.TagWorkers, tags, func(tag string) {
+		digest, err := p.resolveDigest(repo, tag)
+		mux.Lock()
+		defer mux.Unlock()
+		if err != nil {
+			p.logger.Errorf("[%s] %s", repo, err)
+			p.summary.addError(err)
+			failed = failed || ItemInSlice(tag, keep)
+			return
+		}
+		digests[tag] = digest
+	})
+	if failed || ctx.Err() != nil {
+		p.logger.Errorf("[%s] keeping all %d tags to purge as the manifests of the kept tags could not be resolved.", repo, len(purge))
+		return append(keep, purge...), nil
+	}
+
+	kept := map[string]string{}
+	for _, tag := range keep {
+		kept[digests[tag]] = tag
+	}
+	pinned := []string{}
+	for _, tag := range purge {
+		digest, ok := digests[tag]
+		if !ok {
+			keep = append(keep, tag)
+			continue
+		}
+		if shared, ok := kept[digest]; ok {
+			if p.opts.SharedManifestPolicy != SharedManifestDelete {
+				p.logger.Warnf("[%s] keeping tag %s sharing manifest %s with kept tag %s.", repo, tag, digest, shared)
+				keep = append(keep, tag)
+				continue
+			}
+			p.logger.Warnf("[%s] purging tag %s also deletes kept tag %s sharing manifest %s.", repo, tag, shared, digest)
+		}
+		p.digests[repo+":"+tag] = digest
+		pinned = append(pinned, tag)
+	}
+	return keep, pinned
+}
+
+// vulnerable check whether the tag has a vulnerability of the severity or higher, always true for no severity.
+// Tags failing to be checked are not considered vulnerable, so kept.
+func (p *purger) vulnerable(repo, tag, severity string) bool {
+	if severity == "" {
+		return true
+	}
+	digest, err := p.resolveDigest(repo, tag)
+	var found string
+	if err == nil {
+		found, err = p.opts.VulnProvider(repo, tag, digest)
+	}
+	if err != nil {
+		p.logger.Errorf("[%s] keeping tag %s failed to check for vulnerabilities: %s", repo, tag, err)
+		p.summary.addError(err)
+		return false
+	}
+	if severityRank(found) < severityRank(severity) {
+		p.logger.Infof("[%s] keeping tag %s with no vulnerability of %s severity or higher", repo, tag, severity)
+		return false
+	}
+	return true
+}
+
+// deleteTags delete tags by a pool of workers. Once the context is cancelled no new deletions start,
+// the in-flight ones are allowed to complete within the drain timeout to not leave half-deleted manifest lists.
+func (p *purger) deleteTags(ctx context.Context, purgeTags map[string][]string) {
+	type job struct {
+		repo, tag string
+		// aliases are the other tags to purge known to share the manifest of tag, deleted along with it.
+		aliases []string
+	}
+	repos := SortedMapKeys(purgeTags)
+	if len(repos) == 0 {
+		return
+	}
+	if err := p.client.CheckDelete(repos[0]); err != nil {
+		p.logger.Errorf("Not purging any tag: %s", err)
+		p.summary.addError(err)
+		return
+	}
+	workers := p.opts.DeleteWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	// Aborting on a deletion error stops the dispatch like cancelling does.
+	ctx, abort := context.WithCancel(ctx)
+	defer abort()
+	jobs := make(chan job)
+	wg := sync.WaitGroup{}
+	var drained, failed int32
+	// pending count the deletions left per repo to report it finished.
+	pending := map[string]int{}
+	for repo, tags := range purgeTags {
+		pending[repo] = len(tags)
+	}
+	pendingMux := sync.Mutex{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				// No new deletions start once cancelled or aborted.
+				if ctx.Err() != nil {
+					continue
+				}
+				var size int64
+				if p.opts.MeasureBytes {
+					size, _ = p.client.TagSize(j.repo, j.tag)
+				}
+				recent, err := p.recentlyPushed(j.repo, j.tag)
+				persisted := false
+				// verifyErr is the failure to verify a deletion which succeeded, the tags still count as deleted.
+				var verifyErr error
+				if err == nil && !recent {
+					var digest string
+					digest, err = p.deleteTag(j.repo, j.tag)
+					if err == nil && p.opts.VerifyDeletions > 0 {
+						persisted, err = p.verifyDeleted(ctx, j.repo, j.tag, digest)
+						if !persisted {
+							verifyErr, err = err, nil
+						}
+					}
+				}
+				tags := append([]string{j.tag}, j.aliases...)
+				switch {
+				case recent:
+					p.skip(SkipRecentlyPushed, len(tags), p.logger.Warnf, "[%s] not deleting tag %s pushed within the last %s, it may be being pushed.", j.repo, j.tag, p.opts.RecentPushGrace)
+					for _, tag := range tags {
+						p.summary.addRecentlyPushed(j.repo, tag)
+					}
+					err = fmt.Errorf("not deleted as pushed within the last %s", p.opts.RecentPushGrace)
+				case persisted:
+					p.logger.Errorf("[%s] %s", j.repo, err)
+					for _, tag := range tags {
+						p.summary.addNotDeleted(j.repo, tag)
+					}
+					p.summary.addError(err)
+					if p.opts.FailFast && atomic.CompareAndSwapInt32(&failed, 0, 1) {
+						abort()
+					}
+				case err != nil:
+					p.logger.Errorf("[%s] %s", j.repo, err)
+					p.summary.addError(err)
+					if p.opts.FailFast && atomic.CompareAndSwapInt32(&failed, 0, 1) {
+						abort()
+					}
+				default:
+					// The size of the manifest is reclaimed once.
+					p.summary.addDeleted(j.repo, size)
+					for range j.aliases {
+						p.summary.addDeleted(j.repo, 0)
+					}
+					if verifyErr != nil {
+						p.logger.Errorf("[%s] tag %s deleted, %s", j.repo, j.tag, verifyErr)
+						for _, tag := range tags {
+							p.summary.addUnverified(j.repo, tag)
+						}
+						p.summary.addError(verifyErr)
+					}
+				}
+				for _, tag := range tags {
+					p.progressTag(j.repo, tag, err)
+				}
+				if ctx.Err() != nil {
+					atomic.AddInt32(&drained, 1)
+				}
+				pendingMux.Lock()
+				pending[j.repo] = pending[j.repo] - len(tags)
+				finished := pending[j.repo] == 0
+				pendingMux.Unlock()
+				if finished {
+					p.progress(ProgressRepoFinish, j.repo)
+				}
+			}
+		}()
+	}
+
+dispatch:
+	for i, repo := range repos {
+		if p.overBudget() {
+			p.remaining = append(p.remaining, repos[i:]...)
+			break
+		}
+		p.logger.Infof("[%s] Purging %d tags...", repo, len(purgeTags[repo]))
+		// Deleting a manifest deletes all its tags, so the tags known to share one are deleted at once.
+		repoJobs := []*job{}
+		byManifest := map[string]*job{}
+		p.resolvedMux.Lock()
+		for _, tag := range purgeTags[repo] {
+			digest, ok := p.resolved[repo+":"+tag]
+			if j, shared := byManifest[digest]; ok && shared {
+				j.aliases = append(j.aliases, tag)
+				continue
+			}
+			j := &job{repo: repo, tag: tag}
+			if ok {
+				byManifest[digest] = j
+			}
+			repoJobs = append(repoJobs, j)
+		}
+		p.resolvedMux.Unlock()
+		for _, j := range repoJobs {
+			if ctx.Err() != nil {
+				break dispatch
+			}
+			if len(j.aliases) > 0 {
+				p.logger.Infof("[%s] deleting tags %v along with tag %s sharing its manifest.", repo, j.aliases, j.tag)
+			}
+			select {
+			case jobs <- *j:
+			case <-ctx.Done():
+				break dispatch
+			}
+		}
+	}
+	close(jobs)
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	if ctx.Err() == nil {
+		<-done
+		return
+	}
+
+	aborted := atomic.LoadInt32(&failed) == 1
+	if aborted {
+		p.logger.Warn("Purging aborted on deletion error, draining in-flight deletions...")
+	} else {
+		p.logger.Warn("Purging cancelled, draining in-flight deletions...")
+	}
+	var timeout <-chan time.Time
+	if p.opts.DrainTimeout > 0 {
+		timeout = time.After(p.opts.DrainTimeout)
+	}
+	select {
+	case <-done:
+	case <-timeout:
+		p.logger.Warnf("Drain timeout of %s exceeded, not waiting for the remaining deletions.", p.opts.DrainTimeout)
+	}
+	p.logger.Warnf("%d deletions completed during drain.", atomic.LoadInt32(&drained))
+	if aborted {
+		p.summary.Aborted = true
+	} else {
+		p.summary.addError(fmt.Errorf("purging cancelled: %s", ctx.Err()))
+	}
+}
+
+// deleteTag delete the tag by the planned digest if any, otherwise by the one it references now,
+// and return the digest deleted.
+func (p *purger) deleteTag(repo, tag string) (string, error) {
+	if digest, ok := p.digests[repo+":"+tag]; ok {
+		return digest, p.client.deleteManifest(repo, tag, digest)
+	}
+	return p.client.deleteTag(repo, tag)
+}
+
+// verifyDeletedInterval is the delay between the checks of verifyDeleted.
+var verifyDeletedInterval = time.Second
+
+// verifyDeleted check that the manifest digest of the deleted tag is no longer resolvable, retrying until
+// VerifyDeletions is exceeded, and return whether it persisted. Checks failing on errors are retried too.
+func (p *purger) verifyDeleted(ctx context.Context, repo, tag, digest string) (bool, error) {
+	deadline := time.Now().Add(p.opts.VerifyDeletions)
+	for {
+		exists, _, err := p.client.ManifestExists(repo, digest)
+		if err == nil && !exists {
+			return false, nil
+		}
+		if !time.Now().Add(verifyDeletedInterval).Before(deadline) {
+			if err != nil {
+				return false, fmt.Errorf("failed to verify the deletion of %s:%s: %s", repo, tag, err)
+			}
+			return true, fmt.Errorf("%s:%s still resolvable by %s %s after its deletion", repo, tag, digest, p.opts.VerifyDeletions)
+		}
+		select {
+		case <-time.After(verifyDeletedInterval):
+		case <-ctx.Done():
+			return false, fmt.Errorf("failed to verify the deletion of %s:%s: %s", repo, tag, ctx.Err())
+		}
+	}
+}
+
+// recentlyPushed check whether the manifest of the tag to delete was modified within RecentPushGrace,
+// by the digest it is deleted by if resolved.
+func (p *purger) recentlyPushed(repo, tag string) (bool, error) {
+	if p.opts.RecentPushGrace <= 0 {
+		return false, nil
+	}
+	reference := tag
+	if digest, ok := p.digests[repo+":"+tag]; ok {
+		reference = digest
+	}
+	uploaded, err := p.client.ManifestUploaded(repo, reference)
+	if err != nil {
+		return false, fmt.Errorf("failed to check whether tag %s:%s was pushed recently: %s", repo, tag, err)
+	}
+	return !uploaded.IsZero() && time.Since(uploaded) < p.opts.RecentPushGrace, nil
+}
+
+// validatePolicies check the policy options are known.
+func validatePolicies(opts PurgeTagsOptions) error {
+	switch opts.UnmatchedTagPolicy {
+	case "", UnmatchedTagKeep, UnmatchedTagPurgePerGlobal:
+	default:
+		return fmt.Errorf("invalid unmatched tag policy: %s", opts.UnmatchedTagPolicy)
+	}
+	switch opts.AgeSource {
+	case "", AgeCreated, AgeUploaded:
+	case AgeFirstSeen:
+		if opts.FirstSeenFile == "" {
+			return fmt.Errorf("age source %s requires a first seen file", opts.AgeSource)
+		}
+	default:
+		return fmt.Errorf("invalid age source: %s", opts.AgeSource)
+	}
+	switch opts.SharedManifestPolicy {
+	case "", SharedManifestKeep, SharedManifestDelete:
+	default:
+		return fmt.Errorf("invalid shared manifest policy: %s", opts.SharedManifestPolicy)
+	}
+	if opts.ZeroMeansUnlimited && (opts.KeepDays < -1 || opts.KeepCount < -1) {
+		return fmt.Errorf("invalid catch-all keep days %d or count %d, 0 keeps all the tags and -1 none", opts.KeepDays, opts.KeepCount)
+	}
+	if opts.SamplePercent < 0 || opts.SamplePercent > 100 {
+		return fmt.Errorf("invalid sample percent %g, expected between 0 and 100", opts.SamplePercent)
+	}
+	return nil
+}
+
+// CheckPurgeOptions compile the regexes of the purge configs and validate the options without purging,
+// it returns the warnings about the regexes which may not match as intended.
+func CheckPurgeOptions(opts PurgeTagsOptions) ([]string, error) {
+	if _, err := compileRules(opts); err != nil {
+		return nil, err
+	}
+	if err := validatePolicies(opts); err != nil {
+		return nil, err
+	}
+	return configWarnings(opts), nil
+}
+
+// configWarnings return the warnings about the regexes of the purge configs which may not match as intended.
+func configWarnings(opts PurgeTagsOptions) []string {
+	warnings := []string{}
+	if !opts.ZeroMeansUnlimited && opts.KeepDays == 0 && opts.KeepCount == 0 {
+		warnings = append(warnings, "The catch-all rule keeps 0 tags for 0 days, purging all the tags older than a day of the repos matching no rule.")
+	}
+	if !opts.AnchorMatch {
+		for _, r := range unanchoredPatterns(opts.Configs) {
+			warnings = append(warnings, fmt.Sprintf("Regex %q is not anchored with ^...$ and matches anywhere in the name.", r))
+		}
+	}
+	for _, s := range shadowedPatterns(opts.Configs) {
+		warnings = append(warnings, fmt.Sprintf("The %s is already matched by an earlier tags rule, so it never applies.", s))
+	}
+	return warnings
+}
+
+// PurgeOldTags purge old tags and return the summary of the run.
+// Cancelling the context stops scanning immediately and lets started deletions drain.
+func PurgeOldTags(ctx context.Context, client *Client, opts PurgeTagsOptions) *PurgeSummary {
+	runID := newRunID(opts.RunID)
+	logger, closeLog := SetupRunLogging("registry.tasks.PurgeOldTags", runID)
+	defer closeLog()
 	// Reduce client logging.
 	client.logger.SetLevel(logging.LevelError)
+	// Cache config blobs within the run only.
+	client.resetConfigCache()
+
+	now := time.Now().UTC()
+	summary := &PurgeSummary{ID: now.Format("20060102-150405"), RunID: runID, Started: now, DryRun: opts.DryRun, FailFast: opts.FailFast}
+	span := client.startSpan("PurgeOldTags", "id", summary.ID, "run_id", runID, "dry_run", strconv.FormatBool(opts.DryRun))
+	defer func() {
+		summary.Finished = time.Now().UTC()
+		var err error
+		if len(summary.Errors) > 0 {
+			err = fmt.Errorf("%d errors, first one: %s", len(summary.Errors), summary.Errors[0])
+		}
+		span.SetAttribute("repos", strconv.Itoa(len(summary.Repos)))
+		endSpan(span, "tags_deleted", summary.TagsDeleted, err)
+		publishReport(logger, opts.ReportSinks, summary)
+	}()
+
+	var rules []*repoRule
+	configs, err := artifactConfigs(client, logger, opts)
+	if err == nil {
+		opts.Configs = configs
+		rules, err = compileRules(opts)
+	}
+	if err == nil {
+		err = validatePolicies(opts)
+	}
+	if err == nil && opts.SamplePercent > 0 && !opts.DryRun {
+		err = fmt.Errorf("sampling %g%% of the repos requires dry-run", opts.SamplePercent)
+	}
+	if err != nil {
+		logger.Error(err)
+		summary.addError(err)
+		return summary
+	}
+	for _, w := range configWarnings(opts) {
+		logger.Warn(w)
+	}
+	p := &purger{client: client, opts: opts, logger: logger, rules: rules, clock: clock{now: now, loc: opts.Location}, summary: summary, digests: map[string]string{}, resolved: map[string]string{},
+		tombstones: &PurgeTombstones{Tags: map[string]time.Time{}}, selected: map[string]time.Time{},
+		firstSeen: &PurgeFirstSeen{Tags: map[string]FirstSeen{}}, seen: map[string]FirstSeen{}, seenRepos: map[string]bool{}}
+	if opts.MaxDuration > 0 {
+		p.deadline = now.Add(opts.MaxDuration)
+	}
+	if opts.UnmatchedTagPolicy == UnmatchedTagPurgePerGlobal {
+		p.unmatched = &rules[len(rules)-1].tags[0]
+	}
 
 	dryRunText := ""
-	if purgeDryRun {
+	if opts.DryRun {
 		logger.Warn("Dry-run mode enabled.")
 		dryRunText = "skipped"
 	}
 	logger.Info("Scanning registry for repositories, tags and their creation dates...")
-	catalog := client.Repositories(true)
-	// catalog := map[string][]string{"library": []string{""}}
-	now := time.Now().UTC()
-	repos := map[string]timeSlice{}
-	count := 0
-	for namespace := range catalog {
-		count = count + len(catalog[namespace])
-		for _, repo := range catalog[namespace] {
-			if namespace != "library" {
-				repo = fmt.Sprintf("%s/%s", namespace, repo)
-			}
-
-			tags := client.Tags(repo)
-			logger.Infof("[%s] scanning %d tags...", repo, len(tags))
-			if len(tags) == 0 {
-				continue
-			}
-			for _, tag := range tags {
-				_, infoV1, _ := client.TagInfo(repo, tag, true)
-				if infoV1 == "" {
-					logger.Errorf("[%s] missing manifest v1 for tag %s", repo, tag)
-					continue
+	checkpoint := &PurgeCheckpoint{Repos: []string{}}
+	if opts.CheckpointFile != "" && !opts.DryRun {
+		if checkpoint, err = loadCheckpoint(opts.CheckpointFile); err != nil {
+			logger.Error(err)
+			summary.addError(err)
+			return summary
+		}
+		if len(checkpoint.Repos) > 0 {
+			logger.Infof("Resuming from checkpoint of %s, skipping %d repositories already purged.", checkpoint.Updated.Format(time.RFC3339), len(checkpoint.Repos))
+		}
+	}
+	if opts.TombstoneFile != "" {
+		if p.tombstones, err = loadTombstones(opts.TombstoneFile); err != nil {
+			logger.Error(err)
+			summary.addError(err)
+			return summary
+		}
+	}
+	if opts.FirstSeenFile != "" {
+		if p.firstSeen, err = loadFirstSeen(opts.FirstSeenFile); err != nil {
+			logger.Error(err)
+			summary.addError(err)
+			return summary
+		}
+	}
+	var changed map[string]bool
+	if opts.WatermarkFile != "" && opts.ChangedRepos != nil {
+		if changed, err = p.changedRepos(); err != nil {
+			logger.Error(err)
+			summary.addError(err)
+			return summary
+		}
+	}
+	if opts.InUseProvider != nil {
+		refs, err := opts.InUseProvider()
+		if err != nil {
+			err = fmt.Errorf("failed to get the images in use: %s", err)
+			logger.Error(err)
+			summary.addError(err)
+			return summary
+		}
+		p.inUse = newInUse(refs, client.host())
+		logger.Infof("Found %d references to the images of this registry in use.", len(p.inUse))
+	}
+	if opts.ProtectedTagsProvider != nil {
+		refs, err := opts.ProtectedTagsProvider()
+		if err != nil {
+			err = fmt.Errorf("failed to get the protected tags: %s", err)
+			logger.Error(err)
+			summary.addError(err)
+			return summary
+		}
+		p.protectedTags = protectedTagNames(refs)
+		p.headSHAs = headSHAs(refs)
+		logger.Infof("Found %d names protecting the tags named after them, %d with the commit SHA of their head.", len(refs), len(p.headSHAs))
+	}
+	// Scan the repos as the catalog pages are fetched.
+	repoNames := []string{}
+	queue := make(chan string)
+	var walkErr error
+	// eligible counts the repos the run would scan without sampling.
+	eligible := 0
+	// The given repos are scanned without listing the whole catalog, e.g. the one pushed to.
+	walk := client.WalkRepositories
+	if len(opts.Repos) > 0 {
+		walk = func(fn func(repo string) error) error {
+			for _, repo := range opts.Repos {
+				if err := fn(repo); err != nil {
+					return err
 				}
-				created := gjson.Get(gjson.Get(infoV1, "history.0.v1Compatibility").String(), "created").Time()
-				repos[repo] = append(repos[repo], tagData{name: tag, created: created})
 			}
+			return nil
 		}
 	}
+	go func() {
+		defer close(queue)
+		walkErr = walk(func(repo string) error {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			namespace := "library"
+			if strings.Contains(repo, "/") {
+				namespace = strings.SplitN(repo, "/", 2)[0]
+			}
+			// The filtered repos are too many to log, they are counted only.
+			if len(opts.Namespaces) > 0 && !ItemInSlice(namespace, opts.Namespaces) {
+				summary.addSkipped(SkipFiltered, 1)
+				return nil
+			}
+			if ItemInSlice(repo, checkpoint.Repos) {
+				summary.addSkipped(SkipCheckpoint, 1)
+				return nil
+			}
+			if changed != nil && !changed[repo] {
+				summary.addSkipped(SkipUnchanged, 1)
+				return nil
+			}
+			eligible++
+			if opts.SamplePercent > 0 && !sampled(repo, opts.SamplePercent) {
+				summary.addSkipped(SkipNotSampled, 1)
+				return nil
+			}
+			repoNames = append(repoNames, repo)
+			p.progressMux.Lock()
+			p.total++
+			p.progressMux.Unlock()
+			queue <- repo
+			return nil
+		})
+	}()
+	repos := p.scanRepos(ctx, queue)
+	if ctx.Err() != nil {
+		logger.Warn("Purging cancelled while scanning, nothing deleted.")
+		summary.addError(fmt.Errorf("purging cancelled: %s", ctx.Err()))
+		return summary
+	}
+	if walkErr != nil {
+		logger.Errorf("%s, nothing deleted.", walkErr)
+		summary.addError(walkErr)
+		return summary
+	}
+	count := len(repoNames)
 
 	logger.Infof("Scanned %d repositories.", count)
 	logger.Info("Filtering out tags for purging...")
@@ -79,54 +1956,231 @@ func PurgeOldTags(client *Client, purgeDryRun bool, purgeTagsKeepDays, purgeTags
 	keepTags := map[string][]string{}
 	count = 0
 	for _, repo := range SortedMapKeys(repos) {
-		// Sort tags by "created" from newest to oldest.
-		sortedTags := make(timeSlice, 0, len(repos[repo]))
-		for _, d := range repos[repo] {
-			sortedTags = append(sortedTags, d)
-		}
-		sort.Sort(sortedTags)
-		repos[repo] = sortedTags
-
-		// Filter out tags by retention days.
-		for _, tag := range repos[repo] {
-			delta := int(now.Sub(tag.created).Hours() / 24)
-			if delta > purgeTagsKeepDays {
-				purgeTags[repo] = append(purgeTags[repo], tag.name)
-			} else {
-				keepTags[repo] = append(keepTags[repo], tag.name)
+		scan := repos[repo]
+		untouched := false
+		incomplete := len(scan.unscanned) > 0
+		if incomplete {
+			// Retention cannot be decided from a part of the tags, e.g. the newest ones may be unscanned.
+			keepTags[repo], purgeTags[repo] = make([]string, 0, len(scan.tags)+len(scan.unscanned)), nil
+			for _, t := range scan.tags {
+				keepTags[repo] = append(keepTags[repo], t.name)
+			}
+			keepTags[repo] = append(keepTags[repo], scan.unscanned...)
+		} else if n := scan.count(); n < opts.MinTagsBeforePurge && !matchRepoRule(p.rules, repo).deleteAll {
+			p.skip(SkipMinTags, 1, logger.Infof, "[%s] has %d tags, fewer than %d, leaving it untouched.", repo, n, opts.MinTagsBeforePurge)
+			untouched = true
+			sort.Sort(scan.tags)
+			keepTags[repo], purgeTags[repo] = make([]string, 0, len(scan.tags)), nil
+			for _, t := range scan.tags {
+				keepTags[repo] = append(keepTags[repo], t.name)
 			}
+		} else if opts.GroupByManifest {
+			tags, aliases := p.groupByManifest(ctx, repo, scan.tags)
+			keepTags[repo], purgeTags[repo] = p.analyzeRepo(repo, tags)
+			keepTags[repo], purgeTags[repo] = expandAliases(keepTags[repo], aliases), expandAliases(purgeTags[repo], aliases)
+		} else {
+			keepTags[repo], purgeTags[repo] = p.analyzeRepo(repo, scan.tags)
 		}
-
-		// Keep minimal count of tags no matter how old they are.
-		if len(repos[repo])-len(purgeTags[repo]) < purgeTagsKeepCount {
-			if len(purgeTags[repo]) > purgeTagsKeepCount {
-				keepTags[repo] = append(keepTags[repo], purgeTags[repo][:purgeTagsKeepCount]...)
-				purgeTags[repo] = purgeTags[repo][purgeTagsKeepCount:]
-			} else {
-				keepTags[repo] = append(keepTags[repo], purgeTags[repo]...)
-				delete(purgeTags, repo)
+		// reasons of the decisions on the tags are recorded along the analysis with TagDetails.
+		var reasons map[string]string
+		if opts.TagDetails {
+			reasons = p.analysisReasons(repo, keepTags[repo], purgeTags[repo])
+			if untouched {
+				for tag := range reasons {
+					reasons[tag] = ReasonMinTags
+				}
+			}
+			for _, tag := range scan.unprocessed {
+				reasons[tag] = ReasonUnprocessed
+			}
+			for _, tag := range scan.artifacts {
+				reasons[tag] = ReasonArtifact
+			}
+			if incomplete {
+				for tag := range reasons {
+					reasons[tag] = ReasonIncomplete
+				}
 			}
 		}
+		// Tags which could not be evaluated are never purged.
+		keepTags[repo] = append(keepTags[repo], scan.unprocessed...)
+		keepTags[repo] = append(keepTags[repo], scan.artifacts...)
+		dryRunOnly := matchRepoRule(p.rules, repo).dryRunOnly
+		var deferred []string
+		for _, step := range []struct {
+			reason func(tag string) string
+			apply  func(keep, purge []string) ([]string, []string)
+		}{
+			{constReason(ReasonInUse), func(keep, purge []string) ([]string, []string) { return p.keepInUse(repo, keep, purge) }},
+			{constReason(ReasonLarge), func(keep, purge []string) ([]string, []string) { return p.keepLarge(repo, keep, purge) }},
+			{constReason(ReasonIndexChild), func(keep, purge []string) ([]string, []string) { return p.keepIndexChildren(ctx, repo, keep, purge) }},
+			{constReason(ReasonDeferred), func(keep, purge []string) ([]string, []string) {
+				keep, purge, deferred = p.deferDeletions(repo, scan, keep, purge)
+				return keep, purge
+			}},
+			{subjectReason, func(keep, purge []string) ([]string, []string) { return p.followSubjects(ctx, repo, keep, purge) }},
+			{constReason(ReasonSharedManifest), func(keep, purge []string) ([]string, []string) {
+				if len(purge) > 0 && !dryRunOnly {
+					return p.pinManifests(ctx, repo, keep, purge)
+				}
+				return keep, purge
+			}},
+		} {
+			before := purgeTags[repo]
+			keepTags[repo], purgeTags[repo] = step.apply(keepTags[repo], purgeTags[repo])
+			recordMoved(reasons, before, purgeTags[repo], step.reason)
+		}
+		summary.Repos = append(summary.Repos, RepoSummary{
+			Repo: repo, TagsCount: scan.count(), Keep: keepTags[repo], Purge: purgeTags[repo],
+			Unprocessed: scan.unprocessed, DryRunOnly: dryRunOnly, Incomplete: incomplete, Deferred: deferred,
+		})
+		if opts.TagDetails {
+			summary.Repos[len(summary.Repos)-1].Tags = tagDetails(scan, purgeTags[repo], reasons)
+		}
+		if opts.MeasureBytes {
+			summary.Repos[len(summary.Repos)-1].BytesToPurge = p.measureBytes(ctx, repo, purgeTags[repo])
+		}
+		if dryRunOnly && len(purgeTags[repo]) > 0 {
+			p.skip(SkipDryRunOnly, 1, logger.Warnf, "[%s] Dry-run only, not purging %d tags: %v", repo, len(purgeTags[repo]), purgeTags[repo])
+			purgeTags[repo] = nil
+		}
+		if n := scan.count(); opts.WarnTagCount > 0 && n > opts.WarnTagCount {
+			logger.Warnf("[%s] has %d tags, more than %d, check what creates them.", repo, n, opts.WarnTagCount)
+			summary.Repos[len(summary.Repos)-1].OverTagCount = true
+		}
+		if len(purgeTags[repo]) == 0 {
+			delete(purgeTags, repo)
+		}
+		if opts.DryRun || len(purgeTags[repo]) == 0 {
+			p.progress(ProgressRepoFinish, repo)
+		}
 
 		count = count + len(purgeTags[repo])
-		logger.Infof("[%s] All %d: %v", repo, len(repos[repo]), repos[repo])
-		logger.Infof("[%s] Keep %d: %v", repo, len(keepTags[repo]), keepTags[repo])
-		logger.Infof("[%s] Purge %d: %v", repo, len(purgeTags[repo]), purgeTags[repo])
+		limit := opts.LogTagsLimit
+		if len(scan.artifacts) > 0 {
+			p.skip(SkipArtifact, len(scan.artifacts), logger.Infof, "[%s] Artifacts excluded %d: %s", repo, len(scan.artifacts), logList(scan.artifacts, limit))
+		}
+		if len(scan.unprocessed) > 0 {
+			logger.Warnf("[%s] Unprocessed %d: %s", repo, len(scan.unprocessed), logList(scan.unprocessed, limit))
+		}
+		logger.Infof("[%s] All %d: %s", repo, len(scan.tags), logList(scan.tags.strings(), limit))
+		logger.Infof("[%s] Keep %d: %s", repo, len(keepTags[repo]), logList(keepTags[repo], limit))
+		logger.Infof("[%s] Purge %d: %s", repo, len(purgeTags[repo]), logList(purgeTags[repo], limit))
+	}
+	if opts.CrossRepoProtection && len(purgeTags) > 0 {
+		logger.Info("Checking the manifests referenced across repositories...")
+		changed, err := p.keepCrossRepoReferences(ctx, keepTags, purgeTags)
+		if err != nil {
+			logger.Errorf("%s, nothing deleted.", err)
+			summary.addError(err)
+			return summary
+		}
+		for _, repo := range changed {
+			count = count - len(summary.repo(repo).Purge) + len(purgeTags[repo])
+			summary.repo(repo).Keep, summary.repo(repo).Purge = keepTags[repo], purgeTags[repo]
+			summary.repo(repo).keptAcrossRepos(keepTags[repo])
+			if opts.MeasureBytes {
+				summary.repo(repo).BytesToPurge = p.measureBytes(ctx, repo, purgeTags[repo])
+			}
+			if len(purgeTags[repo]) == 0 {
+				delete(purgeTags, repo)
+				if !opts.DryRun {
+					p.progress(ProgressRepoFinish, repo)
+				}
+			}
+		}
 	}
 
+	if n := summary.TagsUnprocessed(); n > 0 {
+		logger.Warnf("There are %d tags which could not be evaluated, they are kept.", n)
+	}
+	if repos := summary.ReposIncomplete(); len(repos) > 0 {
+		logger.Warnf("There are %d repos left untouched as their scan was incomplete: %s", len(repos), strings.Join(repos, ", "))
+	}
+	if opts.InUseProvider != nil {
+		logger.Infof("Kept %d tags in use.", p.inUseKept)
+	}
 	logger.Infof("There are %d tags to purge.", count)
+	if opts.FirstSeenFile != "" && opts.AgeSource == AgeFirstSeen {
+		p.saveFirstSeen()
+	}
+	if count > 0 && !opts.DryRun && opts.Confirm != nil && !opts.Confirm(purgeTags) {
+		err := fmt.Errorf("purge of %d tags not confirmed, nothing deleted", count)
+		logger.Error(err)
+		summary.addError(err)
+		summary.Aborted = true
+		return summary
+	}
+	if opts.TombstoneFile != "" && !opts.DryRun {
+		p.saveTombstones()
+	}
 	if count > 0 {
 		logger.Info("Purging old tags...")
 	}
 
-	for _, repo := range SortedMapKeys(purgeTags) {
-		logger.Infof("[%s] Purging %d tags... %s", repo, len(purgeTags[repo]), dryRunText)
-		if purgeDryRun {
-			continue
+	if opts.DryRun {
+		for _, repo := range SortedMapKeys(purgeTags) {
+			logger.Infof("[%s] Purging %d tags... %s", repo, len(purgeTags[repo]), dryRunText)
 		}
-		for _, tag := range purgeTags[repo] {
-			client.DeleteTag(repo, tag)
+		if opts.PlanFile != "" {
+			p.writePlan(ctx, purgeTags)
 		}
+		if opts.SamplePercent > 0 {
+			summary.Sample = newPurgeSample(summary, opts.SamplePercent, eligible)
+			logger.Infof("Sampled %d of %d repositories with %d tags to purge, estimated %d tags to purge across all of them.",
+				summary.Sample.ReposSampled, eligible, summary.TagsToPurge(), summary.Sample.EstimatedTagsToPurge)
+		}
+	} else {
+		usage := count > 0 && opts.StorageUsage != nil
+		if usage {
+			p.logStorageUsage("before")
+		}
+		p.deleteTags(ctx, purgeTags)
+		if usage {
+			p.logStorageUsage("after")
+			logger.Info("The space of the deleted tags is reclaimed once the registry garbage collection runs.")
+		}
+	}
+	if len(p.remaining) > 0 {
+		summary.TimedOut = true
+		p.skip(SkipMaxDuration, len(p.remaining), logger.Warnf, "Max duration of %s exceeded, stopped with %d of %d repositories done, %d remaining.",
+			opts.MaxDuration, len(repoNames)-len(p.remaining), len(repoNames), len(p.remaining))
+	}
+	if opts.CheckpointFile != "" && !opts.DryRun && ctx.Err() == nil {
+		p.saveCheckpoint(checkpoint, repoNames)
+	}
+	if opts.WatermarkFile != "" && opts.ChangedRepos != nil && !opts.DryRun && ctx.Err() == nil && !summary.TimedOut && len(summary.Errors) == 0 {
+		// The run start, so the pushes during the run are scanned by the next one.
+		if err := (&PurgeWatermark{Since: now}).save(opts.WatermarkFile); err != nil {
+			logger.Error(err)
+			summary.addError(err)
+		}
+	}
+	if len(summary.Skipped) > 0 {
+		logger.Infof("Skipped by reason: %s", skippedText(summary.Skipped))
 	}
 	logger.Info("Done.")
+	return summary
+}
+
+// saveCheckpoint add the repos done to the checkpoint when stopped on MaxDuration, or remove it once all are done.
+func (p *purger) saveCheckpoint(checkpoint *PurgeCheckpoint, repoNames []string) {
+	var err error
+	if len(p.remaining) == 0 {
+		err = removeCheckpoint(p.opts.CheckpointFile)
+	} else {
+		for _, repo := range repoNames {
+			if !ItemInSlice(repo, p.remaining) {
+				checkpoint.Repos = append(checkpoint.Repos, repo)
+			}
+		}
+		checkpoint.Updated = p.clock.now
+		if err = checkpoint.save(p.opts.CheckpointFile); err == nil {
+			p.logger.Infof("Saved checkpoint to %s, the next run resumes after %d repositories.", p.opts.CheckpointFile, len(checkpoint.Repos))
+		}
+	}
+	if err != nil {
+		p.logger.Error(err)
+		p.summary.addError(err)
+	}
 }
@@ -0,0 +1,1390 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hhkbp2/go-logging"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+// logLines records the log lines written to it as a logging stream.
+type logLines []string
+
+func (l *logLines) Tell() (int64, error) { return 0, nil }
+func (l *logLines) Write(s string) error { *l = append(*l, s); return nil }
+func (l *logLines) Flush() error         { return nil }
+func (l *logLines) Close() error         { return nil }
+
+// recordingLogger make a logger recording its lines, the name must be out of the dotted hierarchy of the
+// other loggers as adding one under an existing logger of go-logging hangs the tests.
+func recordingLogger(name string) (logging.Logger, *logLines) {
+	lines := &logLines{}
+	logger := logging.GetLogger(name)
+	logger.SetLevel(logging.LevelInfo)
+	logger.AddHandler(logging.NewStreamHandler(name, logging.LevelInfo, lines))
+	return logger, lines
+}
+
+// daysAgo make tag data created the given number of days before now.
+func daysAgo(now time.Time, name string, days int) tagData {
+	return tagData{name: name, created: now.Add(-time.Duration(days) * 24 * time.Hour)}
+}
+
+func TestFilterTags(t *testing.T) {
+	now := time.Now().UTC()
+	tags := timeSlice{daysAgo(now, "a", 1), daysAgo(now, "b", 5), daysAgo(now, "c", 10), daysAgo(now, "d", 20)}
+	convey.Convey("Filter tags by days and count", t, func() {
+		keep, purge := filterTags(tags, clock{now: now}, 7, 1)
+		convey.So(keep, convey.ShouldResemble, []string{"a", "b"})
+		convey.So(purge, convey.ShouldResemble, []string{"c", "d"})
+
+		keep, purge = filterTags(tags, clock{now: now}, 0, 3)
+		convey.So(keep, convey.ShouldResemble, []string{"a", "b", "c"})
+		convey.So(purge, convey.ShouldResemble, []string{"d"})
+	})
+}
+
+func TestFilterTagsPerDay(t *testing.T) {
+	now := time.Date(2019, 7, 10, 12, 0, 0, 0, time.UTC)
+	at := func(name string, day, hour int) tagData {
+		return tagData{name: name, created: time.Date(2019, 7, day, hour, 0, 0, 0, time.UTC)}
+	}
+	tags := timeSlice{
+		at("10-b", 10, 11), at("10-a", 10, 9), at("9-c", 9, 20), at("9-b", 9, 15), at("9-a", 9, 1),
+		at("7-a", 7, 3), at("1-b", 1, 8), at("1-a", 1, 7),
+	}
+
+	convey.Convey("Keep the newest tags per day within the window", t, func() {
+		keep, purge := filterTagsPerDay(tags, clock{now: now}, 2, 5, 0)
+		convey.So(keep, convey.ShouldResemble, []string{"10-b", "10-a", "9-c", "9-b", "7-a"})
+		convey.So(purge, convey.ShouldResemble, []string{"9-a", "1-b", "1-a"})
+
+		keep, purge = filterTagsPerDay(tags, clock{now: now}, 1, 1, 0)
+		convey.So(keep, convey.ShouldResemble, []string{"10-b", "9-c"})
+		convey.So(purge, convey.ShouldResemble, []string{"10-a", "9-b", "9-a", "7-a", "1-b", "1-a"})
+	})
+
+	convey.Convey("Bucket days in the location", t, func() {
+		tokyo := time.FixedZone("JST", 9*3600)
+		// 9-c and 9-b are created on 2019-07-10 in Tokyo, so 9-a is the newest one of 2019-07-09 there.
+		keep, _ := filterTagsPerDay(tags, clock{now: now, loc: tokyo}, 1, 1, 0)
+		convey.So(keep, convey.ShouldResemble, []string{"10-b", "9-a"})
+	})
+
+	convey.Convey("Select per day strategy by the tags rule", t, func() {
+		c := TagConfig{KeepDays: 100, KeepPerDay: 1, KeepPerDayWindow: 1, KeepCount: 3}
+		keep, purge := c.filter(tags, clock{now: now})
+		convey.So(keep, convey.ShouldResemble, []string{"10-b", "9-c", "10-a", "9-b", "9-a"})
+		convey.So(purge, convey.ShouldResemble, []string{"7-a", "1-b", "1-a"})
+	})
+}
+
+func TestFilterTagsTiered(t *testing.T) {
+	// Wednesday of ISO week 2019-W28.
+	now := time.Date(2019, 7, 10, 12, 0, 0, 0, time.UTC)
+	tiers := []RetentionTier{
+		{WithinDays: 7},
+		{WithinDays: 30, Keep: 1, Per: TierPerWeek},
+		{WithinDays: 365, Keep: 1, Per: TierPerMonth},
+	}
+	tags := timeSlice{
+		daysAgo(now, "d0", 0), daysAgo(now, "d5", 5), daysAgo(now, "d7", 7),
+		// 2019-07-02 and 2019-07-01 are in W27, 2019-06-30 is in W26.
+		daysAgo(now, "d8", 8), daysAgo(now, "d9", 9), daysAgo(now, "d10", 10),
+		daysAgo(now, "d20", 20), daysAgo(now, "d21", 21),
+		// 2019-05-31, 2019-05-26 and 2019-05-11.
+		daysAgo(now, "d40", 40), daysAgo(now, "d45", 45), daysAgo(now, "d60", 60),
+		daysAgo(now, "d400", 400),
+	}
+
+	convey.Convey("Keep all, then weekly, then monthly tags", t, func() {
+		keep, purge := filterTagsTiered(tags, clock{now: now}, tiers, 0)
+		convey.So(keep, convey.ShouldResemble, []string{"d0", "d5", "d7", "d8", "d10", "d20", "d40"})
+		convey.So(purge, convey.ShouldResemble, []string{"d9", "d21", "d45", "d60", "d400"})
+	})
+
+	convey.Convey("Keep several tags per period and one per day by default", t, func() {
+		keep, _ := filterTagsTiered(tags, clock{now: now}, []RetentionTier{{WithinDays: 30, Keep: 2, Per: TierPerWeek}}, 0)
+		// d5, d7, d8 and d9 are all in W27.
+		convey.So(keep, convey.ShouldResemble, []string{"d0", "d5", "d7", "d10", "d20", "d21"})
+
+		keep, _ = filterTagsTiered(tags, clock{now: now}, []RetentionTier{{WithinDays: 9, Per: TierPerDay}}, 0)
+		convey.So(keep, convey.ShouldResemble, []string{"d0", "d5", "d7", "d8", "d9"})
+		keep, _ = filterTagsTiered(tags, clock{now: now}, []RetentionTier{{WithinDays: 10, Keep: 1, Per: TierPerMonth}}, 0)
+		convey.So(keep, convey.ShouldResemble, []string{"d0", "d10"})
+	})
+
+	convey.Convey("Select tiered strategy by the tags rule keeping the minimal count", t, func() {
+		c := TagConfig{KeepDays: 1000, KeepPerDay: 5, Tiers: []RetentionTier{{WithinDays: 1}}, KeepCount: 3}
+		keep, purge := c.filter(tags, clock{now: now})
+		convey.So(keep, convey.ShouldResemble, []string{"d0", "d5", "d7", "d8"})
+		convey.So(purge, convey.ShouldHaveLength, 8)
+	})
+
+	convey.Convey("Validate tiers", t, func() {
+		convey.So(validateTiers(tiers), convey.ShouldBeNil)
+		convey.So(validateTiers([]RetentionTier{{WithinDays: 7, Per: "year"}}), convey.ShouldNotBeNil)
+		convey.So(validateTiers([]RetentionTier{{WithinDays: 30}, {WithinDays: 7}}), convey.ShouldNotBeNil)
+		configs := []PurgeConfig{{RepoRegex: "^app$", Tags: []TagConfig{{TagsRegex: ".*", Tiers: []RetentionTier{{Keep: -1}}}}}}
+		_, err := compileRules(PurgeTagsOptions{Configs: configs})
+		convey.So(err, convey.ShouldNotBeNil)
+	})
+}
+
+func TestKeepByListOrder(t *testing.T) {
+	now := time.Now().UTC()
+	// Pushed in order a, b, c, d while the created dates are unreliable.
+	at := func(name string, index, days int) tagData {
+		tag := daysAgo(now, name, days)
+		tag.index = index
+		return tag
+	}
+	tags := timeSlice{at("a", 0, 10), at("c", 2, 20), at("d", 3, 30), at("b", 1, 40)}
+
+	convey.Convey("Protect the last pushed tags", t, func() {
+		protected, rest := protectByListOrder(tags, 2, false)
+		convey.So(protected, convey.ShouldResemble, []string{"c", "d"})
+		convey.So(rest, convey.ShouldResemble, timeSlice{tags[0], tags[3]})
+
+		protected, _ = protectByListOrder(tags, 2, true)
+		convey.So(protected, convey.ShouldResemble, []string{"a", "b"})
+
+		protected, rest = protectByListOrder(tags, 5, false)
+		convey.So(protected, convey.ShouldHaveLength, 4)
+		convey.So(rest, convey.ShouldBeEmpty)
+	})
+
+	convey.Convey("Combine with the retention strategy of the tags rule", t, func() {
+		c := TagConfig{KeepByListOrder: 1, KeepDays: 15}
+		keep, purge := c.filter(tags, clock{now: now})
+		convey.So(keep, convey.ShouldResemble, []string{"d", "a"})
+		convey.So(purge, convey.ShouldResemble, []string{"c", "b"})
+	})
+}
+
+func TestKeepByNameOrder(t *testing.T) {
+	now := time.Now().UTC()
+
+	convey.Convey("Protect the lexically highest date-stamped tags whatever their dates", t, func() {
+		tags := timeSlice{daysAgo(now, "20231231", 50), daysAgo(now, "20240104", 100), daysAgo(now, "20240103", 200), daysAgo(now, "20240102", 300)}
+		protected, rest := protectByNameOrder(tags, 2, false)
+		convey.So(protected, convey.ShouldResemble, []string{"20240104", "20240103"})
+		convey.So(rest, convey.ShouldHaveLength, 2)
+
+		protected, _ = protectByNameOrder(tags, 2, true)
+		convey.So(protected, convey.ShouldResemble, []string{"20231231", "20240102"})
+
+		protected, rest = protectByNameOrder(tags, 10, false)
+		convey.So(protected, convey.ShouldHaveLength, 4)
+		convey.So(rest, convey.ShouldBeEmpty)
+	})
+
+	convey.Convey("Purge the other numeric tags with negative keep days", t, func() {
+		tags := timeSlice{daysAgo(now, "003", 1), daysAgo(now, "010", 2), daysAgo(now, "001", 3), daysAgo(now, "002", 4)}
+		c := TagConfig{KeepByNameOrder: 2, KeepDays: -1}
+		keep, purge := c.filter(tags, clock{now: now})
+		convey.So(keep, convey.ShouldResemble, []string{"003", "010"})
+		convey.So(purge, convey.ShouldResemble, []string{"001", "002"})
+	})
+
+	convey.Convey("Combine with the retention strategy of the tags rule", t, func() {
+		tags := timeSlice{daysAgo(now, "b", 1), daysAgo(now, "a", 20), daysAgo(now, "c", 30)}
+		c := TagConfig{KeepByNameOrder: 1, KeepDays: 15}
+		keep, purge := c.filter(tags, clock{now: now})
+		convey.So(keep, convey.ShouldResemble, []string{"c", "b"})
+		convey.So(purge, convey.ShouldResemble, []string{"a"})
+	})
+}
+
+func TestMaxAgeDays(t *testing.T) {
+	now := time.Now().UTC()
+	tags := timeSlice{daysAgo(now, "a", 200), daysAgo(now, "b", 300), daysAgo(now, "c", 400), daysAgo(now, "d", 500)}
+
+	convey.Convey("Purge the tags past the max age which the count keeps", t, func() {
+		c := TagConfig{KeepDays: 30, KeepCount: 3, MaxAgeDays: 365}
+		keep, purge := c.filter(tags, clock{now: now})
+		convey.So(keep, convey.ShouldResemble, []string{"a", "b"})
+		convey.So(purge, convey.ShouldResemble, []string{"d", "c"})
+	})
+
+	convey.Convey("Cap the other strategies too", t, func() {
+		c := TagConfig{KeepByListOrder: 4, MaxAgeDays: 250}
+		keep, purge := c.filter(tags, clock{now: now})
+		convey.So(keep, convey.ShouldResemble, []string{"a"})
+		convey.So(purge, convey.ShouldHaveLength, 3)
+	})
+
+	convey.Convey("Cap the catch-all rule", t, func() {
+		f, server := newFakeRegistry(map[string]map[string]time.Time{"app": {"v1": now.Add(-400 * 24 * time.Hour), "v2": now.Add(-100 * 24 * time.Hour)}})
+		defer server.Close()
+		opts := PurgeTagsOptions{KeepDays: 7, KeepCount: 2, MaxAgeDays: 365, DeleteWorkers: 1, DrainTimeout: time.Second}
+		PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), opts)
+		convey.So(f.deleted, convey.ShouldResemble, []string{"app:v1"})
+	})
+}
+
+func TestAgeDays(t *testing.T) {
+	ny, _ := time.LoadLocation("America/New_York")
+	convey.Convey("Count elapsed 24h periods by default", t, func() {
+		now := time.Date(2019, 7, 10, 1, 0, 0, 0, time.UTC)
+		clk := clock{now: now}
+		convey.So(clk.ageDays(time.Date(2019, 7, 9, 23, 0, 0, 0, time.UTC)), convey.ShouldEqual, 0)
+		convey.So(clk.ageDays(time.Date(2019, 7, 8, 2, 0, 0, 0, time.UTC)), convey.ShouldEqual, 1)
+	})
+
+	convey.Convey("Count calendar days in the location", t, func() {
+		now := time.Date(2019, 7, 10, 1, 0, 0, 0, ny)
+		clk := clock{now: now, loc: ny}
+		convey.So(clk.ageDays(time.Date(2019, 7, 9, 23, 0, 0, 0, ny)), convey.ShouldEqual, 1)
+		convey.So(clk.ageDays(time.Date(2019, 7, 10, 0, 0, 0, 0, ny)), convey.ShouldEqual, 0)
+		// 2019-07-10 04:00 UTC is still 2019-07-10 00:00 in New York.
+		convey.So(clk.ageDays(time.Date(2019, 7, 10, 4, 0, 0, 0, time.UTC)), convey.ShouldEqual, 0)
+	})
+
+	convey.Convey("Count calendar days across DST transitions", t, func() {
+		// 2019-03-10 is 23h long and 2019-11-03 is 25h long in New York.
+		clk := clock{now: time.Date(2019, 3, 11, 0, 30, 0, 0, ny), loc: ny}
+		convey.So(clk.ageDays(time.Date(2019, 3, 10, 0, 0, 0, 0, ny)), convey.ShouldEqual, 1)
+		convey.So(clk.ageDays(time.Date(2019, 3, 9, 23, 59, 0, 0, ny)), convey.ShouldEqual, 2)
+
+		clk = clock{now: time.Date(2019, 11, 4, 0, 0, 0, 0, ny), loc: ny}
+		convey.So(clk.ageDays(time.Date(2019, 11, 3, 0, 0, 0, 0, ny)), convey.ShouldEqual, 1)
+		convey.So(clk.ageDays(time.Date(2019, 11, 2, 23, 30, 0, 0, ny)), convey.ShouldEqual, 2)
+	})
+}
+
+func TestUnmatchedTagPolicy(t *testing.T) {
+	now := time.Now().UTC()
+	configs := []PurgeConfig{{RepoRegex: "^app$", Tags: []TagConfig{{TagsRegex: "^release-", KeepDays: 30, KeepCount: 1}}}}
+	rules, err := compileRules(PurgeTagsOptions{Configs: configs, KeepDays: 7, KeepCount: 1})
+	tags := timeSlice{
+		daysAgo(now, "release-2", 10), daysAgo(now, "dev-2", 12), daysAgo(now, "release-1", 40), daysAgo(now, "dev-1", 50),
+	}
+
+	convey.Convey("Keep tags matching no tags rule", t, func() {
+		convey.So(err, convey.ShouldBeNil)
+		keep, purge, skipped := matchRepoRule(rules, "app").selectTags(tags, clock{now: now}, nil)
+		convey.So(keep, convey.ShouldResemble, []string{"dev-2", "dev-1", "release-2"})
+		convey.So(purge, convey.ShouldResemble, []string{"release-1"})
+		convey.So(skipped, convey.ShouldResemble, []string{"dev-2", "dev-1"})
+	})
+
+	convey.Convey("Purge tags matching no tags rule per global rule", t, func() {
+		global := &rules[len(rules)-1].tags[0]
+		keep, purge, _ := matchRepoRule(rules, "app").selectTags(tags, clock{now: now}, global)
+		convey.So(keep, convey.ShouldResemble, []string{"release-2", "dev-2"})
+		convey.So(purge, convey.ShouldResemble, []string{"release-1", "dev-1"})
+	})
+
+	convey.Convey("Log every tag matching no tags rule unless quiet", t, func() {
+		logger, lines := recordingLogger("skips_test")
+		p := &purger{rules: rules, clock: clock{now: now}, logger: logger, summary: &PurgeSummary{}}
+		p.analyzeRepo("app", append(timeSlice{}, tags...))
+		convey.So(*lines, convey.ShouldHaveLength, 2)
+		convey.So((*lines)[0], convey.ShouldContainSubstring, "[app] skipping tag dev-2 matching no tags rule skip_reason=no_tag_rule")
+		convey.So((*lines)[1], convey.ShouldContainSubstring, "[app] skipping tag dev-1 matching no tags rule skip_reason=no_tag_rule")
+		convey.So(p.summary.Skipped, convey.ShouldResemble, map[SkipReason]int{SkipNoTagRule: 2})
+
+		logger, lines = recordingLogger("quiet_skips_test")
+		p = &purger{rules: rules, clock: clock{now: now}, logger: logger, opts: PurgeTagsOptions{QuietSkips: true}, summary: &PurgeSummary{}}
+		p.analyzeRepo("app", append(timeSlice{}, tags...))
+		convey.So(*lines, convey.ShouldHaveLength, 1)
+		convey.So((*lines)[0], convey.ShouldContainSubstring, "[app] skipped 2 tags not matching any rule skip_reason=no_tag_rule")
+		convey.So(p.summary.Skipped, convey.ShouldResemble, map[SkipReason]int{SkipNoTagRule: 2})
+	})
+
+	convey.Convey("Other repos follow the catch-all rule", t, func() {
+		keep, purge, skipped := matchRepoRule(rules, "other").selectTags(tags, clock{now: now}, nil)
+		convey.So(keep, convey.ShouldResemble, []string{"release-2"})
+		convey.So(purge, convey.ShouldResemble, []string{"dev-2", "release-1", "dev-1"})
+		convey.So(skipped, convey.ShouldBeEmpty)
+	})
+
+	convey.Convey("Fail on invalid regex", t, func() {
+		_, err := compileRules(PurgeTagsOptions{Configs: []PurgeConfig{{RepoRegex: "("}}})
+		convey.So(err, convey.ShouldNotBeNil)
+	})
+}
+
+func TestCaseInsensitive(t *testing.T) {
+	now := time.Now().UTC()
+	tags := timeSlice{daysAgo(now, "Latest", 1), daysAgo(now, "latest-1", 2), daysAgo(now, "LATEST-2", 3), daysAgo(now, "v1", 4)}
+	configs := []PurgeConfig{
+		{RepoRegex: "^app$", CaseInsensitive: true, Tags: []TagConfig{{TagsRegex: "^latest", KeepDays: 0, KeepCount: 1, CaseInsensitive: true}}},
+		{RepoRegex: "^lib$", Tags: []TagConfig{{TagsRegex: "^latest", KeepDays: 0, KeepCount: 1}}},
+	}
+	rules, err := compileRules(PurgeTagsOptions{Configs: configs})
+
+	convey.Convey("Match repos and tags case-insensitively", t, func() {
+		convey.So(err, convey.ShouldBeNil)
+		rule := matchRepoRule(rules, "APP")
+		convey.So(rule, convey.ShouldEqual, rules[0])
+		keep, purge, skipped := rule.selectTags(tags, clock{now: now}, nil)
+		convey.So(keep, convey.ShouldResemble, []string{"v1", "Latest"})
+		convey.So(purge, convey.ShouldResemble, []string{"latest-1", "LATEST-2"})
+		convey.So(skipped, convey.ShouldResemble, []string{"v1"})
+	})
+
+	convey.Convey("Existing patterns stay case-sensitive", t, func() {
+		convey.So(matchRepoRule(rules, "LIB"), convey.ShouldEqual, rules[2])
+		keep, purge, skipped := matchRepoRule(rules, "lib").selectTags(tags, clock{now: now}, nil)
+		convey.So(keep, convey.ShouldResemble, []string{"Latest", "LATEST-2", "v1", "latest-1"})
+		convey.So(purge, convey.ShouldBeEmpty)
+		convey.So(skipped, convey.ShouldResemble, []string{"Latest", "LATEST-2", "v1"})
+	})
+}
+
+func TestTagsRegexes(t *testing.T) {
+	now := time.Now().UTC()
+	tags := timeSlice{daysAgo(now, "release-2", 5), daysAgo(now, "hotfix-1", 35), daysAgo(now, "release-1", 40), daysAgo(now, "dev-1", 50)}
+	configs := []PurgeConfig{{RepoRegex: "^app$", Tags: []TagConfig{
+		{TagsRegex: "^release-", TagsRegexes: []string{"^hotfix-"}, KeepDays: 30},
+		{TagsRegexes: []string{"^dev-", "^hotfix-"}, KeepCount: 10},
+	}}}
+	rules, err := compileRules(PurgeTagsOptions{Configs: configs})
+
+	convey.Convey("Select the config by any of its regexes", t, func() {
+		convey.So(err, convey.ShouldBeNil)
+		keep, purge, skipped := matchRepoRule(rules, "app").selectTags(tags, clock{now: now}, nil)
+		convey.So(keep, convey.ShouldResemble, []string{"release-2", "dev-1"})
+		convey.So(purge, convey.ShouldResemble, []string{"hotfix-1", "release-1"})
+		convey.So(skipped, convey.ShouldBeEmpty)
+	})
+
+	convey.Convey("List regexes shadowed by an earlier tags rule", t, func() {
+		convey.So(shadowedPatterns(configs), convey.ShouldResemble, []string{`tags regex "^hotfix-" of repo regex "^app$"`})
+	})
+
+	convey.Convey("Fail on empty, duplicate or invalid regexes", t, func() {
+		for _, regexes := range [][]string{{"^a", ""}, {"^a", "^a"}, {"a)|(b"}} {
+			_, err := compileRules(PurgeTagsOptions{Configs: []PurgeConfig{{RepoRegex: ".*", Tags: []TagConfig{{TagsRegexes: regexes}}}}})
+			convey.So(err, convey.ShouldNotBeNil)
+		}
+	})
+}
+
+func TestAnchorMatch(t *testing.T) {
+	now := time.Now().UTC()
+	configs := []PurgeConfig{{RepoRegex: "prod", Tags: []TagConfig{{TagsRegex: "v1|v2", KeepDays: 0, KeepCount: 0}}}}
+	tags := timeSlice{daysAgo(now, "v1", 1), daysAgo(now, "v10", 2)}
+
+	convey.Convey("Match substrings by default", t, func() {
+		rules, err := compileRules(PurgeTagsOptions{Configs: configs, KeepDays: 100})
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(matchRepoRule(rules, "non-prod-app"), convey.ShouldEqual, rules[0])
+		_, purge, _ := rules[0].selectTags(tags, clock{now: now}, nil)
+		convey.So(purge, convey.ShouldResemble, []string{"v1", "v10"})
+	})
+
+	convey.Convey("Match whole names when anchored", t, func() {
+		rules, err := compileRules(PurgeTagsOptions{Configs: configs, KeepDays: 100, AnchorMatch: true})
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(matchRepoRule(rules, "non-prod-app"), convey.ShouldEqual, rules[1])
+		convey.So(matchRepoRule(rules, "prod"), convey.ShouldEqual, rules[0])
+		keep, purge, _ := rules[0].selectTags(tags, clock{now: now}, nil)
+		convey.So(keep, convey.ShouldResemble, []string{"v10"})
+		convey.So(purge, convey.ShouldResemble, []string{"v1"})
+	})
+
+	convey.Convey("List unanchored patterns", t, func() {
+		configs := append(configs, PurgeConfig{RepoRegex: "^app$", Tags: []TagConfig{{TagsRegex: "^v"}}})
+		convey.So(unanchoredPatterns(configs), convey.ShouldResemble, []string{"prod", "v1|v2", "^v"})
+	})
+}
+
+func TestDeleteAll(t *testing.T) {
+	now := time.Now().UTC()
+	configs := []PurgeConfig{{RepoRegex: "^old/", Mode: PurgeModeDeleteAll, KeepRegex: "^keep-"}}
+	rules, err := compileRules(PurgeTagsOptions{Configs: configs, KeepDays: 100, KeepCount: 10})
+	tags := timeSlice{daysAgo(now, "new", 0), daysAgo(now, "keep-1", 1), daysAgo(now, "old", 300)}
+
+	convey.Convey("Purge all tags except protected ones", t, func() {
+		convey.So(err, convey.ShouldBeNil)
+		keep, purge, _ := matchRepoRule(rules, "old/app").selectTags(tags, clock{now: now}, nil)
+		convey.So(keep, convey.ShouldResemble, []string{"keep-1"})
+		convey.So(purge, convey.ShouldResemble, []string{"new", "old"})
+	})
+
+	convey.Convey("Keep all tags unless confirmed", t, func() {
+		p := &purger{rules: rules, clock: clock{now: now}, logger: SetupLogging("registry.tasks_test"), opts: PurgeTagsOptions{}, summary: &PurgeSummary{}}
+		keep, purge := p.analyzeRepo("old/app", tags)
+		convey.So(keep, convey.ShouldHaveLength, 3)
+		convey.So(purge, convey.ShouldBeEmpty)
+
+		p.opts.DryRun = true
+		_, purge = p.analyzeRepo("old/app", tags)
+		convey.So(purge, convey.ShouldHaveLength, 2)
+
+		p.opts = PurgeTagsOptions{ConfirmDeleteAll: true}
+		_, purge = p.analyzeRepo("old/app", tags)
+		convey.So(purge, convey.ShouldHaveLength, 2)
+	})
+
+	convey.Convey("Fail on invalid mode", t, func() {
+		_, err := compileRules(PurgeTagsOptions{Configs: []PurgeConfig{{RepoRegex: "^a$", Mode: "all"}}})
+		convey.So(err, convey.ShouldNotBeNil)
+	})
+}
+
+func TestCheckPurgeOptions(t *testing.T) {
+	convey.Convey("Return the regex warnings of valid options", t, func() {
+		warnings, err := CheckPurgeOptions(PurgeTagsOptions{KeepDays: 30, Configs: []PurgeConfig{{RepoRegex: "^app$", Tags: []TagConfig{{TagsRegex: "dev"}}}}})
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(warnings, convey.ShouldHaveLength, 1)
+		convey.So(warnings[0], convey.ShouldContainSubstring, `"dev"`)
+	})
+
+	convey.Convey("Warn about a catch-all rule keeping nothing unless 0 means unlimited", t, func() {
+		warnings, err := CheckPurgeOptions(PurgeTagsOptions{})
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(warnings, convey.ShouldHaveLength, 1)
+		convey.So(warnings[0], convey.ShouldContainSubstring, "keeps 0 tags for 0 days")
+		warnings, err = CheckPurgeOptions(PurgeTagsOptions{ZeroMeansUnlimited: true})
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(warnings, convey.ShouldBeEmpty)
+		_, err = CheckPurgeOptions(PurgeTagsOptions{ZeroMeansUnlimited: true, KeepCount: -2})
+		convey.So(err, convey.ShouldNotBeNil)
+	})
+
+	convey.Convey("Fail on invalid regexes and policies", t, func() {
+		_, err := CheckPurgeOptions(PurgeTagsOptions{Configs: []PurgeConfig{{RepoRegex: "app)"}}})
+		convey.So(err, convey.ShouldNotBeNil)
+		_, err = CheckPurgeOptions(PurgeTagsOptions{AgeSource: "pushed"})
+		convey.So(err, convey.ShouldNotBeNil)
+	})
+}
+
+func TestPurgeOldTags(t *testing.T) {
+	now := time.Now().UTC()
+	newRepos := func() map[string]map[string]time.Time {
+		return map[string]map[string]time.Time{
+			"app": {"v1": now.Add(-30 * 24 * time.Hour), "v2": now.Add(-20 * 24 * time.Hour), "v3": now},
+		}
+	}
+	opts := PurgeTagsOptions{KeepDays: 7, KeepCount: 1, DeleteWorkers: 2, DrainTimeout: time.Second}
+
+	convey.Convey("Purge old tags", t, func() {
+		f, server := newFakeRegistry(newRepos())
+		defer server.Close()
+		PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), opts)
+		convey.So(f.deleted, convey.ShouldHaveLength, 2)
+		convey.So(f.repos["app"], convey.ShouldContainKey, "v3")
+	})
+
+	convey.Convey("Tag the run with the run ID given", t, func() {
+		_, server := newFakeRegistry(newRepos())
+		defer server.Close()
+		tagged := opts
+		tagged.RunID = "ci-1234"
+		summary := PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), tagged)
+		convey.So(summary.RunID, convey.ShouldEqual, "ci-1234")
+	})
+
+	convey.Convey("Treat 0 keep days or count of the catch-all rule as unlimited", t, func() {
+		f, server := newFakeRegistry(newRepos())
+		defer server.Close()
+		PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), PurgeTagsOptions{ZeroMeansUnlimited: true, KeepCount: 1, DeleteWorkers: 1})
+		PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), PurgeTagsOptions{ZeroMeansUnlimited: true, KeepDays: 7, DeleteWorkers: 1})
+		convey.So(f.deleted, convey.ShouldBeEmpty)
+		PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), PurgeTagsOptions{ZeroMeansUnlimited: true, KeepDays: -1, KeepCount: 2, DeleteWorkers: 1})
+		convey.So(f.deleted, convey.ShouldResemble, []string{"app:v1"})
+	})
+
+	convey.Convey("Purge the repos of all the catalog pages", t, func() {
+		repos := newRepos()
+		repos["team/app"] = newRepos()["app"]
+		f, server := newFakeRegistry(repos)
+		defer server.Close()
+		f.catalogPageSize = 1
+		summary := PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), opts)
+		convey.So(f.deleted, convey.ShouldHaveLength, 4)
+		convey.So(summary.Repos, convey.ShouldHaveLength, 2)
+	})
+
+	convey.Convey("Wait the inter repo delay between repos", t, func() {
+		repos := newRepos()
+		repos["team/app"] = newRepos()["app"]
+		f, server := newFakeRegistry(repos)
+		defer server.Close()
+		paced := opts
+		paced.ScanWorkers, paced.InterRepoDelay = 2, 50*time.Millisecond
+		started := time.Now()
+		PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), paced)
+		convey.So(time.Since(started), convey.ShouldBeGreaterThanOrEqualTo, paced.InterRepoDelay)
+		convey.So(f.deleted, convey.ShouldHaveLength, 4)
+	})
+
+	convey.Convey("Purge only the oldest tags of a repo up to the max deletions per run", t, func() {
+		repos := newRepos()
+		repos["app"]["v0"] = now.Add(-40 * 24 * time.Hour)
+		f, server := newFakeRegistry(repos)
+		defer server.Close()
+		capped := opts
+		capped.MaxDeletionsPerRepoPerRun = 2
+		capped.TagDetails = true
+		summary := PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), capped)
+		sort.Strings(f.deleted)
+		convey.So(f.deleted, convey.ShouldResemble, []string{"app:v0", "app:v1"})
+		convey.So(summary.Repos[0].Deferred, convey.ShouldResemble, []string{"v2"})
+		convey.So(summary.Repos[0].Keep, convey.ShouldContain, "v2")
+		for _, d := range summary.Repos[0].Tags {
+			if d.Tag == "v2" {
+				convey.So(d.Decision+" "+d.Reason, convey.ShouldEqual, "keep deferred")
+			}
+		}
+
+		summary = PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), capped)
+		convey.So(f.deleted, convey.ShouldContain, "app:v2")
+		convey.So(summary.Repos[0].Deferred, convey.ShouldBeEmpty)
+	})
+
+	convey.Convey("Delete the tags first by the deletion priority under the max deletions per run", t, func() {
+		// v0 is the oldest and the smallest as annotated images have no config blob, v2 and v2b share a manifest.
+		for priority, deleted := range map[string][]string{
+			"":                    {"app:v0"},
+			DeletionOldestFirst:   {"app:v0"},
+			DeletionLargestFirst:  {"app:v1"},
+			DeletionMostTagsFirst: {"app:v2", "app:v2b"},
+		} {
+			repos := newRepos()
+			repos["app"]["v0"] = now.Add(-40 * 24 * time.Hour)
+			repos["app"]["v2b"] = repos["app"]["v2"]
+			f, server := newFakeRegistry(repos)
+			f.annotated = map[string]bool{"v0": true}
+			capped := opts
+			capped.MaxDeletionsPerRepoPerRun = 1
+			capped.Configs = []PurgeConfig{{RepoRegex: "^app$", Tags: []TagConfig{{TagsRegex: "^.*$", KeepCount: 1, DeletionPriority: priority}}}}
+			summary := PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), capped)
+			server.Close()
+			sort.Strings(f.deleted)
+			convey.So(summary.Errors, convey.ShouldBeEmpty)
+			convey.So(f.deleted, convey.ShouldResemble, deleted)
+		}
+
+		invalid := opts
+		invalid.Configs = []PurgeConfig{{RepoRegex: "^app$", Tags: []TagConfig{{TagsRegex: "^.*$", DeletionPriority: "newest"}}}}
+		_, err := CheckPurgeOptions(invalid)
+		convey.So(err, convey.ShouldNotBeNil)
+	})
+
+	convey.Convey("Leave the repos which tag list fails untouched", t, func() {
+		f, server := newFakeRegistry(newRepos())
+		defer server.Close()
+		f.pageSize, f.failTagsAfter = 2, "v2"
+		summary := PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), opts)
+		convey.So(f.deleted, convey.ShouldBeEmpty)
+		convey.So(summary.Errors, convey.ShouldHaveLength, 1)
+		convey.So(summary.ReposIncomplete(), convey.ShouldResemble, []string{"app"})
+		convey.So(summary.Repos[0].Keep, convey.ShouldResemble, []string{"v1", "v2"})
+	})
+
+	convey.Convey("Leave the repos exceeding the repo max duration untouched", t, func() {
+		f, server := newFakeRegistry(newRepos())
+		defer server.Close()
+		o := opts
+		o.RepoMaxDuration = time.Nanosecond
+		o.TagDetails = true
+		summary := PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), o)
+		convey.So(f.deleted, convey.ShouldBeEmpty)
+		convey.So(summary.ReposIncomplete(), convey.ShouldResemble, []string{"app"})
+		convey.So(summary.Repos[0].TagsCount, convey.ShouldEqual, 3)
+		convey.So(summary.Repos[0].Tags, convey.ShouldHaveLength, 3)
+		for _, d := range summary.Repos[0].Tags {
+			convey.So(d.Decision+" "+d.Reason, convey.ShouldEqual, "keep incomplete")
+		}
+	})
+
+	convey.Convey("Keep the scanned and unscanned tags of the repos exceeding the repo max duration", t, func() {
+		f, server := newFakeRegistry(newRepos())
+		defer server.Close()
+		f.manifestDelay = 30 * time.Millisecond
+		o := opts
+		o.RepoMaxDuration = 10 * time.Millisecond
+		summary := PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), o)
+		convey.So(f.deleted, convey.ShouldBeEmpty)
+		convey.So(summary.ReposIncomplete(), convey.ShouldResemble, []string{"app"})
+		keep := append([]string{}, summary.Repos[0].Keep...)
+		sort.Strings(keep)
+		convey.So(keep, convey.ShouldResemble, []string{"v1", "v2", "v3"})
+	})
+
+	convey.Convey("Skip repos deleted while scanning", t, func() {
+		repos := newRepos()
+		repos["other"] = newRepos()["app"]
+		f, server := newFakeRegistry(repos)
+		defer server.Close()
+		f.vanish = map[string]bool{"other": true}
+		summary := PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), opts)
+		convey.So(f.deleted, convey.ShouldHaveLength, 2)
+		convey.So(summary.Repos, convey.ShouldHaveLength, 1)
+		convey.So(summary.Repos[0].Repo, convey.ShouldEqual, "app")
+		convey.So(summary.TagsUnprocessed(), convey.ShouldEqual, 0)
+		convey.So(summary.Errors, convey.ShouldBeEmpty)
+	})
+
+	convey.Convey("Skip the repos without tags as empty", t, func() {
+		repos := newRepos()
+		repos["empty"] = map[string]time.Time{}
+		f, server := newFakeRegistry(repos)
+		defer server.Close()
+		summary := PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), opts)
+		convey.So(f.deleted, convey.ShouldHaveLength, 2)
+		convey.So(summary.Repos, convey.ShouldHaveLength, 1)
+		convey.So(summary.Repos[0].Repo, convey.ShouldEqual, "app")
+		convey.So(summary.Skipped[SkipRepoEmpty], convey.ShouldEqual, 1)
+		convey.So(summary.Errors, convey.ShouldBeEmpty)
+	})
+
+	convey.Convey("Leave repos with fewer tags than min tags before purge untouched", t, func() {
+		repos := newRepos()
+		repos["small"] = map[string]time.Time{"v1": now.Add(-30 * 24 * time.Hour), "v2": now}
+		f, server := newFakeRegistry(repos)
+		defer server.Close()
+		threshold := opts
+		threshold.MinTagsBeforePurge = 3
+		summary := PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), threshold)
+		convey.So(f.deleted, convey.ShouldHaveLength, 2)
+		convey.So(f.repos["small"], convey.ShouldHaveLength, 2)
+		convey.So(summary.Repos[1].Keep, convey.ShouldResemble, []string{"v2", "v1"})
+	})
+
+	convey.Convey("Warn about repos having too many tags", t, func() {
+		_, server := newFakeRegistry(newRepos())
+		defer server.Close()
+		warn := opts
+		warn.DryRun, warn.WarnTagCount = true, 2
+		summary := PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), warn)
+		convey.So(summary.Repos[0].OverTagCount, convey.ShouldBeTrue)
+		convey.So(summary.Metrics(), convey.ShouldContainSubstring, "registry_ui_purge_repos_over_tag_count 1\n")
+
+		warn.WarnTagCount = 3
+		summary = PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), warn)
+		convey.So(summary.ReposOverTagCount(), convey.ShouldEqual, 0)
+	})
+
+	convey.Convey("Purge only the given namespaces", t, func() {
+		repos := newRepos()
+		repos["team-a/app"] = newRepos()["app"]
+		repos["team-b/app"] = newRepos()["app"]
+		f, server := newFakeRegistry(repos)
+		defer server.Close()
+		scoped := opts
+		scoped.Namespaces = []string{"team-a", "library"}
+		summary := PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), scoped)
+		convey.So(f.deleted, convey.ShouldHaveLength, 4)
+		convey.So(f.repos["team-b/app"], convey.ShouldHaveLength, 3)
+		convey.So(summary.Repos, convey.ShouldHaveLength, 2)
+	})
+
+	convey.Convey("Purge only the given repos", t, func() {
+		repos := newRepos()
+		repos["team-a/app"] = newRepos()["app"]
+		f, server := newFakeRegistry(repos)
+		defer server.Close()
+		scoped := opts
+		scoped.Repos = []string{"team-a/app"}
+		summary := PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), scoped)
+		convey.So(f.deleted, convey.ShouldHaveLength, 2)
+		convey.So(f.repos["app"], convey.ShouldHaveLength, 3)
+		convey.So(summary.Repos, convey.ShouldHaveLength, 1)
+		convey.So(f.catalogQueries, convey.ShouldBeEmpty)
+	})
+
+	convey.Convey("Keep the tags which manifest another repo references with cross-repo protection", t, func() {
+		withMirror := func() map[string]map[string]time.Time {
+			repos := newRepos()
+			repos["mirror"] = map[string]time.Time{"base": repos["app"]["v1"]}
+			return repos
+		}
+		f, server := newFakeRegistry(withMirror())
+		defer server.Close()
+		protected := opts
+		protected.CrossRepoProtection = true
+		summary := PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), protected)
+		convey.So(f.deleted, convey.ShouldResemble, []string{"app:v2"})
+		convey.So(summary.repo("app").Purge, convey.ShouldResemble, []string{"v2"})
+		convey.So(summary.repo("app").Keep, convey.ShouldContain, "v1")
+		convey.So(summary.Errors, convey.ShouldBeEmpty)
+
+		f, server = newFakeRegistry(withMirror())
+		defer server.Close()
+		PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), opts)
+		convey.So(f.deleted, convey.ShouldHaveLength, 2)
+	})
+
+	convey.Convey("Keep the tags which manifest a kept image index of the repo references", t, func() {
+		repos := newRepos()
+		repos["app"]["multi"] = now.Add(-40 * 24 * time.Hour)
+		f, server := newFakeRegistry(repos)
+		defer server.Close()
+		f.indexes = map[string][]string{"multi": {"v1"}}
+		protected := opts
+		protected.Configs = []PurgeConfig{{RepoRegex: ".*", KeepRegex: "^multi$", Tags: []TagConfig{{TagsRegex: ".*", KeepDays: 7}}}}
+		protected.IndexChildProtection = true
+		summary := PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), protected)
+		convey.So(f.deleted, convey.ShouldResemble, []string{"app:v2"})
+		convey.So(summary.repo("app").Keep, convey.ShouldContain, "v1")
+		convey.So(summary.Errors, convey.ShouldBeEmpty)
+
+		protected.IndexChildProtection = false
+		PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), protected)
+		convey.So(f.deleted, convey.ShouldResemble, []string{"app:v2", "app:v1"})
+	})
+
+	convey.Convey("Purge the cosign companion tags along with their subject", t, func() {
+		v1 := strings.Replace(fakeDigest(newRepos()["app"]["v1"]), "sha256:", "sha256-", 1)
+		v3 := strings.Replace(fakeDigest(newRepos()["app"]["v3"]), "sha256:", "sha256-", 1)
+		signedRepos := func() map[string]map[string]time.Time {
+			repos := newRepos()
+			repos["app"][v1+".att"] = now.Add(-time.Hour)
+			repos["app"][v3+".sig"] = now.Add(-40 * 24 * time.Hour)
+			return repos
+		}
+		f, server := newFakeRegistry(signedRepos())
+		defer server.Close()
+		companions := opts
+		companions.DeleteCompanions = true
+		summary := PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), companions)
+		convey.So(f.deleted, convey.ShouldHaveLength, 3)
+		convey.So(f.deleted, convey.ShouldContain, "app:"+v1+".att")
+		convey.So(summary.repo("app").Keep, convey.ShouldResemble, []string{"v3", v3 + ".sig"})
+		convey.So(summary.Errors, convey.ShouldBeEmpty)
+
+		f, server = newFakeRegistry(signedRepos())
+		defer server.Close()
+		companions.KeepAttested = true
+		summary = PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), companions)
+		convey.So(f.deleted, convey.ShouldResemble, []string{"app:v2"})
+		convey.So(summary.repo("app").Keep, convey.ShouldContain, "v1")
+		convey.So(summary.repo("app").Keep, convey.ShouldContain, v1+".att")
+	})
+
+	convey.Convey("Detail the decision on every tag with its reason and date source", t, func() {
+		repos := newRepos()
+		repos["app"]["latest"] = now.Add(-40 * 24 * time.Hour)
+		_, server := newFakeRegistry(repos)
+		defer server.Close()
+		detailed := opts
+		detailed.DryRun = true
+		detailed.TagDetails = true
+		detailed.Configs = []PurgeConfig{{RepoRegex: ".*", KeepRegex: "^latest$", Tags: []TagConfig{{TagsRegex: ".*", KeepDays: 7, KeepCount: 1}}}}
+		detailed.InUseProvider = func() ([]string, error) {
+			return []string{strings.TrimPrefix(server.URL, "http://") + "/app:v2"}, nil
+		}
+		summary := PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), detailed)
+		reasons := map[string]string{}
+		for _, d := range summary.repo("app").Tags {
+			reasons[d.Tag] = d.Decision + " " + d.Reason
+			convey.So(d.DateSource, convey.ShouldEqual, DateSourceV1)
+			convey.So(d.Created.Equal(repos["app"][d.Tag]), convey.ShouldBeTrue)
+		}
+		convey.So(reasons, convey.ShouldResemble, map[string]string{
+			"v1": "purge retention", "v2": "keep in_use", "v3": "keep retention", "latest": "keep keep_regex",
+		})
+
+		_, server = newFakeRegistry(newRepos())
+		defer server.Close()
+		summary = PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), opts)
+		convey.So(summary.Repos[0].Tags, convey.ShouldBeNil)
+	})
+
+	convey.Convey("Detail the date taken from the config blob or the annotation", t, func() {
+		f, server := newFakeRegistry(newRepos())
+		defer server.Close()
+		f.noSchema1 = true
+		f.annotated = map[string]bool{"v2": true}
+		detailed := opts
+		detailed.DryRun = true
+		detailed.TagDetails = true
+		summary := PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), detailed)
+		sources := map[string]string{}
+		for _, d := range summary.repo("app").Tags {
+			sources[d.Tag] = d.DateSource
+		}
+		convey.So(sources, convey.ShouldResemble, map[string]string{"v1": DateSourceConfig, "v2": DateSourceAnnotation, "v3": DateSourceConfig})
+	})
+
+	convey.Convey("Sample the repos on dry-run and extrapolate the totals", t, func() {
+		repos := map[string]map[string]time.Time{}
+		for i := 0; i < 100; i++ {
+			repos[fmt.Sprintf("app-%d", i)] = newRepos()["app"]
+		}
+		f, server := newFakeRegistry(repos)
+		defer server.Close()
+		sample := opts
+		sample.DryRun = true
+		sample.SamplePercent = 20
+		summary := PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), sample)
+		convey.So(f.deleted, convey.ShouldBeEmpty)
+		convey.So(summary.Sample, convey.ShouldNotBeNil)
+		convey.So(summary.Sample.ReposTotal, convey.ShouldEqual, 100)
+		convey.So(summary.Sample.ReposSampled, convey.ShouldEqual, len(summary.Repos))
+		convey.So(len(summary.Repos), convey.ShouldBeBetween, 5, 40)
+		convey.So(summary.Sample.EstimatedTagsToPurge, convey.ShouldEqual, 200)
+		convey.So(summary.Markdown(), convey.ShouldContainSubstring, "Estimated across all of them")
+
+		again := PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), sample)
+		convey.So(again.Repos, convey.ShouldResemble, summary.Repos)
+
+		sample.DryRun = false
+		refused := PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), sample)
+		convey.So(refused.Errors, convey.ShouldHaveLength, 1)
+		convey.So(f.deleted, convey.ShouldBeEmpty)
+	})
+
+	convey.Convey("Measure the bytes to purge per repo", t, func() {
+		f, server := newFakeRegistry(newRepos())
+		defer server.Close()
+		measured := opts
+		measured.MeasureBytes = true
+		summary := PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), measured)
+		convey.So(f.deleted, convey.ShouldHaveLength, 2)
+		convey.So(summary.Repos[0].BytesToPurge, convey.ShouldEqual, 2200)
+		convey.So(summary.BytesReclaimed, convey.ShouldEqual, 2200)
+
+		// The sizes are not fetched unless measured.
+		f, server = newFakeRegistry(newRepos())
+		defer server.Close()
+		summary = PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), opts)
+		convey.So(f.deleted, convey.ShouldHaveLength, 2)
+		convey.So(summary.BytesReclaimed, convey.ShouldEqual, 0)
+	})
+
+	convey.Convey("Keep the tags larger than the size threshold", t, func() {
+		f, server := newFakeRegistry(newRepos())
+		defer server.Close()
+		large := opts
+		// The fake images are 1100 bytes.
+		large.TagsKeepIfLargerThanBytes = 1000
+		summary := PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), large)
+		convey.So(f.deleted, convey.ShouldBeEmpty)
+		convey.So(summary.Errors, convey.ShouldBeEmpty)
+		large.TagsKeepIfLargerThanBytes = 1100
+		PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), large)
+		convey.So(f.deleted, convey.ShouldHaveLength, 2)
+	})
+
+	convey.Convey("Purge only vulnerable tags with purge severity", t, func() {
+		f, server := newFakeRegistry(newRepos())
+		defer server.Close()
+		vuln := opts
+		vuln.Configs = []PurgeConfig{{RepoRegex: ".*", Tags: []TagConfig{{TagsRegex: ".*", KeepDays: 7, KeepCount: 1, PurgeSeverity: "high"}}}}
+		vuln.VulnProvider = func(repo, tag, digest string) (string, error) {
+			if tag == "v1" {
+				return "CRITICAL", nil
+			}
+			return "LOW", nil
+		}
+		summary := PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), vuln)
+		convey.So(f.deleted, convey.ShouldHaveLength, 1)
+		convey.So(f.repos["app"], convey.ShouldContainKey, "v2")
+		convey.So(summary.Errors, convey.ShouldBeEmpty)
+	})
+
+	convey.Convey("Fail on purge severity without vulnerability provider", t, func() {
+		vuln := opts
+		vuln.Configs = []PurgeConfig{{RepoRegex: ".*", Tags: []TagConfig{{TagsRegex: ".*", PurgeSeverity: "HIGH"}}}}
+		_, err := compileRules(vuln)
+		convey.So(err, convey.ShouldNotBeNil)
+
+		vuln.VulnProvider = func(repo, tag, digest string) (string, error) { return "", nil }
+		vuln.Configs[0].Tags[0].PurgeSeverity = "SEVERE"
+		_, err = compileRules(vuln)
+		convey.So(err, convey.ShouldNotBeNil)
+	})
+
+	convey.Convey("Stop starting new repos after max duration", t, func() {
+		f, server := newFakeRegistry(newRepos())
+		defer server.Close()
+		dir, _ := ioutil.TempDir("", "checkpoint")
+		defer os.RemoveAll(dir)
+		budget := opts
+		budget.MaxDuration, budget.CheckpointFile = time.Nanosecond, filepath.Join(dir, "checkpoint.json")
+		summary := PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), budget)
+		convey.So(f.deleted, convey.ShouldBeEmpty)
+		convey.So(summary.TimedOut, convey.ShouldBeTrue)
+		_, err := os.Stat(budget.CheckpointFile)
+		convey.So(err, convey.ShouldBeNil)
+	})
+
+	convey.Convey("Resume after the repos of the checkpoint", t, func() {
+		repos := newRepos()
+		repos["other"] = newRepos()["app"]
+		f, server := newFakeRegistry(repos)
+		defer server.Close()
+		dir, _ := ioutil.TempDir("", "checkpoint")
+		defer os.RemoveAll(dir)
+		resume := opts
+		resume.CheckpointFile = filepath.Join(dir, "checkpoint.json")
+		(&PurgeCheckpoint{Repos: []string{"app"}}).save(resume.CheckpointFile)
+		summary := PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), resume)
+		convey.So(f.repos["app"], convey.ShouldHaveLength, 3)
+		convey.So(f.repos["other"], convey.ShouldHaveLength, 1)
+		convey.So(summary.TimedOut, convey.ShouldBeFalse)
+		_, err := os.Stat(resume.CheckpointFile)
+		convey.So(os.IsNotExist(err), convey.ShouldBeTrue)
+	})
+
+	convey.Convey("Scan only the repos changed since the watermark", t, func() {
+		repos := newRepos()
+		repos["other"] = newRepos()["app"]
+		f, server := newFakeRegistry(repos)
+		defer server.Close()
+		dir, _ := ioutil.TempDir("", "watermark")
+		defer os.RemoveAll(dir)
+		incremental := opts
+		incremental.WatermarkFile = filepath.Join(dir, "watermark.json")
+		var asked []time.Time
+		known := true
+		incremental.ChangedRepos = func(since time.Time) ([]string, bool, error) {
+			asked = append(asked, since)
+			return []string{"other"}, known, nil
+		}
+		since := now.Add(-time.Hour).Truncate(time.Second)
+		(&PurgeWatermark{Since: since}).save(incremental.WatermarkFile)
+		summary := PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), incremental)
+		convey.So(asked, convey.ShouldHaveLength, 1)
+		convey.So(asked[0].Equal(since), convey.ShouldBeTrue)
+		convey.So(f.repos["app"], convey.ShouldHaveLength, 3)
+		convey.So(f.repos["other"], convey.ShouldHaveLength, 1)
+		watermark, err := loadWatermark(incremental.WatermarkFile)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(watermark.Since.Equal(summary.Started), convey.ShouldBeTrue)
+
+		// Scan all the repos when the provider cannot tell.
+		known = false
+		PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), incremental)
+		convey.So(f.repos["app"], convey.ShouldHaveLength, 1)
+	})
+
+	convey.Convey("Scan all the repos without a watermark yet", t, func() {
+		repos := newRepos()
+		repos["other"] = newRepos()["app"]
+		f, server := newFakeRegistry(repos)
+		defer server.Close()
+		dir, _ := ioutil.TempDir("", "watermark")
+		defer os.RemoveAll(dir)
+		incremental := opts
+		incremental.WatermarkFile = filepath.Join(dir, "watermark.json")
+		incremental.ChangedRepos = func(since time.Time) ([]string, bool, error) {
+			return nil, true, nil
+		}
+		incremental.DryRun = true
+		summary := PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), incremental)
+		convey.So(summary.Repos, convey.ShouldHaveLength, 2)
+		convey.So(f.deleted, convey.ShouldBeEmpty)
+		_, err := os.Stat(incremental.WatermarkFile)
+		convey.So(os.IsNotExist(err), convey.ShouldBeTrue)
+	})
+
+	convey.Convey("Delete tags only once selected for delete after days", t, func() {
+		f, server := newFakeRegistry(newRepos())
+		defer server.Close()
+		dir, _ := ioutil.TempDir("", "tombstones")
+		defer os.RemoveAll(dir)
+		delayed := opts
+		delayed.TombstoneFile = filepath.Join(dir, "tombstones.json")
+		delayed.Configs = []PurgeConfig{{RepoRegex: ".*", Tags: []TagConfig{{TagsRegex: ".*", KeepDays: 7, KeepCount: 1, DeleteAfterDays: 3}}}}
+		(&PurgeTombstones{Tags: map[string]time.Time{"app:v1": now.AddDate(0, 0, -4), "other:v1": now}}).save(delayed.TombstoneFile)
+		summary := PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), delayed)
+		convey.So(f.deleted, convey.ShouldResemble, []string{"app:v1"})
+		convey.So(summary.Repos[0].Keep, convey.ShouldContain, "v2")
+
+		tombstones, err := loadTombstones(delayed.TombstoneFile)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(tombstones.Tags, convey.ShouldContainKey, "app:v2")
+		convey.So(tombstones.Tags, convey.ShouldContainKey, "other:v1")
+		convey.So(tombstones.Tags, convey.ShouldHaveLength, 3)
+
+		delayed.Configs[0].Tags[0].KeepDays = 30
+		PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), delayed)
+		tombstones, _ = loadTombstones(delayed.TombstoneFile)
+		convey.So(tombstones.Tags, convey.ShouldNotContainKey, "app:v2")
+	})
+
+	convey.Convey("Age the tags from when the runs first saw them", t, func() {
+		repos := newRepos()
+		f, server := newFakeRegistry(repos)
+		defer server.Close()
+		dir, _ := ioutil.TempDir("", "firstseen")
+		defer os.RemoveAll(dir)
+		seen := opts
+		seen.AgeSource, seen.FirstSeenFile = AgeFirstSeen, filepath.Join(dir, "firstseen.json")
+		PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), seen)
+		convey.So(f.deleted, convey.ShouldBeEmpty)
+		firstSeen, err := loadFirstSeen(seen.FirstSeenFile)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(firstSeen.Tags, convey.ShouldHaveLength, 3)
+
+		old := now.AddDate(0, 0, -30)
+		(&PurgeFirstSeen{Tags: map[string]FirstSeen{
+			"app:v1": {Digest: fakeDigest(repos["app"]["v1"]), First: old}, "app:v2": {Digest: "sha256:repushed", First: old},
+			"app:gone": {Digest: "sha256:gone", First: old}, "other:v1": {Digest: "sha256:other", First: old},
+		}}).save(seen.FirstSeenFile)
+		summary := PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), seen)
+		convey.So(f.deleted, convey.ShouldResemble, []string{"app:v1"})
+		convey.So(summary.Repos[0].Keep, convey.ShouldContain, "v2")
+		firstSeen, _ = loadFirstSeen(seen.FirstSeenFile)
+		convey.So(firstSeen.Tags, convey.ShouldNotContainKey, "app:gone")
+		convey.So(firstSeen.Tags, convey.ShouldContainKey, "other:v1")
+		convey.So(firstSeen.Tags["app:v2"].First, convey.ShouldHappenAfter, old)
+	})
+
+	convey.Convey("Fail on first seen age source without first seen file", t, func() {
+		_, server := newFakeRegistry(newRepos())
+		defer server.Close()
+		seen := opts
+		seen.AgeSource = AgeFirstSeen
+		summary := PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), seen)
+		convey.So(summary.Errors, convey.ShouldHaveLength, 1)
+	})
+
+	convey.Convey("Fail on delete after days without tombstone file", t, func() {
+		_, server := newFakeRegistry(newRepos())
+		defer server.Close()
+		delayed := opts
+		delayed.Configs = []PurgeConfig{{RepoRegex: ".*", Tags: []TagConfig{{TagsRegex: ".*", DeleteAfterDays: 3}}}}
+		summary := PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), delayed)
+		convey.So(summary.Errors, convey.ShouldHaveLength, 1)
+	})
+
+	convey.Convey("Keep tags in use by tag or digest", t, func() {
+		repos := newRepos()
+		repos["app"]["v0"] = now.Add(-40 * 24 * time.Hour)
+		f, server := newFakeRegistry(repos)
+		defer server.Close()
+		host := strings.TrimPrefix(server.URL, "http://")
+		used := opts
+		used.InUseProvider = func() ([]string, error) {
+			return []string{host + "/app:v1", host + "/app@" + fakeDigest(repos["app"]["v2"]), "other.local/app:v0"}, nil
+		}
+		PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), used)
+		convey.So(f.deleted, convey.ShouldResemble, []string{"app:v0"})
+	})
+
+	convey.Convey("Keep tags which manifest digest is listed in the file of the images in use", t, func() {
+		repos := newRepos()
+		repos["app"]["v0"] = now.Add(-40 * 24 * time.Hour)
+		f, server := newFakeRegistry(repos)
+		defer server.Close()
+		file, err := ioutil.TempFile("", "in-use")
+		convey.So(err, convey.ShouldBeNil)
+		defer os.Remove(file.Name())
+		file.WriteString("# running digests\n" + fakeDigest(repos["app"]["v1"]) + "\n\n" + fakeDigest(repos["app"]["v2"]) + "\n")
+		file.Close()
+		used := opts
+		used.InUseProvider = NewFileProvider(file.Name())
+		PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), used)
+		convey.So(f.deleted, convey.ShouldResemble, []string{"app:v0"})
+	})
+
+	convey.Convey("Keep tags named after the protected names without counting them towards keep count", t, func() {
+		repos := newRepos()
+		repos["app"]["feature-login"] = now.Add(-25 * 24 * time.Hour)
+		f, server := newFakeRegistry(repos)
+		defer server.Close()
+		branches := opts
+		branches.ProtectedTagsProvider = func() ([]ProtectedRef, error) {
+			return []ProtectedRef{{Name: "feature/login"}, {Name: "v1"}}, nil
+		}
+		PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), branches)
+		convey.So(f.deleted, convey.ShouldResemble, []string{"app:v2"})
+	})
+
+	convey.Convey("Keep the commit SHA tags of the branch heads only", t, func() {
+		repos := newRepos()
+		repos["app"]["0123456"] = now.Add(-25 * 24 * time.Hour)
+		repos["app"]["89abcde"] = now.Add(-26 * 24 * time.Hour)
+		f, server := newFakeRegistry(repos)
+		defer server.Close()
+		branches := opts
+		branches.ProtectedTagsProvider = func() ([]ProtectedRef, error) {
+			return []ProtectedRef{{Name: "main", SHA: "0123456789abcdef0123456789abcdef01234567"}}, nil
+		}
+		summary := PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), branches)
+		convey.So(f.deleted, convey.ShouldHaveLength, 3)
+		convey.So(f.deleted, convey.ShouldContain, "app:89abcde")
+		convey.So(summary.repo("app").Keep, convey.ShouldContain, "0123456")
+	})
+
+	convey.Convey("Fail when the protected tags cannot be listed", t, func() {
+		f, server := newFakeRegistry(newRepos())
+		defer server.Close()
+		branches := opts
+		branches.ProtectedTagsProvider = func() ([]ProtectedRef, error) {
+			return nil, fmt.Errorf("api unreachable")
+		}
+		summary := PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), branches)
+		convey.So(f.deleted, convey.ShouldBeEmpty)
+		convey.So(summary.Errors, convey.ShouldHaveLength, 1)
+	})
+
+	convey.Convey("Skip deleting the tags pushed within the grace period", t, func() {
+		f, server := newFakeRegistry(newRepos())
+		defer server.Close()
+		f.uploaded = map[string]time.Time{"v1": now.Add(-time.Minute), "v2": now.Add(-time.Hour)}
+		grace := opts
+		grace.RecentPushGrace = 10 * time.Minute
+		summary := PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), grace)
+		convey.So(f.deleted, convey.ShouldResemble, []string{"app:v2"})
+		convey.So(summary.Repos[0].RecentlyPushed, convey.ShouldResemble, []string{"v1"})
+		convey.So(summary.Errors, convey.ShouldBeEmpty)
+		convey.So(summary.Markdown(), convey.ShouldContainSubstring, "| `v1` | purge, not deleted as pushed recently |")
+	})
+
+	convey.Convey("Fail when the images in use cannot be listed", t, func() {
+		f, server := newFakeRegistry(newRepos())
+		defer server.Close()
+		used := opts
+		used.InUseProvider = func() ([]string, error) {
+			return nil, fmt.Errorf("cluster unreachable")
+		}
+		summary := PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), used)
+		convey.So(f.deleted, convey.ShouldBeEmpty)
+		convey.So(summary.Errors, convey.ShouldHaveLength, 1)
+	})
+
+	convey.Convey("Keep tags sharing the manifest with a kept tag", t, func() {
+		old := now.Add(-30 * 24 * time.Hour)
+		repos := map[string]map[string]time.Time{
+			"app": {"latest": old, "nightly-1": old, "nightly-2": old.Add(-time.Hour)},
+		}
+		shared := opts
+		shared.Configs = []PurgeConfig{{RepoRegex: ".*", Tags: []TagConfig{
+			{TagsRegex: "^latest$", KeepCount: 1},
+			{TagsRegex: "^nightly-", KeepDays: 7},
+		}}}
+		f, server := newFakeRegistry(repos)
+		defer server.Close()
+		summary := PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), shared)
+		convey.So(f.deleted, convey.ShouldResemble, []string{"app:nightly-2"})
+		convey.So(summary.Repos[0].Keep, convey.ShouldContain, "nightly-1")
+
+		shared.SharedManifestPolicy = SharedManifestDelete
+		PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), shared)
+		convey.So(f.repos["app"], convey.ShouldBeEmpty)
+	})
+
+	convey.Convey("Report the progress per repo", t, func() {
+		repos := newRepos()
+		repos["other"] = map[string]time.Time{"v1": now}
+		repos["empty"] = map[string]time.Time{}
+		_, server := newFakeRegistry(repos)
+		defer server.Close()
+		var events []PurgeProgress
+		progress := opts
+		progress.Progress = func(e PurgeProgress) {
+			events = append(events, e)
+		}
+		PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), progress)
+		convey.So(events, convey.ShouldHaveLength, 8)
+		finished := map[string]PurgeProgress{}
+		deleted := []string{}
+		for _, e := range events {
+			if e.Event == ProgressRepoFinish {
+				finished[e.Repo] = e
+			}
+			if e.Event == ProgressTagDelete {
+				convey.So(e.Error, convey.ShouldBeEmpty)
+				deleted = append(deleted, e.Repo+":"+e.Tag)
+			}
+		}
+		convey.So(finished, convey.ShouldHaveLength, 3)
+		convey.So(finished["app"].Summary.Deleted, convey.ShouldEqual, 2)
+		convey.So(finished["empty"].Summary, convey.ShouldBeNil)
+		sort.Strings(deleted)
+		convey.So(deleted, convey.ShouldResemble, []string{"app:v1", "app:v2"})
+		convey.So(events[7].Event, convey.ShouldEqual, ProgressRepoFinish)
+		convey.So(events[7].Done, convey.ShouldEqual, 3)
+		convey.So(events[7].Total, convey.ShouldEqual, 3)
+	})
+
+	convey.Convey("Report the failed tag deletions", t, func() {
+		f, server := newFakeRegistry(newRepos())
+		defer server.Close()
+		f.failDelete = true
+		failed := []string{}
+		progress := opts
+		progress.Progress = func(e PurgeProgress) {
+			if e.Event == ProgressTagDelete && e.Error != "" {
+				failed = append(failed, e.Tag)
+			}
+		}
+		PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), progress)
+		convey.So(failed, convey.ShouldHaveLength, 2)
+	})
+
+	convey.Convey("Count tags sharing a manifest once with group by manifest", t, func() {
+		newAliases := func() map[string]map[string]time.Time {
+			release := now.Add(-10 * 24 * time.Hour)
+			return map[string]map[string]time.Time{"app": {
+				"1.2.3": release, "1.2": release, "1": release,
+				"1.2.2": now.Add(-20 * 24 * time.Hour), "1.2.1": now.Add(-30 * 24 * time.Hour),
+			}}
+		}
+		f, server := newFakeRegistry(newAliases())
+		defer server.Close()
+		aliases := opts
+		aliases.KeepCount = 2
+		PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), aliases)
+		convey.So(f.deleted, convey.ShouldHaveLength, 2)
+
+		f, server = newFakeRegistry(newAliases())
+		defer server.Close()
+		aliases.GroupByManifest = true
+		summary := PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), aliases)
+		convey.So(f.deleted, convey.ShouldResemble, []string{"app:1.2.1"})
+		convey.So(summary.Repos[0].Keep, convey.ShouldHaveLength, 4)
+	})
+
+	convey.Convey("Only report the repos of dry-run only configs", t, func() {
+		f, server := newFakeRegistry(newRepos())
+		defer server.Close()
+		reportOnly := opts
+		reportOnly.Configs = []PurgeConfig{{RepoRegex: "^app$", DryRunOnly: true, Tags: []TagConfig{{TagsRegex: ".*", KeepDays: 7, KeepCount: 1}}}}
+		summary := PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), reportOnly)
+		convey.So(f.deleted, convey.ShouldBeEmpty)
+		convey.So(summary.Repos[0].Purge, convey.ShouldHaveLength, 2)
+		convey.So(summary.Repos[0].DryRunOnly, convey.ShouldBeTrue)
+		convey.So(summary.Errors, convey.ShouldBeEmpty)
+	})
+
+	convey.Convey("Count the repos and tags skipped by reason", t, func() {
+		repos := newRepos()
+		repos["tools/ci"] = map[string]time.Time{"v1": now}
+		_, server := newFakeRegistry(repos)
+		defer server.Close()
+		skips := opts
+		skips.DryRun = true
+		skips.Namespaces = []string{"library"}
+		skips.Configs = []PurgeConfig{{RepoRegex: "^app$", Tags: []TagConfig{{TagsRegex: "^v1$", KeepDays: 7, KeepCount: 1}}}}
+		summary := PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), skips)
+		convey.So(summary.Skipped, convey.ShouldResemble, map[SkipReason]int{SkipFiltered: 1, SkipNoTagRule: 2})
+		convey.So(summary.Markdown(), convey.ShouldContainSubstring, "## Skipped\n\n* filtered: 1\n* no_tag_rule: 2\n")
+		convey.So(summary.Metrics(), convey.ShouldContainSubstring, "registry_ui_purge_skipped{reason=\"no_tag_rule\"} 2\n")
+		convey.So(skippedText(summary.Skipped), convey.ShouldEqual, "filtered=1 no_tag_rule=2")
+	})
+
+	convey.Convey("Delete nothing once cancelled", t, func() {
+		f, server := newFakeRegistry(newRepos())
+		defer server.Close()
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		PurgeOldTags(ctx, NewClient(server.URL, false, "", ""), opts)
+		convey.So(f.deleted, convey.ShouldBeEmpty)
+	})
+
+	convey.Convey("Collect deletion errors and complete the run", t, func() {
+		f, server := newFakeRegistry(newRepos())
+		defer server.Close()
+		f.failDelete = true
+		summary := PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), opts)
+		convey.So(summary.Errors, convey.ShouldHaveLength, 2)
+		convey.So(summary.Aborted, convey.ShouldBeFalse)
+	})
+
+	convey.Convey("Verify the deleted manifests are no longer resolvable", t, func() {
+		verify := opts
+		verify.VerifyDeletions = time.Millisecond
+		f, server := newFakeRegistry(newRepos())
+		defer server.Close()
+		summary := PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), verify)
+		convey.So(f.deleted, convey.ShouldHaveLength, 2)
+		convey.So(summary.Errors, convey.ShouldBeEmpty)
+		convey.So(summary.TagsDeleted, convey.ShouldEqual, 2)
+
+		f, server = newFakeRegistry(newRepos())
+		defer server.Close()
+		f.ignoreDelete = true
+		summary = PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), verify)
+		convey.So(summary.Errors, convey.ShouldHaveLength, 2)
+		convey.So(summary.TagsDeleted, convey.ShouldEqual, 0)
+		convey.So(summary.Repos[0].NotDeleted, convey.ShouldHaveLength, 2)
+	})
+
+	convey.Convey("Count the deletions which verification fails as deleted", t, func() {
+		verify := opts
+		verify.VerifyDeletions = time.Millisecond
+		f, server := newFakeRegistry(newRepos())
+		defer server.Close()
+		f.failVerify = true
+		verify.MeasureBytes = true
+		summary := PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), verify)
+		convey.So(f.deleted, convey.ShouldHaveLength, 2)
+		convey.So(summary.TagsDeleted, convey.ShouldEqual, 2)
+		convey.So(summary.BytesReclaimed, convey.ShouldEqual, 2200)
+		convey.So(summary.Repos[0].NotDeleted, convey.ShouldBeEmpty)
+		convey.So(summary.Repos[0].Unverified, convey.ShouldHaveLength, 2)
+		convey.So(summary.Errors, convey.ShouldHaveLength, 2)
+		convey.So(summary.Errors[0], convey.ShouldStartWith, "failed to verify the deletion of app:")
+		convey.So(summary.Markdown(), convey.ShouldContainSubstring, "2 deleted, 2 deleted but not verified")
+	})
+
+	convey.Convey("Wait for the in-flight deletions on cancel without drain timeout", t, func() {
+		f, server := newFakeRegistry(newRepos())
+		defer server.Close()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		f.onDelete, f.deleteDelay = cancel, 50*time.Millisecond
+		unset := opts
+		unset.DeleteWorkers, unset.DrainTimeout = 1, 0
+		summary := PurgeOldTags(ctx, NewClient(server.URL, false, "", ""), unset)
+		convey.So(f.deleted, convey.ShouldHaveLength, 1)
+		convey.So(summary.TagsDeleted, convey.ShouldEqual, 1)
+		convey.So(summary.Errors, convey.ShouldHaveLength, 1)
+	})
+
+	convey.Convey("Abort the run on first deletion error with fail fast", t, func() {
+		f, server := newFakeRegistry(newRepos())
+		defer server.Close()
+		f.failDelete = true
+		failFast := opts
+		failFast.DeleteWorkers, failFast.FailFast = 1, true
+		summary := PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), failFast)
+		convey.So(summary.Errors, convey.ShouldHaveLength, 1)
+		convey.So(summary.Aborted, convey.ShouldBeTrue)
+		convey.So(summary.FailFast, convey.ShouldBeTrue)
+	})
+
+	convey.Convey("Delete only once the purge is confirmed", t, func() {
+		for _, confirmed := range []bool{false, true} {
+			f, server := newFakeRegistry(newRepos())
+			var asked map[string][]string
+			confirm := opts
+			confirm.Confirm = func(purge map[string][]string) bool {
+				asked = purge
+				return confirmed
+			}
+			summary := PurgeOldTags(context.Background(), NewClient(server.URL, false, "", ""), confirm)
+			server.Close()
+			convey.So(asked["app"], convey.ShouldHaveLength, 2)
+			convey.So(summary.Aborted, convey.ShouldEqual, !confirmed)
+			if confirmed {
+				convey.So(f.deleted, convey.ShouldHaveLength, 2)
+			} else {
+				convey.So(f.deleted, convey.ShouldBeEmpty)
+				convey.So(summary.Errors, convey.ShouldHaveLength, 1)
+			}
+		}
+	})
+}
+
+func TestLogList(t *testing.T) {
+	convey.Convey("Truncate the logged lists to the limit", t, func() {
+		tags := []string{"v1", "v2", "v3"}
+		convey.So(logList(tags, 0), convey.ShouldEqual, "[v1 v2 v3]")
+		convey.So(logList(tags, 3), convey.ShouldEqual, "[v1 v2 v3]")
+		convey.So(logList(tags, 2), convey.ShouldEqual, "[v1 v2] and 1 more")
+
+		created := time.Date(2019, 7, 1, 0, 0, 0, 0, time.UTC)
+		convey.So(logList(timeSlice{{name: "v1", created: created}}.strings(), 1), convey.ShouldEqual, `["v1 <2019-07-01 00:00:00>"]`)
+	})
+}
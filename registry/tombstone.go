@@ -0,0 +1,86 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// PurgeTombstones first times the tags of TagConfigs with DeleteAfterDays were selected for purging,
+// by "repo:tag", so they are only deleted once selected by every run for that many days.
+type PurgeTombstones struct {
+	Updated time.Time            `json:"updated"`
+	Tags    map[string]time.Time `json:"tags"`
+}
+
+// loadTombstones read the tombstones from the file, empty ones if the file does not exist.
+func loadTombstones(path string) (*PurgeTombstones, error) {
+	tombstones := &PurgeTombstones{Tags: map[string]time.Time{}}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return tombstones, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error reading purge tombstones: %s", err)
+	}
+	if err := json.Unmarshal(data, tombstones); err != nil {
+		return nil, fmt.Errorf("Error parsing purge tombstones %s: %s", path, err)
+	}
+	if tombstones.Tags == nil {
+		tombstones.Tags = map[string]time.Time{}
+	}
+	return tombstones, nil
+}
+
+// save write the tombstones to the file.
+func (t *PurgeTombstones) save(path string) error {
+	data, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("Error writing purge tombstones: %s", err)
+	}
+	return nil
+}
+
+// deleteDue check whether the tag selected for purging was first selected at least days ago, recording it
+// as selected by this run. The tags not selected again by a run lose their tombstone, so the delay restarts.
+func (p *purger) deleteDue(repo, tag string, days int) bool {
+	if days <= 0 {
+		return true
+	}
+	key := repo + ":" + tag
+	first, ok := p.tombstones.Tags[key]
+	if !ok {
+		first = p.clock.now
+	}
+	p.selected[key] = first
+	if due := first.AddDate(0, 0, days); p.clock.now.Before(due) {
+		p.logger.Infof("[%s] keeping tag %s selected for purging since %s until %s", repo, tag, first.Format("2006-01-02"), due.Format("2006-01-02"))
+		return false
+	}
+	return true
+}
+
+// saveTombstones replace the tombstones of the repos analyzed by the run with the tags it selected,
+// keeping the ones of the other repos, e.g. out of the namespaces or not started on MaxDuration.
+func (p *purger) saveTombstones() {
+	analyzed := map[string]bool{}
+	for _, r := range p.summary.Repos {
+		analyzed[r.Repo] = true
+	}
+	for key, first := range p.tombstones.Tags {
+		if !analyzed[strings.SplitN(key, ":", 2)[0]] {
+			p.selected[key] = first
+		}
+	}
+	tombstones := &PurgeTombstones{Updated: p.clock.now, Tags: p.selected}
+	if err := tombstones.save(p.opts.TombstoneFile); err != nil {
+		p.logger.Error(err)
+		p.summary.addError(err)
+	}
+}
@@ -0,0 +1,45 @@
+package registry
+
+import "strconv"
+
+// Tracer start the spans of the Client operations, e.g. an adapter to an OpenTelemetry tracer. The spans
+// of a purge run are all started while its "PurgeOldTags" span is open, so an adapter can make them its children.
+type Tracer interface {
+	StartSpan(name string, attrs map[string]string) Span
+}
+
+// Span of a Client operation, ended with its error if it failed.
+type Span interface {
+	SetAttribute(key, value string)
+	End(err error)
+}
+
+// noopSpan is returned without a tracer so the instrumentation costs nothing by default.
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(key, value string) {}
+func (noopSpan) End(err error)                  {}
+
+// SetTracer start the spans of Repositories, Tags, TagInfo, DeleteTag and PurgeOldTags with the tracer,
+// nil to disable tracing which is the default.
+func (c *Client) SetTracer(tracer Tracer) {
+	c.tracer = tracer
+}
+
+// startSpan start a span with the attributes given as key value pairs, a no-op one without a tracer.
+func (c *Client) startSpan(name string, kv ...string) Span {
+	if c.tracer == nil {
+		return noopSpan{}
+	}
+	attrs := map[string]string{}
+	for i := 0; i+1 < len(kv); i = i + 2 {
+		attrs[kv[i]] = kv[i+1]
+	}
+	return c.tracer.StartSpan(name, attrs)
+}
+
+// endSpan set the count attribute and end the span.
+func endSpan(span Span, key string, count int, err error) {
+	span.SetAttribute(key, strconv.Itoa(count))
+	span.End(err)
+}
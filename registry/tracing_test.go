@@ -0,0 +1,98 @@
+package registry
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/smartystreets/goconvey/convey"
+)
+
+type recordedSpan struct {
+	name  string
+	attrs map[string]string
+	err   error
+	ended bool
+}
+
+type recordingTracer struct {
+	mux   sync.Mutex
+	spans []*recordedSpan
+}
+
+func (t *recordingTracer) StartSpan(name string, attrs map[string]string) Span {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	span := &recordedSpan{name: name, attrs: attrs}
+	t.spans = append(t.spans, span)
+	return &recordingSpan{tracer: t, span: span}
+}
+
+func (t *recordingTracer) named(name string) []*recordedSpan {
+	spans := []*recordedSpan{}
+	for _, s := range t.spans {
+		if s.name == name {
+			spans = append(spans, s)
+		}
+	}
+	return spans
+}
+
+type recordingSpan struct {
+	tracer *recordingTracer
+	span   *recordedSpan
+}
+
+func (s *recordingSpan) SetAttribute(key, value string) {
+	s.tracer.mux.Lock()
+	defer s.tracer.mux.Unlock()
+	s.span.attrs[key] = value
+}
+
+func (s *recordingSpan) End(err error) {
+	s.tracer.mux.Lock()
+	defer s.tracer.mux.Unlock()
+	s.span.err, s.span.ended = err, true
+}
+
+func TestTracer(t *testing.T) {
+	now := time.Now().UTC()
+
+	convey.Convey("Trace the operations of a purge run", t, func() {
+		_, server := newFakeRegistry(map[string]map[string]time.Time{
+			"app": {"v1": now.Add(-30 * 24 * time.Hour), "v2": now},
+		})
+		defer server.Close()
+		client := NewClient(server.URL, false, "", "")
+		tracer := &recordingTracer{}
+		client.SetTracer(tracer)
+		PurgeOldTags(context.Background(), client, PurgeTagsOptions{KeepDays: 7, KeepCount: 1, DrainTimeout: time.Second})
+
+		for _, s := range tracer.spans {
+			convey.So(s.ended, convey.ShouldBeTrue)
+		}
+		convey.So(tracer.named("PurgeOldTags"), convey.ShouldHaveLength, 1)
+		convey.So(tracer.named("PurgeOldTags")[0].attrs["tags_deleted"], convey.ShouldEqual, "1")
+		convey.So(tracer.named("PurgeOldTags")[0].err, convey.ShouldBeNil)
+		convey.So(tracer.named("Repositories")[0].attrs["repos"], convey.ShouldEqual, "1")
+		convey.So(tracer.named("Tags")[0].attrs["repo"], convey.ShouldEqual, "app")
+		convey.So(tracer.named("TagInfo"), convey.ShouldHaveLength, 2)
+
+		deletes := tracer.named("DeleteTag")
+		convey.So(deletes, convey.ShouldHaveLength, 1)
+		convey.So(deletes[0].attrs["tag"], convey.ShouldEqual, "v1")
+		convey.So(deletes[0].attrs["status"], convey.ShouldStartWith, "202")
+		convey.So(deletes[0].err, convey.ShouldBeNil)
+	})
+
+	convey.Convey("End the spans of failed operations with their error", t, func() {
+		_, server := newFakeRegistry(map[string]map[string]time.Time{"app": {"v1": now}})
+		defer server.Close()
+		client := NewClient(server.URL, false, "", "")
+		tracer := &recordingTracer{}
+		client.SetTracer(tracer)
+		client.TagInfo("app", "missing", true)
+		convey.So(tracer.named("TagInfo")[0].err, convey.ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,84 @@
+package registry
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// VulnProvider return the highest vulnerability severity of the image found by a scanner,
+// one of the Severities or empty string if none found.
+type VulnProvider func(repo, tag, digest string) (string, error)
+
+// Severities vulnerability severities from the lowest to the highest.
+var Severities = []string{"UNKNOWN", "NEGLIGIBLE", "LOW", "MEDIUM", "HIGH", "CRITICAL"}
+
+// severityRank return the rank of the severity in Severities, case-insensitive, -1 if none or unknown.
+func severityRank(severity string) int {
+	for i, s := range Severities {
+		if strings.EqualFold(s, severity) {
+			return i
+		}
+	}
+	return -1
+}
+
+// maxSeverity return the highest of the severities.
+func maxSeverity(severities []gjson.Result) string {
+	max := ""
+	for _, s := range severities {
+		if severityRank(s.String()) > severityRank(max) {
+			max = strings.ToUpper(s.String())
+		}
+	}
+	return max
+}
+
+// NewTrivyProvider sample VulnProvider scanning images of the registry host with Trivy CLI, which has to be
+// installed and authenticated to the registry, e.g. with TRIVY_USERNAME and TRIVY_PASSWORD.
+func NewTrivyProvider(registryHost string) VulnProvider {
+	return func(repo, tag, digest string) (string, error) {
+		image := fmt.Sprintf("%s/%s@%s", registryHost, repo, digest)
+		out, err := exec.Command("trivy", "image", "--quiet", "--format", "json", image).Output()
+		if err != nil {
+			return "", fmt.Errorf("Error scanning %s with trivy: %s", image, err)
+		}
+		var severities []gjson.Result
+		for _, r := range gjson.GetBytes(out, "Results.#.Vulnerabilities.#.Severity").Array() {
+			severities = append(severities, r.Array()...)
+		}
+		return maxSeverity(severities), nil
+	}
+}
+
+// NewClairProvider sample VulnProvider getting the vulnerability reports of the images from Clair v4 matcher.
+// The images have to be indexed by Clair beforehand, e.g. by the registry notifications or clairctl.
+func NewClairProvider(clairURL string) VulnProvider {
+	client := &http.Client{Timeout: time.Minute}
+	return func(repo, tag, digest string) (string, error) {
+		uri := fmt.Sprintf("%s/matcher/api/v1/vulnerability_report/%s", strings.TrimRight(clairURL, "/"), digest)
+		resp, err := client.Get(uri)
+		if err != nil {
+			return "", fmt.Errorf("Error getting vulnerability report of %s:%s: %s", repo, tag, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != 200 {
+			return "", fmt.Errorf("Error getting vulnerability report of %s:%s: %s", repo, tag, resp.Status)
+		}
+		data, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return "", err
+		}
+		var severities []gjson.Result
+		gjson.GetBytes(data, "vulnerabilities").ForEach(func(_, v gjson.Result) bool {
+			severities = append(severities, v.Get("normalized_severity"))
+			return true
+		})
+		return maxSeverity(severities), nil
+	}
+}
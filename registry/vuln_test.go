@@ -0,0 +1,33 @@
+package registry
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestClairProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/matcher/api/v1/vulnerability_report/sha256:abc" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, `{"vulnerabilities": {"1": {"normalized_severity": "Medium"}, "2": {"normalized_severity": "High"}, "3": {"normalized_severity": "Low"}}}`)
+	}))
+	defer server.Close()
+	provider := NewClairProvider(server.URL + "/")
+
+	convey.Convey("Return the highest severity of the report", t, func() {
+		severity, err := provider("app", "v1", "sha256:abc")
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(severity, convey.ShouldEqual, "HIGH")
+	})
+
+	convey.Convey("Fail on images not indexed", t, func() {
+		_, err := provider("app", "v2", "sha256:def")
+		convey.So(err, convey.ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,74 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// ChangedReposProvider list the repos pushed to since the time, e.g. from the registry notifications.
+// It returns false when it cannot tell, e.g. the notifications do not go back that far, so all the repos are scanned.
+type ChangedReposProvider func(since time.Time) (repos []string, ok bool, err error)
+
+// PurgeWatermark start of the last run which scanned the repos changed since then, see WatermarkFile.
+type PurgeWatermark struct {
+	Since time.Time `json:"since"`
+}
+
+// loadWatermark read the watermark from the file, a zero one if the file does not exist.
+func loadWatermark(path string) (*PurgeWatermark, error) {
+	watermark := &PurgeWatermark{}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return watermark, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error reading purge watermark: %s", err)
+	}
+	if err := json.Unmarshal(data, watermark); err != nil {
+		return nil, fmt.Errorf("Error parsing purge watermark %s: %s", path, err)
+	}
+	return watermark, nil
+}
+
+// save write the watermark to the file.
+func (w *PurgeWatermark) save(path string) error {
+	data, err := json.MarshalIndent(w, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("Error writing purge watermark: %s", err)
+	}
+	return nil
+}
+
+// changedRepos list the repos to scan incrementally, nil to scan them all when there is no watermark yet
+// or the provider cannot tell which repos changed since it.
+func (p *purger) changedRepos() (map[string]bool, error) {
+	watermark, err := loadWatermark(p.opts.WatermarkFile)
+	if err != nil {
+		return nil, err
+	}
+	if watermark.Since.IsZero() {
+		p.logger.Info("No purge watermark yet, scanning all the repositories.")
+		return nil, nil
+	}
+	repos, ok, err := p.opts.ChangedRepos(watermark.Since)
+	if err != nil {
+		p.logger.Warnf("Failed to list the repositories changed since %s, scanning all of them: %s", watermark.Since.Format(time.RFC3339), err)
+		return nil, nil
+	}
+	if !ok {
+		p.logger.Warnf("Cannot tell the repositories changed since %s, scanning all of them.", watermark.Since.Format(time.RFC3339))
+		return nil, nil
+	}
+	p.logger.Infof("Scanning only the %d repositories changed since %s.", len(repos), watermark.Since.Format(time.RFC3339))
+	changed := map[string]bool{}
+	for _, repo := range repos {
+		changed[repo] = true
+	}
+	return changed, nil
+}
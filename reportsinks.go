@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/quiq/docker-registry-ui/registry"
+)
+
+// reportSinkConfig report sink of purge_report_sinks, which fields apply depends on the type.
+type reportSinkConfig struct {
+	// Type is one of stdout, file, webhook, slack and email.
+	Type string `yaml:"type"`
+	// Path is the file of the file sink.
+	Path string `yaml:"path"`
+	// URL is the one of the webhook and slack sinks.
+	URL string `yaml:"url"`
+	// SMTPServer, From, To, Username and Password are the ones of the email sink.
+	SMTPServer string   `yaml:"smtp_server"`
+	From       string   `yaml:"from"`
+	To         []string `yaml:"to"`
+	Username   string   `yaml:"username"`
+	Password   string   `yaml:"password"`
+}
+
+// newReportSinks build the report sinks of the configs.
+func newReportSinks(configs []reportSinkConfig) ([]registry.ReportSink, error) {
+	sinks := []registry.ReportSink{}
+	for i, c := range configs {
+		var missing string
+		switch {
+		case c.Type == "file" && c.Path == "":
+			missing = "path"
+		case (c.Type == "webhook" || c.Type == "slack") && c.URL == "":
+			missing = "url"
+		case c.Type == "email" && (c.SMTPServer == "" || c.From == "" || len(c.To) == 0):
+			missing = "smtp_server, from and to"
+		}
+		if missing != "" {
+			return nil, fmt.Errorf("Invalid purge_report_sinks #%d: %s sink requires %s", i+1, c.Type, missing)
+		}
+		switch c.Type {
+		case "stdout":
+			sinks = append(sinks, registry.NewStdoutSink())
+		case "file":
+			sinks = append(sinks, registry.NewFileSink(c.Path))
+		case "webhook":
+			sinks = append(sinks, registry.NewWebhookSink(c.URL))
+		case "slack":
+			sinks = append(sinks, registry.NewSlackSink(c.URL))
+		case "email":
+			sinks = append(sinks, registry.NewEmailSink(c.SMTPServer, c.From, c.To, c.Username, c.Password))
+		default:
+			return nil, fmt.Errorf("Invalid purge_report_sinks #%d type: %s", i+1, c.Type)
+		}
+	}
+	return sinks, nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hhkbp2/go-logging"
+	"github.com/labstack/echo"
+	"github.com/robfig/cron"
+)
+
+// purgeScheduler run the scheduled purges, which operators can pause, e.g. during an incident, and resume
+// over the scheduler endpoints without restarting the process or editing the config.
+type purgeScheduler struct {
+	cron     *cron.Cron
+	schedule string
+	logger   logging.Logger
+	mux      sync.Mutex
+	paused   bool
+	pausedAt time.Time
+	skipped  int
+}
+
+// schedulerStatus status of the purge scheduler served by /scheduler/status.
+type schedulerStatus struct {
+	Schedule    string     `json:"schedule"`
+	Paused      bool       `json:"paused"`
+	PausedAt    *time.Time `json:"paused_at,omitempty"`
+	SkippedRuns int        `json:"skipped_runs"`
+	NextRun     time.Time  `json:"next_run"`
+	Running     bool       `json:"running"`
+}
+
+// newPurgeScheduler schedule the purge, the runs are skipped while the scheduler is paused.
+func newPurgeScheduler(schedule string, purge func(), logger logging.Logger) (*purgeScheduler, error) {
+	s := &purgeScheduler{cron: cron.New(), schedule: schedule, logger: logger}
+	if err := s.cron.AddFunc(schedule, func() { s.run(purge) }); err != nil {
+		return nil, fmt.Errorf("Invalid schedule format: %s", schedule)
+	}
+	return s, nil
+}
+
+// run the scheduled purge unless the scheduler is paused, counting the runs skipped then.
+func (s *purgeScheduler) run(purge func()) {
+	s.mux.Lock()
+	paused, pausedAt := s.paused, s.pausedAt
+	if paused {
+		s.skipped++
+	}
+	s.mux.Unlock()
+	if paused {
+		s.logger.Warnf("Skipping scheduled purge as the scheduler is paused since %s.", pausedAt.Format(time.RFC3339))
+		return
+	}
+	purge()
+}
+
+// setPaused pause or resume the scheduled runs, a purge already running is not stopped.
+func (s *purgeScheduler) setPaused(paused bool, by string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.paused == paused {
+		return
+	}
+	s.paused = paused
+	if paused {
+		s.pausedAt = time.Now()
+		s.skipped = 0
+		s.logger.Warnf("Purge scheduler paused by %s, scheduled purges are skipped until it is resumed.", by)
+	} else {
+		s.logger.Warnf("Purge scheduler resumed by %s after skipping %d scheduled purges.", by, s.skipped)
+	}
+}
+
+// status return the status of the scheduler, running tells whether any purge is running.
+func (s *purgeScheduler) status(running bool) schedulerStatus {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	status := schedulerStatus{Schedule: s.schedule, Paused: s.paused, SkippedRuns: s.skipped, Running: running}
+	if s.paused {
+		pausedAt := s.pausedAt
+		status.PausedAt = &pausedAt
+	}
+	if entries := s.cron.Entries(); len(entries) > 0 {
+		status.NextRun = entries[0].Next
+	}
+	return status
+}
+
+// pauseScheduler pause the scheduled purges.
+func (a *apiClient) pauseScheduler(c echo.Context) error {
+	if a.scheduler == nil {
+		return c.String(http.StatusNotFound, "No purge schedule configured.")
+	}
+	a.scheduler.setPaused(true, c.RealIP())
+	return a.viewSchedulerStatus(c)
+}
+
+// resumeScheduler resume the scheduled purges.
+func (a *apiClient) resumeScheduler(c echo.Context) error {
+	if a.scheduler == nil {
+		return c.String(http.StatusNotFound, "No purge schedule configured.")
+	}
+	a.scheduler.setPaused(false, c.RealIP())
+	return a.viewSchedulerStatus(c)
+}
+
+// viewSchedulerStatus serve the schedule, the next run time and whether the scheduler is paused.
+func (a *apiClient) viewSchedulerStatus(c echo.Context) error {
+	if a.scheduler == nil {
+		return c.String(http.StatusNotFound, "No purge schedule configured.")
+	}
+	return c.JSON(http.StatusOK, a.scheduler.status(atomic.LoadInt32(&a.purging) == 1))
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo"
+	"github.com/quiq/docker-registry-ui/registry"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestPurgeScheduler(t *testing.T) {
+	logger := registry.SetupLogging("scheduler_test")
+
+	convey.Convey("Skip the scheduled runs while paused", t, func() {
+		purges := 0
+		purge := func() { purges++ }
+		s, err := newPurgeScheduler("@every 1h", purge, logger)
+		convey.So(err, convey.ShouldBeNil)
+		s.run(purge)
+		convey.So(purges, convey.ShouldEqual, 1)
+
+		s.setPaused(true, "ops")
+		s.run(purge)
+		s.run(purge)
+		convey.So(purges, convey.ShouldEqual, 1)
+		status := s.status(false)
+		convey.So(status.Paused, convey.ShouldBeTrue)
+		convey.So(status.PausedAt, convey.ShouldNotBeNil)
+		convey.So(status.SkippedRuns, convey.ShouldEqual, 2)
+
+		s.setPaused(false, "ops")
+		s.run(purge)
+		convey.So(purges, convey.ShouldEqual, 2)
+	})
+
+	convey.Convey("Report the status after resume", t, func() {
+		s, err := newPurgeScheduler("@every 1h", func() {}, logger)
+		convey.So(err, convey.ShouldBeNil)
+		a := &apiClient{scheduler: s, purging: 1}
+		call := func(handler echo.HandlerFunc) schedulerStatus {
+			rec := httptest.NewRecorder()
+			convey.So(handler(echo.New().NewContext(httptest.NewRequest(http.MethodPost, "/", nil), rec)), convey.ShouldBeNil)
+			convey.So(rec.Code, convey.ShouldEqual, http.StatusOK)
+			var status schedulerStatus
+			convey.So(json.Unmarshal(rec.Body.Bytes(), &status), convey.ShouldBeNil)
+			return status
+		}
+		convey.So(call(a.pauseScheduler).Paused, convey.ShouldBeTrue)
+		s.run(func() {})
+
+		status := call(a.resumeScheduler)
+		convey.So(status.Schedule, convey.ShouldEqual, "@every 1h")
+		convey.So(status.Paused, convey.ShouldBeFalse)
+		convey.So(status.PausedAt, convey.ShouldBeNil)
+		convey.So(status.SkippedRuns, convey.ShouldEqual, 1)
+		convey.So(status.Running, convey.ShouldBeTrue)
+	})
+
+	convey.Convey("Fail on an invalid schedule and without a scheduler", t, func() {
+		_, err := newPurgeScheduler("every hour", func() {}, logger)
+		convey.So(err, convey.ShouldNotBeNil)
+		rec := httptest.NewRecorder()
+		convey.So((&apiClient{}).viewSchedulerStatus(echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/", nil), rec)), convey.ShouldBeNil)
+		convey.So(rec.Code, convey.ShouldEqual, http.StatusNotFound)
+	})
+}
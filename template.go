@@ -64,6 +64,7 @@ func setupRenderer(debug bool, registryHost, basePath string) *Template {
 		}
 		return res
 	})
+	view.AddGlobal("join", strings.Join)
 	view.AddGlobal("url_decode", func(m interface{}) string {
 		res, err := url.PathUnescape(m.(string))
 		if err != nil {